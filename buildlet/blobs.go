@@ -0,0 +1,269 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildlet
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// PutFiles writes files into dir on the buildlet, a relative directory
+// from the workdir, uploading only the contents that the buildlet's
+// blob cache doesn't already have. The open func is called for each
+// regular file whose digest is missing on the buildlet, and the missing
+// contents are streamed to the buildlet as a single tar.gz. The Size of
+// each regular file must be set.
+//
+// If the buildlet has no blob cache, PutFiles returns ErrBlobsUnsupported
+// without writing anything, and the caller should fall back to PutTar.
+// It should do the same if the error wraps ErrBlobsMissing, as happens
+// when the buildlet evicts blobs between the upload and the write.
+func PutFiles(ctx context.Context, c Client, dir string, files []BlobFile, open func(BlobFile) (io.ReadCloser, error)) error {
+	missing, err := missingBlobFiles(ctx, c, files)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		err := putBlobsTgz(ctx, c, func(tw *tar.Writer) error {
+			for _, f := range missing {
+				rc, err := open(f)
+				if err != nil {
+					return err
+				}
+				err = writeBlobEntry(tw, f, rc)
+				rc.Close()
+				if err != nil {
+					return fmt.Errorf("uploading %s: %v", f.Path, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return c.WriteBlobs(ctx, dir, files)
+}
+
+// PutTarDelta is like Client.PutTar, but only sends the contents of the
+// files in the tar.gz stream r that the buildlet's blob cache is missing.
+// Buildlets without a blob cache are sent the whole tarball with PutTar,
+// as are buildlets that evicted some of the blobs before they could be
+// written.
+//
+// The tarball is read twice: once to compute the digests of its files
+// and once to send the missing ones. If r is not an io.ReadSeeker, the
+// compressed tarball is held in memory between the two passes.
+func PutTarDelta(ctx context.Context, c Client, r io.Reader, dir string) error {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		tgz, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		rs = bytes.NewReader(tgz)
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	rewind := func() error {
+		_, err := rs.Seek(start, io.SeekStart)
+		return err
+	}
+
+	files, err := blobFilesFromTgz(rs)
+	if err != nil {
+		return err
+	}
+	missing, err := missingBlobFiles(ctx, c, files)
+	if errors.Is(err, ErrBlobsUnsupported) {
+		if err := rewind(); err != nil {
+			return err
+		}
+		return c.PutTar(ctx, rs, dir)
+	}
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		if err := rewind(); err != nil {
+			return err
+		}
+		want := make(map[string]bool, len(missing))
+		for _, f := range missing {
+			want[f.Digest] = true
+		}
+		// The second pass sees the same entries in the same order
+		// as blobFilesFromTgz, so files[i] is the i'th entry it kept.
+		i := 0
+		err := putBlobsTgz(ctx, c, func(tw *tar.Writer) error {
+			return walkTgz(rs, func(h *tar.Header, r io.Reader) error {
+				if _, ok := blobFileFromHeader(h); !ok {
+					return nil
+				}
+				if i >= len(files) {
+					return errors.New("buildlet: tarball changed between reads")
+				}
+				f := files[i]
+				i++
+				if !f.Mode.IsRegular() || !want[f.Digest] {
+					return nil
+				}
+				delete(want, f.Digest)
+				return writeBlobEntry(tw, f, r)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	err = c.WriteBlobs(ctx, dir, files)
+	if errors.Is(err, ErrBlobsMissing) {
+		if err := rewind(); err != nil {
+			return err
+		}
+		return c.PutTar(ctx, rs, dir)
+	}
+	return err
+}
+
+// missingBlobFiles returns one regular file from files for each
+// distinct digest that the buildlet's blob cache is missing.
+func missingBlobFiles(ctx context.Context, c Client, files []BlobFile) ([]BlobFile, error) {
+	var digests []string
+	byDigest := map[string]BlobFile{}
+	for _, f := range files {
+		if !f.Mode.IsRegular() {
+			continue
+		}
+		if _, dup := byDigest[f.Digest]; !dup {
+			byDigest[f.Digest] = f
+			digests = append(digests, f.Digest)
+		}
+	}
+	missing, err := c.MissingBlobs(ctx, digests)
+	if err != nil {
+		return nil, err
+	}
+	var out []BlobFile
+	for _, d := range missing {
+		f, ok := byDigest[d]
+		if !ok {
+			return nil, fmt.Errorf("buildlet reported unrequested digest %q as missing", d)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// putBlobsTgz streams the tar.gz written by write to the buildlet's
+// blob cache with PutBlobsTgz.
+func putBlobsTgz(ctx context.Context, c Client, write func(*tar.Writer) error) error {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		tw := tar.NewWriter(zw)
+		err := write(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	err := c.PutBlobsTgz(ctx, pr)
+	// Unblock the writer if PutBlobsTgz returned before reading
+	// all of pr.
+	pr.CloseWithError(errors.New("buildlet: blob upload finished early"))
+	return err
+}
+
+// writeBlobEntry writes the contents of f, read from r, to tw as an
+// entry named by its digest, as expected by PutBlobsTgz.
+func writeBlobEntry(tw *tar.Writer, f BlobFile, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     f.Digest,
+		Mode:     0644,
+		Size:     f.Size,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// walkTgz calls fn for each entry in the tar.gz stream r.
+func walkTgz(r io.Reader, fn func(h *tar.Header, r io.Reader) error) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(h, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// blobFileFromHeader returns the BlobFile, without its digest, for the
+// regular file or directory described by h. It reports false for
+// other entries, such as global headers and symlinks, which are
+// skipped as they are by the buildlet's /writetgz handler.
+func blobFileFromHeader(h *tar.Header) (BlobFile, bool) {
+	mode := h.FileInfo().Mode()
+	name := strings.TrimSuffix(h.Name, "/")
+	switch {
+	case mode.IsDir():
+		if name == "" || name == "." {
+			return BlobFile{}, false
+		}
+		return BlobFile{Path: name, Mode: os.ModeDir | mode.Perm()}, true
+	case mode.IsRegular():
+		return BlobFile{Path: name, Mode: mode.Perm(), Size: h.Size, ModTime: h.ModTime}, true
+	}
+	return BlobFile{}, false
+}
+
+// blobFilesFromTgz returns the regular files and directories in the
+// tar.gz stream r, with the SHA-1 digests of the regular files.
+func blobFilesFromTgz(r io.Reader) ([]BlobFile, error) {
+	var files []BlobFile
+	err := walkTgz(r, func(h *tar.Header, r io.Reader) error {
+		f, ok := blobFileFromHeader(h)
+		if !ok {
+			return nil
+		}
+		if f.Mode.IsRegular() {
+			s1 := sha1.New()
+			if _, err := io.Copy(s1, r); err != nil {
+				return err
+			}
+			f.Digest = fmt.Sprintf("%x", s1.Sum(nil))
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
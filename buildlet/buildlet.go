@@ -32,6 +32,12 @@ type VMOpts struct {
 	// If zero, http without auth is used.
 	TLS KeyPair
 
+	// SessionCA optionally puts the buildlet in mutual TLS mode. The
+	// returned client then authenticates with short-lived certificates
+	// from the CA, scoped to the VM's name, rather than a password
+	// derived from TLS. It requires TLS to be set.
+	SessionCA *SessionCA
+
 	// Optional description of the VM.
 	Description string
 
@@ -83,10 +89,10 @@ type VMOpts struct {
 // buildletClient returns a buildlet client configured to speak to a VM via the buildlet
 // URL. The communication will use TLS if one is provided in the vmopts. This will wait until
 // it can connect with the endpoint before returning. The buildletURL is in the form of:
-// "https://<ip>". The ipPort field is in the form of "<ip>:<port>". The function
-// will attempt to connect to the buildlet for the lesser of: the default timeout period
+// "https://<ip>". The ipPort field is in the form of "<ip>:<port>". The session is
+// the buildlet's session name for opts.SessionCA. The function will attempt to connect to the buildlet for the lesser of: the default timeout period
 // (10 minutes) or the timeout set in the passed in context.
-func buildletClient(ctx context.Context, buildletURL, ipPort string, opts *VMOpts) (Client, error) {
+func buildletClient(ctx context.Context, buildletURL, ipPort, session string, opts *VMOpts) (Client, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 	try := 0
@@ -102,6 +108,9 @@ func buildletClient(ctx context.Context, buildletURL, ipPort string, opts *VMOpt
 		log.Printf("probing buildlet at %s with attempt %d failed: %s", buildletURL, try, err)
 		time.Sleep(time.Second)
 	}
+	if opts.SessionCA != nil {
+		return NewSessionClient(ipPort, opts.TLS, *opts.SessionCA, session), nil
+	}
 	return NewClient(ipPort, opts.TLS), nil
 }
 
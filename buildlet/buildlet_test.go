@@ -37,7 +37,7 @@ func TestBuildletClient(t *testing.T) {
 		OnEndBuildletProbe:   func(*http.Response, error) { OnEndBuildletProbeCalled = true },
 	}
 
-	gotClient, gotErr := buildletClient(context.Background(), ts.URL, u.Host, opt)
+	gotClient, gotErr := buildletClient(context.Background(), ts.URL, u.Host, "", opt)
 	if gotErr != nil {
 		t.Errorf("buildletClient(ctx, %s, %s, %v) error %s", ts.URL, u.Host, opt, gotErr)
 	}
@@ -81,7 +81,7 @@ func TestBuildletClientError(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
-	gotClient, gotErr := buildletClient(ctx, ts.URL, u.Host, opt)
+	gotClient, gotErr := buildletClient(ctx, ts.URL, u.Host, "", opt)
 	if gotErr == nil {
 		t.Errorf("buildletClient(ctx, %s, %s, %v) error %s", ts.URL, u.Host, opt, gotErr)
 	}
@@ -6,7 +6,9 @@ package buildlet // import "golang.org/x/build/buildlet"
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -31,18 +34,28 @@ var _ Client = (*client)(nil)
 //
 // This constructor returns immediately without testing the host or auth.
 func NewClient(ipPort string, kp KeyPair) Client {
+	return newClient(ipPort, kp, nil)
+}
+
+// newClient returns a *client that authenticates with TLS client
+// certificates from clientCert if it's non-nil, or else with kp's
+// password.
+func newClient(ipPort string, kp KeyPair, clientCert func() (*tls.Certificate, error)) *client {
 	tr := &http.Transport{
 		Dial:            defaultDialer(),
-		DialTLS:         kp.tlsDialer(),
+		DialTLS:         kp.tlsDialer(clientCert),
 		IdleConnTimeout: time.Minute,
 	}
 	c := &client{
 		ipPort:     ipPort,
 		tls:        kp,
-		password:   kp.Password(),
+		clientCert: clientCert,
 		httpClient: &http.Client{Transport: tr},
 		closeFuncs: []func(){tr.CloseIdleConnections},
 	}
+	if clientCert == nil {
+		c.password = kp.Password()
+	}
 	c.setCommon()
 	return c
 }
@@ -157,6 +170,7 @@ func defaultDialer() func(network, addr string) (net.Conn, error) {
 type client struct {
 	ipPort          string // required, unless remoteBuildlet+baseURL is set
 	tls             KeyPair
+	clientCert      func() (*tls.Certificate, error) // optional mutual TLS client certificate source
 	httpClient      *http.Client
 	dialer          func(context.Context) (net.Conn, error) // nil means to use net.Dialer.DialContext
 	baseURL         string                                  // optional baseURL (used by remote buildlets)
@@ -378,7 +392,12 @@ func (c *client) doHeaderTimeout(req *http.Request, max time.Duration) (res *htt
 		}
 		// Clean up our cancel context above when the caller
 		// reads to the end of the response body or closes.
-		re.res.Body = onEOFReadCloser{re.res.Body, cancel}
+		if rwc, ok := re.res.Body.(io.ReadWriteCloser); ok {
+			// An upgraded connection; keep it writable.
+			re.res.Body = onEOFReadWriteCloser{onEOFReadCloser{rwc, cancel}, rwc}
+		} else {
+			re.res.Body = onEOFReadCloser{re.res.Body, cancel}
+		}
 		return re.res, nil
 	case <-c.peerDead:
 		log.Printf("%s: peer dead with %v, waiting for headers for %v", c.Name(), c.deadErr, req.URL.Path)
@@ -405,6 +424,39 @@ func (c *client) doOK(req *http.Request) error {
 	return nil
 }
 
+// doForm sends form to endpoint, in the URL for GET requests and in
+// the body otherwise, and returns the response if it's a 200 OK. A 404
+// Not Found error wraps os.ErrNotExist.
+func (c *client) doForm(ctx context.Context, method, endpoint string, form url.Values) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequest("GET", c.URL()+endpoint+"?"+form.Encode(), nil)
+	} else {
+		req, err = http.NewRequest(method, c.URL()+endpoint, strings.NewReader(form.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		res.Body.Close()
+		msg := strings.TrimSpace(string(slurp))
+		if res.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("buildlet: %s: %w: %s", endpoint, os.ErrNotExist, msg)
+		}
+		return nil, fmt.Errorf("buildlet: %s: HTTP status %v: %s", endpoint, res.Status, msg)
+	}
+	return res, nil
+}
+
 // PutTar writes files to the remote buildlet, rooted at the relative
 // directory dir.
 // If dir is empty, they're placed at the root of the buildlet's work directory.
@@ -467,6 +519,186 @@ func (c *client) GetTar(ctx context.Context, dir string) (io.ReadCloser, error)
 	return res.Body, nil
 }
 
+// ArchiveFormat is an archive file format for UploadArchive.
+type ArchiveFormat string
+
+const (
+	ArchiveTGZ ArchiveFormat = "tgz" // gzip-compressed tar
+	ArchiveZip ArchiveFormat = "zip"
+)
+
+// UploadResult describes an archive uploaded by UploadArchive.
+type UploadResult struct {
+	Size   int64  `json:"size"`   // size of the archive in bytes
+	SHA256 string `json:"sha256"` // hex SHA-256 of the archive
+}
+
+// UploadArchive makes an archive of dir, a directory relative to the
+// work directory, and has the buildlet upload it with a PUT request to
+// putURL, such as a pre-signed object storage URL. It's the reverse of
+// PutTarFromURL, and unlike GetTar the archive doesn't pass through the
+// caller. The archive is sent with a Content-Length, as S3 requires.
+// It requires buildlet version 31 or later.
+func (c *client) UploadArchive(ctx context.Context, dir string, format ArchiveFormat, putURL string) (*UploadResult, error) {
+	form := url.Values{
+		"dir":    {dir},
+		"format": {string(format)},
+		"url":    {putURL},
+	}
+	req, err := http.NewRequest("POST", c.URL()+"/uploadarchive", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		return nil, fmt.Errorf("%v; body: %s", res.Status, slurp)
+	}
+	var ur UploadResult
+	if err := json.NewDecoder(res.Body).Decode(&ur); err != nil {
+		return nil, fmt.Errorf("decoding upload result: %v", err)
+	}
+	return &ur, nil
+}
+
+// A BlobFile describes a file to be written by WriteBlobs from the
+// buildlet's content-addressed blob cache.
+type BlobFile struct {
+	// Path is the forward-slash separated path of the file,
+	// relative to the directory passed to WriteBlobs.
+	Path string `json:"path"`
+
+	// Mode is the file's mode. Only regular files and
+	// directories are supported.
+	Mode os.FileMode `json:"mode"`
+
+	// Digest is the lowercase hex SHA-1 digest of the file's
+	// contents, as also reported by DirEntry.Digest.
+	// It is ignored for directories.
+	Digest string `json:"digest,omitempty"`
+
+	// Size is the size of a regular file's contents in bytes.
+	// It's used by PutFiles and ignored by WriteBlobs.
+	Size int64 `json:"size,omitempty"`
+
+	// ModTime, if non-zero, is the modification time to give
+	// a regular file, as /writetgz does for tar entries.
+	ModTime time.Time `json:"modTime,omitempty"`
+}
+
+// ErrBlobsUnsupported is returned by the blob cache methods when the
+// buildlet is too old to have a blob cache.
+var ErrBlobsUnsupported = errors.New("buildlet: blob cache not supported by buildlet")
+
+// ErrBlobsMissing is returned by WriteBlobs when a blob it needs isn't
+// in the buildlet's blob cache, such as when it was evicted after
+// MissingBlobs reported it present.
+var ErrBlobsMissing = errors.New("buildlet: blob missing from cache")
+
+// MissingBlobs reports which of the provided SHA-1 digests are not
+// present in the buildlet's blob cache.
+func (c *client) MissingBlobs(ctx context.Context, digests []string) ([]string, error) {
+	if len(digests) == 0 {
+		return nil, nil
+	}
+	form := url.Values{"digest": digests}
+	req, err := http.NewRequest("POST", c.URL()+"/blobs/missing", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrBlobsUnsupported
+	}
+	if res.StatusCode != http.StatusOK {
+		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		return nil, fmt.Errorf("%v; body: %s", res.Status, slurp)
+	}
+	var missing []string
+	sc := bufio.NewScanner(res.Body)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			missing = append(missing, line)
+		}
+	}
+	return missing, sc.Err()
+}
+
+// PutBlob adds the contents of r to the buildlet's blob cache.
+// The buildlet rejects the blob if its SHA-1 digest is not digest.
+func (c *client) PutBlob(ctx context.Context, r io.Reader, digest string) error {
+	req, err := http.NewRequest("PUT", c.URL()+"/blobs/put?digest="+url.QueryEscape(digest), r)
+	if err != nil {
+		return err
+	}
+	return c.doOK(req.WithContext(ctx))
+}
+
+// PutBlobsTgz adds the regular files in the tar.gz stream r to the
+// buildlet's blob cache. Each file must be named by the SHA-1 digest
+// of its contents; the buildlet rejects files whose contents don't
+// match their name.
+func (c *client) PutBlobsTgz(ctx context.Context, r io.Reader) error {
+	req, err := http.NewRequest("PUT", c.URL()+"/blobs/puttgz", r)
+	if err != nil {
+		return err
+	}
+	res, err := c.do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return ErrBlobsUnsupported
+	}
+	if res.StatusCode != http.StatusOK {
+		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		return fmt.Errorf("%v; body: %s", res.Status, slurp)
+	}
+	return nil
+}
+
+// WriteBlobs writes files from the buildlet's blob cache into dir, a
+// relative directory from the workdir. If dir is empty, they're
+// placed at the root of the buildlet's work directory. Every digest
+// referenced by files must already be present in the cache; see
+// MissingBlobs and PutBlob. If one isn't, the error wraps
+// ErrBlobsMissing.
+func (c *client) WriteBlobs(ctx context.Context, dir string, files []BlobFile) error {
+	manifest, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.URL()+"/writeblobs?dir="+url.QueryEscape(dir), bytes.NewReader(manifest))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		if res.StatusCode == http.StatusConflict {
+			return fmt.Errorf("%w: %s", ErrBlobsMissing, bytes.TrimSpace(slurp))
+		}
+		return fmt.Errorf("%v; body: %s", res.Status, slurp)
+	}
+	return nil
+}
+
 // ExecOpts are options for a remote command invocation.
 type ExecOpts struct {
 	// Output is the output of stdout and stderr.
@@ -507,12 +739,109 @@ type ExecOpts struct {
 	// response from the buildlet, but before the output begins
 	// writing to Output.
 	OnStartExec func()
+
+	// Timeout, if positive, is how long the command may run before
+	// the buildlet kills it. Unlike a context deadline, the buildlet
+	// stays healthy and reports the kill as ExecResult.Limit.
+	Timeout time.Duration
+
+	// MaxMemory, if positive, is the maximum memory in bytes the
+	// command may use. On Linux it is enforced with a cgroup v2
+	// memory.max if possible and RLIMIT_AS otherwise; on other Unix
+	// systems with RLIMIT_AS via ulimit. It is not supported on
+	// Windows or Plan 9.
+	MaxMemory int64
+
+	// MaxProcs, if positive, is the maximum number of processes the
+	// command may have running. It is enforced like MaxMemory, with
+	// a cgroup v2 pids.max or RLIMIT_NPROC. Note that RLIMIT_NPROC
+	// counts all of the user's processes and doesn't apply to root.
+	MaxProcs int
+
+	// Stdin, if non-nil, is streamed to the command's standard
+	// input, which is otherwise empty. It requires a buildlet of
+	// version 29 or later. As with os/exec, the command may exit
+	// before Stdin has been read to EOF.
+	Stdin io.Reader
 }
 
 // ErrTimeout is a sentinel error that represents that waiting
 // for a command to complete has exceeded the given timeout.
 var ErrTimeout = errors.New("buildlet: timeout waiting for command to complete")
 
+// ExecResult describes the outcome of a command run by ExecWithResult.
+type ExecResult struct {
+	// State is "ok" if the command succeeded, or otherwise a
+	// description of how it failed, such as "exit status 1" or
+	// "signal: killed".
+	State string `json:"state"`
+
+	// ExitCode is the process's exit code, or -1 if the process
+	// was terminated by a signal or the exit code is unknown.
+	ExitCode int `json:"exitCode"`
+
+	// Signal is the name of the signal that terminated the
+	// process, such as "killed". It is empty if the process
+	// exited normally or the buildlet's OS has no signals.
+	Signal string `json:"signal,omitempty"`
+
+	// WallTime is how long the process ran.
+	WallTime time.Duration `json:"wallTime"`
+
+	// UserTime and SystemTime are the user and system CPU time
+	// of the process and its waited-for children.
+	UserTime   time.Duration `json:"userTime"`
+	SystemTime time.Duration `json:"systemTime"`
+
+	// MaxRSS is the peak resident set size of the process in
+	// bytes, or 0 if unknown.
+	MaxRSS int64 `json:"maxRSS,omitempty"`
+
+	// TimedOut reports whether the buildlet killed the process
+	// because it ran out of time.
+	TimedOut bool `json:"timedOut,omitempty"`
+
+	// Limit is the ExecOpts limit the process exceeded, if any:
+	// LimitTimeout, LimitMemory or LimitProcs. Memory and process
+	// limits are only detected when enforced with Linux cgroups.
+	Limit string `json:"limit,omitempty"`
+
+	// Partial reports whether the result was reconstructed from
+	// an older buildlet's Process-State trailer, in which case only
+	// State and ExitCode are meaningful.
+	Partial bool `json:"-"`
+}
+
+// Values of ExecResult.Limit.
+const (
+	LimitTimeout = "timeout"
+	LimitMemory  = "memory"
+	LimitProcs   = "procs"
+)
+
+// Err returns nil if the command succeeded, or an error describing
+// how it failed. It matches the remoteErr result of Exec.
+func (r *ExecResult) Err() error {
+	if r.State == "ok" {
+		return nil
+	}
+	if r.Limit != "" {
+		return fmt.Errorf("%s (%s limit exceeded)", r.State, r.Limit)
+	}
+	return errors.New(r.State)
+}
+
+// ProcessResultVersion is the version of the JSON-encoded ExecResult
+// sent by the buildlet in the Process-Result HTTP trailer of an /exec
+// response. It is bumped whenever the encoding changes incompatibly.
+const ProcessResultVersion = 1
+
+// ProcessResult is the wire form of an ExecResult.
+type ProcessResult struct {
+	Version int `json:"v"`
+	ExecResult
+}
+
 // Exec runs cmd on the buildlet.
 //
 // Two errors are returned: one is whether the command succeeded
@@ -524,24 +853,28 @@ var ErrTimeout = errors.New("buildlet: timeout waiting for command to complete")
 // If the context's deadline is exceeded while waiting for the command
 // to complete, the returned execErr is ErrTimeout.
 func (c *client) Exec(ctx context.Context, cmd string, opts ExecOpts) (remoteErr, execErr error) {
-	var mode string
-	if opts.SystemLevel {
-		mode = "sys"
+	res, execErr := c.ExecWithResult(ctx, cmd, opts)
+	if execErr != nil {
+		return nil, execErr
 	}
-	path := opts.Path
-	if len(path) == 0 && path != nil {
-		// url.Values doesn't distinguish between a nil slice and
-		// a non-nil zero-length slice, so use this sentinel value.
-		path = []string{"$EMPTY"}
+	return res.Err(), nil
+}
+
+// ExecWithResult is like Exec, but instead of a remote error it returns
+// a structured description of how the command ended, including its
+// exit code and resource usage. The returned error is non-nil only if
+// the command could not be started or seen to completion, in which
+// case the result is nil.
+func (c *client) ExecWithResult(ctx context.Context, cmd string, opts ExecOpts) (*ExecResult, error) {
+	form := execForm(cmd, opts)
+	if opts.Timeout > 0 {
+		form.Set("timeout", opts.Timeout.String())
 	}
-	form := url.Values{
-		"cmd":    {cmd},
-		"mode":   {mode},
-		"dir":    {opts.Dir},
-		"cmdArg": opts.Args,
-		"env":    opts.ExtraEnv,
-		"path":   path,
-		"debug":  {fmt.Sprint(opts.Debug)},
+	if opts.MaxMemory > 0 {
+		form.Set("maxMemory", fmt.Sprint(opts.MaxMemory))
+	}
+	if opts.MaxProcs > 0 {
+		form.Set("maxProcs", fmt.Sprint(opts.MaxProcs))
 	}
 	req, err := http.NewRequest("POST", c.URL()+"/exec", strings.NewReader(form.Encode()))
 	if err != nil {
@@ -549,6 +882,12 @@ func (c *client) Exec(ctx context.Context, cmd string, opts ExecOpts) (remoteErr
 	}
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	wantStatus := http.StatusOK
+	if opts.Stdin != nil {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", ExecStreamProto)
+		wantStatus = http.StatusSwitchingProtocols
+	}
 
 	// The first thing the buildlet's exec handler does is flush the headers, so
 	// 20 seconds should be plenty of time, regardless of where on the planet
@@ -563,24 +902,41 @@ func (c *client) Exec(ctx context.Context, cmd string, opts ExecOpts) (remoteErr
 		return nil, err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
+	if res.StatusCode != wantStatus {
+		if opts.Stdin != nil && res.StatusCode == http.StatusOK {
+			return nil, errors.New("buildlet: Stdin requires buildlet version 29 or later")
+		}
 		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
 		return nil, fmt.Errorf("buildlet: HTTP status %v: %s", res.Status, slurp)
 	}
+	var stream io.ReadWriteCloser
+	if opts.Stdin != nil {
+		var ok bool
+		if stream, ok = res.Body.(io.ReadWriteCloser); !ok {
+			return nil, errors.New("buildlet: exec stream response was not a Writer")
+		}
+	}
 	condRun(opts.OnStartExec)
 
-	type errs struct {
-		remoteErr, execErr error
+	type resultErr struct {
+		res *ExecResult
+		err error
 	}
-	resc := make(chan errs, 1)
+	resc := make(chan resultErr, 1)
 	go func() {
+		if stream != nil {
+			r, err := runExecStream(ctx, stream, opts.Stdin, opts.Output)
+			resc <- resultErr{r, err}
+			return
+		}
+
 		// Stream the output:
 		out := opts.Output
 		if out == nil {
 			out = ioutil.Discard
 		}
 		if _, err := io.Copy(out, res.Body); err != nil {
-			resc <- errs{execErr: fmt.Errorf("error copying response: %w", err)}
+			resc <- resultErr{err: fmt.Errorf("error copying response: %w", err)}
 			return
 		}
 
@@ -589,20 +945,12 @@ func (c *client) Exec(ctx context.Context, cmd string, opts ExecOpts) (remoteErr
 		// (like the VM being killed, or the buildlet crashing due to
 		// e.g. https://golang.org/issue/9309, since we require a tip
 		// build of the buildlet to get Trailers support)
-		state := res.Trailer.Get("Process-State")
-		if state == "" {
-			resc <- errs{execErr: errors.New("missing Process-State trailer from HTTP response; buildlet built with old (<= 1.4) Go?")}
-			return
-		}
-		if state != "ok" {
-			resc <- errs{remoteErr: errors.New(state)}
-		} else {
-			resc <- errs{} // success
-		}
+		r, err := parseExecTrailer(res.Trailer)
+		resc <- resultErr{r, err}
 	}()
 	select {
 	case res := <-resc:
-		if res.execErr != nil {
+		if res.err != nil {
 			// Note: We've historically marked the buildlet as unhealthy after
 			// reaching any kind of execution error, even when it's a remote command
 			// execution timeout (see use of ErrTimeout below).
@@ -611,16 +959,70 @@ func (c *client) Exec(ctx context.Context, cmd string, opts ExecOpts) (remoteErr
 			// such a condition and not mark it as unhealthy.
 
 			c.MarkBroken()
-			if errors.Is(res.execErr, context.DeadlineExceeded) {
-				res.execErr = ErrTimeout
+			if errors.Is(res.err, context.DeadlineExceeded) {
+				res.err = ErrTimeout
 			}
+			return nil, res.err
 		}
-		return res.remoteErr, res.execErr
+		return res.res, nil
 	case <-c.peerDead:
 		return nil, c.deadErr
 	}
 }
 
+// execForm returns the request parameters describing how to run cmd
+// that are common to /exec and /jobs/start.
+func execForm(cmd string, opts ExecOpts) url.Values {
+	var mode string
+	if opts.SystemLevel {
+		mode = "sys"
+	}
+	path := opts.Path
+	if len(path) == 0 && path != nil {
+		// url.Values doesn't distinguish between a nil slice and
+		// a non-nil zero-length slice, so use this sentinel value.
+		path = []string{"$EMPTY"}
+	}
+	return url.Values{
+		"cmd":    {cmd},
+		"mode":   {mode},
+		"dir":    {opts.Dir},
+		"cmdArg": opts.Args,
+		"env":    opts.ExtraEnv,
+		"path":   path,
+		"debug":  {fmt.Sprint(opts.Debug)},
+	}
+}
+
+// parseExecTrailer returns the result of an /exec request from its
+// HTTP trailer. Buildlets older than version 27 only send the
+// Process-State trailer, from which a partial result is constructed.
+func parseExecTrailer(trailer http.Header) (*ExecResult, error) {
+	if v := trailer.Get("Process-Result"); v != "" {
+		var pr ProcessResult
+		if err := json.Unmarshal([]byte(v), &pr); err != nil {
+			return nil, fmt.Errorf("malformed Process-Result trailer %q: %v", v, err)
+		}
+		if pr.Version != ProcessResultVersion {
+			return nil, fmt.Errorf("unsupported Process-Result trailer version %d; want %d", pr.Version, ProcessResultVersion)
+		}
+		return &pr.ExecResult, nil
+	}
+	state := trailer.Get("Process-State")
+	if state == "" {
+		return nil, errors.New("missing Process-State trailer from HTTP response; buildlet built with old (<= 1.4) Go?")
+	}
+	r := &ExecResult{State: state, ExitCode: -1, Partial: true}
+	if state == "ok" {
+		r.ExitCode = 0
+	} else if v := strings.TrimPrefix(state, "exit status "); v != state {
+		if code, err := strconv.Atoi(v); err == nil {
+			r.ExitCode = code
+		}
+	}
+	return r, nil
+}
+
 // RemoveAll deletes the provided paths, relative to the work directory.
 func (c *client) RemoveAll(ctx context.Context, paths ...string) error {
 	if len(paths) == 0 {
@@ -838,10 +1240,132 @@ func (c *client) ListDir(ctx context.Context, dir string, opts ListDirOpts, fn f
 	return sc.Err()
 }
 
+// FileInfo describes a file on a buildlet, as returned by Client.Stat.
+type FileInfo struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+}
+
+// IsDir reports whether fi describes a directory.
+func (fi FileInfo) IsDir() bool { return fi.Mode.IsDir() }
+
+// doFileOp sends a request for a single-file operation and returns
+// the response if it's a 200 OK. Otherwise the error is an
+// *os.PathError for op and path, which wraps os.ErrNotExist if the
+// file wasn't found.
+func (c *client) doFileOp(req *http.Request, op, path string) (*http.Response, error) {
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusOK {
+		return res, nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+	return nil, &os.PathError{Op: op, Path: path, Err: fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(slurp))}
+}
+
+// postFileOp sends form to the single-file operation endpoint and
+// expects a 200 OK response.
+func (c *client) postFileOp(ctx context.Context, endpoint string, form url.Values, op, path string) error {
+	req, err := http.NewRequest("POST", c.URL()+endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.doFileOp(req.WithContext(ctx), op, path)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Stat returns information about the file at path, relative to the
+// work directory. An empty path refers to the work directory itself.
+// It requires buildlet version 30 or later.
+func (c *client) Stat(ctx context.Context, path string) (FileInfo, error) {
+	req, err := http.NewRequest("GET", c.URL()+"/stat?"+url.Values{"path": {path}}.Encode(), nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	res, err := c.doFileOp(req.WithContext(ctx), "stat", path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer res.Body.Close()
+	var fi FileInfo
+	if err := json.NewDecoder(res.Body).Decode(&fi); err != nil {
+		return FileInfo{}, fmt.Errorf("decoding stat response: %v", err)
+	}
+	return fi, nil
+}
+
+// Mkdir creates the directory path, relative to the work directory,
+// along with any necessary parents, like os.MkdirAll.
+// It requires buildlet version 30 or later.
+func (c *client) Mkdir(ctx context.Context, path string, mode os.FileMode) error {
+	form := url.Values{
+		"path": {path},
+		"mode": {fmt.Sprint(uint32(mode))},
+	}
+	return c.postFileOp(ctx, "/mkdir", form, "mkdir", path)
+}
+
+// Rename renames oldpath to newpath, both relative to the work
+// directory, like os.Rename.
+// It requires buildlet version 30 or later.
+func (c *client) Rename(ctx context.Context, oldpath, newpath string) error {
+	form := url.Values{
+		"oldpath": {oldpath},
+		"newpath": {newpath},
+	}
+	return c.postFileOp(ctx, "/rename", form, "rename", oldpath)
+}
+
+// Chmod changes the mode of path, relative to the work directory,
+// like os.Chmod.
+// It requires buildlet version 30 or later.
+func (c *client) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	form := url.Values{
+		"path": {path},
+		"mode": {fmt.Sprint(uint32(mode))},
+	}
+	return c.postFileOp(ctx, "/chmod", form, "chmod", path)
+}
+
+// ReadFile returns the contents of the regular file path, relative to
+// the work directory, starting at offset. If length is non-negative,
+// at most length bytes are returned. The caller must close the
+// returned ReadCloser.
+// It requires buildlet version 30 or later.
+func (c *client) ReadFile(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	param := url.Values{
+		"path":   {path},
+		"offset": {fmt.Sprint(offset)},
+		"length": {fmt.Sprint(length)},
+	}
+	req, err := http.NewRequest("GET", c.URL()+"/readfile?"+param.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.doFileOp(req.WithContext(ctx), "read", path)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
 func (c *client) getDialer() func(context.Context) (net.Conn, error) {
 	if !c.tls.IsZero() {
 		return func(_ context.Context) (net.Conn, error) {
-			return c.tls.tlsDialer()("tcp", c.ipPort)
+			return c.tls.tlsDialer(c.clientCert)("tcp", c.ipPort)
 		}
 	}
 	if c.dialer != nil {
@@ -924,3 +1448,10 @@ func (o onEOFReadCloser) Close() error {
 	o.fn()
 	return o.rc.Close()
 }
+
+type onEOFReadWriteCloser struct {
+	onEOFReadCloser
+	w io.Writer
+}
+
+func (o onEOFReadWriteCloser) Write(p []byte) (int, error) { return o.w.Write(p) }
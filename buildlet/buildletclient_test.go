@@ -15,6 +15,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestConnectSSHTLS(t *testing.T) {
@@ -225,3 +226,76 @@ func (c deadlineOnDemandContext) Err() error {
 		return context.DeadlineExceeded
 	}
 }
+
+func TestExecWithResult(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		trailers map[string]string
+		want     ExecResult
+		wantErr  bool
+	}{
+		{
+			desc: "process-result",
+			trailers: map[string]string{
+				"Process-State":  "signal: killed",
+				"Process-Result": `{"v":1,"state":"signal: killed","exitCode":-1,"signal":"killed","wallTime":2000000000,"maxRSS":1048576}`,
+			},
+			want: ExecResult{State: "signal: killed", ExitCode: -1, Signal: "killed", WallTime: 2 * time.Second, MaxRSS: 1 << 20},
+		},
+		{
+			desc:     "old-buildlet-ok",
+			trailers: map[string]string{"Process-State": "ok"},
+			want:     ExecResult{State: "ok", ExitCode: 0, Partial: true},
+		},
+		{
+			desc:     "old-buildlet-exit-status",
+			trailers: map[string]string{"Process-State": "exit status 3"},
+			want:     ExecResult{State: "exit status 3", ExitCode: 3, Partial: true},
+		},
+		{
+			desc: "unknown-version",
+			trailers: map[string]string{
+				"Process-State":  "ok",
+				"Process-Result": `{"v":99,"state":"ok"}`,
+			},
+			wantErr: true,
+		},
+		{
+			desc:    "no-trailer",
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				for k := range tc.trailers {
+					w.Header().Add("Trailer", k)
+				}
+				w.(http.Flusher).Flush()
+				for k, v := range tc.trailers {
+					w.Header().Set(k, v)
+				}
+			}))
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatalf("unable to parse http server url %s", err)
+			}
+			cl := NewClient(u.Host, NoKeyPair)
+			defer cl.Close()
+			got, err := cl.ExecWithResult(context.Background(), "./bin/test", ExecOpts{})
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("cl.ExecWithResult = %+v, nil; want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cl.ExecWithResult error = %v; want no error", err)
+			}
+			if *got != tc.want {
+				t.Errorf("cl.ExecWithResult = %+v; want %+v", *got, tc.want)
+			}
+		})
+	}
+}
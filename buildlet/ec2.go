@@ -82,7 +82,7 @@ func (c *EC2Client) StartNewVM(ctx context.Context, buildEnv *buildenv.Environme
 	if err != nil {
 		return nil, err
 	}
-	return buildletClient(ctx, buildletURL, ipPort, opts)
+	return buildletClient(ctx, buildletURL, ipPort, vmName, opts)
 }
 
 // createVM submits a request for the creation of a VM.
@@ -137,6 +137,11 @@ func vmUserDataSpec(buildEnv *buildenv.Environment, hconf *dashboard.HostConfig,
 	for k, v := range opts.Meta {
 		ud.Metadata[k] = v
 	}
+	if ca := opts.SessionCA; ca != nil {
+		ud.TLSPassword = ""
+		ud.Metadata["tls-client-ca"] = ca.CertPEM
+		ud.Metadata["tls-client-session"] = vmName
+	}
 	return ud.EncodedString()
 }
 
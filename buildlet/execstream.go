@@ -0,0 +1,141 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildlet
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ExecStreamProto is the protocol that an /exec request asks to
+// upgrade its connection to when ExecOpts.Stdin is set. After the
+// buildlet's 101 Switching Protocols response, both directions carry
+// frames written by WriteExecFrame: a one byte kind, a big-endian
+// uint32 payload length, and the payload.
+//
+// The client sends ExecFrameStdin frames and then an ExecFrameStdinEOF
+// frame. The buildlet sends ExecFrameOutput frames and then a single
+// ExecFrameResult frame, a JSON ProcessResult, before closing the
+// connection. The command is killed if the connection is closed
+// before it exits.
+const ExecStreamProto = "buildlet-exec"
+
+// Frame kinds of the ExecStreamProto protocol.
+const (
+	ExecFrameStdin    = 'i' // stdin data, from the client
+	ExecFrameStdinEOF = 'c' // close stdin, from the client
+	ExecFrameOutput   = 'o' // stdout and stderr data, from the buildlet
+	ExecFrameResult   = 'r' // JSON ProcessResult, from the buildlet
+)
+
+// MaxExecFrameSize is the largest payload of an ExecStreamProto frame.
+const MaxExecFrameSize = 1 << 20
+
+// WriteExecFrame writes an ExecStreamProto frame of the given kind
+// with payload p, which must be at most MaxExecFrameSize bytes.
+func WriteExecFrame(w io.Writer, kind byte, p []byte) error {
+	var hdr [5]byte
+	hdr[0] = kind
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(p)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// ReadExecFrame reads an ExecStreamProto frame from r.
+func ReadExecFrame(r io.Reader) (kind byte, p []byte, err error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > MaxExecFrameSize {
+		return 0, nil, fmt.Errorf("exec stream frame of %d bytes exceeds limit", n)
+	}
+	p = make([]byte, n)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return hdr[0], p, nil
+}
+
+// runExecStream sends stdin over the upgraded /exec connection rwc,
+// copies the command's output to out, and returns the command's result.
+// It closes rwc when done or when ctx is done.
+func runExecStream(ctx context.Context, rwc io.ReadWriteCloser, stdin io.Reader, out io.Writer) (*ExecResult, error) {
+	if out == nil {
+		out = ioutil.Discard
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			rwc.Close()
+		case <-done:
+			rwc.Close()
+		}
+	}()
+
+	// Like os/exec, don't wait for stdin to reach EOF once the
+	// command has exited: it may be a terminal.
+	go func() {
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				if werr := WriteExecFrame(rwc, ExecFrameStdin, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				WriteExecFrame(rwc, ExecFrameStdinEOF, nil)
+				return
+			}
+		}
+	}()
+
+	br := bufio.NewReader(rwc)
+	for {
+		kind, p, err := ReadExecFrame(br)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err == io.EOF {
+				err = errors.New("exec stream closed without a process result")
+			}
+			return nil, fmt.Errorf("error reading exec stream: %w", err)
+		}
+		switch kind {
+		case ExecFrameOutput:
+			if _, err := out.Write(p); err != nil {
+				return nil, fmt.Errorf("error copying response: %w", err)
+			}
+		case ExecFrameResult:
+			var pr ProcessResult
+			if err := json.Unmarshal(p, &pr); err != nil {
+				return nil, fmt.Errorf("malformed exec stream result %q: %v", p, err)
+			}
+			if pr.Version != ProcessResultVersion {
+				return nil, fmt.Errorf("unsupported exec stream result version %d; want %d", pr.Version, ProcessResultVersion)
+			}
+			return &pr.ExecResult, nil
+		default:
+			return nil, fmt.Errorf("unexpected exec stream frame kind %q", kind)
+		}
+	}
+}
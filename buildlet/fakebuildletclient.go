@@ -23,33 +23,53 @@ import (
 type Client interface {
 	AddCloseFunc(fn func())
 	Close() error
+	Chmod(ctx context.Context, path string, mode os.FileMode) error
 	ConnectSSH(user, authorizedPubKey string) (net.Conn, error)
+	DeleteSnapshot(ctx context.Context, name string) error
 	DestroyVM(ts oauth2.TokenSource, proj, zone, instance string) error
 	Exec(ctx context.Context, cmd string, opts ExecOpts) (remoteErr, execErr error)
+	ExecWithResult(ctx context.Context, cmd string, opts ExecOpts) (*ExecResult, error)
 	GCEInstanceName() string
 	GetTar(ctx context.Context, dir string) (io.ReadCloser, error)
 	IPPort() string
 	IsBroken() bool
+	JobOutput(ctx context.Context, id string, offset int64, follow bool) (io.ReadCloser, error)
+	Jobs(ctx context.Context) ([]JobInfo, error)
 	ListDir(ctx context.Context, dir string, opts ListDirOpts, fn func(DirEntry)) error
 	MarkBroken()
+	MissingBlobs(ctx context.Context, digests []string) ([]string, error)
+	Mkdir(ctx context.Context, path string, mode os.FileMode) error
 	Name() string
 	ProxyRoundTripper() http.RoundTripper
 	ProxyTCP(port int) (io.ReadWriteCloser, error)
 	Put(ctx context.Context, r io.Reader, path string, mode os.FileMode) error
+	PutBlob(ctx context.Context, r io.Reader, digest string) error
+	PutBlobsTgz(ctx context.Context, r io.Reader) error
 	PutTar(ctx context.Context, r io.Reader, dir string) error
 	PutTarFromURL(ctx context.Context, tarURL, dir string) error
+	ReadFile(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error)
 	RemoteName() string
 	RemoveAll(ctx context.Context, paths ...string) error
+	RemoveJob(ctx context.Context, id string) error
+	Rename(ctx context.Context, oldpath, newpath string) error
+	RestoreSnapshot(ctx context.Context, name, dir string) (method string, err error)
 	SetDescription(v string)
 	SetDialer(dialer func(context.Context) (net.Conn, error))
 	SetGCEInstanceName(v string)
 	SetHTTPClient(httpClient *http.Client)
 	SetName(name string)
+	SignalJob(ctx context.Context, id, signal string) error
 	SetOnHeartbeatFailure(fn func())
+	Snapshot(ctx context.Context, name, dir string) (*SnapshotInfo, error)
+	Snapshots(ctx context.Context) ([]SnapshotInfo, error)
+	StartJob(ctx context.Context, cmd string, opts ExecOpts) (*JobInfo, error)
+	Stat(ctx context.Context, path string) (FileInfo, error)
 	Status(ctx context.Context) (Status, error)
 	String() string
+	UploadArchive(ctx context.Context, dir string, format ArchiveFormat, putURL string) (*UploadResult, error)
 	URL() string
 	WorkDir(ctx context.Context) (string, error)
+	WriteBlobs(ctx context.Context, dir string, files []BlobFile) error
 }
 
 var errUnimplemented = errors.New("unimplemented function")
@@ -76,11 +96,19 @@ func (fc *FakeClient) Close() error {
 	return nil
 }
 
+// Chmod changes the mode of a file on a fake buildlet.
+func (fc *FakeClient) Chmod(ctx context.Context, path string, mode os.FileMode) error {
+	return errUnimplemented
+}
+
 // ConnectSSH connects to a fake SSH server.
 func (fc *FakeClient) ConnectSSH(user, authorizedPubKey string) (net.Conn, error) {
 	return nil, errUnimplemented
 }
 
+// DeleteSnapshot fakes deleting a snapshot.
+func (fc *FakeClient) DeleteSnapshot(ctx context.Context, name string) error { return errUnimplemented }
+
 // DestroyVM destroys a fake VM.
 func (fc *FakeClient) DestroyVM(ts oauth2.TokenSource, proj, zone, instance string) error {
 	return errUnimplemented
@@ -103,6 +131,18 @@ func (fc *FakeClient) Exec(ctx context.Context, cmd string, opts ExecOpts) (remo
 	return nil, nil
 }
 
+// ExecWithResult fakes the execution, reporting a successful result.
+func (fc *FakeClient) ExecWithResult(ctx context.Context, cmd string, opts ExecOpts) (*ExecResult, error) {
+	remoteErr, execErr := fc.Exec(ctx, cmd, opts)
+	if execErr != nil {
+		return nil, execErr
+	}
+	if remoteErr != nil {
+		return &ExecResult{State: remoteErr.Error(), ExitCode: 1}, nil
+	}
+	return &ExecResult{State: "ok"}, nil
+}
+
 // GCEInstanceName gives the fake instance name.
 func (fc *FakeClient) GCEInstanceName() string { return fc.instanceName }
 
@@ -118,6 +158,14 @@ func (fc *FakeClient) IPPort() string { return "" }
 // IsBroken returns a fake broken response.
 func (fc *FakeClient) IsBroken() bool { return false }
 
+// JobOutput fakes reading a job's output.
+func (fc *FakeClient) JobOutput(ctx context.Context, id string, offset int64, follow bool) (io.ReadCloser, error) {
+	return nil, errUnimplemented
+}
+
+// Jobs fakes listing jobs.
+func (fc *FakeClient) Jobs(ctx context.Context) ([]JobInfo, error) { return nil, errUnimplemented }
+
 // ListDir lists a directory on a fake buildlet.
 func (fc *FakeClient) ListDir(ctx context.Context, dir string, opts ListDirOpts, fn func(DirEntry)) error {
 	if dir == "" || fn == nil {
@@ -135,6 +183,16 @@ drwxr-xr-x      tmp/`
 // MarkBroken marks the fake client as broken.
 func (fc *FakeClient) MarkBroken() {}
 
+// MissingBlobs reports that the fake buildlet has no blob cache.
+func (fc *FakeClient) MissingBlobs(ctx context.Context, digests []string) ([]string, error) {
+	return nil, ErrBlobsUnsupported
+}
+
+// Mkdir creates a directory on a fake buildlet.
+func (fc *FakeClient) Mkdir(ctx context.Context, path string, mode os.FileMode) error {
+	return errUnimplemented
+}
+
 // Name is the name of the fake client.
 func (fc *FakeClient) Name() string { return fc.name }
 
@@ -153,6 +211,16 @@ func (fc *FakeClient) Put(ctx context.Context, r io.Reader, path string, mode os
 	return nil
 }
 
+// PutBlob fakes adding a blob to a buildlet's blob cache.
+func (fc *FakeClient) PutBlob(ctx context.Context, r io.Reader, digest string) error {
+	return ErrBlobsUnsupported
+}
+
+// PutBlobsTgz fakes adding blobs to a buildlet's blob cache.
+func (fc *FakeClient) PutBlobsTgz(ctx context.Context, r io.Reader) error {
+	return ErrBlobsUnsupported
+}
+
 // PutTar fakes putting  a tar zipped file on a buildldet.
 func (fc *FakeClient) PutTar(ctx context.Context, r io.Reader, dir string) error {
 	// TODO(go.dev/issue/48742) add a file system implementation which would enable proper testing.
@@ -164,9 +232,22 @@ func (fc *FakeClient) PutTarFromURL(ctx context.Context, tarURL, dir string) err
 	return nil
 }
 
+// ReadFile reads a file on a fake buildlet.
+func (fc *FakeClient) ReadFile(ctx context.Context, path string, offset, length int64) (io.ReadCloser, error) {
+	return nil, errUnimplemented
+}
+
 // RemoteName gives the remote name of the fake buildlet.
 func (fc *FakeClient) RemoteName() string { return "" }
 
+// RemoveJob fakes removing a job.
+func (fc *FakeClient) RemoveJob(ctx context.Context, id string) error { return errUnimplemented }
+
+// RestoreSnapshot fakes restoring a snapshot.
+func (fc *FakeClient) RestoreSnapshot(ctx context.Context, name, dir string) (method string, err error) {
+	return "", errUnimplemented
+}
+
 // SetDescription sets the description on a fake client.
 func (fc *FakeClient) SetDescription(v string) {}
 
@@ -189,20 +270,60 @@ func (fc *FakeClient) SetName(name string) {
 // SetOnHeartbeatFailure sets a function to be called when heartbeats against this fake buildlet fail.
 func (fc *FakeClient) SetOnHeartbeatFailure(fn func()) {}
 
+// SignalJob fakes signaling a job.
+func (fc *FakeClient) SignalJob(ctx context.Context, id, signal string) error {
+	return errUnimplemented
+}
+
+// Snapshot fakes snapshotting a directory.
+func (fc *FakeClient) Snapshot(ctx context.Context, name, dir string) (*SnapshotInfo, error) {
+	return nil, errUnimplemented
+}
+
+// Snapshots fakes listing snapshots.
+func (fc *FakeClient) Snapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	return nil, errUnimplemented
+}
+
+// StartJob fakes starting a background job.
+func (fc *FakeClient) StartJob(ctx context.Context, cmd string, opts ExecOpts) (*JobInfo, error) {
+	return nil, errUnimplemented
+}
+
+// Stat describes a file on a fake buildlet.
+func (fc *FakeClient) Stat(ctx context.Context, path string) (FileInfo, error) {
+	return FileInfo{}, errUnimplemented
+}
+
 // Status provides a status on the fake client.
 func (fc *FakeClient) Status(ctx context.Context) (Status, error) { return Status{}, errUnimplemented }
 
 // String provides a fake string representation of the client.
 func (fc *FakeClient) String() string { return "" }
 
+// UploadArchive fakes uploading an archive from a buildlet.
+func (fc *FakeClient) UploadArchive(ctx context.Context, dir string, format ArchiveFormat, putURL string) (*UploadResult, error) {
+	return nil, errUnimplemented
+}
+
 // URL is the URL for a fake buildlet.
 func (fc *FakeClient) URL() string { return "" }
 
 // WorkDir is the working directory for the fake buildlet.
 func (fc *FakeClient) WorkDir(ctx context.Context) (string, error) { return "", errUnimplemented }
 
+// WriteBlobs fakes writing files from a buildlet's blob cache.
+func (fc *FakeClient) WriteBlobs(ctx context.Context, dir string, files []BlobFile) error {
+	return ErrBlobsUnsupported
+}
+
 // RemoveAll deletes the provided paths, relative to the work directory for a fake buildlet.
 func (fc *FakeClient) RemoveAll(ctx context.Context, paths ...string) error {
 	// TODO(go.dev/issue/48742) add a file system implementation which would enable proper testing.
 	return nil
 }
+
+// Rename renames a file on a fake buildlet.
+func (fc *FakeClient) Rename(ctx context.Context, oldpath, newpath string) error {
+	return errUnimplemented
+}
@@ -156,7 +156,12 @@ func StartNewVM(creds *google.Credentials, buildEnv *buildenv.Environment, instN
 	if !opts.TLS.IsZero() {
 		addMeta("tls-cert", opts.TLS.CertPEM)
 		addMeta("tls-key", opts.TLS.KeyPEM)
-		addMeta("password", opts.TLS.Password())
+		if ca := opts.SessionCA; ca != nil {
+			addMeta("tls-client-ca", ca.CertPEM)
+			addMeta("tls-client-session", instName)
+		} else {
+			addMeta("password", opts.TLS.Password())
+		}
 	}
 	if hconf.IsContainer() {
 		addMeta("gce-container-declaration", fmt.Sprintf(`spec:
@@ -267,7 +272,7 @@ OpLoop:
 		ipPort = "127.0.0.1:" + localPort
 		closeFuncs = append(closeFuncs, closeFunc)
 	}
-	client, err := buildletClient(ctx, buildletURL, ipPort, &opts)
+	client, err := buildletClient(ctx, buildletURL, ipPort, instName, &opts)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,113 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildlet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// JobInfo describes a background job started by StartJob.
+type JobInfo struct {
+	ID          string      `json:"id"`
+	Cmd         string      `json:"cmd"`  // absolute path of the command
+	Args        []string    `json:"args"` // arguments, not including the command
+	Dir         string      `json:"dir"`
+	Pid         int         `json:"pid"`
+	Started     time.Time   `json:"started"`
+	Result      *ExecResult `json:"result,omitempty"` // nil while the job is running
+	OutputBytes int64       `json:"outputBytes"`      // total output so far
+}
+
+// Running reports whether the job's process was still running when
+// the JobInfo was returned.
+func (ji *JobInfo) Running() bool { return ji.Result == nil }
+
+// StartJob starts cmd as a background job, which keeps running after
+// StartJob returns until it exits or is killed with SignalJob or
+// RemoveJob. The cmd and opts are as for Exec, except that Output,
+// Stdin, OnStartExec, Timeout, MaxMemory and MaxProcs are ignored;
+// the job's output is retrieved with JobOutput instead.
+// It requires buildlet version 33 or later.
+func (c *client) StartJob(ctx context.Context, cmd string, opts ExecOpts) (*JobInfo, error) {
+	res, err := c.doForm(ctx, "POST", "/jobs/start", execForm(cmd, opts))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var ji JobInfo
+	if err := json.NewDecoder(res.Body).Decode(&ji); err != nil {
+		return nil, fmt.Errorf("decoding job info: %v", err)
+	}
+	return &ji, nil
+}
+
+// Jobs returns the buildlet's jobs, oldest first. Jobs that have
+// exited are listed until removed with RemoveJob, or until many more
+// have exited since.
+// It requires buildlet version 33 or later.
+func (c *client) Jobs(ctx context.Context) ([]JobInfo, error) {
+	res, err := c.doForm(ctx, "GET", "/jobs/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var jobs []JobInfo
+	if err := json.NewDecoder(res.Body).Decode(&jobs); err != nil {
+		return nil, fmt.Errorf("decoding job list: %v", err)
+	}
+	return jobs, nil
+}
+
+// JobOutput returns the combined stdout and stderr of job id, starting
+// at byte offset. A negative offset is relative to the end of the
+// output, so -4096 tails it. Only the most recent megabyte of output
+// is kept, and older output is skipped. If follow is true, the
+// returned ReadCloser keeps returning output until the job exits or
+// ctx is done. The caller must close it.
+// It requires buildlet version 33 or later.
+func (c *client) JobOutput(ctx context.Context, id string, offset int64, follow bool) (io.ReadCloser, error) {
+	form := url.Values{
+		"id":     {id},
+		"offset": {fmt.Sprint(offset)},
+		"follow": {fmt.Sprint(follow)},
+	}
+	res, err := c.doForm(ctx, "GET", "/jobs/output", form)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// SignalJob sends a signal to job id. The signal "kill" kills the
+// job and any processes it started. The signal "interrupt" and, on
+// Unix buildlets, "term", "hup", "quit", "usr1" and "usr2" are
+// delivered to the job's process. Signaling a job that has exited
+// isn't an error.
+// It requires buildlet version 33 or later.
+func (c *client) SignalJob(ctx context.Context, id, signal string) error {
+	res, err := c.doForm(ctx, "POST", "/jobs/signal", url.Values{"id": {id}, "signal": {signal}})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// RemoveJob kills job id, if it's still running, and removes it and
+// its output from the buildlet.
+// It requires buildlet version 33 or later.
+func (c *client) RemoveJob(ctx context.Context, id string) error {
+	res, err := c.doForm(ctx, "POST", "/jobs/remove", url.Values{"id": {id}})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
@@ -41,8 +41,9 @@ func (kp KeyPair) Password() string {
 }
 
 // tlsDialer returns a TLS dialer for http.Transport.DialTLS that expects
-// exactly our TLS cert.
-func (kp KeyPair) tlsDialer() func(network, addr string) (net.Conn, error) {
+// exactly our TLS cert. If clientCert is non-nil, it provides the
+// client certificate to present for mutual TLS.
+func (kp KeyPair) tlsDialer(clientCert func() (*tls.Certificate, error)) func(network, addr string) (net.Conn, error) {
 	if kp.IsZero() {
 		// Unused.
 		return nil
@@ -58,7 +59,13 @@ func (kp KeyPair) tlsDialer() func(network, addr string) (net.Conn, error) {
 		if err != nil {
 			return nil, err
 		}
-		tlsConn := tls.Client(plainConn, &tls.Config{InsecureSkipVerify: true})
+		conf := &tls.Config{InsecureSkipVerify: true}
+		if clientCert != nil {
+			conf.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return clientCert()
+			}
+		}
+		tlsConn := tls.Client(plainConn, conf)
 		if err := tlsConn.Handshake(); err != nil {
 			return nil, err
 		}
@@ -195,6 +195,41 @@ func (cc *CoordinatorClient) RemoteBuildlets() ([]RemoteBuildlet, error) {
 	return ret, nil
 }
 
+// DrainReverseHost starts draining the reverse buildlet host hostname,
+// so it finishes its current work but is given no new work. As after
+// any build, its buildlets are asked to halt when their builds finish.
+// If halt is true, its idle buildlets are also halted, once. If drain
+// is false,
+// the host is instead put back in service. It requires the user to be
+// a reverse buildlet admin on the coordinator.
+func (cc *CoordinatorClient) DrainReverseHost(hostname string, drain, halt bool) (string, error) {
+	hc, err := cc.client()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"hostname": {hostname}, "drain": {"0"}}
+	if drain {
+		form.Set("drain", "1")
+	}
+	if halt {
+		form.Set("halt", "1")
+	}
+	ipPort, _ := cc.instance().TLSHostPort() // must succeed if client did
+	req, _ := http.NewRequest("POST", "https://"+ipPort+"/reverse/drain", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(cc.Auth.Username, cc.Auth.Password)
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	slurp, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("%s: %s", res.Status, slurp)
+	}
+	return string(slurp), nil
+}
+
 // NamedBuildlet returns a buildlet client for the named remote buildlet.
 // Names are not validated. Use Client.Status to check whether the client works.
 func (cc *CoordinatorClient) NamedBuildlet(name string) (Client, error) {
@@ -0,0 +1,150 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildlet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// SessionCA is a certificate authority for the buildlet's mutual TLS
+// mode. A buildlet given the CA certificate requires clients to
+// present a certificate issued by the CA for the buildlet's session,
+// instead of the password derived from its KeyPair.
+//
+// The certificates are short-lived and reissued as needed, so no
+// long-lived secret is shared with the buildlet. Rotating the CA
+// itself only requires updating the buildlet's "tls-client-ca"
+// metadata, which may hold both the old and new CA certificates
+// during the changeover.
+type SessionCA struct {
+	CertPEM string
+	KeyPEM  string
+}
+
+// DefaultSessionCertLifetime is the lifetime of the client
+// certificates used by clients created with a SessionCA.
+const DefaultSessionCertLifetime = time.Hour
+
+// NewSessionCA returns a new self-signed session CA, valid for a year.
+func NewSessionCA() (SessionCA, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return SessionCA{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          randSerial(),
+		Subject:               pkix.Name{Organization: []string{"Gopher Co"}, CommonName: "buildlet session CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		return SessionCA{}, fmt.Errorf("creating CA certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return SessionCA{}, err
+	}
+	var certOut, keyOut bytes.Buffer
+	pem.Encode(&certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return SessionCA{CertPEM: certOut.String(), KeyPEM: keyOut.String()}, nil
+}
+
+func randSerial() *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// IssueClientCert returns a new client certificate for session, valid
+// for lifetime. The session is recorded as the certificate's subject
+// common name, which the buildlet checks against its own.
+func (ca SessionCA) IssueClientCert(session string, lifetime time.Duration) (*tls.Certificate, error) {
+	if session == "" {
+		return nil, errors.New("buildlet: empty session name")
+	}
+	caPair, err := tls.X509KeyPair([]byte(ca.CertPEM), []byte(ca.KeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("buildlet: bad session CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: randSerial(),
+		Subject:      pkix.Name{CommonName: session},
+		// Allow for some clock skew with the buildlet.
+		NotBefore:   now.Add(-5 * time.Minute),
+		NotAfter:    now.Add(lifetime),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &priv.PublicKey, caPair.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("buildlet: issuing client certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+		Leaf:        leaf,
+	}, nil
+}
+
+// sessionCertSource returns a func that returns a client certificate
+// for session, issuing a new one when the last is within a quarter of
+// its lifetime of expiring.
+func (ca SessionCA) sessionCertSource(session string, lifetime time.Duration) func() (*tls.Certificate, error) {
+	var mu sync.Mutex
+	var cur *tls.Certificate
+	return func() (*tls.Certificate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if cur != nil && time.Until(cur.Leaf.NotAfter) > lifetime/4 {
+			return cur, nil
+		}
+		cert, err := ca.IssueClientCert(session, lifetime)
+		if err != nil {
+			return nil, err
+		}
+		cur = cert
+		return cur, nil
+	}
+}
+
+// NewSessionClient is like NewClient, but authenticates to a buildlet in
+// mutual TLS mode with short-lived client certificates for session,
+// issued by ca, instead of a password.
+func NewSessionClient(ipPort string, kp KeyPair, ca SessionCA, session string) Client {
+	return newClient(ipPort, kp, ca.sessionCertSource(session, DefaultSessionCertLifetime))
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildlet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+)
+
+// SnapshotInfo describes a snapshot of a work directory subdirectory,
+// made by Snapshot.
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Dir     string    `json:"dir"`   // the directory snapshotted, relative to the work directory
+	Files   int       `json:"files"` // number of regular files
+	Size    int64     `json:"size"`  // total size of regular files
+	Method  string    `json:"method"`
+	Created time.Time `json:"created"`
+}
+
+// Snapshot saves a copy of dir, a directory relative to the work
+// directory, on the buildlet as snapshot name, replacing any snapshot
+// of that name. Snapshots are kept outside the work directory, so
+// RemoveAll doesn't remove them, but only the most recently used few
+// are kept. The returned Method is "reflink" if the copy was made with
+// copy-on-write clones, and otherwise "copy".
+// It requires buildlet version 34 or later.
+func (c *client) Snapshot(ctx context.Context, name, dir string) (*SnapshotInfo, error) {
+	res, err := c.doForm(ctx, "POST", "/snapshots/create", url.Values{"name": {name}, "dir": {dir}})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var si SnapshotInfo
+	if err := json.NewDecoder(res.Body).Decode(&si); err != nil {
+		return nil, fmt.Errorf("decoding snapshot info: %v", err)
+	}
+	return &si, nil
+}
+
+// RestoreSnapshot replaces dir, a directory relative to the work
+// directory, with the contents of snapshot name, and reports how it
+// was restored: "overlay" if the snapshot was mounted as the lower
+// layer of an overlay filesystem, and otherwise "reflink" or "copy",
+// as for Snapshot. If dir is empty, the directory the snapshot was
+// made of is used. If there's no such snapshot, the error wraps
+// os.ErrNotExist.
+// It requires buildlet version 34 or later.
+func (c *client) RestoreSnapshot(ctx context.Context, name, dir string) (method string, err error) {
+	res, err := c.doForm(ctx, "POST", "/snapshots/restore", url.Values{"name": {name}, "dir": {dir}})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Snapshots returns the buildlet's snapshots, sorted by name.
+// It requires buildlet version 34 or later.
+func (c *client) Snapshots(ctx context.Context) ([]SnapshotInfo, error) {
+	res, err := c.doForm(ctx, "GET", "/snapshots/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var list []SnapshotInfo
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding snapshot list: %v", err)
+	}
+	return list, nil
+}
+
+// DeleteSnapshot deletes snapshot name. A snapshot can't be deleted
+// while restored in an overlay; RemoveAll of the directory it was
+// restored to unmounts the overlay.
+// It requires buildlet version 34 or later.
+func (c *client) DeleteSnapshot(ctx context.Context, name string) error {
+	res, err := c.doForm(ctx, "POST", "/snapshots/delete", url.Values{"name": {name}})
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
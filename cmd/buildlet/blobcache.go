@@ -0,0 +1,405 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+// blobs is the buildlet's content-addressed file cache. Files are
+// stored by the lowercase hex SHA-1 of their contents, which is the
+// same digest reported by /ls?digest=true. The cache lives outside of
+// the work directory so that it survives RemoveAll calls between builds
+// on long-lived (mostly reverse) buildlets.
+var blobs = &blobCache{}
+
+// A blobCache is a directory of files named by their SHA-1 digest.
+type blobCache struct {
+	mu       sync.Mutex
+	dir      string // set by init; empty means not yet initialized
+	maxBytes int64  // size at which to start evicting; zero means unbounded
+	size     int64  // approximate total bytes stored; -1 if unknown
+}
+
+// init sets the cache's directory and maximum size.
+// It must be called before the cache is used.
+func (bc *blobCache) init(dir string, maxBytes int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.dir = dir
+	bc.maxBytes = maxBytes
+	bc.size = -1
+	return nil
+}
+
+var validDigest = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// path returns the on-disk path of the blob with the given digest.
+// The digest must be valid.
+func (bc *blobCache) path(digest string) string {
+	return filepath.Join(bc.dir, digest[:2], digest)
+}
+
+// has reports whether the blob with the given digest is present.
+//
+// A present blob's modtime is bumped, so that a client told that the
+// blob is present can usually rely on it not being evicted to make
+// room for the blobs that aren't.
+func (bc *blobCache) has(digest string) bool {
+	p := bc.path(digest)
+	fi, err := os.Stat(p)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	now := time.Now()
+	os.Chtimes(p, now, now)
+	return true
+}
+
+// put reads r to EOF and stores it as the blob with the given digest,
+// verifying that the contents match.
+func (bc *blobCache) put(r io.Reader, digest string) error {
+	dst := bc.path(digest)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	s1 := sha1.New()
+	n, err := io.Copy(io.MultiWriter(tmp, s1), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if got := fmt.Sprintf("%x", s1.Sum(nil)); got != digest {
+		return badRequest(fmt.Sprintf("blob content has digest %s; want %s", got, digest))
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	bc.added(n)
+	return nil
+}
+
+// copyTo writes the blob with the given digest to dst with the given mode.
+//
+// Blobs are copied rather than linked so that later in-place writes to
+// dst (by /write or /writetgz, which truncate existing files) can never
+// corrupt the cache.
+func (bc *blobCache) copyTo(digest, dst string, mode os.FileMode) error {
+	src := bc.path(digest)
+	f, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return blobMissing(digest)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Bump the modtime so that recently used blobs are evicted last.
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	return writeFile(f, dst, mode)
+}
+
+// added records that n bytes were added to the cache, evicting old
+// blobs if the cache has grown past its maximum size.
+func (bc *blobCache) added(n int64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.size >= 0 {
+		bc.size += n
+	}
+	if bc.maxBytes <= 0 {
+		return
+	}
+	if bc.size < 0 {
+		bc.size = bc.diskUsageLocked()
+	}
+	if bc.size > bc.maxBytes {
+		bc.evictLocked()
+	}
+}
+
+type blobInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (bc *blobCache) listLocked() (all []blobInfo) {
+	filepath.Walk(bc.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() || !validDigest.MatchString(fi.Name()) {
+			return nil
+		}
+		all = append(all, blobInfo{path, fi.Size(), fi.ModTime()})
+		return nil
+	})
+	return all
+}
+
+func (bc *blobCache) diskUsageLocked() (n int64) {
+	for _, b := range bc.listLocked() {
+		n += b.size
+	}
+	return n
+}
+
+// evictLocked removes the least recently used blobs until the
+// cache is at three quarters of its maximum size.
+func (bc *blobCache) evictLocked() {
+	all := bc.listLocked()
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.Before(all[j].modTime) })
+	var size int64
+	for _, b := range all {
+		size += b.size
+	}
+	target := bc.maxBytes / 4 * 3
+	removed := 0
+	for _, b := range all {
+		if size <= target {
+			break
+		}
+		if err := os.Remove(b.path); err != nil {
+			log.Printf("blobs: evicting %s: %v", b.path, err)
+			continue
+		}
+		size -= b.size
+		removed++
+	}
+	bc.size = size
+	log.Printf("blobs: evicted %d blobs; cache is now %d bytes", removed, size)
+}
+
+// handleBlobsMissing accepts a POST form of "digest" values and
+// responds with the subset not present in the blob cache, one per
+// line.
+func handleBlobsMissing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	digests := r.PostForm["digest"]
+	for _, d := range digests {
+		if !validDigest.MatchString(d) {
+			http.Error(w, fmt.Sprintf("bad 'digest' parameter: %q", d), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, d := range digests {
+		if !blobs.has(d) {
+			fmt.Fprintf(bw, "%s\n", d)
+		}
+	}
+	bw.Flush()
+}
+
+// handleBlobsPut stores the PUT body in the blob cache under the
+// "digest" URL parameter.
+func handleBlobsPut(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "requires PUT method", http.StatusBadRequest)
+		return
+	}
+	param, _ := url.ParseQuery(r.URL.RawQuery)
+	digest := param.Get("digest")
+	if !validDigest.MatchString(digest) {
+		http.Error(w, "bad 'digest' parameter", http.StatusBadRequest)
+		return
+	}
+	if err := blobs.put(r.Body, digest); err != nil {
+		status := http.StatusInternalServerError
+		if he, ok := err.(httpStatuser); ok {
+			status = he.httpStatus()
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	io.WriteString(w, "OK")
+}
+
+// handleBlobsPutTgz stores the regular files in the tar.gz PUT body in
+// the blob cache. Each file is named by the digest of its contents.
+func handleBlobsPutTgz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "requires PUT method", http.StatusBadRequest)
+		return
+	}
+	n, err := putBlobsTgz(r.Body)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if he, ok := err.(httpStatuser); ok {
+			status = he.httpStatus()
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	log.Printf("blobs: added %d blobs from tgz", n)
+	io.WriteString(w, "OK")
+}
+
+// putBlobsTgz adds the regular files in the tar.gz stream r to the
+// blob cache and returns how many it added.
+func putBlobsTgz(r io.Reader) (n int, err error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, badRequest("requires gzip-compressed body: " + err.Error())
+	}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, badRequest("reading tar: " + err.Error())
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if !validDigest.MatchString(h.Name) {
+			return n, badRequest(fmt.Sprintf("tar entry %q is not named by a digest", h.Name))
+		}
+		if err := blobs.put(tr, h.Name); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// blobMissing returns the error for a blob that /writeblobs needs but
+// isn't in the cache, such as one evicted since the client asked for
+// /blobs/missing. Clients recognize its 409 Conflict status.
+func blobMissing(digest string) error {
+	return httpError{http.StatusConflict, fmt.Sprintf("blob %s not in cache", digest)}
+}
+
+// handleWriteBlobs materializes the JSON-encoded []buildlet.BlobFile
+// in the request body into the "dir" URL parameter, a directory
+// relative to the work directory. Every referenced blob must already
+// be in the cache.
+func handleWriteBlobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	if !mkdirAllWorkdirOr500(w) {
+		return
+	}
+	param, _ := url.ParseQuery(r.URL.RawQuery)
+	dir := param.Get("dir")
+	if !validRelativeDir(dir) {
+		http.Error(w, "bogus dir", http.StatusBadRequest)
+		return
+	}
+	var files []buildlet.BlobFile
+	if err := json.NewDecoder(r.Body).Decode(&files); err != nil {
+		http.Error(w, "bad manifest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := writeBlobs(filepath.Join(*workDir, filepath.FromSlash(dir)), files); err != nil {
+		status := http.StatusInternalServerError
+		if he, ok := err.(httpStatuser); ok {
+			status = he.httpStatus()
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	io.WriteString(w, "OK")
+}
+
+// writeBlobs writes files into base from the blob cache.
+func writeBlobs(base string, files []buildlet.BlobFile) (err error) {
+	t0 := time.Now()
+	defer func() {
+		if err == nil {
+			log.Printf("wrote %d files from blob cache into %s (%v)", len(files), base, time.Since(t0))
+		}
+	}()
+	for _, f := range files {
+		if !validRelPath(f.Path) {
+			return badRequest(fmt.Sprintf("invalid path %q", f.Path))
+		}
+		if f.Mode.IsDir() {
+			continue
+		}
+		if !f.Mode.IsRegular() {
+			return badRequest(fmt.Sprintf("%s has unsupported file type %v", f.Path, f.Mode))
+		}
+		if !validDigest.MatchString(f.Digest) {
+			return badRequest(fmt.Sprintf("%s has invalid digest %q", f.Path, f.Digest))
+		}
+		if !blobs.has(f.Digest) {
+			return blobMissing(f.Digest)
+		}
+	}
+	madeDir := map[string]bool{}
+	for _, f := range files {
+		abs := filepath.Join(base, filepath.FromSlash(strings.TrimSuffix(f.Path, "/")))
+		if f.Mode.IsDir() {
+			if err := os.MkdirAll(abs, 0755); err != nil {
+				return err
+			}
+			madeDir[abs] = true
+			continue
+		}
+		if dir := filepath.Dir(abs); !madeDir[dir] {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			madeDir[dir] = true
+		}
+		if err := blobs.copyTo(f.Digest, abs, f.Mode.Perm()); err != nil {
+			if _, ok := err.(httpStatuser); ok {
+				return err
+			}
+			return fmt.Errorf("writing %s: %v", f.Path, err)
+		}
+		if modTime := f.ModTime; !modTime.IsZero() {
+			// Clamp modtimes at system time, as untar does.
+			// See golang.org/issue/19062.
+			if modTime.After(t0) {
+				modTime = t0
+			}
+			if err := os.Chtimes(abs, modTime, modTime); err != nil {
+				return fmt.Errorf("setting modtime of %s: %v", f.Path, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+// newBlobTestServer starts a buildlet HTTP server with the blob
+// handlers on a temporary work directory and a blob cache of at most
+// maxBytes, or unbounded if zero. It returns a
+// client for the server and a func reporting how many bytes of blob
+// contents were uploaded and in how many requests.
+func newBlobTestServer(t *testing.T, maxBytes int64) (bc buildlet.Client, putBytes func() (n int64, reqs int)) {
+	oldWorkDir, oldBlobs := *workDir, blobs
+	t.Cleanup(func() { *workDir, blobs = oldWorkDir, oldBlobs })
+	*workDir = t.TempDir()
+	blobs = &blobCache{}
+	if err := blobs.init(t.TempDir(), maxBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var n int64
+	var reqs int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/blobs/missing", handleBlobsMissing)
+	mux.HandleFunc("/blobs/put", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading /blobs/put body: %v", err)
+		}
+		mu.Lock()
+		n += int64(len(body))
+		reqs++
+		mu.Unlock()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handleBlobsPut(w, r)
+	})
+	mux.HandleFunc("/blobs/puttgz", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading /blobs/puttgz body: %v", err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Errorf("/blobs/puttgz body: %v", err)
+		} else {
+			tr := tar.NewReader(zr)
+			for {
+				h, err := tr.Next()
+				if err != nil {
+					break
+				}
+				mu.Lock()
+				n += h.Size
+				mu.Unlock()
+			}
+		}
+		mu.Lock()
+		reqs++
+		mu.Unlock()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		handleBlobsPutTgz(w, r)
+	})
+	mux.HandleFunc("/writeblobs", handleWriteBlobs)
+	mux.HandleFunc("/writetgz", handleWriteTGZ)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	bc = buildlet.NewClient(strings.TrimPrefix(ts.URL, "http://"), buildlet.KeyPair{})
+	return bc, func() (int64, int) {
+		mu.Lock()
+		defer mu.Unlock()
+		return n, reqs
+	}
+}
+
+var tgzModTime = time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func makeTgz(t *testing.T, files map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), ModTime: tgzModTime}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestPutTarDelta(t *testing.T) {
+	bc, putBytes := newBlobTestServer(t, 0)
+	ctx := context.Background()
+
+	big := strings.Repeat("x", 10000)
+	if err := buildlet.PutTarDelta(ctx, bc, makeTgz(t, map[string]string{
+		"a/big.txt":   big,
+		"a/small.txt": "small",
+		"b/big.txt":   big,
+	}), "src"); err != nil {
+		t.Fatalf("first PutTarDelta: %v", err)
+	}
+	if got, reqs := putBytes(); got != int64(len(big)+len("small")) || reqs != 1 {
+		t.Errorf("first PutTarDelta uploaded %d bytes in %d requests; want %d in 1", got, reqs, len(big)+len("small"))
+	}
+
+	if err := buildlet.PutTarDelta(ctx, bc, makeTgz(t, map[string]string{
+		"a/big.txt":   big,
+		"a/small.txt": "changed",
+	}), "src2"); err != nil {
+		t.Fatalf("second PutTarDelta: %v", err)
+	}
+	if got, reqs := putBytes(); got != int64(len(big)+len("small")+len("changed")) || reqs != 2 {
+		t.Errorf("after second PutTarDelta, uploaded %d bytes in %d requests; want %d in 2", got, reqs, len(big)+len("small")+len("changed"))
+	}
+
+	for path, want := range map[string]string{
+		"src/a/big.txt":    big,
+		"src/a/small.txt":  "small",
+		"src/b/big.txt":    big,
+		"src2/a/big.txt":   big,
+		"src2/a/small.txt": "changed",
+	} {
+		abs := filepath.Join(*workDir, filepath.FromSlash(path))
+		got, err := os.ReadFile(abs)
+		if err != nil {
+			t.Errorf("reading %s: %v", path, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s has %d bytes; want %d", path, len(got), len(want))
+		}
+		if fi, err := os.Stat(abs); err != nil {
+			t.Error(err)
+		} else if !fi.ModTime().Equal(tgzModTime) {
+			t.Errorf("%s modtime = %v; want %v", path, fi.ModTime(), tgzModTime)
+		}
+	}
+}
+
+func TestPutBlobDigestMismatch(t *testing.T) {
+	bc, _ := newBlobTestServer(t, 0)
+	const digest = "da39a3ee5e6b4b0d3255bfef95601890afd80709" // SHA-1 of ""
+	if err := bc.PutBlob(context.Background(), strings.NewReader("not empty"), digest); err == nil {
+		t.Errorf("PutBlob with mismatched digest succeeded; want error")
+	}
+	missing, err := bc.MissingBlobs(context.Background(), []string{digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0] != digest {
+		t.Errorf("MissingBlobs = %q; want [%q]", missing, digest)
+	}
+}
+
+func TestWriteBlobsMissingBlob(t *testing.T) {
+	bc, _ := newBlobTestServer(t, 0)
+	err := bc.WriteBlobs(context.Background(), "", []buildlet.BlobFile{
+		{Path: "f", Mode: 0644, Digest: "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+	})
+	if !errors.Is(err, buildlet.ErrBlobsMissing) {
+		t.Errorf("WriteBlobs of uncached blob = %v; want ErrBlobsMissing", err)
+	}
+}
+
+func TestBlobCacheEvict(t *testing.T) {
+	bc := &blobCache{}
+	if err := bc.init(t.TempDir(), 100); err != nil {
+		t.Fatal(err)
+	}
+	put := func(s string) string {
+		d := fmt.Sprintf("%x", sha1.Sum([]byte(s)))
+		if err := bc.put(strings.NewReader(s), d); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	old := time.Now().Add(-time.Hour)
+	for i, s := range []string{strings.Repeat("a", 40), strings.Repeat("b", 40)} {
+		mtime := old.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(bc.path(put(s)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newest := put(strings.Repeat("c", 40))
+	if bc.size > 75 {
+		t.Errorf("cache size after eviction = %d; want <= 75", bc.size)
+	}
+	if !bc.has(newest) {
+		t.Errorf("most recently added blob was evicted")
+	}
+}
+
+// Tests that a blob reported present outlives older blobs.
+func TestBlobCacheHasTouches(t *testing.T) {
+	bc := &blobCache{}
+	if err := bc.init(t.TempDir(), 110); err != nil {
+		t.Fatal(err)
+	}
+	var digests []string
+	old := time.Now().Add(-time.Hour)
+	for i, s := range []string{strings.Repeat("a", 40), strings.Repeat("b", 40)} {
+		d := fmt.Sprintf("%x", sha1.Sum([]byte(s)))
+		if err := bc.put(strings.NewReader(s), d); err != nil {
+			t.Fatal(err)
+		}
+		mtime := old.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(bc.path(d), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, d)
+	}
+	// The oldest blob is reported present, so the next put
+	// evicts the other one instead.
+	if !bc.has(digests[0]) {
+		t.Fatal("blob missing before eviction")
+	}
+	s := strings.Repeat("c", 40)
+	if err := bc.put(strings.NewReader(s), fmt.Sprintf("%x", sha1.Sum([]byte(s)))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(bc.path(digests[0])); err != nil {
+		t.Errorf("blob reported present was evicted: %v", err)
+	}
+	if _, err := os.Stat(bc.path(digests[1])); err == nil {
+		t.Errorf("older unused blob survived eviction")
+	}
+}
+
+// Tests that PutTarDelta falls back to PutTar when a blob reported
+// present is evicted by the upload of the missing ones.
+func TestPutTarDeltaEvicted(t *testing.T) {
+	bc, _ := newBlobTestServer(t, 100)
+	ctx := context.Background()
+
+	cached := strings.Repeat("a", 40)
+	if err := bc.PutBlob(ctx, strings.NewReader(cached), fmt.Sprintf("%x", sha1.Sum([]byte(cached)))); err != nil {
+		t.Fatal(err)
+	}
+	// Uploading the two missing blobs overflows the cache and
+	// evicts the oldest blob, the cached one, even though it was
+	// just reported present.
+	files := map[string]string{
+		"cached.txt": cached,
+		"b.txt":      strings.Repeat("b", 40),
+		"c.txt":      strings.Repeat("c", 40),
+	}
+	if err := buildlet.PutTarDelta(ctx, bc, makeTgz(t, files), "src"); err != nil {
+		t.Fatalf("PutTarDelta: %v", err)
+	}
+	for name, want := range files {
+		got, err := os.ReadFile(filepath.Join(*workDir, "src", name))
+		if err != nil {
+			t.Errorf("reading %s: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%s = %q; want %q", name, got, want)
+		}
+	}
+}
@@ -38,6 +38,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/compute/metadata"
@@ -59,6 +60,8 @@ var (
 	coordinator  = flag.String("coordinator", "localhost:8119", "address of coordinator, in production use farmer.golang.org. Only used in reverse mode.")
 	hostname     = flag.String("hostname", "", "hostname to advertise to coordinator for reverse mode; default is actual hostname")
 	healthAddr   = flag.String("health-addr", "0.0.0.0:8080", "For reverse buildlets, address to listen for /healthz requests separately from the reverse dialer to the coordinator.")
+	blobDir      = flag.String("blobdir", "", "Directory for the content-addressed file cache used by /writeblobs. If empty, a sibling of --workdir is used.")
+	blobMaxBytes = flag.Int64("blob-max-bytes", 2<<30, "Size in bytes at which the least recently used entries of the file cache are evicted. Zero or negative means unbounded.")
 )
 
 // Bump this whenever something notable happens, or when another
@@ -82,7 +85,18 @@ var (
 //	23: revdial v2
 //	24: removeAllIncludingReadonly
 //	25: use removeAllIncludingReadonly for all work area cleanup
-const buildletVersion = 25
+//	26: content-addressed blob cache (/blobs/missing, /blobs/put, /writeblobs)
+//	27: Process-Result trailer with exit code and resource usage
+//	28: /exec timeout, maxMemory and maxProcs limits
+//	29: /exec stdin streaming over an upgraded connection
+//	30: /stat, /mkdir, /rename, /chmod and /readfile
+//	31: /uploadarchive of a tgz or zip to a pre-signed URL
+//	32: mutual TLS mode with session client certificates (tls-client-ca)
+//	33: /jobs/start, /jobs/list, /jobs/output, /jobs/signal and /jobs/remove
+//	34: /snapshots/create, /snapshots/restore, /snapshots/list and /snapshots/delete
+//	35: /metrics in the Prometheus text format
+//	36: /blobs/puttgz and modtimes in /writeblobs
+const buildletVersion = 36
 
 func defaultListenAddr() string {
 	if runtime.GOOS == "darwin" {
@@ -117,9 +131,11 @@ var (
 )
 
 const (
-	metaKeyPassword = "password"
-	metaKeyTLSCert  = "tls-cert"
-	metaKeyTLSkey   = "tls-key"
+	metaKeyPassword         = "password"
+	metaKeyTLSCert          = "tls-cert"
+	metaKeyTLSkey           = "tls-key"
+	metaKeyTLSClientCA      = "tls-client-ca"
+	metaKeyTLSClientSession = "tls-client-session"
 )
 
 func main() {
@@ -217,14 +233,27 @@ func main() {
 		removeAllAndMkdir(processGoCacheEnv)
 	}
 
+	if *blobDir == "" {
+		*blobDir = filepath.Clean(*workDir) + "-blobs"
+	}
+	if err := blobs.init(*blobDir, *blobMaxBytes); err != nil {
+		log.Fatalf("error creating blob cache directory: %v", err)
+	}
+
 	http.HandleFunc("/", handleRoot)
 	http.HandleFunc("/debug/x", handleX)
 
-	var password string
+	var password, session string
+	var mutualTLS bool
 	if !isReverse {
 		password = metadataValue(metaKeyPassword)
+		mutualTLS = metadataValue(metaKeyTLSClientCA) != ""
+		session = metadataValue(metaKeyTLSClientSession)
 	}
 	requireAuth := func(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
+		if mutualTLS {
+			return requireSessionCertHandler{http.HandlerFunc(handler), session}
+		}
 		return requirePasswordHandler{http.HandlerFunc(handler), password}
 	}
 	http.Handle("/debug/goroutines", requireAuth(handleGoroutines))
@@ -238,6 +267,26 @@ func main() {
 	http.Handle("/status", requireAuth(handleStatus))
 	http.Handle("/ls", requireAuth(handleLs))
 	http.Handle("/connect-ssh", requireAuth(handleConnectSSH))
+	http.Handle("/blobs/missing", requireAuth(handleBlobsMissing))
+	http.Handle("/blobs/put", requireAuth(handleBlobsPut))
+	http.Handle("/blobs/puttgz", requireAuth(handleBlobsPutTgz))
+	http.Handle("/writeblobs", requireAuth(handleWriteBlobs))
+	http.Handle("/stat", requireAuth(handleStat))
+	http.Handle("/mkdir", requireAuth(handleMkdir))
+	http.Handle("/rename", requireAuth(handleRename))
+	http.Handle("/chmod", requireAuth(handleChmod))
+	http.Handle("/readfile", requireAuth(handleReadFile))
+	http.Handle("/uploadarchive", requireAuth(handleUploadArchive))
+	http.Handle("/jobs/start", requireAuth(handleJobStart))
+	http.Handle("/jobs/list", requireAuth(handleJobList))
+	http.Handle("/jobs/output", requireAuth(handleJobOutput))
+	http.Handle("/jobs/signal", requireAuth(handleJobSignal))
+	http.Handle("/jobs/remove", requireAuth(handleJobRemove))
+	http.Handle("/snapshots/create", requireAuth(handleSnapshotCreate))
+	http.Handle("/snapshots/restore", requireAuth(handleSnapshotRestore))
+	http.Handle("/snapshots/list", requireAuth(handleSnapshotList))
+	http.Handle("/snapshots/delete", requireAuth(handleSnapshotDelete))
+	http.Handle("/metrics", requireAuth(handleMetrics))
 	http.HandleFunc("/healthz", handleHealthz)
 
 	if !isReverse {
@@ -270,6 +319,9 @@ func listenForCoordinator() {
 	if (tlsCert == "") != (tlsKey == "") {
 		log.Fatalf("tls-cert and tls-key must both be supplied, or neither.")
 	}
+	if tlsCert == "" && metadataValue(metaKeyTLSClientCA) != "" {
+		log.Fatalf("tls-client-ca requires tls-cert and tls-key.")
+	}
 
 	log.Printf("Listening on %s ...", *listenAddr)
 	ln, err := net.Listen("tcp", *listenAddr)
@@ -284,10 +336,14 @@ func listenForCoordinator() {
 		if err != nil {
 			log.Fatalf("TLS cert error: %v", err)
 		}
-		tlsConf := &tls.Config{
-			Certificates: []tls.Certificate{cert},
+		var cas *clientCAs
+		if metadataValue(metaKeyTLSClientCA) != "" {
+			cas = &clientCAs{load: func() (string, error) { return lookupMetadataValue(metaKeyTLSClientCA, true) }}
+			cas.refresh()
+			go cas.refreshLoop()
+			log.Printf("Using mutual TLS with session %q", metadataValue(metaKeyTLSClientSession))
 		}
-		ln = tls.NewListener(ln, tlsConf)
+		ln = tls.NewListener(ln, tlsServerConfig(cert, cas))
 	}
 
 	serveErr := make(chan error, 1)
@@ -324,7 +380,8 @@ var inKube = os.Getenv("KUBERNETES_SERVICE_HOST") != ""
 
 var (
 	// ec2UD contains a copy of the EC2 vm user data retrieved from the metadata.
-	ec2UD *cloud.EC2UserData
+	ec2UD   *cloud.EC2UserData
+	ec2UDMu sync.Mutex // guards ec2UD
 	// ec2MdC is an EC2 metadata client.
 	ec2MdC *ec2metadata.EC2Metadata
 )
@@ -360,7 +417,7 @@ func mdValueFromUserData(ud *cloud.EC2UserData, key string) string {
 	case metaKeyPassword:
 		return ud.TLSPassword
 	default:
-		return ""
+		return ud.Metadata[key]
 	}
 }
 
@@ -371,35 +428,52 @@ func mdValueFromUserData(ud *cloud.EC2UserData, key string) string {
 //
 // If not running on GCE or EC2, it falls back to using environment variables
 // for local development.
+//
+// metadataValue exits the process if the metadata can't be read, so
+// it's only for use at startup; see lookupMetadataValue.
 func metadataValue(key string) string {
+	v, err := lookupMetadataValue(key, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return v
+}
+
+// lookupMetadataValue is like metadataValue, but returns an error if
+// the metadata can't be read. If fresh is set, the EC2 user data is
+// read again rather than taken from the copy read at startup.
+func lookupMetadataValue(key string, fresh bool) (string, error) {
 	// The common case (on GCE, but not in Kubernetes):
 	if metadata.OnGCE() && !inKube {
 		v, err := metadata.InstanceAttributeValue(key)
 		if _, notDefined := err.(metadata.NotDefinedError); notDefined {
-			return ""
+			return "", nil
 		}
 		if err != nil {
-			log.Fatalf("metadata.InstanceAttributeValue(%q): %v", key, err)
+			return "", fmt.Errorf("metadata.InstanceAttributeValue(%q): %v", key, err)
 		}
-		return v
+		return v, nil
 	}
 
 	if onEC2() {
-		if ec2UD != nil {
-			return mdValueFromUserData(ec2UD, key)
+		ec2UDMu.Lock()
+		defer ec2UDMu.Unlock()
+		if ec2UD != nil && !fresh {
+			return mdValueFromUserData(ec2UD, key), nil
 		}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		ec2MetaJson, err := ec2MdC.GetUserDataWithContext(ctx)
 		if err != nil {
-			log.Fatalf("unable to retrieve EC2 user data: %v", err)
+			return "", fmt.Errorf("unable to retrieve EC2 user data: %v", err)
 		}
-		ec2UD = &cloud.EC2UserData{}
-		err = json.Unmarshal([]byte(ec2MetaJson), ec2UD)
+		ud := &cloud.EC2UserData{}
+		err = json.Unmarshal([]byte(ec2MetaJson), ud)
 		if err != nil {
-			log.Fatalf("unable to unmarshal user data json: %v", err)
+			return "", fmt.Errorf("unable to unmarshal user data json: %v", err)
 		}
-		return mdValueFromUserData(ec2UD, key)
+		ec2UD = ud
+		return mdValueFromUserData(ec2UD, key), nil
 	}
 
 	// Else allow use of environment variables to fake
@@ -410,14 +484,14 @@ func metadataValue(key string) string {
 	if strings.HasPrefix(v, "@") {
 		slurp, err := ioutil.ReadFile(v[1:])
 		if err != nil {
-			log.Fatalf("Error reading file for GCEMETA_%v: %v", key, err)
+			return "", fmt.Errorf("Error reading file for GCEMETA_%v: %v", key, err)
 		}
-		return string(slurp)
+		return string(slurp), nil
 	}
 	if v == "" {
 		log.Printf("Warning: not running on GCE, and no %v environment variable defined", envKey)
 	}
-	return v
+	return v, nil
 }
 
 // tcpKeepAliveListener is a net.Listener that sets TCP keep-alive
@@ -545,9 +619,18 @@ func handleGetTGZ(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bogus dir", http.StatusBadRequest)
 		return
 	}
+	base := filepath.Join(*workDir, filepath.FromSlash(dir))
+	if err := writeTGZ(meteredWriter{w, getTGZBytes}, base); err != nil {
+		log.Printf("Walk error: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeTGZ writes the tree rooted at base to w as a gzip-compressed
+// tar file.
+func writeTGZ(w io.Writer, base string) error {
 	zw := pargzip.NewWriter(w)
 	tw := tar.NewWriter(zw)
-	base := filepath.Join(*workDir, filepath.FromSlash(dir))
 	err := filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -587,11 +670,12 @@ func handleGetTGZ(w http.ResponseWriter, r *http.Request) {
 		return nil
 	})
 	if err != nil {
-		log.Printf("Walk error: %v", err)
-		panic(http.ErrAbortHandler)
+		return err
 	}
-	tw.Close()
-	zw.Close()
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
 }
 
 func handleWriteTGZ(w http.ResponseWriter, r *http.Request) {
@@ -660,7 +744,7 @@ func handleWriteTGZ(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := untar(tgz, baseDir)
+	err := untar(meteredReader{tgz, writeTGZBytes}, baseDir)
 	if err != nil {
 		status := http.StatusInternalServerError
 		if he, ok := err.(httpStatuser); ok {
@@ -840,6 +924,11 @@ func untar(r io.Reader, dir string) (err error) {
 // on success, or os.ProcessState.String() on failure.
 const hdrProcessState = "Process-State"
 
+// Process-Result is an HTTP Trailer set in the /exec handler to a
+// JSON-encoded buildlet.ProcessResult. It supersedes Process-State,
+// which is still sent for older clients.
+const hdrProcessResult = "Process-Result"
+
 func handleExec(w http.ResponseWriter, r *http.Request) {
 	cn := w.(http.CloseNotifier)
 	clientGone := cn.CloseNotify()
@@ -873,18 +962,221 @@ func handleExec(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Trailer", hdrProcessState) // declare it so we can set it
+	// Declare the trailers so we can set them.
+	w.Header().Add("Trailer", hdrProcessState)
+	w.Header().Add("Trailer", hdrProcessResult)
+
+	debug, _ := strconv.ParseBool(r.FormValue("debug"))
+	lim, err := parseExecLimits(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	absCmd, dir, err := parseExecTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var stream *execStream // non-nil if the client streams stdin
+	if wantsExecStream(r) {
+		var ok bool
+		if stream, ok = hijackExecStream(w); !ok {
+			return
+		}
+		clientGone = stream.gone
+	} else if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	cmd := newExecCmd(r, absCmd, dir)
+	var cmdOutput io.Writer = flushWriter{w}
+	if stream != nil {
+		cmdOutput = stream
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			// Can't happen: cmd.Stdin is unset and cmd isn't started.
+			panic(err)
+		}
+		go stream.copyStdin(stdin)
+	}
+	// Only a command with a timeout or limits runs in its own process
+	// group, so that all of it can be killed, and gets an output pipe
+	// of ours, so that processes it leaves behind can't hold up the
+	// response forever. Other commands run as they always have.
+	//
+	// Like a failure to set up limits, a failure to create the output
+	// pipe is reported in the process result, as the response may
+	// already have been committed to streaming.
+	var out *execOutput
+	if lim.timeout > 0 || lim.maxMemory > 0 || lim.maxProcs > 0 {
+		if setProcessGroup != nil {
+			setProcessGroup(cmd)
+		}
+		out, err = newExecOutput(cmdOutput)
+		if err == nil {
+			cmd.Stdout = out.w
+			cmd.Stderr = out.w
+		} else {
+			out = nil
+			err = fmt.Errorf("creating output pipe: %v", err)
+		}
+	} else {
+		cmd.Stdout = cmdOutput
+		cmd.Stderr = cmdOutput
+	}
+
+	log.Printf("[%p] Running %s with args %q and env %q in dir %s",
+		cmd, cmd.Path, cmd.Args, cmd.Env, cmd.Dir)
+
+	if debug {
+		fmt.Fprintf(cmdOutput, ":: Running %s with args %q and env %q in dir %s\n\n",
+			cmd.Path, cmd.Args, cmd.Env, cmd.Dir)
+	}
+
+	var lmt limiter
+	if err == nil && (lim.maxMemory > 0 || lim.maxProcs > 0) {
+		if lmt, err = newLimiter(cmd, lim); err != nil {
+			err = fmt.Errorf("setting up limits: %v", err)
+		} else {
+			defer lmt.done()
+		}
+	}
+
+	t0 := time.Now()
+	var timedOut int32
+	if err == nil {
+		err = cmd.Start()
+	}
+	if out != nil {
+		// The child has its own copy of the write end of the pipe
+		// now; close ours so that the copy ends when the child's is
+		// closed.
+		out.w.Close()
+	}
+	if err == nil && lmt != nil {
+		if err = lmt.started(cmd.Process); err != nil {
+			killProcessTree(cmd.Process)
+			cmd.Wait()
+			err = fmt.Errorf("applying limits: %v", err)
+		}
+	}
+	var ps *os.ProcessState // nil unless the command ran
+	if err == nil {
+		atomic.AddInt64(&execRunning, 1)
+		defer atomic.AddInt64(&execRunning, -1)
+		var timeout <-chan time.Time
+		if lim.timeout > 0 {
+			t := time.NewTimer(lim.timeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		go func() {
+			select {
+			case <-clientGone:
+			case <-timeout:
+				atomic.StoreInt32(&timedOut, 1)
+				log.Printf("[%p] timeout after %v; killing", cmd, lim.timeout)
+			case <-handlerDone:
+				return
+			}
+			err := killProcessTree(cmd.Process)
+			if err != nil {
+				log.Printf("Kill failed: %v", err)
+			}
+			if lmt != nil {
+				lmt.kill()
+			}
+		}()
+		err = cmd.Wait()
+		ps = cmd.ProcessState
+	}
+	if stream != nil {
+		stream.commandExited()
+	}
+	if out != nil {
+		out.wait(execWaitDelay)
+	}
+	res := processResult(ps, err, time.Since(t0))
+	recordExec(ps != nil, res.State == "ok", res.WallTime)
+	if atomic.LoadInt32(&timedOut) != 0 {
+		res.TimedOut = true
+		res.Limit = buildlet.LimitTimeout
+	} else if lmt != nil && err != nil {
+		res.Limit = lmt.exceeded()
+	}
+	if stream != nil {
+		if err := stream.finish(res); err != nil {
+			log.Printf("[%p] %v", cmd, err)
+		}
+	} else {
+		w.Header().Set(hdrProcessState, res.State)
+		if b, err := json.Marshal(buildlet.ProcessResult{Version: buildlet.ProcessResultVersion, ExecResult: res}); err == nil {
+			w.Header().Set(hdrProcessResult, string(b))
+		} else {
+			log.Printf("[%p] encoding process result: %v", cmd, err)
+		}
+	}
+	log.Printf("[%p] Run = %s, after %v", cmd, res.State, res.WallTime)
+}
+
+// execWaitDelay is how long handleExec waits for the output of a
+// command that has exited to reach EOF. Processes the command left
+// running, such as those that escaped its process group, may keep
+// the output pipe open indefinitely.
+const execWaitDelay = 5 * time.Second
+
+// An execOutput copies what a command writes to the write end of a
+// pipe to an io.Writer.
+//
+// os/exec does the same when Cmd.Stdout isn't an *os.File, but then
+// Cmd.Wait doesn't return until every process holding the pipe open
+// has exited, and there's no way to bound that wait before Go 1.20's
+// Cmd.WaitDelay.
+type execOutput struct {
+	r, w *os.File
+	done chan struct{} // closed when the copy is done
+}
+
+func newExecOutput(dst io.Writer) (*execOutput, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	o := &execOutput{r: r, w: w, done: make(chan struct{})}
+	go func() {
+		defer close(o.done)
+		io.Copy(dst, r)
+	}()
+	return o, nil
+}
+
+// wait waits up to d for the copy to reach EOF, then stops it.
+// The write end must already be closed.
+func (o *execOutput) wait(d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-o.done:
+	case <-t.C:
+		log.Printf("output still open %v after command exited; abandoning it", d)
+	}
+	o.r.Close()
+	<-o.done
+}
 
+// parseExecTarget returns the absolute path of the command to run for
+// an /exec or /jobs/start request and the directory to run it in,
+// from the "cmd", "dir" and "mode" parameters.
+func parseExecTarget(r *http.Request) (absCmd, dir string, err error) {
 	cmdPath := r.FormValue("cmd") // required
-	absCmd := cmdPath
-	dir := r.FormValue("dir") // optional
+	absCmd = cmdPath
+	dir = r.FormValue("dir") // optional
 	sysMode := r.FormValue("mode") == "sys"
-	debug, _ := strconv.ParseBool(r.FormValue("debug"))
 
 	if sysMode {
 		if cmdPath == "" {
-			http.Error(w, "requires 'cmd' parameter", http.StatusBadRequest)
-			return
+			return "", "", errors.New("requires 'cmd' parameter")
 		}
 		if dir == "" {
 			dir = *workDir
@@ -896,25 +1188,25 @@ func handleExec(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		if !validRelPath(cmdPath) {
-			http.Error(w, "requires 'cmd' parameter", http.StatusBadRequest)
-			return
+			return "", "", errors.New("requires 'cmd' parameter")
 		}
 		absCmd = filepath.Join(*workDir, filepath.FromSlash(cmdPath))
 		if dir == "" {
 			dir = filepath.Dir(absCmd)
 		} else {
 			if !validRelPath(dir) {
-				http.Error(w, "bogus 'dir' parameter", http.StatusBadRequest)
-				return
+				return "", "", errors.New("bogus 'dir' parameter")
 			}
 			dir = filepath.Join(*workDir, filepath.FromSlash(dir))
 		}
 	}
+	return absCmd, dir, nil
+}
 
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
-	}
-
+// newExecCmd returns the command absCmd to run in dir for an /exec or
+// /jobs/start request, with the request's "cmdArg" arguments, and with
+// its "env" and "path" parameters applied to the buildlet's environment.
+func newExecCmd(r *http.Request, absCmd, dir string) *exec.Cmd {
 	postEnv := r.PostForm["env"]
 
 	goarch := "amd64" // unless we find otherwise
@@ -948,44 +1240,50 @@ func handleExec(w http.ResponseWriter, r *http.Request) {
 	cmd.Args = append(cmd.Args, r.PostForm["cmdArg"]...)
 	cmd.Env = env
 	envutil.SetDir(cmd, dir)
-	cmdOutput := flushWriter{w}
-	cmd.Stdout = cmdOutput
-	cmd.Stderr = cmdOutput
+	return cmd
+}
 
-	log.Printf("[%p] Running %s with args %q and env %q in dir %s",
-		cmd, cmd.Path, cmd.Args, cmd.Env, cmd.Dir)
+// Functionality set non-nil by some platforms, to report details of
+// an exited process not available from os.ProcessState on all
+// systems:
+var (
+	// exitSignal returns the name of the signal that terminated
+	// the process, or the empty string.
+	exitSignal func(*os.ProcessState) string
+	// peakRSS returns the process's maximum resident set size in
+	// bytes, or 0 if unknown.
+	peakRSS func(*os.ProcessState) int64
+)
 
-	if debug {
-		fmt.Fprintf(cmdOutput, ":: Running %s with args %q and env %q in dir %s\n\n",
-			cmd.Path, cmd.Args, cmd.Env, cmd.Dir)
+// processResult returns the result of running a process that exited
+// with state ps (nil if it was never started) after wall time, where
+// err is the error from starting or waiting for it.
+func processResult(ps *os.ProcessState, err error, wall time.Duration) buildlet.ExecResult {
+	res := buildlet.ExecResult{
+		State:    "ok",
+		ExitCode: -1,
+		WallTime: wall,
 	}
-
-	t0 := time.Now()
-	err := cmd.Start()
-	if err == nil {
-		go func() {
-			select {
-			case <-clientGone:
-				err := killProcessTree(cmd.Process)
-				if err != nil {
-					log.Printf("Kill failed: %v", err)
-				}
-			case <-handlerDone:
-				return
-			}
-		}()
-		err = cmd.Wait()
-	}
-	state := "ok"
 	if err != nil {
-		if ps := cmd.ProcessState; ps != nil {
-			state = ps.String()
+		if ps != nil {
+			res.State = ps.String()
 		} else {
-			state = err.Error()
+			res.State = err.Error()
 		}
 	}
-	w.Header().Set(hdrProcessState, state)
-	log.Printf("[%p] Run = %s, after %v", cmd, state, time.Since(t0))
+	if ps == nil {
+		return res
+	}
+	res.ExitCode = ps.ExitCode()
+	res.UserTime = ps.UserTime()
+	res.SystemTime = ps.SystemTime()
+	if exitSignal != nil {
+		res.Signal = exitSignal(ps)
+	}
+	if peakRSS != nil {
+		res.MaxRSS = peakRSS(ps)
+	}
+	return res
 }
 
 // needsBashWrappers reports whether the given command needs to
@@ -1147,6 +1445,7 @@ func handleHalt(w http.ResponseWriter, r *http.Request) {
 	// remaining second.
 	log.Printf("Halting in 1 second.")
 	time.AfterFunc(1*time.Second, func() {
+		jobs.killAll(5 * time.Second)
 		if *rebootOnHalt {
 			doReboot()
 		}
@@ -1230,10 +1529,21 @@ func handleRemoveAll(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	for _, p := range paths {
+		if path.Clean(p) == "." {
+			// Removing the whole work directory means the
+			// buildlet is being cleaned up for its next user.
+			jobs.killAll(10 * time.Second)
+			break
+		}
+	}
 	for _, p := range paths {
 		log.Printf("Removing %s", p)
 		fullDir := filepath.Join(*workDir, filepath.FromSlash(p))
-		err := removeAllIncludingReadonly(fullDir)
+		err := prepareRemoveAll(fullDir)
+		if err == nil {
+			err = removeAllIncludingReadonly(fullDir)
+		}
 		if p == "." && err != nil {
 			// If workDir is a mountpoint and/or contains a binary
 			// using it, we can get a "Device or resource busy" error.
@@ -1639,12 +1949,19 @@ func (pw *gcePlan9LogWriter) Write(p []byte) (n int, err error) {
 	return pw.w.Write(pw.buf[:n+1])
 }
 
-var killProcessTree = killProcessTreeUnix
+// killProcessTree kills p and, where the platform supports it, the
+// processes it started. On Unix that requires p to have been started
+// by a command passed to setProcessGroup; otherwise only p is killed.
+var killProcessTree = killProcess
 
-func killProcessTreeUnix(p *os.Process) error {
+func killProcess(p *os.Process) error {
 	return p.Kill()
 }
 
+// setProcessGroup, if non-nil, arranges for cmd to be started in a
+// new process group, for killProcessTree.
+var setProcessGroup func(cmd *exec.Cmd)
+
 // configureMacStadium configures the buildlet flags for use on a Mac
 // VM running on MacStadium under VMWare.
 func configureMacStadium() {
@@ -1965,5 +2282,8 @@ func handleHealthz(w http.ResponseWriter, _ *http.Request) {
 func serveReverseHealth() error {
 	m := &http.ServeMux{}
 	m.HandleFunc("/healthz", handleHealthz)
+	// Reverse buildlets are otherwise only reachable through the
+	// coordinator, so serve metrics here too, for scraping.
+	m.HandleFunc("/metrics", handleMetrics)
 	return http.ListenAndServe(*healthAddr, m)
 }
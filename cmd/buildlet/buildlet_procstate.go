@@ -0,0 +1,51 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+func init() {
+	exitSignal = exitSignalUnix
+	peakRSS = peakRSSUnix
+	namedSignal = namedSignalUnix
+}
+
+func namedSignalUnix(name string) (os.Signal, bool) {
+	sig, ok := map[string]syscall.Signal{
+		"term": syscall.SIGTERM,
+		"hup":  syscall.SIGHUP,
+		"quit": syscall.SIGQUIT,
+		"usr1": syscall.SIGUSR1,
+		"usr2": syscall.SIGUSR2,
+	}[name]
+	return sig, ok
+}
+
+func exitSignalUnix(ps *os.ProcessState) string {
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	return ws.Signal().String()
+}
+
+func peakRSSUnix(ps *os.ProcessState) int64 {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// Maxrss is in bytes on macOS and kilobytes elsewhere.
+	if runtime.GOOS == "darwin" {
+		return int64(ru.Maxrss)
+	}
+	return int64(ru.Maxrss) * 1024
+}
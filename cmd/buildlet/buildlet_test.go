@@ -5,9 +5,12 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"os/exec"
 	"runtime"
 	"testing"
+	"time"
 )
 
 func TestPathEnv(t *testing.T) {
@@ -110,3 +113,30 @@ func TestPathListSeparator(t *testing.T) {
 		t.Errorf("pathListSeparator(%q) = %q; want %q", runtime.GOOS, sep, want)
 	}
 }
+
+func TestProcessResult(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	cmd := exec.Command("sh", "-c", "exit 3")
+	err := cmd.Run()
+	res := processResult(cmd.ProcessState, err, time.Second)
+	if res.State != "exit status 3" || res.ExitCode != 3 || res.Signal != "" || res.WallTime != time.Second {
+		t.Errorf("processResult = %+v; want exit status 3 after 1s", res)
+	}
+
+	cmd = exec.Command("sh", "-c", "kill -9 $$")
+	err = cmd.Run()
+	res = processResult(cmd.ProcessState, err, time.Second)
+	if res.ExitCode != -1 || res.Signal != "killed" {
+		t.Errorf("processResult = %+v; want exit code -1 and signal killed", res)
+	}
+	if res.MaxRSS <= 0 {
+		t.Errorf("processResult MaxRSS = %d; want > 0", res.MaxRSS)
+	}
+
+	res = processResult(nil, errors.New("exec: not found"), 0)
+	if res.State != "exec: not found" || res.ExitCode != -1 {
+		t.Errorf("processResult for unstarted process = %+v; want state of start error", res)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/build/buildlet"
+)
+
+// execStream is a hijacked /exec connection.
+//
+// An /exec request with an "Upgrade: buildlet-exec" header streams the
+// command's stdin from the client, using the buildlet.ExecStreamProto
+// protocol on the hijacked connection once the request form has been
+// read. The final buildlet.ExecFrameResult frame takes the place of the
+// trailers of a plain /exec response.
+type execStream struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	gone   chan bool     // closed when the client hangs up
+	exited chan struct{} // closed when the command exits
+
+	mu sync.Mutex // guards writes to conn
+}
+
+func wantsExecStream(r *http.Request) bool {
+	return r.Header.Get("Upgrade") == buildlet.ExecStreamProto
+}
+
+// hijackExecStream takes over the connection of an /exec request and
+// sends the 101 Switching Protocols response. If it fails, it has
+// already replied to the client.
+func hijackExecStream(w http.ResponseWriter) (*execStream, bool) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "streaming stdin requires HTTP/1.1", http.StatusBadRequest)
+		return nil, false
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("exec stream hijack: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	io.WriteString(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: "+buildlet.ExecStreamProto+"\r\nConnection: Upgrade\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	return &execStream{conn: conn, br: brw.Reader, gone: make(chan bool), exited: make(chan struct{})}, true
+}
+
+// copyStdin copies stdin frames from the client to stdin, closing
+// stdin at the client's request. It then waits for the client to hang
+// up, which it reports by closing s.gone.
+//
+// The writes to stdin happen in their own goroutine, so that a command
+// that isn't reading its stdin doesn't keep copyStdin from noticing
+// the client hang up. stdin is closed as soon as the command exits or
+// the client hangs up, which unblocks any such write.
+func (s *execStream) copyStdin(stdin io.WriteCloser) {
+	defer close(s.gone)
+	go func() {
+		select {
+		case <-s.exited:
+		case <-s.gone:
+		}
+		stdin.Close()
+	}()
+	writes := make(chan []byte)
+	defer func() {
+		if writes != nil {
+			close(writes)
+		}
+	}()
+	go func() {
+		for p := range writes {
+			// Errors are ignored: once the command exits,
+			// its stdin is closed and the rest is discarded.
+			stdin.Write(p)
+		}
+		stdin.Close()
+	}()
+	var peeked chan error // non-nil while a Peek of s.br is outstanding
+	for {
+		if peeked != nil {
+			if err := <-peeked; err != nil {
+				s.readFailed(err)
+				return
+			}
+			peeked = nil
+		}
+		kind, p, err := buildlet.ReadExecFrame(s.br)
+		if err != nil {
+			s.readFailed(err)
+			return
+		}
+		switch kind {
+		case buildlet.ExecFrameStdin:
+			if writes == nil {
+				break // stdin already closed
+			}
+			select {
+			case writes <- p:
+				continue
+			default:
+			}
+			// The previous write is still blocked. Wait for it
+			// while watching for the client to hang up.
+			peeked = make(chan error, 1)
+			go func() {
+				_, err := s.br.Peek(1)
+				peeked <- err
+			}()
+			select {
+			case writes <- p:
+			case err := <-peeked:
+				if err != nil {
+					s.readFailed(err)
+					return
+				}
+				peeked = nil
+				writes <- p
+			}
+		case buildlet.ExecFrameStdinEOF:
+			if writes != nil {
+				close(writes)
+				writes = nil
+			}
+		default:
+			log.Printf("unexpected exec stream frame kind %q", kind)
+			return
+		}
+	}
+}
+
+func (s *execStream) readFailed(err error) {
+	if err != io.EOF {
+		log.Printf("exec stream: %v", err)
+	}
+}
+
+// commandExited reports that the command has exited, closing its stdin.
+func (s *execStream) commandExited() {
+	close(s.exited)
+}
+
+func (s *execStream) writeFrame(kind byte, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return buildlet.WriteExecFrame(s.conn, kind, p)
+}
+
+// Write writes p to the client as command output.
+func (s *execStream) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > buildlet.MaxExecFrameSize {
+			chunk = chunk[:buildlet.MaxExecFrameSize]
+		}
+		if err := s.writeFrame(buildlet.ExecFrameOutput, chunk); err != nil {
+			return 0, err
+		}
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// finish sends the command's result to the client and closes the
+// connection.
+func (s *execStream) finish(res buildlet.ExecResult) error {
+	defer s.conn.Close()
+	b, err := json.Marshal(buildlet.ProcessResult{Version: buildlet.ProcessResultVersion, ExecResult: res})
+	if err != nil {
+		return fmt.Errorf("encoding process result: %v", err)
+	}
+	if err := s.writeFrame(buildlet.ExecFrameResult, b); err != nil {
+		return errors.New("client hung up before the process result was sent")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestExecStdin(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	ts := httptest.NewServer(http.HandlerFunc(handleExec))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+
+	// Send more than one frame's worth of input.
+	in := strings.Repeat("hello, gopher\n", 100000)
+	var out bytes.Buffer
+	res, err := bc.ExecWithResult(context.Background(), "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", "tr a-z A-Z; exit 3"},
+		Output:      &out,
+		Stdin:       strings.NewReader(in),
+	})
+	if err != nil {
+		t.Fatalf("ExecWithResult: %v", err)
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("ExitCode = %d; want 3", res.ExitCode)
+	}
+	if want := strings.ToUpper(in); out.String() != want {
+		t.Errorf("got %d bytes of output; want %d bytes of uppercased input", out.Len(), len(want))
+	}
+
+	// A command that exits without reading its input mustn't hang.
+	res, err = bc.ExecWithResult(context.Background(), "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", "true"},
+		Stdin:       strings.NewReader(in),
+	})
+	if err != nil {
+		t.Fatalf("ExecWithResult without reading stdin: %v", err)
+	}
+	if res.State != "ok" {
+		t.Errorf("State = %q; want ok", res.State)
+	}
+}
+
+// blockingReader returns the contents of r and then blocks until
+// unblock is closed.
+type blockingReader struct {
+	r       *strings.Reader
+	unblock chan struct{}
+}
+
+func (br blockingReader) Read(p []byte) (int, error) {
+	if br.r.Len() > 0 {
+		return br.r.Read(p)
+	}
+	<-br.unblock
+	return 0, io.EOF
+}
+
+// Tests that a client hanging up is noticed, and the command killed,
+// even while the command isn't reading the stdin it was sent.
+func TestExecStdinClientGone(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	ts := httptest.NewServer(http.HandlerFunc(handleExec))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+
+	// Send more than fits in the pipe to the command's stdin, so
+	// that the buildlet's write to it blocks.
+	unblock := make(chan struct{})
+	defer close(unblock)
+	stdin := blockingReader{strings.NewReader(strings.Repeat("x", 96<<10)), unblock}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = bc.ExecWithResult(ctx, "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", "exec sleep 30"},
+		Stdin:       stdin,
+	})
+	if err == nil {
+		t.Fatal("ExecWithResult succeeded; want context error")
+	}
+	for deadline := time.Now().Add(10 * time.Second); atomic.LoadInt64(&execRunning) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("command still running 10s after the client hung up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/build/buildlet"
+)
+
+// This file implements the single-file operations /stat, /mkdir,
+// /rename, /chmod and /readfile. All their paths are '/'-separated
+// and relative to the work directory.
+
+// fileOpPath returns the absolute path named by the form value key.
+// The work directory itself ("" or ".") is allowed only if allowWorkDir
+// is set.
+func fileOpPath(r *http.Request, key string, allowWorkDir bool) (string, error) {
+	p := r.FormValue(key)
+	if !validRelativeDir(p) || (!allowWorkDir && path.Clean(p) == ".") {
+		return "", badRequest(fmt.Sprintf("bad %q parameter %q", key, p))
+	}
+	return filepath.Join(*workDir, filepath.FromSlash(p)), nil
+}
+
+// fileOpMode parses the form value key as an os.FileMode in decimal,
+// as sent by the buildlet client, or returns def if it's empty.
+func fileOpMode(r *http.Request, key string, def os.FileMode) (os.FileMode, error) {
+	v := r.FormValue(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, badRequest(fmt.Sprintf("bad %q parameter %q", key, v))
+	}
+	return os.FileMode(n), nil
+}
+
+// fileOpError replies to the request with err, using a 404 status if
+// it's a file not found error.
+func fileOpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if he, ok := err.(httpStatuser); ok {
+		status = he.httpStatus()
+	} else if os.IsNotExist(err) {
+		status = http.StatusNotFound
+	} else if os.IsPermission(err) {
+		status = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func handleStat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "requires GET method", http.StatusBadRequest)
+		return
+	}
+	if !mkdirAllWorkdirOr500(w) {
+		return
+	}
+	p, err := fileOpPath(r, "path", true)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	b, err := json.Marshal(buildlet.FileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		Mode:    fi.Mode(),
+		ModTime: fi.ModTime().UTC(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+func handleMkdir(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	p, err := fileOpPath(r, "path", false)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	mode, err := fileOpMode(r, "mode", 0755)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	if err := os.MkdirAll(p, mode.Perm()); err != nil {
+		fileOpError(w, err)
+		return
+	}
+	io.WriteString(w, "OK")
+}
+
+func handleRename(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	oldpath, err := fileOpPath(r, "oldpath", false)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	newpath, err := fileOpPath(r, "newpath", false)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	if err := os.Rename(oldpath, newpath); err != nil {
+		fileOpError(w, err)
+		return
+	}
+	io.WriteString(w, "OK")
+}
+
+func handleChmod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	p, err := fileOpPath(r, "path", false)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	if r.FormValue("mode") == "" {
+		http.Error(w, "requires 'mode' parameter", http.StatusBadRequest)
+		return
+	}
+	mode, err := fileOpMode(r, "mode", 0)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	if err := os.Chmod(p, mode); err != nil {
+		fileOpError(w, err)
+		return
+	}
+	io.WriteString(w, "OK")
+}
+
+// handleReadFile writes the contents of the regular file "path",
+// starting at byte "offset" and limited to "length" bytes if that's
+// non-negative.
+func handleReadFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "requires GET method", http.StatusBadRequest)
+		return
+	}
+	p, err := fileOpPath(r, "path", false)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	offset, length := int64(0), int64(-1)
+	if v := r.FormValue("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			http.Error(w, "bad 'offset' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("length"); v != "" {
+		if length, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "bad 'length' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	if !fi.Mode().IsRegular() {
+		http.Error(w, "not a regular file", http.StatusBadRequest)
+		return
+	}
+	n := fi.Size() - offset
+	if n < 0 {
+		n = 0
+	}
+	if length >= 0 && length < n {
+		n = length
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		fileOpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	// If the file shrank since the Stat, the short body breaks the
+	// response, signaling the failure to the client.
+	io.CopyN(w, f, n)
+}
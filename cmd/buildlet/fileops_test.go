@@ -0,0 +1,107 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestFileOps(t *testing.T) {
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/write", handleWrite)
+	mux.HandleFunc("/stat", handleStat)
+	mux.HandleFunc("/mkdir", handleMkdir)
+	mux.HandleFunc("/rename", handleRename)
+	mux.HandleFunc("/chmod", handleChmod)
+	mux.HandleFunc("/readfile", handleReadFile)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	bc := buildlet.NewClient(strings.TrimPrefix(ts.URL, "http://"), buildlet.NoKeyPair)
+	defer bc.Close()
+	ctx := context.Background()
+
+	if err := bc.Mkdir(ctx, "a/b", 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if fi, err := bc.Stat(ctx, "a/b"); err != nil || !fi.IsDir() || fi.Name != "b" {
+		t.Errorf("Stat(a/b) = %+v, %v; want directory b", fi, err)
+	}
+	const contents = "0123456789"
+	if err := bc.Put(ctx, strings.NewReader(contents), "a/b/f", 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Rename(ctx, "a/b/f", "a/g"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := bc.Stat(ctx, "a/b/f"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Stat of renamed file = %v; want ErrNotExist", err)
+	}
+	fi, err := bc.Stat(ctx, "a/g")
+	if err != nil || fi.Size != int64(len(contents)) || !fi.Mode.IsRegular() {
+		t.Errorf("Stat(a/g) = %+v, %v; want regular file of %d bytes", fi, err, len(contents))
+	}
+
+	if runtime.GOOS != "windows" && runtime.GOOS != "plan9" {
+		if err := bc.Chmod(ctx, "a/g", 0600); err != nil {
+			t.Fatalf("Chmod: %v", err)
+		}
+		osfi, err := os.Stat(filepath.Join(*workDir, "a", "g"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := osfi.Mode().Perm(); got != 0600 {
+			t.Errorf("after Chmod, mode = %v; want 0600", got)
+		}
+	}
+
+	for _, tt := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, contents},
+		{3, -1, contents[3:]},
+		{3, 4, contents[3:7]},
+		{8, 100, contents[8:]},
+		{100, -1, ""},
+	} {
+		rc, err := bc.ReadFile(ctx, "a/g", tt.offset, tt.length)
+		if err != nil {
+			t.Errorf("ReadFile(a/g, %d, %d): %v", tt.offset, tt.length, err)
+			continue
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(got) != tt.want {
+			t.Errorf("ReadFile(a/g, %d, %d) = %q, %v; want %q", tt.offset, tt.length, got, err, tt.want)
+		}
+	}
+	if _, err := bc.ReadFile(ctx, "a/missing", 0, -1); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadFile of missing file = %v; want ErrNotExist", err)
+	}
+
+	for _, bad := range []string{"..", "../x", "a/../..", "/etc/passwd"} {
+		if _, err := bc.Stat(ctx, bad); err == nil {
+			t.Errorf("Stat(%q) succeeded; want error", bad)
+		}
+	}
+	if err := bc.Rename(ctx, "a", "."); err == nil {
+		t.Errorf("Rename onto the work directory succeeded; want error")
+	}
+}
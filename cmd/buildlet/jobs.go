@@ -0,0 +1,429 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+// Jobs are processes started by /jobs/start that, unlike those run by
+// /exec, outlive the request that started them. They're meant for
+// servers and other helpers that tests or gomote users need running
+// in the background.
+//
+// Each job runs in its own process group where the platform supports
+// it, so that killing a job kills everything it started. All jobs are
+// killed when the whole work directory is removed and when the
+// buildlet halts.
+
+const (
+	// maxJobOutput is how much of each job's most recent output is kept.
+	maxJobOutput = 1 << 20
+
+	// maxFinishedJobs is how many exited jobs are kept until removed
+	// before the oldest are forgotten.
+	maxFinishedJobs = 100
+)
+
+// namedSignal, if non-nil, returns the signal with the given name,
+// such as "term" or "hup", other than "kill" and "interrupt".
+// It's set by platforms with more than those.
+var namedSignal func(name string) (os.Signal, bool)
+
+var jobs = &jobTable{m: map[string]*job{}}
+
+type jobTable struct {
+	mu   sync.Mutex
+	m    map[string]*job
+	next int
+}
+
+type job struct {
+	id      string
+	cmd     *exec.Cmd
+	started time.Time
+	out     *jobOutput
+	done    chan struct{} // closed when the process has exited
+
+	mu  sync.Mutex
+	res *buildlet.ExecResult // non-nil once done
+}
+
+func (j *job) info() buildlet.JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return buildlet.JobInfo{
+		ID:          j.id,
+		Cmd:         j.cmd.Path,
+		Args:        j.cmd.Args[1:],
+		Dir:         j.cmd.Dir,
+		Pid:         j.cmd.Process.Pid,
+		Started:     j.started,
+		Result:      j.res,
+		OutputBytes: j.out.size(),
+	}
+}
+
+// jobOutput is a job's combined stdout and stderr, of which only the
+// last maxJobOutput bytes are retained.
+type jobOutput struct {
+	mu      sync.Mutex
+	buf     []byte // the retained output, ending at offset total
+	total   int64
+	closed  bool
+	changed chan struct{} // closed and replaced on each write or close
+}
+
+func newJobOutput() *jobOutput {
+	return &jobOutput{changed: make(chan struct{})}
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf = append(o.buf, p...)
+	if over := len(o.buf) - maxJobOutput; over > 0 {
+		o.buf = append(o.buf[:0], o.buf[over:]...)
+	}
+	o.total += int64(len(p))
+	close(o.changed)
+	o.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func (o *jobOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.closed = true
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+func (o *jobOutput) size() int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.total
+}
+
+// read returns the output from offset off onward and the offset after
+// it. If off is negative, it's relative to the end of the output. If
+// off has been discarded, the output starts at the oldest retained
+// byte. If there's no output after off, done reports whether there will
+// never be, and otherwise changed is closed when there might be.
+func (o *jobOutput) read(off int64) (p []byte, start, next int64, done bool, changed <-chan struct{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	first := o.total - int64(len(o.buf))
+	if off < 0 {
+		off += o.total
+	}
+	if off < first {
+		off = first
+	}
+	if off > o.total {
+		off = o.total
+	}
+	p = append([]byte(nil), o.buf[off-first:]...)
+	return p, off, o.total, o.closed, o.changed
+}
+
+func (t *jobTable) add(j *job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	j.id = strconv.Itoa(t.next)
+	t.m[j.id] = j
+
+	var finished []*job
+	for _, j := range t.m {
+		select {
+		case <-j.done:
+			finished = append(finished, j)
+		default:
+		}
+	}
+	if len(finished) > maxFinishedJobs {
+		sort.Slice(finished, func(i, k int) bool { return finished[i].started.Before(finished[k].started) })
+		for _, j := range finished[:len(finished)-maxFinishedJobs] {
+			delete(t.m, j.id)
+		}
+	}
+}
+
+func (t *jobTable) get(id string) *job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.m[id]
+}
+
+func (t *jobTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.m, id)
+}
+
+func (t *jobTable) list() []*job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]*job, 0, len(t.m))
+	for _, j := range t.m {
+		list = append(list, j)
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].started.Before(list[k].started) })
+	return list
+}
+
+// killAll kills every running job, waiting up to timeout for them to
+// exit, and forgets all jobs. It's used when the buildlet is handed to
+// its next user, so that no job outlives the build or gomote session
+// that started it.
+func (t *jobTable) killAll(timeout time.Duration) {
+	t.mu.Lock()
+	all := t.m
+	t.m = map[string]*job{}
+	t.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for _, j := range all {
+		select {
+		case <-j.done:
+			continue
+		default:
+		}
+		log.Printf("job %s: killing", j.id)
+		if err := killProcessTree(j.cmd.Process); err != nil {
+			log.Printf("job %s: kill: %v", j.id, err)
+		}
+	}
+	for _, j := range all {
+		select {
+		case <-j.done:
+		case <-deadline:
+			log.Printf("job %s didn't exit after being killed", j.id)
+			return
+		}
+	}
+}
+
+// jobFromRequest returns the job named by the request's "id"
+// parameter, or replies with an error and returns nil.
+func jobFromRequest(w http.ResponseWriter, r *http.Request) *job {
+	j := jobs.get(r.FormValue("id"))
+	if j == nil {
+		http.Error(w, "unknown job", http.StatusNotFound)
+	}
+	return j
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b)
+}
+
+// handleJobStart starts a job. It takes the same "cmd", "mode", "dir",
+// "cmdArg", "env" and "path" parameters as /exec, and replies with the
+// JSON buildlet.JobInfo of the new job.
+func handleJobStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	if !mkdirAllWorkdirOr500(w) {
+		return
+	}
+	absCmd, dir, err := parseExecTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j := &job{
+		cmd:  newExecCmd(r, absCmd, dir),
+		out:  newJobOutput(),
+		done: make(chan struct{}),
+	}
+	if setProcessGroup != nil {
+		setProcessGroup(j.cmd)
+	}
+	// Give the job an *os.File for its output, so Wait doesn't wait
+	// for processes it leaves behind that still hold it open.
+	out, err := newExecOutput(j.out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j.cmd.Stdout = out.w
+	j.cmd.Stderr = out.w
+	j.started = time.Now()
+	err = j.cmd.Start()
+	out.w.Close()
+	if err != nil {
+		out.wait(0)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs.add(j)
+	log.Printf("job %s: started %s with args %q in dir %s", j.id, j.cmd.Path, j.cmd.Args, j.cmd.Dir)
+	go func() {
+		err := j.cmd.Wait()
+		res := processResult(j.cmd.ProcessState, err, time.Since(j.started))
+		// Collect the rest of the output, so it's complete once
+		// the job is done, unless processes the job left behind
+		// keep it open.
+		out.wait(execWaitDelay)
+		j.mu.Lock()
+		j.res = &res
+		j.mu.Unlock()
+		j.out.close()
+		close(j.done)
+		log.Printf("job %s: %s, after %v", j.id, res.State, res.WallTime)
+	}()
+	writeJSON(w, j.info())
+}
+
+// handleJobList replies with the JSON []buildlet.JobInfo of all jobs,
+// oldest first.
+func handleJobList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "requires GET method", http.StatusBadRequest)
+		return
+	}
+	infos := []buildlet.JobInfo{}
+	for _, j := range jobs.list() {
+		infos = append(infos, j.info())
+	}
+	writeJSON(w, infos)
+}
+
+// handleJobOutput writes the output of job "id" from byte "offset",
+// which is relative to the end if negative. If "follow" is true, it
+// keeps streaming output until the job exits. The X-Job-Offset header
+// reports the offset of the first byte written.
+func handleJobOutput(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "requires GET method", http.StatusBadRequest)
+		return
+	}
+	j := jobFromRequest(w, r)
+	if j == nil {
+		return
+	}
+	var off int64
+	if v := r.FormValue("offset"); v != "" {
+		var err error
+		if off, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "bad 'offset' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	follow, _ := strconv.ParseBool(r.FormValue("follow"))
+	p, start, next, done, changed := j.out.read(off)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Job-Offset", strconv.FormatInt(start, 10))
+	for {
+		if len(p) > 0 {
+			if _, err := w.Write(p); err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+		if done || !follow {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		p, _, next, done, changed = j.out.read(next)
+	}
+}
+
+// handleJobSignal sends the signal "signal" to job "id". The signal
+// "kill" kills the job's whole process tree; "interrupt" and, on Unix,
+// "term", "hup", "quit", "usr1" and "usr2" are sent to the process.
+func handleJobSignal(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	j := jobFromRequest(w, r)
+	if j == nil {
+		return
+	}
+	name := r.FormValue("signal")
+	var err error
+	switch name {
+	case "kill":
+		err = killProcessTree(j.cmd.Process)
+	case "interrupt":
+		err = j.cmd.Process.Signal(os.Interrupt)
+	default:
+		var sig os.Signal
+		ok := false
+		if namedSignal != nil {
+			sig, ok = namedSignal(name)
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("unsupported signal %q", name), http.StatusBadRequest)
+			return
+		}
+		err = j.cmd.Process.Signal(sig)
+	}
+	select {
+	case <-j.done:
+		// Signaling an exited process isn't an error.
+		err = nil
+	default:
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("job %s: sent %s", j.id, name)
+	w.Write([]byte("OK"))
+}
+
+// handleJobRemove kills job "id", if it's still running, and forgets it.
+func handleJobRemove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	j := jobFromRequest(w, r)
+	if j == nil {
+		return
+	}
+	select {
+	case <-j.done:
+	default:
+		killProcessTree(j.cmd.Process)
+		select {
+		case <-j.done:
+		case <-time.After(10 * time.Second):
+			http.Error(w, "job didn't exit after being killed", http.StatusInternalServerError)
+			return
+		}
+	}
+	jobs.remove(j.id)
+	w.Write([]byte("OK"))
+}
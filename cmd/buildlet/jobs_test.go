@@ -0,0 +1,263 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestJobs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs/start", handleJobStart)
+	mux.HandleFunc("/jobs/list", handleJobList)
+	mux.HandleFunc("/jobs/output", handleJobOutput)
+	mux.HandleFunc("/jobs/signal", handleJobSignal)
+	mux.HandleFunc("/jobs/remove", handleJobRemove)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// A job that outlives the request that started it, and prints a
+	// line once it gets SIGTERM.
+	ji, err := bc.StartJob(ctx, "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", `trap 'echo stopping; exit 7' TERM; echo ready; while :; do sleep 0.1; done`},
+	})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if !ji.Running() || ji.Pid == 0 {
+		t.Errorf("StartJob = %+v; want running job with a pid", ji)
+	}
+
+	rc, err := bc.JobOutput(ctx, ji.ID, 0, true)
+	if err != nil {
+		t.Fatalf("JobOutput: %v", err)
+	}
+	defer rc.Close()
+	br := bufio.NewReader(rc)
+	if line, err := br.ReadString('\n'); line != "ready\n" {
+		t.Fatalf("first line of output = %q, %v; want %q", line, err, "ready\n")
+	}
+
+	list, err := bc.Jobs(ctx)
+	if err != nil {
+		t.Fatalf("Jobs: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != ji.ID || !list[0].Running() {
+		t.Errorf("Jobs = %+v; want just running job %s", list, ji.ID)
+	}
+
+	if err := bc.SignalJob(ctx, ji.ID, "bogus"); err == nil {
+		t.Errorf("SignalJob with unknown signal succeeded; want error")
+	}
+	if err := bc.SignalJob(ctx, ji.ID, "term"); err != nil {
+		t.Fatalf("SignalJob: %v", err)
+	}
+	// Following the output ends when the job exits.
+	rest, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("reading rest of output: %v", err)
+	}
+	if string(rest) != "stopping\n" {
+		t.Errorf("rest of output = %q; want %q", rest, "stopping\n")
+	}
+
+	list, err = bc.Jobs(ctx)
+	if err != nil {
+		t.Fatalf("Jobs: %v", err)
+	}
+	if len(list) != 1 || list[0].Running() || list[0].Result.ExitCode != 7 {
+		t.Errorf("Jobs after exit = %+v; want job with exit code 7", list)
+	}
+
+	// Tailing returns just the end of the output.
+	rc, err = bc.JobOutput(ctx, ji.ID, -int64(len("stopping\n")), false)
+	if err != nil {
+		t.Fatalf("JobOutput: %v", err)
+	}
+	tail, err := io.ReadAll(rc)
+	rc.Close()
+	if string(tail) != "stopping\n" || err != nil {
+		t.Errorf("tail of output = %q, %v; want %q", tail, err, "stopping\n")
+	}
+
+	if err := bc.RemoveJob(ctx, ji.ID); err != nil {
+		t.Fatalf("RemoveJob: %v", err)
+	}
+	if _, err := bc.JobOutput(ctx, ji.ID, 0, false); err == nil || !strings.Contains(err.Error(), "unknown job") {
+		t.Errorf("JobOutput of removed job: err = %v; want unknown job", err)
+	}
+
+	// Removing a running job kills it.
+	ji, err = bc.StartJob(ctx, "sleep", buildlet.ExecOpts{SystemLevel: true, Args: []string{"60"}})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	if err := bc.RemoveJob(ctx, ji.ID); err != nil {
+		t.Fatalf("RemoveJob of running job: %v", err)
+	}
+	if list, err := bc.Jobs(ctx); err != nil || len(list) != 0 {
+		t.Errorf("Jobs after RemoveJob = %+v, %v; want none", list, err)
+	}
+}
+
+// Tests that removing the whole work directory, as is done before a
+// buildlet is handed to its next user, kills jobs and the processes
+// they started.
+func TestRemoveAllKillsJobs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs/start", handleJobStart)
+	mux.HandleFunc("/jobs/list", handleJobList)
+	mux.HandleFunc("/removeall", handleRemoveAll)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// The job forks a child and records its pid outside the work
+	// directory.
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	if _, err := bc.StartJob(ctx, "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", `sleep 60 & echo $! > "$1"; wait`, "sh", pidFile},
+	}); err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	var pid int
+	for pid == 0 {
+		if b, err := os.ReadFile(pidFile); err == nil {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+		}
+		if ctx.Err() != nil {
+			t.Fatal("job didn't write its child's pid")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := bc.RemoveAll(ctx, "."); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if list, err := bc.Jobs(ctx); err != nil || len(list) != 0 {
+		t.Errorf("Jobs after RemoveAll = %+v, %v; want none", list, err)
+	}
+	if runtime.GOOS == "linux" {
+		// The killed child may linger as a zombie until it's
+		// reaped by init, which counts as dead.
+		for {
+			stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+			if err != nil || strings.Contains(string(stat), ") Z ") {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("job's child %d still running after RemoveAll: %s", pid, stat)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// Tests that a job is done when its process exits, even if processes
+// it left behind still hold its output open.
+func TestJobDoneWithBackgroundChild(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs/start", handleJobStart)
+	mux.HandleFunc("/jobs/list", handleJobList)
+	mux.HandleFunc("/jobs/remove", handleJobRemove)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	ji, err := bc.StartJob(ctx, "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", `sleep 60 & echo $! > "$1"; echo started`, "sh", pidFile},
+	})
+	if err != nil {
+		t.Fatalf("StartJob: %v", err)
+	}
+	defer func() {
+		if b, err := os.ReadFile(pidFile); err == nil {
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+				if p, err := os.FindProcess(pid); err == nil {
+					p.Kill()
+				}
+			}
+		}
+	}()
+	deadline := time.Now().Add(execWaitDelay + 10*time.Second)
+	for {
+		list, err := bc.Jobs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 1 && !list[0].Running() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still running with its child holding its output", ji.ID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := bc.RemoveJob(ctx, ji.ID); err != nil {
+		t.Fatalf("RemoveJob: %v", err)
+	}
+}
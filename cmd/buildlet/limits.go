@@ -0,0 +1,74 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// execLimits are the per-command limits of an /exec request.
+type execLimits struct {
+	timeout   time.Duration // zero means none
+	maxMemory int64         // bytes; zero means none
+	maxProcs  int           // zero means none
+}
+
+// parseExecLimits parses the optional "timeout", "maxMemory" and
+// "maxProcs" form values of an /exec request.
+func parseExecLimits(r *http.Request) (lim execLimits, err error) {
+	if v := r.FormValue("timeout"); v != "" {
+		if lim.timeout, err = time.ParseDuration(v); err != nil || lim.timeout <= 0 {
+			return lim, fmt.Errorf("bad 'timeout' parameter %q", v)
+		}
+	}
+	if v := r.FormValue("maxMemory"); v != "" {
+		if lim.maxMemory, err = strconv.ParseInt(v, 10, 64); err != nil || lim.maxMemory <= 0 {
+			return lim, fmt.Errorf("bad 'maxMemory' parameter %q", v)
+		}
+	}
+	if v := r.FormValue("maxProcs"); v != "" {
+		if lim.maxProcs, err = strconv.Atoi(v); err != nil || lim.maxProcs <= 0 {
+			return lim, fmt.Errorf("bad 'maxProcs' parameter %q", v)
+		}
+	}
+	if (lim.maxMemory > 0 || lim.maxProcs > 0) && newLimiter == nil {
+		return lim, fmt.Errorf("memory and process limits are not supported on %s", runtime.GOOS)
+	}
+	return lim, nil
+}
+
+// A limiter enforces the memory and process limits of one command.
+type limiter interface {
+	// started is called after the command has started.
+	started(p *os.Process) error
+
+	// exceeded returns buildlet.LimitMemory or buildlet.LimitProcs
+	// if the command hit that limit, or the empty string if it
+	// didn't or that can't be determined.
+	exceeded() string
+
+	// kill kills every process the limiter knows to belong to the
+	// command, if it keeps track of them. It's called in addition
+	// to killProcessTree when the command times out or the client
+	// goes away.
+	kill()
+
+	// done is called after the command has exited, to release
+	// any resources held by the limiter.
+	done()
+}
+
+// newLimiter returns a limiter for cmd, which has not yet been
+// started, and may modify cmd to apply the limits.
+//
+// It is set non-nil on operating systems that support memory and
+// process limits.
+var newLimiter func(cmd *exec.Cmd, lim execLimits) (limiter, error)
@@ -0,0 +1,242 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/build/buildlet"
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	newLimiter = newLimiterLinux
+}
+
+// newLimiterLinux returns a limiter using a cgroup v2 if the buildlet
+// is able to manage its own cgroup, or else one using prlimit(2).
+func newLimiterLinux(cmd *exec.Cmd, lim execLimits) (limiter, error) {
+	parent, err := cgroupParent()
+	if err != nil {
+		return prlimitLimiter{lim}, nil
+	}
+	return newCgroupLimiter(cmd, parent, lim)
+}
+
+// prlimitLimiter sets RLIMIT_AS and RLIMIT_NPROC on the started process.
+// It's only a best effort:
+//
+//   - Children forked before the limits are set aren't limited, but
+//     that window is tiny.
+//   - RLIMIT_NPROC counts every process of the buildlet's user, not
+//     just the command's, and isn't enforced at all for root, which
+//     most buildlets run as. Only a cgroup can limit processes there.
+type prlimitLimiter struct {
+	lim execLimits
+}
+
+func (l prlimitLimiter) started(p *os.Process) error {
+	if n := l.lim.maxMemory; n > 0 {
+		if err := unix.Prlimit(p.Pid, unix.RLIMIT_AS, &unix.Rlimit{Cur: uint64(n), Max: uint64(n)}, nil); err != nil {
+			return fmt.Errorf("setting RLIMIT_AS: %v", err)
+		}
+	}
+	if n := l.lim.maxProcs; n > 0 {
+		if err := unix.Prlimit(p.Pid, unix.RLIMIT_NPROC, &unix.Rlimit{Cur: uint64(n), Max: uint64(n)}, nil); err != nil {
+			return fmt.Errorf("setting RLIMIT_NPROC: %v", err)
+		}
+	}
+	return nil
+}
+
+func (prlimitLimiter) exceeded() string { return "" }
+func (prlimitLimiter) kill()            {}
+func (prlimitLimiter) done()            {}
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+var cgroupSetup struct {
+	once sync.Once
+	dir  string // the cgroup under which per-command cgroups are made
+	err  error
+}
+
+// cgroupParent returns the directory of a cgroup v2 under which the
+// buildlet may create per-command cgroups with the memory and pids
+// controllers enabled.
+//
+// Because of cgroup v2's rule that only leaf cgroups may contain
+// processes, the first call moves the buildlet process from its own
+// cgroup into a new "buildlet" child, and then delegates the
+// controllers to its children. Other processes in the buildlet's
+// cgroup are left alone; if there are any, delegation fails and the
+// prlimit limiter is used instead.
+func cgroupParent() (string, error) {
+	cgroupSetup.once.Do(func() {
+		cgroupSetup.dir, cgroupSetup.err = setupCgroupParent()
+		if cgroupSetup.err != nil {
+			log.Printf("cgroup v2 limits unavailable, falling back to prlimit: %v", cgroupSetup.err)
+		}
+	})
+	return cgroupSetup.dir, cgroupSetup.err
+}
+
+func setupCgroupParent() (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", errors.New("no cgroup v2 hierarchy mounted at " + cgroupRoot)
+	}
+	self, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	var rel string
+	sc := bufio.NewScanner(bytes.NewReader(self))
+	for sc.Scan() {
+		if v := strings.TrimPrefix(sc.Text(), "0::"); v != sc.Text() {
+			rel = v
+			break
+		}
+	}
+	if rel == "" {
+		return "", errors.New("buildlet is not in a cgroup v2")
+	}
+	dir := filepath.Join(cgroupRoot, filepath.FromSlash(rel))
+	controllers, err := ioutil.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return "", err
+	}
+	for _, c := range []string{"memory", "pids"} {
+		if !strings.Contains(" "+strings.TrimSpace(string(controllers))+" ", " "+c+" ") {
+			return "", fmt.Errorf("cgroup controller %q not available in %s", c, dir)
+		}
+	}
+	leaf := filepath.Join(dir, "buildlet")
+	if err := os.MkdirAll(leaf, 0755); err != nil {
+		return "", err
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return "", fmt.Errorf("moving buildlet to %s: %v", leaf, err)
+	}
+	if err := writeCgroupFile(dir, "cgroup.subtree_control", "+memory +pids"); err != nil {
+		// Most likely other processes share the buildlet's cgroup.
+		// They aren't ours to move, so leave them be.
+		return "", fmt.Errorf("enabling controllers (is the buildlet alone in %s?): %v", dir, err)
+	}
+	return dir, nil
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
+
+var cgroupSeq int64
+
+// cgroupLimiter runs a command in its own cgroup.
+type cgroupLimiter struct {
+	dir string
+}
+
+// newCgroupLimiter creates a cgroup under parent with the limits lim
+// and arranges for cmd to run in it.
+//
+// cmd is run via a shell that moves itself into the cgroup before
+// executing the command, so that no process the command starts can
+// escape the limits, as it could if the command was moved after
+// being started.
+func newCgroupLimiter(cmd *exec.Cmd, parent string, lim execLimits) (limiter, error) {
+	dir := filepath.Join(parent, fmt.Sprintf("exec-%d", atomic.AddInt64(&cgroupSeq, 1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	l := &cgroupLimiter{dir: dir}
+	if n := lim.maxMemory; n > 0 {
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(n, 10)); err != nil {
+			l.done()
+			return nil, err
+		}
+		// Count swap against the limit too, if swap accounting is on.
+		writeCgroupFile(dir, "memory.swap.max", "0")
+	}
+	if n := lim.maxProcs; n > 0 {
+		if err := writeCgroupFile(dir, "pids.max", strconv.Itoa(n)); err != nil {
+			l.done()
+			return nil, err
+		}
+	}
+	cmd.Args = append([]string{"sh", "-c", `echo $$ > "$1" && shift && exec "$@"`, "sh", filepath.Join(dir, "cgroup.procs"), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+	return l, nil
+}
+
+// started does nothing; the command moved itself into the cgroup.
+func (*cgroupLimiter) started(*os.Process) error { return nil }
+
+func (l *cgroupLimiter) exceeded() string {
+	if cgroupEventCount(l.dir, "memory.events", "oom_kill") > 0 {
+		return buildlet.LimitMemory
+	}
+	if cgroupEventCount(l.dir, "pids.events", "max") > 0 {
+		return buildlet.LimitProcs
+	}
+	return ""
+}
+
+// cgroupEventCount returns the value of key in a cgroup's flat-keyed
+// events file, or 0 if unavailable.
+func cgroupEventCount(dir, file, key string) int64 {
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == key {
+			n, _ := strconv.ParseInt(f[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// kill kills every process in the cgroup, including any that left
+// the command's process group.
+func (l *cgroupLimiter) kill() {
+	if writeCgroupFile(l.dir, "cgroup.kill", "1") != nil {
+		// Kernels before 5.14 lack cgroup.kill.
+		procs, _ := ioutil.ReadFile(filepath.Join(l.dir, "cgroup.procs"))
+		for _, v := range strings.Fields(string(procs)) {
+			if pid, err := strconv.Atoi(v); err == nil {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	}
+}
+
+// done kills any processes left in the cgroup and removes it.
+func (l *cgroupLimiter) done() {
+	for try := 0; try < 10; try++ {
+		err := os.Remove(l.dir)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		l.kill()
+		time.Sleep(10 * time.Millisecond * time.Duration(try+1))
+	}
+	log.Printf("failed to remove cgroup %s", l.dir)
+}
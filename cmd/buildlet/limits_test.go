@@ -0,0 +1,158 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestParseExecLimits(t *testing.T) {
+	for _, tc := range []struct {
+		form    string
+		want    execLimits
+		wantErr bool
+	}{
+		{form: "", want: execLimits{}},
+		{form: "timeout=1m30s", want: execLimits{timeout: 90 * time.Second}},
+		{form: "timeout=-1s", wantErr: true},
+		{form: "timeout=soon", wantErr: true},
+		{form: "maxMemory=0", wantErr: true},
+		{form: "maxProcs=x", wantErr: true},
+	} {
+		r := httptest.NewRequest("POST", "/exec", strings.NewReader(tc.form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		got, err := parseExecLimits(r)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseExecLimits(%q) = %+v, nil; want error", tc.form, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseExecLimits(%q) = %+v, %v; want %+v, nil", tc.form, got, err, tc.want)
+		}
+	}
+}
+
+func TestExecTimeoutLimit(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	ts := httptest.NewServer(http.HandlerFunc(handleExec))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+
+	res, err := bc.ExecWithResult(context.Background(), "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Args:        []string{"-c", "exec sleep 60"},
+		Timeout:     100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("ExecWithResult: %v", err)
+	}
+	if !res.TimedOut || res.Limit != buildlet.LimitTimeout {
+		t.Errorf("ExecWithResult = %+v; want timed out", res)
+	}
+	if res.WallTime > 30*time.Second {
+		t.Errorf("command ran for %v; want it killed after ~100ms", res.WallTime)
+	}
+	if res.Err() == nil || !strings.Contains(res.Err().Error(), "timeout limit exceeded") {
+		t.Errorf("res.Err() = %v; want timeout limit error", res.Err())
+	}
+}
+
+// Tests that a timeout kills the processes a command started, too,
+// and doesn't wait for them to close its output.
+func TestExecTimeoutKillsChildren(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	ts := httptest.NewServer(http.HandlerFunc(handleExec))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+
+	t0 := time.Now()
+	res, err := bc.ExecWithResult(context.Background(), "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		// The shell forks sleep, which inherits its output.
+		Args:    []string{"-c", "sleep 30; echo done"},
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("ExecWithResult: %v", err)
+	}
+	if !res.TimedOut {
+		t.Errorf("ExecWithResult = %+v; want timed out", res)
+	}
+	// Returning after execWaitDelay would mean that sleep wasn't
+	// killed and only the output wait bound ended the command.
+	if d := time.Since(t0); d >= execWaitDelay {
+		t.Errorf("ExecWithResult returned after %v; want soon after the 200ms timeout", d)
+	}
+}
+
+// Tests that a command without a timeout or limits still gets the
+// output of processes it leaves running in the background, as
+// commands always have.
+func TestExecUnlimitedWaitsForChildren(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		t.Skipf("skipping on %s; test uses sh", runtime.GOOS)
+	}
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+
+	ts := httptest.NewServer(http.HandlerFunc(handleExec))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := buildlet.NewClient(u.Host, buildlet.NoKeyPair)
+	defer bc.Close()
+
+	var out strings.Builder
+	res, err := bc.ExecWithResult(context.Background(), "sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Output:      &out,
+		Args:        []string{"-c", "(sleep 0.5; echo late) & echo early"},
+	})
+	if err != nil {
+		t.Fatalf("ExecWithResult: %v", err)
+	}
+	if res.Err() != nil {
+		t.Fatalf("ExecWithResult: %v", res.Err())
+	}
+	if got, want := out.String(), "early\nlate\n"; got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || illumos || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos netbsd openbsd solaris
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	newLimiter = newUlimitLimiter
+}
+
+// newUlimitLimiter runs cmd via a shell that first lowers its own
+// rlimits with ulimit, as there's no portable way to set rlimits on
+// a child process from Go.
+func newUlimitLimiter(cmd *exec.Cmd, lim execLimits) (limiter, error) {
+	var script []string
+	if lim.maxMemory > 0 {
+		script = append(script, fmt.Sprintf("ulimit -v %d", (lim.maxMemory+1023)/1024))
+	}
+	if lim.maxProcs > 0 {
+		script = append(script, fmt.Sprintf("ulimit -u %d", lim.maxProcs))
+	}
+	script = append(script, `exec "$@"`)
+	cmd.Args = append([]string{"sh", "-c", strings.Join(script, " && "), "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+	return ulimitLimiter{}, nil
+}
+
+type ulimitLimiter struct{}
+
+func (ulimitLimiter) started(*os.Process) error { return nil }
+func (ulimitLimiter) exceeded() string          { return "" }
+func (ulimitLimiter) kill()                     {}
+func (ulimitLimiter) done()                     {}
@@ -0,0 +1,217 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This file implements /metrics, which serves the buildlet's metrics
+// in the Prometheus text exposition format. It's written by hand,
+// rather than with a Prometheus client library, to keep the buildlet's
+// dependencies small and portable to all its platforms.
+
+var (
+	startTime = time.Now()
+
+	execTotal = newCounter("buildlet_exec_total",
+		"Commands run by /exec, by result: ok, failed (ran but didn't succeed) or error (couldn't be run).", "result")
+	execDuration = newHistogram("buildlet_exec_duration_seconds",
+		"Wall time of commands run by /exec.",
+		[]float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1200, 3600})
+	execRunning int64 // atomic; commands currently running for /exec
+
+	writeTGZBytes = newCounter("buildlet_writetgz_bytes_total",
+		"Bytes of tar.gz files read by /writetgz, from the request or its URL.", "")
+	getTGZBytes = newCounter("buildlet_tgz_bytes_total",
+		"Bytes of tar.gz files written by /tgz.", "")
+
+	reverseDials = newCounter("buildlet_reverse_dials_total",
+		"Connections dialed to the coordinator in reverse mode, by result: ok or error.", "result")
+)
+
+// Functionality set non-nil by some platforms, to report details of
+// the machine.
+var (
+	// diskFree returns the bytes available to the buildlet and the
+	// total size of the filesystem containing dir.
+	diskFree func(dir string) (avail, total uint64, err error)
+
+	// loadAverage returns the 1, 5 and 15 minute load averages.
+	loadAverage func() ([3]float64, error)
+)
+
+// counter is a Prometheus counter, optionally with a single label.
+type counter struct {
+	name, help, label string
+
+	mu   sync.Mutex
+	vals map[string]float64 // keyed by label value
+}
+
+// histogram is a Prometheus histogram without labels.
+type histogram struct {
+	name, help string
+	bounds     []float64 // upper bounds of the buckets, ascending
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// registeredMetrics are the counters and histograms in the order
+// they're served, after the gauges.
+var registeredMetrics []interface{ write(io.Writer) }
+
+func newCounter(name, help, label string) *counter {
+	c := &counter{name: name, help: help, label: label, vals: map[string]float64{}}
+	registeredMetrics = append(registeredMetrics, c)
+	return c
+}
+
+func newHistogram(name, help string, bounds []float64) *histogram {
+	h := &histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+	registeredMetrics = append(registeredMetrics, h)
+	return h
+}
+
+// add adds v to the counter with the given label value, which must be
+// empty if the counter has no label.
+func (c *counter) add(labelValue string, v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vals[labelValue] += v
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(c.vals[""]))
+		return
+	}
+	keys := make([]string, 0, len(c.vals))
+	for k := range c.vals {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", c.name, c.label, k, formatFloat(c.vals[k]))
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cum uint64
+	for i, b := range h.bounds {
+		cum += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(b), cum)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, formatFloat(v))
+}
+
+// recordExec records the result of a command run by /exec.
+func recordExec(ran, ok bool, wall time.Duration) {
+	result := "ok"
+	switch {
+	case !ran:
+		result = "error"
+	case !ok:
+		result = "failed"
+	}
+	execTotal.add(result, 1)
+	if ran {
+		execDuration.observe(wall.Seconds())
+	}
+}
+
+// meteredReader adds the bytes read from an io.Reader to a counter.
+type meteredReader struct {
+	r io.Reader
+	c *counter
+}
+
+func (mr meteredReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	mr.c.add("", float64(n))
+	return n, err
+}
+
+// meteredWriter adds the bytes written to an io.Writer to a counter.
+type meteredWriter struct {
+	w io.Writer
+	c *counter
+}
+
+func (mw meteredWriter) Write(p []byte) (int, error) {
+	n, err := mw.w.Write(p)
+	mw.c.add("", float64(n))
+	return n, err
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "requires GET method", http.StatusBadRequest)
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# HELP buildlet_info Information about the buildlet, as labels.\n# TYPE buildlet_info gauge\n")
+	fmt.Fprintf(&buf, "buildlet_info{version=%q,goos=%q,goarch=%q,reverse_type=%q} 1\n",
+		strconv.Itoa(buildletVersion), runtime.GOOS, runtime.GOARCH, *reverseType)
+	writeGauge(&buf, "buildlet_start_time_seconds", "When the buildlet started, in seconds since the Unix epoch.",
+		float64(startTime.UnixNano())/1e9)
+	writeGauge(&buf, "buildlet_exec_running", "Commands currently running for /exec.",
+		float64(atomic.LoadInt64(&execRunning)))
+	if diskFree != nil {
+		if avail, total, err := diskFree(*workDir); err == nil {
+			writeGauge(&buf, "buildlet_workdir_avail_bytes", "Bytes available to the buildlet on the work directory's filesystem.", float64(avail))
+			writeGauge(&buf, "buildlet_workdir_size_bytes", "Size of the work directory's filesystem.", float64(total))
+		}
+	}
+	if loadAverage != nil {
+		if load, err := loadAverage(); err == nil {
+			writeGauge(&buf, "buildlet_load1", "1-minute load average.", load[0])
+			writeGauge(&buf, "buildlet_load5", "5-minute load average.", load[1])
+			writeGauge(&buf, "buildlet_load15", "15-minute load average.", load[2])
+		}
+	}
+	for _, m := range registeredMetrics {
+		m.write(&buf)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	loadAverage = loadAverageSysctl
+}
+
+// loadAverageSysctl returns the load averages from the vm.loadavg
+// sysctl, a struct loadavg:
+//
+//	struct loadavg {
+//		fixpt_t ldavg[3]; // uint32
+//		long    fscale;
+//	};
+func loadAverageSysctl() ([3]float64, error) {
+	var load [3]float64
+	b, err := unix.SysctlRaw("vm.loadavg")
+	if err != nil {
+		return load, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	switch runtime.GOARCH {
+	case "mips", "mips64", "ppc64", "sparc64":
+		order = binary.BigEndian
+	}
+	var fscale float64
+	switch len(b) {
+	case 16: // 32-bit long
+		fscale = float64(int32(order.Uint32(b[12:])))
+	case 24: // 64-bit long, after 4 bytes of padding
+		fscale = float64(int64(order.Uint64(b[16:])))
+	default:
+		return load, fmt.Errorf("unexpected vm.loadavg size %d", len(b))
+	}
+	if fscale <= 0 {
+		return load, fmt.Errorf("bad vm.loadavg fscale %v", fscale)
+	}
+	for i := range load {
+		load[i] = float64(order.Uint32(b[4*i:])) / fscale
+	}
+	return load, nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	loadAverage = loadAverageLinux
+}
+
+func loadAverageLinux() ([3]float64, error) {
+	var load [3]float64
+	b, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return load, err
+	}
+	f := strings.Fields(string(b))
+	if len(f) < 3 {
+		return load, fmt.Errorf("malformed /proc/loadavg: %q", b)
+	}
+	for i := range load {
+		if load[i], err = strconv.ParseFloat(f[i], 64); err != nil {
+			return load, fmt.Errorf("malformed /proc/loadavg: %q", b)
+		}
+	}
+	return load, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	c := &counter{name: "test_total", help: "Things.", label: "result", vals: map[string]float64{}}
+	c.add("ok", 2)
+	c.add("error", 1)
+	h := &histogram{name: "test_seconds", help: "Durations.", bounds: []float64{1, 10}, counts: make([]uint64, 3)}
+	for _, v := range []float64{0.5, 1, 5, 100} {
+		h.observe(v)
+	}
+	var buf bytes.Buffer
+	c.write(&buf)
+	h.write(&buf)
+	want := `# HELP test_total Things.
+# TYPE test_total counter
+test_total{result="error"} 1
+test_total{result="ok"} 2
+# HELP test_seconds Durations.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="10"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 106.5
+test_seconds_count 4
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	recordExec(true, true, 2*time.Second)
+	meteredReader{strings.NewReader("12345"), writeTGZBytes}.Read(make([]byte, 10))
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, want := range []string{
+		"# TYPE buildlet_info gauge\n",
+		"buildlet_exec_running 0\n",
+		"buildlet_exec_total{result=\"ok\"} ",
+		"buildlet_exec_duration_seconds_count ",
+		"buildlet_writetgz_bytes_total ",
+		"# TYPE buildlet_tgz_bytes_total counter\n",
+	} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("/metrics output is missing %q; got:\n%s", want, body)
+		}
+	}
+	if loadAverage != nil && !bytes.Contains(body, []byte("buildlet_load1 ")) {
+		t.Errorf("/metrics output is missing the load average; got:\n%s", body)
+	}
+}
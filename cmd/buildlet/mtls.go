@@ -0,0 +1,117 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// In mutual TLS mode, enabled by the tls-client-ca metadata, clients
+// authenticate with a certificate issued by a buildlet.SessionCA for
+// the session named by the tls-client-session metadata, rather than
+// with a password.
+
+// clientCARefresh is how often the client CA certificates are
+// reloaded from metadata, so the session CA can be rotated without
+// restarting the buildlet.
+var clientCARefresh = time.Minute
+
+// clientCAs is the pool of CA certificates trusted to issue client
+// certificates. It's reloaded in the background by refreshLoop, so
+// TLS handshakes never wait for, or fail because of, the metadata
+// server.
+type clientCAs struct {
+	load func() (string, error) // returns the PEM-encoded CA certificates
+
+	mu   sync.Mutex
+	pool *x509.CertPool // nil until the first load
+	pem  string
+}
+
+// get returns the current pool. Until a pool has been loaded, it
+// returns an empty pool, trusting nothing.
+func (c *clientCAs) get() *x509.CertPool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool == nil {
+		return x509.NewCertPool()
+	}
+	return c.pool
+}
+
+// refresh reloads the CA certificates. If they can't be loaded or
+// none are valid, the previous pool is kept.
+func (c *clientCAs) refresh() {
+	v, err := c.load()
+	if err != nil {
+		log.Printf("loading client CA metadata: %v; keeping previous CAs", err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pool != nil && v == c.pem {
+		return
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(v)) {
+		log.Printf("no valid certificates in client CA metadata; keeping previous CAs")
+		return
+	}
+	c.pool, c.pem = pool, v
+}
+
+// refreshLoop calls refresh every clientCARefresh, forever.
+func (c *clientCAs) refreshLoop() {
+	for {
+		time.Sleep(clientCARefresh)
+		c.refresh()
+	}
+}
+
+// tlsServerConfig returns the TLS config for the buildlet's HTTPS
+// server. If cas is non-nil, client certificates are requested and
+// must be signed by one of cas if presented. Connections without one
+// are still allowed, for health checks, but get no further than
+// requireSessionCertHandler.
+func tlsServerConfig(cert tls.Certificate, cas *clientCAs) *tls.Config {
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if cas != nil {
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+		conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c := conf.Clone()
+			c.GetConfigForClient = nil
+			c.ClientCAs = cas.get()
+			return c, nil
+		}
+	}
+	return conf
+}
+
+// requireSessionCertHandler is an http.Handler auth wrapper for mutual
+// TLS mode. It requires a verified client certificate whose common
+// name is the buildlet's session, if that's non-empty.
+type requireSessionCertHandler struct {
+	h       http.Handler
+	session string
+}
+
+func (h requireSessionCertHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return
+	}
+	if cn := r.TLS.VerifiedChains[0][0].Subject.CommonName; h.session != "" && cn != h.session {
+		http.Error(w, "client certificate is for another session", http.StatusForbidden)
+		return
+	}
+	h.h.ServeHTTP(w, r)
+}
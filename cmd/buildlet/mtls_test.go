@@ -0,0 +1,86 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestMutualTLS(t *testing.T) {
+	kp, err := buildlet.NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair([]byte(kp.CertPEM), []byte(kp.KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCA, err := buildlet.NewSessionCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCA, err := buildlet.NewSessionCA()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	caPEM := oldCA.CertPEM
+	var loadErr error
+	cas := &clientCAs{load: func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return caPEM, loadErr
+	}}
+	cas.refresh()
+	ts := httptest.NewUnstartedServer(requireSessionCertHandler{http.HandlerFunc(handleStatus), "vm-1"})
+	ts.TLS = tlsServerConfig(cert, cas)
+	ts.StartTLS()
+	defer ts.Close()
+	ipPort := strings.TrimPrefix(ts.URL, "https://")
+
+	check := func(desc string, bc buildlet.Client, wantOK bool) {
+		t.Helper()
+		defer bc.Close()
+		_, err := bc.Status(context.Background())
+		if wantOK && err != nil {
+			t.Errorf("%s: Status: %v", desc, err)
+		} else if !wantOK && err == nil {
+			t.Errorf("%s: Status succeeded; want error", desc)
+		}
+	}
+	check("session cert", buildlet.NewSessionClient(ipPort, kp, oldCA, "vm-1"), true)
+	check("other session's cert", buildlet.NewSessionClient(ipPort, kp, oldCA, "vm-2"), false)
+	check("password", buildlet.NewClient(ipPort, kp), false)
+	check("untrusted CA", buildlet.NewSessionClient(ipPort, kp, newCA, "vm-1"), false)
+
+	// Rotate the CA, trusting both during the changeover.
+	mu.Lock()
+	caPEM = oldCA.CertPEM + newCA.CertPEM
+	mu.Unlock()
+	cas.refresh()
+	check("old CA during rotation", buildlet.NewSessionClient(ipPort, kp, oldCA, "vm-1"), true)
+	check("new CA during rotation", buildlet.NewSessionClient(ipPort, kp, newCA, "vm-1"), true)
+	mu.Lock()
+	caPEM = newCA.CertPEM
+	mu.Unlock()
+	cas.refresh()
+	check("old CA after rotation", buildlet.NewSessionClient(ipPort, kp, oldCA, "vm-1"), false)
+
+	// A failure to read the metadata keeps the last good CAs.
+	mu.Lock()
+	caPEM, loadErr = "", errors.New("metadata server unavailable")
+	mu.Unlock()
+	cas.refresh()
+	check("new CA after failed reload", buildlet.NewSessionClient(ipPort, kp, newCA, "vm-1"), true)
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd illumos linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func init() {
+	setProcessGroup = setProcessGroupUnix
+	killProcessTree = killProcessGroup
+}
+
+// setProcessGroupUnix makes cmd the leader of a new process group,
+// so that killProcessGroup can kill it along with every process it
+// starts.
+func setProcessGroupUnix(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills the process group led by p. Processes that
+// moved themselves into another process group or session aren't
+// killed; on Linux, the cgroup of a command with limits catches those.
+//
+// If p doesn't lead a process group, only p is killed.
+func killProcessGroup(p *os.Process) error {
+	err := syscall.Kill(-p.Pid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return p.Kill()
+	}
+	return err
+}
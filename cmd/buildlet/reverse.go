@@ -92,6 +92,7 @@ func dialCoordinator() (net.Listener, error) {
 		t0 := time.Now()
 		tcpConn, err := dialCoordinatorTCP(ctx, addr)
 		if err != nil {
+			reverseDials.add("error", 1)
 			log.Printf("buildlet: reverse dial coordinator (%q) error after %v: %v", addr, time.Since(t0).Round(time.Second/100), err)
 			return nil, err
 		}
@@ -105,9 +106,11 @@ func dialCoordinator() (net.Listener, error) {
 		}
 		conn := tls.Client(tcpConn, config)
 		if err := conn.Handshake(); err != nil {
+			reverseDials.add("error", 1)
 			return nil, fmt.Errorf("failed to handshake with coordinator: %v", err)
 		}
 		tcpConn.SetDeadline(time.Time{})
+		reverseDials.add("ok", 1)
 		return conn, nil
 	}
 	conn, err := dial(context.Background())
@@ -0,0 +1,505 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+// Snapshots are named copies of directories in the work directory,
+// made by /snapshots/create, that /snapshots/restore can put back
+// much faster than re-extracting a tarball. They're kept next to the
+// work directory rather than in it, so they survive a RemoveAll of the
+// work directory, and are on the same filesystem for reflinks and
+// overlays. They're only recorded in memory, so they last as long as
+// the buildlet process: a buildlet exits on /halt, at the end of each
+// build or gomote session, so snapshots are for reuse within one
+// session, not across builds.
+//
+// Snapshots are copied with reflinks where the filesystem supports
+// them, so making one is cheap. Where possible, restoring one mounts
+// an overlay filesystem with the snapshot as its read-only lower
+// layer; otherwise the snapshot is copied back.
+
+// maxSnapshots is how many snapshots are kept before the least
+// recently used are deleted.
+const maxSnapshots = 5
+
+// Functionality set non-nil by some platforms, to make snapshots
+// faster.
+var (
+	// cloneFile creates dst as a copy-on-write clone of the regular
+	// file src (a reflink).
+	cloneFile func(src, dst string) error
+
+	// mountOverlay mounts an overlay filesystem at dir that shows
+	// lower with any changes stored in upper, using work as scratch
+	// space. unmountOverlay undoes it.
+	mountOverlay   func(dir, lower, upper, work string) error
+	unmountOverlay func(dir string) error
+)
+
+var snapshotNameRx = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+var snapshots = &snapshotStore{
+	snaps:    map[string]*snapshot{},
+	overlays: map[string]*overlay{},
+}
+
+type snapshotStore struct {
+	mu       sync.Mutex // guards the fields below, except noReflinks
+	root     string     // initialized by init
+	snaps    map[string]*snapshot
+	overlays map[string]*overlay // keyed by absolute mount point
+	seq      int                 // for unique directory names
+
+	noOverlays bool // mountOverlay failed
+
+	// noReflinks is non-zero once cloneFile failed as unsupported.
+	// It's accessed atomically, since snapshots are copied without
+	// holding mu.
+	noReflinks int32
+}
+
+type snapshot struct {
+	buildlet.SnapshotInfo
+	tree     string // absolute path of the copy
+	lastUsed time.Time
+}
+
+// overlay is a restored snapshot mounted as an overlay filesystem.
+type overlay struct {
+	snap    *snapshot
+	scratch string // holds the upper and work directories
+}
+
+// init prepares the snapshot directory, discarding snapshots left by
+// a previous buildlet process, which it has no record of.
+func (s *snapshotStore) init() error {
+	if s.root != "" {
+		return nil
+	}
+	root := filepath.Clean(*workDir) + ".snapshots"
+	if err := removeAllIncludingReadonly(root); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	s.root = root
+	return nil
+}
+
+func (s *snapshotStore) tempDir(kind string) string {
+	s.seq++
+	return filepath.Join(s.root, kind+"-"+strconv.Itoa(s.seq))
+}
+
+// inUse reports whether snap is the lower layer of a mounted overlay.
+func (s *snapshotStore) inUse(snap *snapshot) bool {
+	for _, o := range s.overlays {
+		if o.snap == snap {
+			return true
+		}
+	}
+	return false
+}
+
+// create snapshots dir, a directory in the work directory, as name.
+// The copy is made without holding s.mu, so that other snapshots can
+// be used meanwhile, and is only added to s.snaps once it's complete.
+func (s *snapshotStore) create(name, dir string) (*buildlet.SnapshotInfo, error) {
+	src := filepath.Join(*workDir, filepath.FromSlash(dir))
+	if fi, err := os.Stat(src); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, badRequest(fmt.Sprintf("%q is not a directory", dir))
+	}
+	s.mu.Lock()
+	if err := s.init(); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	if err := s.checkReplaceable(name); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	snap := &snapshot{tree: s.tempDir("snap")}
+	s.mu.Unlock()
+
+	t0 := time.Now()
+	st, err := s.copyTree(src, snap.tree)
+	if err != nil {
+		removeAllIncludingReadonly(snap.tree)
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The old snapshot may have been restored in an overlay while
+	// copying.
+	if err := s.checkReplaceable(name); err != nil {
+		removeAllIncludingReadonly(snap.tree)
+		return nil, err
+	}
+	if old := s.snaps[name]; old != nil {
+		if err := s.remove(old); err != nil {
+			removeAllIncludingReadonly(snap.tree)
+			return nil, err
+		}
+	}
+	snap.SnapshotInfo = buildlet.SnapshotInfo{
+		Name:    name,
+		Dir:     path.Clean(dir),
+		Files:   st.files,
+		Size:    st.size,
+		Method:  st.method(),
+		Created: t0,
+	}
+	snap.lastUsed = t0
+	s.snaps[name] = snap
+	log.Printf("snapshot %s: copied %s (%d files, %d bytes) with %s in %v", name, dir, st.files, st.size, snap.Method, time.Since(t0))
+	s.evict()
+	return &snap.SnapshotInfo, nil
+}
+
+// checkReplaceable returns an error if there's a snapshot name that
+// can't be replaced, because it's in use by an overlay.
+//
+// It requires that s.mu be held.
+func (s *snapshotStore) checkReplaceable(name string) error {
+	if old := s.snaps[name]; old != nil && s.inUse(old) {
+		return httpError{http.StatusConflict, fmt.Sprintf("snapshot %q is restored in an overlay; remove it first", name)}
+	}
+	return nil
+}
+
+// evict removes the least recently used snapshots not in use by an
+// overlay until at most maxSnapshots are left.
+func (s *snapshotStore) evict() {
+	var idle []*snapshot
+	for _, snap := range s.snaps {
+		if !s.inUse(snap) {
+			idle = append(idle, snap)
+		}
+	}
+	sort.Slice(idle, func(i, j int) bool { return idle[i].lastUsed.Before(idle[j].lastUsed) })
+	for i := 0; len(s.snaps) > maxSnapshots && i < len(idle); i++ {
+		log.Printf("snapshot %s: evicting", idle[i].Name)
+		if err := s.remove(idle[i]); err != nil {
+			log.Printf("snapshot %s: %v", idle[i].Name, err)
+		}
+	}
+}
+
+func (s *snapshotStore) remove(snap *snapshot) error {
+	delete(s.snaps, snap.Name)
+	return removeAllIncludingReadonly(snap.tree)
+}
+
+// restore replaces dir, a directory in the work directory, with the
+// contents of snapshot name. If dir is empty, the snapshot's original
+// directory is used. It reports how the snapshot was restored.
+func (s *snapshotStore) restore(name, dir string) (method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := s.snaps[name]
+	if snap == nil {
+		return "", httpError{http.StatusNotFound, fmt.Sprintf("no snapshot %q", name)}
+	}
+	if dir == "" {
+		dir = snap.Dir
+	}
+	dst := filepath.Join(*workDir, filepath.FromSlash(dir))
+	if err := s.unmountUnder(dst); err != nil {
+		return "", err
+	}
+	if err := removeAllIncludingReadonly(dst); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return "", err
+	}
+	snap.lastUsed = time.Now()
+
+	if mountOverlay != nil && !s.noOverlays {
+		o := &overlay{snap: snap, scratch: s.tempDir("overlay")}
+		upper, work := filepath.Join(o.scratch, "upper"), filepath.Join(o.scratch, "work")
+		err := os.MkdirAll(upper, 0755)
+		if err == nil {
+			err = os.MkdirAll(work, 0755)
+		}
+		if err == nil {
+			err = mountOverlay(dst, snap.tree, upper, work)
+		}
+		if err == nil {
+			s.overlays[dst] = o
+			return "overlay", nil
+		}
+		log.Printf("snapshot %s: overlay mount failed, copying instead from now on: %v", name, err)
+		s.noOverlays = true
+		removeAllIncludingReadonly(o.scratch)
+	}
+	st, err := s.copyTree(snap.tree, dst)
+	if err != nil {
+		return "", err
+	}
+	return st.method(), nil
+}
+
+// unmountUnder unmounts the overlays at or below dir, so it can be
+// removed.
+func (s *snapshotStore) unmountUnder(dir string) error {
+	for mnt, o := range s.overlays {
+		if mnt != dir && !strings.HasPrefix(mnt, dir+string(filepath.Separator)) {
+			continue
+		}
+		if err := unmountOverlay(mnt); err != nil {
+			return fmt.Errorf("unmounting snapshot overlay at %s: %v", mnt, err)
+		}
+		delete(s.overlays, mnt)
+		removeAllIncludingReadonly(o.scratch)
+	}
+	return nil
+}
+
+// prepareRemoveAll is called before removing dir from the work
+// directory, to unmount any snapshot overlays in it.
+func prepareRemoveAll(dir string) error {
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	return snapshots.unmountUnder(dir)
+}
+
+type copyStats struct {
+	files, cloned int
+	size          int64
+}
+
+func (st copyStats) method() string {
+	if st.files > 0 && st.cloned == st.files {
+		return "reflink"
+	}
+	return "copy"
+}
+
+// copyTree copies the directory tree src to the new directory dst,
+// using reflinks where possible.
+func (s *snapshotStore) copyTree(src, dst string) (copyStats, error) {
+	var st copyStats
+	type dirMode struct {
+		dir  string
+		mode os.FileMode
+	}
+	var dirModes []dirMode
+	err := filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			// Make directories writable by us until we're done with
+			// them, and fix their permissions afterwards.
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			if mode.Perm() != 0755 {
+				dirModes = append(dirModes, dirMode{target, mode.Perm()})
+			}
+			return nil
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case mode.IsRegular():
+			cloned, err := s.copyFile(p, target, fi)
+			if err != nil {
+				return err
+			}
+			st.files++
+			st.size += fi.Size()
+			if cloned {
+				st.cloned++
+			}
+			return nil
+		default:
+			// Sockets, devices and the like aren't build outputs.
+			return nil
+		}
+	})
+	if err != nil {
+		return st, err
+	}
+	for _, dm := range dirModes {
+		if err := os.Chmod(dm.dir, dm.mode); err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+// copyFile copies the regular file src, described by fi, to dst,
+// reporting whether it was cloned.
+func (s *snapshotStore) copyFile(src, dst string, fi os.FileInfo) (cloned bool, err error) {
+	if cloneFile != nil && atomic.LoadInt32(&s.noReflinks) == 0 {
+		err := cloneFile(src, dst)
+		if err == nil {
+			return true, os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+		}
+		log.Printf("snapshot: reflinks unavailable, copying instead from now on: %v", err)
+		atomic.StoreInt32(&s.noReflinks, 1)
+		os.Remove(dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+	return false, os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
+
+// snapshotName returns the validated "name" parameter.
+func snapshotName(r *http.Request) (string, error) {
+	name := r.FormValue("name")
+	if !snapshotNameRx.MatchString(name) || name == "." || name == ".." {
+		return "", badRequest(fmt.Sprintf("bad 'name' parameter %q", name))
+	}
+	return name, nil
+}
+
+// handleSnapshotCreate snapshots the directory "dir" as "name",
+// replacing any existing snapshot of that name, and replies with the
+// JSON buildlet.SnapshotInfo.
+func handleSnapshotCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	name, err := snapshotName(r)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	dir := r.FormValue("dir")
+	if !validRelativeDir(dir) || path.Clean(dir) == "." {
+		fileOpError(w, badRequest(fmt.Sprintf("bad 'dir' parameter %q", dir)))
+		return
+	}
+	info, err := snapshots.create(name, dir)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	writeJSON(w, info)
+}
+
+// handleSnapshotRestore replaces the directory "dir", or the one the
+// snapshot was made of if empty, with the contents of snapshot "name".
+func handleSnapshotRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	name, err := snapshotName(r)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	dir := r.FormValue("dir")
+	if dir != "" && (!validRelativeDir(dir) || path.Clean(dir) == ".") {
+		fileOpError(w, badRequest(fmt.Sprintf("bad 'dir' parameter %q", dir)))
+		return
+	}
+	if !mkdirAllWorkdirOr500(w) {
+		return
+	}
+	t0 := time.Now()
+	method, err := snapshots.restore(name, dir)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	log.Printf("snapshot %s: restored with %s in %v", name, method, time.Since(t0))
+	w.Write([]byte(method))
+}
+
+// handleSnapshotList replies with the JSON []buildlet.SnapshotInfo of
+// all snapshots, sorted by name.
+func handleSnapshotList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "requires GET method", http.StatusBadRequest)
+		return
+	}
+	snapshots.mu.Lock()
+	infos := []buildlet.SnapshotInfo{}
+	for _, snap := range snapshots.snaps {
+		infos = append(infos, snap.SnapshotInfo)
+	}
+	snapshots.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	writeJSON(w, infos)
+}
+
+// handleSnapshotDelete deletes snapshot "name".
+func handleSnapshotDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	name, err := snapshotName(r)
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	snapshots.mu.Lock()
+	defer snapshots.mu.Unlock()
+	snap := snapshots.snaps[name]
+	switch {
+	case snap == nil:
+		err = httpError{http.StatusNotFound, fmt.Sprintf("no snapshot %q", name)}
+	case snapshots.inUse(snap):
+		err = httpError{http.StatusConflict, fmt.Sprintf("snapshot %q is restored in an overlay; remove it first", name)}
+	default:
+		err = snapshots.remove(snap)
+	}
+	if err != nil {
+		fileOpError(w, err)
+		return
+	}
+	w.Write([]byte("OK"))
+}
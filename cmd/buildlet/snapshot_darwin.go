@@ -0,0 +1,23 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	cloneFile = cloneFileDarwin
+}
+
+// cloneFileDarwin clones src with clonefile(2), which APFS supports.
+func cloneFileDarwin(src, dst string) error {
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		return &os.LinkError{Op: "clonefile", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
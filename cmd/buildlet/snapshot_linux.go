@@ -0,0 +1,60 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func init() {
+	cloneFile = cloneFileLinux
+	// Mounting requires root, which builders on GCE and most reverse
+	// builders run as.
+	if os.Geteuid() == 0 {
+		mountOverlay = mountOverlayLinux
+		unmountOverlay = unmountOverlayLinux
+	}
+}
+
+// cloneFileLinux clones src with the FICLONE ioctl, which btrfs and
+// XFS support.
+func cloneFileLinux(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		return &os.PathError{Op: "ioctl FICLONE", Path: dst, Err: err}
+	}
+	return out.Close()
+}
+
+func mountOverlayLinux(dir, lower, upper, work string) error {
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	if err := unix.Mount("overlay", dir, "overlay", 0, opts); err != nil {
+		return &os.PathError{Op: "mount overlay", Path: dir, Err: err}
+	}
+	return nil
+}
+
+func unmountOverlayLinux(dir string) error {
+	if err := unix.Unmount(dir, unix.MNT_DETACH); err != nil {
+		return &os.PathError{Op: "unmount", Path: dir, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestSnapshots(t *testing.T) {
+	oldWorkDir, oldSnapshots := *workDir, snapshots
+	t.Cleanup(func() { *workDir, snapshots = oldWorkDir, oldSnapshots })
+	*workDir = filepath.Join(t.TempDir(), "workdir")
+	snapshots = &snapshotStore{snaps: map[string]*snapshot{}, overlays: map[string]*overlay{}}
+
+	write := func(name, contents string) {
+		t.Helper()
+		p := filepath.Join(*workDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name, want string) {
+		t.Helper()
+		got, err := os.ReadFile(filepath.Join(*workDir, filepath.FromSlash(name)))
+		if want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s exists (%q, %v); want it removed", name, got, err)
+			}
+			return
+		}
+		if string(got) != want || err != nil {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	write("go/bin/go", "binary")
+	write("go/src/a.go", "package a")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshots/create", handleSnapshotCreate)
+	mux.HandleFunc("/snapshots/restore", handleSnapshotRestore)
+	mux.HandleFunc("/snapshots/list", handleSnapshotList)
+	mux.HandleFunc("/snapshots/delete", handleSnapshotDelete)
+	mux.HandleFunc("/removeall", handleRemoveAll)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	bc := buildlet.NewClient(strings.TrimPrefix(ts.URL, "http://"), buildlet.NoKeyPair)
+	defer bc.Close()
+	ctx := context.Background()
+	// Unmount any overlay before the temporary directory is removed.
+	defer bc.RemoveAll(ctx, ".")
+
+	si, err := bc.Snapshot(ctx, "built", "go")
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if si.Name != "built" || si.Dir != "go" || si.Files != 2 || si.Size != int64(len("binary")+len("package a")) {
+		t.Errorf("Snapshot = %+v; want 2 files of go", si)
+	}
+	if _, err := bc.Snapshot(ctx, "../escape", "go"); err == nil {
+		t.Errorf("Snapshot with bad name succeeded; want error")
+	}
+
+	// Dirty the tree and restore it, twice, since the second restore
+	// may have to replace an overlay.
+	for i := 0; i < 2; i++ {
+		write("go/src/a.go", "modified")
+		write("go/pkg/obj/junk", "junk")
+		method, err := bc.RestoreSnapshot(ctx, "built", "")
+		if err != nil {
+			t.Fatalf("RestoreSnapshot: %v", err)
+		}
+		t.Logf("restored with %s", method)
+		check("go/bin/go", "binary")
+		check("go/src/a.go", "package a")
+		check("go/pkg/obj/junk", "")
+	}
+
+	// Restoring to another directory leaves the snapshot intact.
+	if _, err := bc.RestoreSnapshot(ctx, "built", "go2"); err != nil {
+		t.Fatalf("RestoreSnapshot to go2: %v", err)
+	}
+	check("go2/src/a.go", "package a")
+	if err := bc.RemoveAll(ctx, "go", "go2"); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+
+	if _, err := bc.RestoreSnapshot(ctx, "missing", "go"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("RestoreSnapshot of missing snapshot: err = %v; want os.ErrNotExist", err)
+	}
+	list, err := bc.Snapshots(ctx)
+	if err != nil || len(list) != 1 || list[0].Name != "built" {
+		t.Errorf("Snapshots = %+v, %v; want just built", list, err)
+	}
+	if err := bc.DeleteSnapshot(ctx, "built"); err != nil {
+		t.Fatalf("DeleteSnapshot: %v", err)
+	}
+	if list, err := bc.Snapshots(ctx); err != nil || len(list) != 0 {
+		t.Errorf("Snapshots after delete = %+v, %v; want none", list, err)
+	}
+}
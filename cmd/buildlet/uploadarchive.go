@@ -0,0 +1,181 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+// handleUploadArchive archives the "dir" directory, relative to the
+// work directory, in the "format" given ("tgz" or "zip") and streams
+// it with a PUT request to "url", typically a pre-signed object
+// storage URL. It replies with a JSON buildlet.UploadResult.
+//
+// The archive is written to a temporary file before it's uploaded.
+func handleUploadArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "requires POST method", http.StatusBadRequest)
+		return
+	}
+	if !mkdirAllWorkdirOr500(w) {
+		return
+	}
+	dir := r.FormValue("dir")
+	if !validRelativeDir(dir) {
+		http.Error(w, "bogus dir", http.StatusBadRequest)
+		return
+	}
+	var writeArchive func(io.Writer, string) error
+	switch format := buildlet.ArchiveFormat(r.FormValue("format")); format {
+	case buildlet.ArchiveTGZ:
+		writeArchive = writeTGZ
+	case buildlet.ArchiveZip:
+		writeArchive = writeZip
+	default:
+		http.Error(w, fmt.Sprintf("unsupported archive format %q", format), http.StatusBadRequest)
+		return
+	}
+	urlStr := r.FormValue("url")
+	if u, err := url.Parse(urlStr); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		http.Error(w, "bad 'url' parameter", http.StatusBadRequest)
+		return
+	}
+	base := filepath.Join(*workDir, filepath.FromSlash(dir))
+	if fi, err := os.Stat(base); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if !fi.IsDir() {
+		http.Error(w, "dir is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	// The archive is spooled to a temporary file rather than streamed,
+	// so that it's sent with a Content-Length. S3 pre-signed URLs
+	// reject uploads with chunked transfer encoding.
+	t0 := time.Now()
+	tmp, err := ioutil.TempFile("", "uploadarchive-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sum := sha256.New()
+	var size countingWriter
+	if err := writeArchive(io.MultiWriter(tmp, sum, &size), base); err != nil {
+		log.Printf("uploadarchive: archiving %s: %v", base, err)
+		http.Error(w, fmt.Sprintf("archiving %s: %v", dir, err), http.StatusInternalServerError)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req, err := http.NewRequest("PUT", urlStr, tmp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.ContentLength = int64(size)
+	req = req.WithContext(r.Context())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("uploadarchive: uploading %s: %v", base, err)
+		http.Error(w, fmt.Sprintf("uploading archive: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		slurp, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4<<10))
+		log.Printf("uploadarchive: uploading %s: %v: %s", base, res.Status, slurp)
+		http.Error(w, fmt.Sprintf("uploading archive: %v: %s", res.Status, slurp), http.StatusInternalServerError)
+		return
+	}
+	result := buildlet.UploadResult{
+		Size:   int64(size),
+		SHA256: fmt.Sprintf("%x", sum.Sum(nil)),
+	}
+	log.Printf("uploadarchive: uploaded %d byte %s archive of %s in %v", result.Size, r.FormValue("format"), base, time.Since(t0))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(result)
+}
+
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// writeZip writes the tree rooted at base to w as a zip file.
+// As with writeTGZ, symlinks are archived as links.
+func writeZip(w io.Writer, base string) error {
+	zw := zip.NewWriter(w)
+	err := filepath.Walk(base, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(path, base)), "/")
+		if rel == "" {
+			return nil
+		}
+		zh, err := zip.FileInfoHeader(fi)
+		if err != nil {
+			return err
+		}
+		zh.Name = rel
+		switch {
+		case fi.IsDir():
+			zh.Name += "/"
+			zh.Method = zip.Store
+		case fi.Mode().IsRegular():
+			zh.Method = zip.Deflate
+		case fi.Mode()&os.ModeSymlink != 0:
+			zh.Method = zip.Store
+		default:
+			return nil
+		}
+		fw, err := zw.CreateHeader(zh)
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(fw, f)
+			return err
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(fw, target)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,175 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/build/buildlet"
+)
+
+// fakeBucket is an httptest stand-in for an object store accepting
+// uploads to pre-signed PUT URLs. Like S3, it rejects uploads without
+// a Content-Length.
+type fakeBucket struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		http.Error(w, "want PUT", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("sig") != "ok" {
+		http.Error(w, "bad signature", http.StatusForbidden)
+		return
+	}
+	if r.ContentLength < 0 {
+		http.Error(w, "MissingContentLength", http.StatusLengthRequired)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[r.URL.Path] = body
+}
+
+func TestUploadArchive(t *testing.T) {
+	oldWorkDir := *workDir
+	t.Cleanup(func() { *workDir = oldWorkDir })
+	*workDir = t.TempDir()
+	for name, contents := range map[string]string{
+		"out/a.txt":     "hello",
+		"out/sub/b.txt": "world",
+		"other/c.txt":   "not uploaded",
+	} {
+		p := filepath.Join(*workDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bucket := &fakeBucket{objects: map[string][]byte{}}
+	bs := httptest.NewServer(bucket)
+	defer bs.Close()
+	ts := httptest.NewServer(http.HandlerFunc(handleUploadArchive))
+	defer ts.Close()
+	bc := buildlet.NewClient(strings.TrimPrefix(ts.URL, "http://"), buildlet.NoKeyPair)
+	defer bc.Close()
+	ctx := context.Background()
+
+	want := []string{"a.txt=hello", "sub/", "sub/b.txt=world"}
+	for _, format := range []buildlet.ArchiveFormat{buildlet.ArchiveTGZ, buildlet.ArchiveZip} {
+		obj := "/bucket/out." + string(format)
+		res, err := bc.UploadArchive(ctx, "out", format, bs.URL+obj+"?sig=ok")
+		if err != nil {
+			t.Errorf("UploadArchive(%s): %v", format, err)
+			continue
+		}
+		bucket.mu.Lock()
+		got := bucket.objects[obj]
+		bucket.mu.Unlock()
+		if res.Size != int64(len(got)) || res.SHA256 != fmt.Sprintf("%x", sha256.Sum256(got)) {
+			t.Errorf("UploadArchive(%s) = %+v; want size %d and matching SHA-256", format, res, len(got))
+		}
+		var files []string
+		if format == buildlet.ArchiveTGZ {
+			files = tgzFiles(t, got)
+		} else {
+			files = zipFiles(t, got)
+		}
+		if fmt.Sprint(files) != fmt.Sprint(want) {
+			t.Errorf("%s archive has %q; want %q", format, files, want)
+		}
+	}
+
+	if _, err := bc.UploadArchive(ctx, "out", buildlet.ArchiveTGZ, bs.URL+"/bucket/denied?sig=bad"); err == nil {
+		t.Errorf("UploadArchive to URL with bad signature succeeded; want error")
+	}
+	if _, err := bc.UploadArchive(ctx, "out", "rar", bs.URL+"/bucket/x?sig=ok"); err == nil {
+		t.Errorf("UploadArchive with unknown format succeeded; want error")
+	}
+}
+
+// tgzFiles returns the sorted entries of a tar.gz, as "name=contents"
+// for regular files.
+func tgzFiles(t *testing.T, tgz []byte) []string {
+	zr, err := gzip.NewReader(bytes.NewReader(tgz))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	var files []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeDir {
+			files = append(files, h.Name)
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, h.Name+"="+string(b))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// zipFiles is like tgzFiles, for a zip file.
+func zipFiles(t *testing.T, zb []byte) []string {
+	zr, err := zip.NewReader(bytes.NewReader(zb), int64(len(zb)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			files = append(files, f.Name)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f.Name+"="+string(b))
+	}
+	sort.Strings(files)
+	return files
+}
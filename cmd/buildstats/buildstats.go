@@ -18,7 +18,7 @@ import (
 )
 
 var (
-	mode    = flag.String("mode", "", "one of 'sync', 'testspeed'")
+	mode    = flag.String("mode", "", "one of 'sync', 'testspeed', 'flakes'")
 	verbose = flag.Bool("v", false, "verbose")
 )
 
@@ -44,6 +44,9 @@ func main() {
 		if err := buildstats.SyncSpans(ctx, env); err != nil {
 			log.Fatalf("SyncSpans: %v", err)
 		}
+		if err := buildstats.SyncTestRetries(ctx, env); err != nil {
+			log.Fatalf("SyncTestRetries: %v", err)
+		}
 	case "testspeed":
 		ts, err := buildstats.QueryTestStats(ctx, env)
 		if err != nil {
@@ -59,6 +62,27 @@ func main() {
 					bs.Runs[test])
 			}
 		}
+	case "flakes":
+		ts, err := buildstats.QueryTestStats(ctx, env)
+		if err != nil {
+			log.Fatalf("QueryTestStats: %v", err)
+		}
+		fs, err := buildstats.QueryFlakeStats(ctx, env, ts)
+		if err != nil {
+			log.Fatalf("QueryFlakeStats: %v", err)
+		}
+		for _, builder := range fs.BuilderNames() {
+			for _, test := range fs.Tests(builder) {
+				tf := fs.Builders[builder][test]
+				fmt.Printf("%s\t%s\t%.3f\t%d\t%d\t%d\n",
+					builder,
+					test,
+					tf.FlakeRate(),
+					tf.Passed,
+					tf.Retries,
+					tf.Runs)
+			}
+		}
 	default:
 		log.Fatalf("unknown --mode=%s", *mode)
 	}
@@ -89,6 +89,8 @@ type buildStatus struct {
 
 	onceInitHelpers sync.Once // guards call of onceInitHelpersFunc
 	helpers         <-chan buildlet.Client
+	onceGoVersion   sync.Once          // guards setting goMinor
+	goMinor         int                // see goMinorVersion
 	ctx             context.Context    // used to start the build
 	cancel          context.CancelFunc // used to cancel context; for use by setDone only
 
@@ -96,6 +98,7 @@ type buildStatus struct {
 
 	mu              sync.Mutex          // guards following
 	canceled        bool                // whether this build was forcefully canceled, so errors should be ignored
+	preempted       bool                // whether this build was canceled to give its buildlet to more important work
 	schedItem       *schedule.SchedItem // for the initial buildlet (ignoring helpers for now)
 	logURL          string              // if non-empty, permanent URL of log
 	bc              buildlet.Client     // nil initially, until pool returns one
@@ -104,6 +107,28 @@ type buildStatus struct {
 	output          livelog.Buffer      // stdout and stderr
 	events          []eventAndTime
 	useSnapshotMemo map[string]bool // memoized result of useSnapshotFor(rev), where the key is rev
+	retriedTests    []string        // dist tests that failed but passed when retried
+	testShards      []testShard     // dist test runs, for the status page
+	testMakespan    testMakespan    // predicted and actual time to run dist tests
+	testResults     []*types.TestResult
+}
+
+// A testShard is one run of "go tool dist test" on one buildlet.
+type testShard struct {
+	buildlet  string
+	tests     []string
+	start     time.Time
+	predicted time.Duration // sum of the tests' historical durations
+	actual    time.Duration
+	err       error // non-nil if the tests failed or didn't run
+}
+
+// testMakespan compares how long a build's dist tests were expected
+// to take, given the buildlets it got, with how long they took.
+type testMakespan struct {
+	buildlets int
+	predicted time.Duration
+	actual    time.Duration
 }
 
 func (st *buildStatus) NameAndBranch() string {
@@ -160,6 +185,27 @@ func (st *buildStatus) cancelBuild() {
 	}
 }
 
+// preempt cancels a post-submit build whose buildlet the scheduler
+// has decided more important work needs. The build is added back as
+// work once it's stopped, rather than being reported as failed.
+func (st *buildStatus) preempt() {
+	st.mu.Lock()
+	if st.canceled || !st.done.IsZero() {
+		st.mu.Unlock()
+		return
+	}
+	st.preempted = true
+	st.mu.Unlock()
+	st.LogEventTime("preempted")
+	st.cancelBuild()
+}
+
+func (st *buildStatus) isPreempted() bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.preempted
+}
+
 func (st *buildStatus) setDone(succeeded bool) {
 	st.mu.Lock()
 	defer st.mu.Unlock()
@@ -200,8 +246,12 @@ func (st *buildStatus) start() {
 			}
 			st.setDone(err == nil)
 			clog.CoordinatorProcess().PutBuildRecord(st.buildRecord())
+			st.putTestResults()
 		}
 		markDone(st.BuilderRev)
+		if st.isPreempted() {
+			addWorkDetail(st.BuilderRev, st.commitDetail)
+		}
 	}()
 }
 
@@ -285,6 +335,7 @@ func (st *buildStatus) onceInitHelpersFunc() {
 		IsTry:      st.isTry(),
 		CommitTime: st.commitTime(),
 		Branch:     st.branch(),
+		Owner:      st.owner(),
 	}
 	st.helpers = getBuildlets(st.ctx, st.conf.NumTestHelpers(st.isTry()), schedTmpl, st)
 }
@@ -380,6 +431,10 @@ func (st *buildStatus) getBuildlet() (buildlet.Client, error) {
 		BuilderRev: st.BuilderRev,
 		CommitTime: st.commitTime(),
 		Branch:     st.branch(),
+		Owner:      st.owner(),
+	}
+	if !st.isTry() {
+		schedItem.OnPreempt = st.preempt
 	}
 	st.mu.Lock()
 	st.schedItem = schedItem
@@ -475,6 +530,11 @@ func (st *buildStatus) build() error {
 		remoteErr, err = st.runAllLegacy()
 	}
 	makeTest.Done(err)
+	if st.isCommunicationError(bc, err) {
+		// Count it against the machine if it's a reverse
+		// buildlet, while bc still identifies it.
+		pool.ReversePool().NoteCommunicationError(bc, err)
+	}
 
 	// bc (aka st.bc) may be invalid past this point, so let's
 	// close it to make sure we don't accidentally use it.
@@ -529,6 +589,10 @@ func (st *buildStatus) build() error {
 			}
 			return fmt.Errorf("Build succeeded but failed to report it to the dashboard: %v", err)
 		}
+		doneForcedRebuild(st.BuilderRev)
+		if remoteErr == nil {
+			st.putBuildResult(buildLog)
+		}
 	}
 	if remoteErr != nil {
 		return remoteErr
@@ -555,6 +619,15 @@ func (st *buildStatus) useKeepGoingFlag() bool {
 // It may be a normal TryBot (part of the default try set) or a SlowBot.
 func (st *buildStatus) isTry() bool { return st.trySet != nil }
 
+// owner returns the owner of the work, for fair scheduling of its
+// buildlets, or the empty string if none is known.
+func (st *buildStatus) owner() string {
+	if st.trySet == nil {
+		return ""
+	}
+	return st.trySet.owner()
+}
+
 // isSlowBot reports whether the build is an explicitly requested SlowBot.
 func (st *buildStatus) isSlowBot() bool {
 	if st.trySet == nil {
@@ -592,12 +665,18 @@ func (st *buildStatus) buildRecord() *types.BuildRecord {
 	st.mu.Lock()
 	defer st.mu.Unlock()
 	// TODO: buildlet instance name
-	if !st.done.IsZero() {
+	if st.preempted {
+		rec.EndTime = time.Now()
+		rec.Seconds = rec.EndTime.Sub(rec.StartTime).Seconds()
+		rec.Result = "preempted"
+	} else if !st.done.IsZero() {
 		rec.EndTime = st.done
 		rec.LogURL = st.logURL
 		rec.Seconds = rec.EndTime.Sub(rec.StartTime).Seconds()
 		if st.succeeded {
 			rec.Result = "ok"
+			rec.PassedAfterRetry = len(st.retriedTests) > 0
+			rec.RetriedTests = st.retriedTests
 		} else {
 			rec.Result = "fail"
 		}
@@ -701,6 +780,7 @@ func (st *buildStatus) crossCompileMakeAndSnapshot(config *dashboard.CrossCompil
 		BuilderRev: st.BuilderRev,
 		CommitTime: st.commitTime(),
 		Branch:     st.branch(),
+		Owner:      st.owner(),
 	})
 	sp.Done(err)
 	if err != nil {
@@ -837,7 +917,7 @@ func (st *buildStatus) writeGoSourceTo(bc buildlet.Client, rev, dir string) erro
 		return err
 	}
 	sp = st.CreateSpan("write_go_src_tar")
-	if err := bc.PutTar(st.ctx, srcTar, dir); err != nil {
+	if err := buildlet.PutTarDelta(st.ctx, bc, srcTar, dir); err != nil {
 		return sp.Done(fmt.Errorf("writing tarball from Gerrit: %v", err))
 	}
 	return sp.Done(nil)
@@ -998,8 +1078,9 @@ type token struct{}
 // and benchmark items.
 func (st *buildStatus) newTestSet(testStats *buildstats.TestStats, distTestNames []string) (*testSet, error) {
 	set := &testSet{
-		st:        st,
-		testStats: testStats,
+		st:          st,
+		testStats:   testStats,
+		retryBudget: st.conf.FlakyTestRetries(st.isTry()),
 	}
 	for _, name := range distTestNames {
 		set.items = append(set.items, &testItem{
@@ -1081,7 +1162,7 @@ func (st *buildStatus) runSubrepoTests() (remoteErr, err error) {
 		} else if err != nil {
 			return nil, err
 		}
-		err = st.bc.PutTar(st.ctx, tgz, "gopath/src/"+repoPath)
+		err = buildlet.PutTarDelta(st.ctx, st.bc, tgz, "gopath/src/"+repoPath)
 		if err != nil {
 			return nil, err
 		}
@@ -1313,7 +1394,7 @@ func (st *buildStatus) runBenchmarkTests() (remoteErr, err error) {
 		} else if err != nil {
 			return nil, err
 		}
-		err = st.bc.PutTar(st.ctx, tgz, "gopath/src/"+repoPath)
+		err = buildlet.PutTarDelta(st.ctx, st.bc, tgz, "gopath/src/"+repoPath)
 		if err != nil {
 			return nil, err
 		}
@@ -1434,10 +1515,12 @@ func (st *buildStatus) runTests(helpers <-chan buildlet.Client) (remoteErr, err
 	// the buildlets are dead or done.
 	var buildletActivity sync.WaitGroup
 	buildletActivity.Add(2) // one per goroutine below (main + helper launcher goroutine)
+	set.addBuildlet()
 	go func() {
 		defer buildletActivity.Done() // for the per-goroutine Add(2) above
+		defer set.removeBuildlet()
 		for !st.bc.IsBroken() {
-			tis, ok := set.testsToRunInOrder()
+			tis, ok := set.testsToRunInOrder(st.bc)
 			if !ok {
 				select {
 				case <-st.ctx.Done():
@@ -1473,11 +1556,24 @@ func (st *buildStatus) runTests(helpers <-chan buildlet.Client) (remoteErr, err
 					return
 				}
 				st.LogEventTime("test_helper_set_up", bc.Name())
+				set.addBuildlet()
+				defer set.removeBuildlet()
 				goroot := st.conf.FilePathJoin(workDir, "go")
 				gopath := st.conf.FilePathJoin(workDir, "gopath")
 				for !bc.IsBroken() {
-					tis, ok := set.testsToRunBiggestFirst()
+					tis, ok := set.testsToRunBiggestFirst(bc)
 					if !ok {
+						if set.mayRetry() {
+							// Stay around in case a test
+							// fails and needs to run again
+							// somewhere else.
+							select {
+							case <-st.ctx.Done():
+								return
+							case <-time.After(time.Second):
+							}
+							continue
+						}
 						st.LogEventTime("no_new_tests_remain", bc.Name())
 						return
 					}
@@ -1516,6 +1612,15 @@ func (st *buildStatus) runTests(helpers <-chan buildlet.Client) (remoteErr, err
 		}
 
 		serialDuration += ti.execDuration
+		for _, a := range ti.attempts {
+			if len(a.output) > 0 {
+				fmt.Fprintf(st, "\n%s\n", bytes.TrimSuffix(a.output, nl))
+			}
+			fmt.Fprintf(st, "\n%s failed on %s (%v); retrying.\n", ti.name, a.buildlet, a.err)
+		}
+		if len(ti.attempts) > 0 && ti.remoteErr == nil {
+			st.noteRetriedTest(ti.name)
+		}
 		if len(ti.output) > 0 {
 			metadata, header, out := parseOutputAndHeader(ti.output)
 			printHeader := false
@@ -1552,6 +1657,16 @@ func (st *buildStatus) runTests(helpers <-chan buildlet.Client) (remoteErr, err
 		}
 	}
 	elapsed := time.Since(startTime)
+	set.mu.Lock()
+	buildlets := set.maxBuildlets
+	set.mu.Unlock()
+	st.mu.Lock()
+	st.testMakespan = testMakespan{
+		buildlets: buildlets,
+		predicted: set.predictedMakespan(buildlets),
+		actual:    elapsed,
+	}
+	st.mu.Unlock()
 	var msg string
 	if st.conf.NumTestHelpers(st.isTry()) > 0 {
 		msg = fmt.Sprintf("took %v; aggregate %v; saved %v", elapsed, serialDuration, serialDuration-elapsed)
@@ -1559,10 +1674,30 @@ func (st *buildStatus) runTests(helpers <-chan buildlet.Client) (remoteErr, err
 		msg = fmt.Sprintf("took %v", elapsed)
 	}
 	st.LogEventTime("tests_complete", msg)
-	fmt.Fprintf(st, "\nAll tests passed.\n")
+	if retried := st.retriedTestNames(); len(retried) > 0 {
+		fmt.Fprintf(st, "\nAll tests passed, after retrying %s.\n", strings.Join(retried, ", "))
+	} else {
+		fmt.Fprintf(st, "\nAll tests passed.\n")
+	}
 	return nil, nil
 }
 
+// noteRetriedTest records that the dist test name failed but then
+// passed when retried.
+func (st *buildStatus) noteRetriedTest(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.retriedTests = append(st.retriedTests, name)
+}
+
+// retriedTestNames returns the names of the dist tests that passed
+// only after being retried.
+func (st *buildStatus) retriedTestNames() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]string(nil), st.retriedTests...)
+}
+
 const (
 	banner       = "XXXBANNERXXX:" // flag passed to dist
 	bannerPrefix = "\n" + banner   // with the newline added by dist
@@ -1634,7 +1769,9 @@ func parseOutputAndHeader(b []byte) (metadata, header string, out []byte) {
 const maxTestExecErrors = 3
 
 // runTestsOnBuildlet runs tis on bc, using the optional goroot & gopath environment variables.
+// If the tests fail and the set's retry budget allows, they're queued to run again elsewhere.
 func (st *buildStatus) runTestsOnBuildlet(bc buildlet.Client, tis []*testItem, goroot, gopath string) {
+	defer tis[0].set.chunkDone()
 	names := make([]string, len(tis))
 	for i, ti := range tis {
 		names[i] = ti.name
@@ -1663,6 +1800,9 @@ func (st *buildStatus) runTestsOnBuildlet(bc buildlet.Client, tis []*testItem, g
 	if st.useKeepGoingFlag() {
 		args = append(args, "-k")
 	}
+	if st.useJSONFlag() {
+		args = append(args, "-json")
+	}
 	args = append(args, names...)
 	var buf bytes.Buffer
 	t0 := time.Now()
@@ -1693,6 +1833,7 @@ func (st *buildStatus) runTestsOnBuildlet(bc buildlet.Client, tis []*testItem, g
 	})
 	execDuration := time.Since(t0)
 	sp.Done(err)
+	st.addTestShard(bc, tis, t0, execDuration, err, remoteErr)
 	if err != nil {
 		bc.MarkBroken() // prevents reuse
 		for _, ti := range tis {
@@ -1710,9 +1851,45 @@ func (st *buildStatus) runTestsOnBuildlet(bc buildlet.Client, tis []*testItem, g
 	}
 
 	out := buf.Bytes()
+	var results []*types.TestResult
+	if st.useJSONFlag() {
+		out, results = parseTestJSON(out)
+	}
 	out = bytes.Replace(out, []byte("\nALL TESTS PASSED (some were excluded)\n"), nil, 1)
 	out = bytes.Replace(out, []byte("\nALL TESTS PASSED\n"), nil, 1)
 
+	var failed, passed, notRun []*testItem
+	if remoteErr != nil {
+		failed, passed, notRun = splitFailedTests(tis, results, out)
+	} else {
+		passed = tis
+	}
+	for _, ti := range failed {
+		if len(ti.attempts) > 0 {
+			st.putTestRetryRecord(ti, bc, remoteErr, t0, execDuration)
+		}
+	}
+	for _, ti := range passed {
+		if len(ti.attempts) > 0 {
+			st.putTestRetryRecord(ti, bc, nil, t0, execDuration)
+		}
+	}
+	if remoteErr != nil && tis[0].set.retryFailed(bc, failed, notRun, out, remoteErr) {
+		st.LogEventTime("retrying_failed_tests", fmt.Sprintf("%s: %v", bc.Name(), testNames(failed)))
+		// The tests that passed are done, without output of
+		// their own: it's all shown with the failures.
+		st.addTestResults(packageResults(results, passed))
+		for _, ti := range passed {
+			ti.execDuration = execDuration
+			ti.groupSize = len(tis)
+			ti.shardIPPort = bc.IPPort()
+			close(ti.done)
+			execDuration = 0
+		}
+		return
+	}
+	st.addTestResults(results)
+
 	for _, ti := range tis {
 		ti.output = out
 		ti.remoteErr = remoteErr
@@ -1732,6 +1909,91 @@ func (st *buildStatus) runTestsOnBuildlet(bc buildlet.Client, tis []*testItem, g
 	}
 }
 
+// addTestShard records a run of tis on bc for the status page.
+func (st *buildStatus) addTestShard(bc buildlet.Client, tis []*testItem, start time.Time, d time.Duration, err, remoteErr error) {
+	sh := testShard{
+		buildlet: bc.Name(),
+		start:    start,
+		actual:   d,
+		err:      err,
+	}
+	if sh.err == nil {
+		sh.err = remoteErr
+	}
+	for _, ti := range tis {
+		sh.tests = append(sh.tests, ti.name)
+		sh.predicted += ti.duration
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.testShards = append(st.testShards, sh)
+}
+
+// writeTestShardsLocked writes a report of the dist test shards run
+// so far, comparing their predicted and actual durations.
+func (st *buildStatus) writeTestShardsLocked(w io.Writer) {
+	if m := st.testMakespan; m.buildlets > 0 {
+		fmt.Fprintf(w, "  %d buildlets; predicted %v, took %v\n",
+			m.buildlets, m.predicted.Round(time.Second), m.actual.Round(time.Second))
+	}
+	for _, sh := range st.testShards {
+		status := "ok"
+		if sh.err != nil {
+			status = "failed"
+		}
+		fmt.Fprintf(w, "  %v %s predicted %6.1fs actual %6.1fs %s %s\n",
+			sh.start.Format(time.RFC3339), sh.buildlet, sh.predicted.Seconds(), sh.actual.Seconds(),
+			status, strings.Join(sh.tests, " "))
+	}
+}
+
+// testNames returns the names of tis.
+func testNames(tis []*testItem) []string {
+	names := make([]string, len(tis))
+	for i, ti := range tis {
+		names[i] = ti.name
+	}
+	return names
+}
+
+// packageResults returns the results in results of the packages of
+// the "go_test:" tests tis.
+func packageResults(results []*types.TestResult, tis []*testItem) []*types.TestResult {
+	pkgs := map[string]bool{}
+	for _, ti := range tis {
+		pkgs[strings.TrimPrefix(ti.name, "go_test:")] = true
+	}
+	var out []*types.TestResult
+	for _, tr := range results {
+		if pkgs[tr.Package] {
+			out = append(out, tr)
+		}
+	}
+	return out
+}
+
+// putTestRetryRecord records the outcome of retrying ti on bc.
+func (st *buildStatus) putTestRetryRecord(ti *testItem, bc buildlet.Client, remoteErr error, start time.Time, d time.Duration) {
+	rec := &types.TestRetryRecord{
+		BuildID:   st.buildID,
+		IsTry:     st.isTry(),
+		GoRev:     st.Rev,
+		Builder:   st.Name,
+		TestName:  ti.name,
+		Attempt:   len(ti.attempts),
+		Passed:    remoteErr == nil,
+		FailedOn:  ti.attempts[len(ti.attempts)-1].buildlet,
+		Buildlet:  bc.Name(),
+		StartTime: start,
+		EndTime:   start.Add(d),
+		Seconds:   d.Seconds(),
+	}
+	if remoteErr != nil {
+		rec.Error = remoteErr.Error()
+	}
+	clog.CoordinatorProcess().PutTestRetryRecord(rec)
+}
+
 func (st *buildStatus) CreateSpan(event string, optText ...string) spanlog.Span {
 	return schedule.CreateSpan(st, event, optText...)
 }
@@ -1821,7 +2083,9 @@ func (st *buildStatus) htmlStatus(detail buildStatusDetail) template.HTML {
 	}
 
 	var state string
-	if st.canceled {
+	if st.preempted {
+		state = "preempted"
+	} else if st.canceled {
 		state = "canceled"
 	} else if st.done.IsZero() {
 		if st.HasBuildlet() {
@@ -1936,6 +2200,21 @@ func (st *buildStatus) repeatedCommunicationError(execErr error) error {
 	return nil
 }
 
+// isCommunicationError reports whether err, from building on bc, was
+// a failure to communicate with the buildlet, as opposed to the build
+// being canceled or preempted, a command timing out, or an error on
+// the coordinator's side.
+func (st *buildStatus) isCommunicationError(bc buildlet.Client, err error) bool {
+	if err == nil || st.ctx.Err() != nil || st.isPreempted() {
+		return false
+	}
+	if st.repeatedCommunicationError(err) != nil {
+		return true
+	}
+	// The client marks itself broken when exec fails in transport.
+	return bc.IsBroken() && !errors.Is(err, buildlet.ErrTimeout) && !errors.Is(err, context.Canceled)
+}
+
 // commitTime returns the greater of Rev and SubRev's commit times.
 func (st *buildStatus) commitTime() time.Time {
 	if st.RevCommitTime.Before(st.SubRevCommitTime) {
@@ -9,7 +9,19 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+	"golang.org/x/build/dashboard"
+	"golang.org/x/build/internal/buildgo"
+	"golang.org/x/build/internal/buildstats"
+	"golang.org/x/build/internal/coordinator/pool"
 )
 
 // TestParseOutputAndHeader tests header parsing by parseOutputAndHeader.
@@ -198,3 +210,205 @@ XXXBANNERXXX:Testing packages.`),
 		})
 	}
 }
+
+// fakeDistTest is a stand-in for the go command, installed as
+// go/bin/go on the buildlets in TestRunTestsLocalBuildlet. It lists
+// the tests in $GOROOT/dist_tests for "go tool dist test --list", and runs
+// tests by printing their names, failing on "misc_fail" and on the first
+// runs of "misc_flaky" and "go_test:flaky". With -json, it reports
+// go_test:* tests as test2json events.
+const fakeDistTest = `#!/bin/sh
+shift 3 # tool dist test
+if [ "$2" = --list ]; then
+	cat "$GOROOT/dist_tests"
+	exit 0
+fi
+n=0
+json=
+for arg; do
+	case "$arg" in -json) json=1 ;; -*) ;; *) n=$((n+1)) ;; esac
+done
+printf '\nXXXBANNERXXX:Testing packages.\n'
+for arg; do
+	case "$arg" in
+	-*) ;;
+	go_test:flaky)
+		if [ ! -e "$GOROOT/flaked-pkg" ]; then
+			touch "$GOROOT/flaked-pkg"
+			printf '{"Action":"output","Package":"flaky","Output":"FAIL\\tflaky\\n"}\n'
+			printf '{"Action":"fail","Package":"flaky","Elapsed":0.5}\n'
+			exit 1
+		fi
+		printf '{"Action":"output","Package":"flaky","Output":"ok\\t%s\\t(%d in shard)\\n"}\n' "$arg" $n
+		printf '{"Action":"pass","Package":"flaky","Elapsed":0.5}\n' ;;
+	go_test:*)
+		if [ -n "$json" ]; then
+			printf '{"Action":"output","Package":"%s","Output":"ok\\t%s\\t(%d in shard)\\n"}\n' "${arg#go_test:}" "$arg" $n
+			printf '{"Action":"pass","Package":"%s","Elapsed":0.5}\n' "${arg#go_test:}"
+		else
+			printf 'ok\t%s\t(%d in shard)\n' "$arg" $n
+		fi ;;
+	misc_fail) echo "FAIL: $arg"; exit 1 ;;
+	misc_flaky)
+		if [ ! -e "$GOROOT/flaked" ]; then
+			touch "$GOROOT/flaked"
+			echo "FAIL: $arg"; exit 1
+		fi
+		printf 'ok\t%s\n' "$arg" ;;
+	*) printf 'ok\t%s\t(%d in shard)\n' "$arg" $n ;;
+	esac
+done
+`
+
+// TestRunTestsLocalBuildlet runs the sharded tests of builds on real
+// buildlets, started as local processes by the scheduler.
+func TestRunTestsLocalBuildlet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode; builds and runs cmd/buildlet")
+	}
+	bin, err := pool.BuildLocalBuildlet(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp := pool.NewLocalProcessPool(pool.LocalProcessOpts{Buildlet: bin, Capacity: 1})
+	defer lp.Close()
+	pool.TestPoolHook = func(*dashboard.HostConfig) pool.Buildlet { return lp }
+	defer func() { pool.TestPoolHook = nil }()
+	// Use the default test durations rather than querying BigQuery.
+	testStats.Store(&buildstats.TestStats{AsOf: time.Now()})
+
+	for _, tc := range []struct {
+		name        string
+		builder     string // if empty, linux-amd64
+		tests       string
+		wantErr     string
+		wantLines   []string
+		wantRetried []string
+		wantPassed  []string // packages with test2json results
+	}{
+		{
+			name:  "pass",
+			tests: "go_test:bufio go_test:bytes go_test:fmt go_test:sort go_test:cmd/go misc_a",
+			wantLines: []string{
+				"##### Testing packages.",
+				// With the default of 3s per test, go_test
+				// shards hold up to 3 tests, standard library
+				// first.
+				"ok\tgo_test:bufio\t(3 in shard)",
+				"ok\tgo_test:fmt\t(3 in shard)",
+				"ok\tgo_test:sort\t(2 in shard)",
+				"ok\tgo_test:cmd/go\t(2 in shard)",
+				"ok\tmisc_a\t(1 in shard)",
+				"All tests passed.",
+			},
+			wantPassed: []string{"bufio", "bytes", "fmt", "sort", "cmd/go"},
+		},
+		{
+			name:       "fail",
+			tests:      "go_test:fmt misc_fail misc_a",
+			wantErr:    "dist test failed: misc_fail",
+			wantLines:  []string{"ok\tgo_test:fmt\t(1 in shard)", "FAIL: misc_fail"},
+			wantPassed: []string{"fmt"},
+		},
+		{
+			name:    "no retries",
+			tests:   "misc_a misc_flaky",
+			wantErr: "dist test failed: misc_flaky",
+		},
+		{
+			name:    "flaky",
+			builder: "linux-amd64-longtest",
+			tests:   "misc_a misc_flaky",
+			wantLines: []string{
+				"FAIL: misc_flaky",
+				"ok\tmisc_flaky",
+				"All tests passed, after retrying misc_flaky.",
+			},
+			wantRetried: []string{"misc_flaky"},
+		},
+		{
+			// Only the failed test of a shard is retried;
+			// the one after it, which didn't run, runs alone.
+			name:    "flaky shard",
+			builder: "linux-amd64-longtest",
+			tests:   "go_test:bufio go_test:flaky go_test:sort",
+			wantLines: []string{
+				"ok\tgo_test:bufio\t(3 in shard)",
+				"FAIL\tflaky",
+				"ok\tgo_test:flaky\t(1 in shard)",
+				"ok\tgo_test:sort\t(1 in shard)",
+				"All tests passed, after retrying go_test:flaky.",
+			},
+			wantRetried: []string{"go_test:flaky"},
+			wantPassed:  []string{"bufio", "flaky", "sort"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			builder := tc.builder
+			if builder == "" {
+				builder = "linux-amd64"
+			}
+			st, err := newBuild(buildgo.BuilderRev{Name: builder, Rev: "0123456789abcdef"}, commitDetail{RevBranch: "master"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.cancel()
+			// There's no Go tree for the fake rev. Like Go 1.21,
+			// the fake go command supports dist test -json.
+			st.onceGoVersion.Do(func() { st.goMinor = 21 })
+			bc, err := st.getBuildlet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bc.Close()
+			if err := bc.Put(st.ctx, strings.NewReader(fakeDistTest), "go/bin/go", 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := bc.Put(st.ctx, strings.NewReader(tc.tests), "go/dist_tests", 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			helpers := make(chan buildlet.Client)
+			close(helpers)
+			remoteErr, err := st.runTests(helpers)
+			if err != nil {
+				t.Fatalf("runTests: %v", err)
+			}
+			if tc.wantErr == "" && remoteErr != nil {
+				t.Errorf("runTests remote error: %v", remoteErr)
+			}
+			if tc.wantErr != "" && (remoteErr == nil || !strings.Contains(remoteErr.Error(), tc.wantErr)) {
+				t.Errorf("runTests remote error = %v; want %q", remoteErr, tc.wantErr)
+			}
+			logs := st.logs()
+			for _, line := range tc.wantLines {
+				if !strings.Contains(logs, line+"\n") {
+					t.Errorf("build log lacks %q; log:\n%s", line, logs)
+				}
+			}
+			if got := st.retriedTestNames(); !reflect.DeepEqual(got, tc.wantRetried) {
+				t.Errorf("retried tests = %q; want %q", got, tc.wantRetried)
+			}
+			var passed []string
+			st.mu.Lock()
+			for _, tr := range st.testResults {
+				if tr.Result == "pass" && tr.Builder == builder {
+					passed = append(passed, tr.Package)
+				}
+			}
+			st.mu.Unlock()
+			sort.Strings(passed)
+			sort.Strings(tc.wantPassed)
+			if !reflect.DeepEqual(passed, tc.wantPassed) {
+				t.Errorf("packages passed = %q; want %q", passed, tc.wantPassed)
+			}
+			var report bytes.Buffer
+			st.mu.Lock()
+			st.writeTestShardsLocked(&report)
+			st.mu.Unlock()
+			if !strings.Contains(report.String(), " predicted ") {
+				t.Errorf("test shard report lacks predicted times:\n%s", report.String())
+			}
+		})
+	}
+}
@@ -33,6 +33,7 @@ import (
 	"net/url"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -60,6 +61,7 @@ import (
 	"golang.org/x/build/internal/gomote"
 	gomoteprotos "golang.org/x/build/internal/gomote/protos"
 	"golang.org/x/build/internal/https"
+	"golang.org/x/build/internal/lru"
 	"golang.org/x/build/internal/secret"
 	"golang.org/x/build/maintner/maintnerd/apipb"
 	"golang.org/x/build/repos"
@@ -107,12 +109,19 @@ const devPause = false
 const stagingTryWork = true
 
 var (
-	masterKeyFile = flag.String("masterkey", "", "Path to builder master key. Else fetched using GCE project attribute 'builder-master-key'.")
-	mode          = flag.String("mode", "", "Valid modes are 'dev', 'prod', or '' for auto-detect. dev means localhost development, not be confused with staging on go-dashboard-dev, which is still the 'prod' mode.")
-	buildEnvName  = flag.String("env", "", "The build environment configuration to use. Not required if running on GCE.")
-	devEnableGCE  = flag.Bool("dev_gce", false, "Whether or not to enable the GCE pool when in dev mode. The pool is enabled by default in prod mode.")
-	devEnableEC2  = flag.Bool("dev_ec2", false, "Whether or not to enable the EC2 pool when in dev mode. The pool is enabled by default in prod mode.")
-	sshAddr       = flag.String("ssh_addr", ":2222", "Address the gomote SSH server should listen on")
+	masterKeyFile         = flag.String("masterkey", "", "Path to builder master key. Else fetched using GCE project attribute 'builder-master-key'.")
+	mode                  = flag.String("mode", "", "Valid modes are 'dev', 'prod', or '' for auto-detect. dev means localhost development, not be confused with staging on go-dashboard-dev, which is still the 'prod' mode.")
+	buildEnvName          = flag.String("env", "", "The build environment configuration to use. Not required if running on GCE.")
+	devEnableGCE          = flag.Bool("dev_gce", false, "Whether or not to enable the GCE pool when in dev mode. The pool is enabled by default in prod mode.")
+	devEnableEC2          = flag.Bool("dev_ec2", false, "Whether or not to enable the EC2 pool when in dev mode. The pool is enabled by default in prod mode.")
+	sshAddr               = flag.String("ssh_addr", ":2222", "Address the gomote SSH server should listen on")
+	preemptAfter          = flag.Duration("sched_preempt_after", 0, "If non-zero, how long a gomote or TryBot waits for a buildlet before the scheduler preempts a post-submit build of the same host type for it. Zero, the default, disables preemption.")
+	localContainerRuntime = flag.String("local_container_runtime", "", "If non-empty, the container runtime command, such as 'docker' or 'podman', with which to run container-based buildlets on this machine instead of on GCE or Kubernetes.")
+	localContainerMax     = flag.Int("local_container_max", 0, "The most local container buildlets to run at once, if -local_container_runtime is set. Zero means the number of CPUs.")
+	reverseAdmins         = flag.String("reverse_admins", "", "Comma-separated gomote users, such as 'user-gopher', who may drain reverse buildlet hosts.")
+	warmPoolDemandMax     = flag.Int("warm_pool_demand_max", 0, "The most idle buildlets to keep ready for a host type because of its recent demand, in addition to any configured by its HostConfig.WarmBuildlets. Zero means only the configured ones.")
+	resultCache           = flag.Bool("result_cache", false, "Whether to reuse the result of an earlier successful post-submit build of the same Go and repo trees on the same builder configuration, rather than building again. Function-valued builder configuration, such as which dist tests a builder runs, isn't part of the configuration compared.")
+	ownerWeights          = flag.String("sched_owner_weights", "", "Comma-separated owner=weight pairs, such as 'gopher@golang.org=2', giving CL owners or gomote users a larger or smaller share of TryBot and gomote buildlets than the default weight of 1.")
 )
 
 // LOCK ORDER:
@@ -248,6 +257,17 @@ func main() {
 	}
 	log.Printf("coordinator version %q starting", Version)
 
+	weights, err := parseOwnerWeights(*ownerWeights)
+	if err != nil {
+		log.Fatalf("invalid -sched_owner_weights: %v", err)
+	}
+	for owner, w := range weights {
+		sched.SetOwnerWeight(owner, w)
+	}
+	if *preemptAfter > 0 {
+		sched.SetPolicy(schedule.PreemptingPolicy(*preemptAfter))
+	}
+
 	sc := mustCreateSecretClientOnGCE()
 	if sc != nil {
 		defer sc.Close()
@@ -259,8 +279,11 @@ func main() {
 	// TODO(golang.org/issue/36841): remove after key functions are moved into
 	// a shared package.
 	pool.SetBuilderMasterKey(masterKey())
+	if ca := loadSessionCA(sc); ca != nil {
+		pool.SetSessionCA(ca)
+	}
 
-	err := pool.InitGCE(sc, testFiles, &basePinErr, isGCERemoteBuildlet, *buildEnvName, *mode)
+	err = pool.InitGCE(sc, testFiles, &basePinErr, isGCERemoteBuildlet, *buildEnvName, *mode)
 	if err != nil {
 		if *mode == "" {
 			*mode = "dev"
@@ -281,6 +304,17 @@ func main() {
 		log.Printf("Kube support disabled due to error initializing Kubernetes: %v", err)
 	}
 
+	if *localContainerRuntime != "" {
+		err := pool.InitLocalContainers(pool.LocalContainerOpts{
+			Runtime:  *localContainerRuntime,
+			BuildEnv: gce.BuildEnv(),
+			Capacity: *localContainerMax,
+		})
+		if err != nil {
+			log.Fatalf("initializing local containers: %v", err)
+		}
+	}
+
 	if *mode == "prod" || (*mode == "dev" && *devEnableEC2) {
 		// TODO(golang.org/issues/38337) the coordinator will use a package scoped pool
 		// until the coordinator is refactored to not require them.
@@ -288,6 +322,10 @@ func main() {
 		defer ec2Pool.Close()
 	}
 
+	if *mode == "prod" {
+		pool.EnableWarmPools(pool.WarmOpts{MaxFromDemand: *warmPoolDemandMax})
+	}
+
 	if *mode == "dev" {
 		// Replace linux-amd64 with a config using a -localdev reverse
 		// buildlet so it is possible to run local builds by starting a
@@ -314,7 +352,7 @@ func main() {
 		log.Println("metrics.GKEResource:", err)
 	}
 	mux := http.NewServeMux()
-	if ms, err := metrics.NewService(gr, views); err != nil {
+	if ms, err := metrics.NewService(gr, append(views, schedule.Views...)); err != nil {
 		log.Println("failed to initialize metrics:", err)
 	} else {
 		mux.Handle("/metrics", ms)
@@ -377,9 +415,12 @@ func main() {
 	mux.HandleFunc("/try.json", serveTryStatus(true))
 	mux.HandleFunc("/status/reverse.json", pool.ReversePool().ServeReverseStatusJSON)
 	mux.HandleFunc("/status/post-submit-active.json", handlePostSubmitActiveJSON)
+	mux.HandleFunc("/status/scheduler.json", handleSchedulerStateJSON)
+	mux.HandleFunc("/status/testresults.json", handleTestResultsJSON)
 	mux.Handle("/dashboard", dashV2)
 	mux.Handle("/buildlet/create", requireBuildletProxyAuth(http.HandlerFunc(handleBuildletCreate)))
 	mux.Handle("/buildlet/list", requireBuildletProxyAuth(http.HandlerFunc(handleBuildletList)))
+	mux.Handle("/reverse/drain", requireBuildletProxyAuth(http.HandlerFunc(handleReverseDrain)))
 	if *mode == "dev" {
 		// TODO(crawshaw): do more in dev mode
 		gce.BuildletPool().SetEnabled(*devEnableGCE)
@@ -618,9 +659,9 @@ func getStatus(work buildgo.BuilderRev, statusPtrStr string) *buildStatus {
 	return nil
 }
 
-// cancelOnePostSubmitBuildWithHostType tries to cancel one
+// cancelOnePostSubmitBuildWithHostType tries to preempt one
 // post-submit (non trybot) build with the provided host type and
-// reports whether it did so.
+// reports whether it did so. The build is added back as work.
 //
 // It currently selects the one that's been running the least amount
 // of time, but that's not guaranteed.
@@ -637,7 +678,7 @@ func cancelOnePostSubmitBuildWithHostType(hostType string) bool {
 		}
 	}
 	if best != nil {
-		go best.cancelBuild()
+		go best.preempt()
 	}
 	return best != nil
 }
@@ -888,6 +929,10 @@ func writeStatusHeader(w http.ResponseWriter, st *buildStatus) {
 		io.WriteString(w, "\nEvents:\n")
 		st.writeEventsLocked(w, false, 0)
 	}
+	if len(st.testShards) > 0 {
+		io.WriteString(w, "\nTest shards:\n")
+		st.writeTestShardsLocked(w)
+	}
 	io.WriteString(w, "\nBuild log:\n")
 	workaroundFlush(w)
 }
@@ -912,8 +957,10 @@ func findWorkLoop() {
 	ticker := time.NewTicker(15 * time.Second)
 	// We wait for the ticker first, before looking for work, to
 	// give findTryWork a head start. Because try work is more
-	// important and the scheduler can't (yet?) preempt an
-	// existing post-submit build to take it over for a trybot, we
+	// important and the scheduler doesn't preempt an existing
+	// post-submit build to take it over for a trybot, at least
+	// not until the trybot has waited a while (see
+	// -sched_preempt_after), we
 	// want to make sure that reverse buildlets get assigned to
 	// trybots/slowbots first on start-up.
 	for range ticker.C {
@@ -944,6 +991,13 @@ func findWork() error {
 	commitTime := make(map[string]string)   // git rev => "2019-11-20T22:54:54Z" (time.RFC3339 from build.golang.org's JSON)
 	commitBranch := make(map[string]string) // git rev => "master"
 
+	// Work is collected first, to look up cached results for all of
+	// it at once.
+	type work struct {
+		br buildgo.BuilderRev
+		d  commitDetail
+	}
+	var works []work
 	add := func(br buildgo.BuilderRev) {
 		var d commitDetail
 		var err error
@@ -965,7 +1019,7 @@ func findWork() error {
 			}
 			d.SubRevBranch = commitBranch[br.SubRev]
 		}
-		addWorkDetail(br, d)
+		works = append(works, work{br, d})
 	}
 
 	for _, br := range bs.Revisions {
@@ -1063,6 +1117,19 @@ func findWork() error {
 			}
 		}
 	}
+
+	brs := make([]buildgo.BuilderRev, len(works))
+	for i, w := range works {
+		brs[i] = w.br
+	}
+	cached := cachedBuildResults(brs)
+	for _, w := range works {
+		if cr := cached[w.br]; cr != nil {
+			reuseBuildResult(w.br, cr)
+			continue
+		}
+		addWorkDetail(w.br, w.d)
+	}
 	return nil
 }
 
@@ -1152,6 +1219,7 @@ type trySet struct {
 	tryID    string                   // "T" + 9 random hex
 	slowBots []*dashboard.BuildConfig // any opt-in slower builders to run in a trybot run
 	xrepos   []*buildStatus           // any opt-in x/ repo builds to run in a trybot run
+	previous *tryResult               // if non-nil, the earlier run whose failed builds this one reruns (TRY=failed)
 
 	// wantedAsOf is guarded by statusMu and is used by
 	// findTryWork. It records the last time this tryKey was still
@@ -1164,6 +1232,9 @@ type trySet struct {
 	canceled bool // try run is no longer wanted and its builds were canceled
 	trySetState
 	errMsg bytes.Buffer
+
+	ownerOnce  sync.Once
+	ownerEmail string // set by ownerOnce
 }
 
 type trySetState struct {
@@ -1180,6 +1251,78 @@ func (ts trySetState) clone() trySetState {
 	}
 }
 
+// lastTryResults maps from a tryKey to the *tryResult of the latest
+// trySet for it that finished, so that a later TRY=failed run of the
+// same commit can rerun only the builds that failed.
+var lastTryResults = lru.New(1024)
+
+// tryResult is the outcome of a finished trySet.
+type tryResult struct {
+	passed   []string // builder names, with optional " ($branch)" suffix
+	failed   []failedTryBuild
+	slowBots []*dashboard.BuildConfig
+	xrepos   []string // the opt-in x/ repo builds, as names with optional " ($branch)" suffix
+}
+
+// failedTryBuild is a build that failed in a trySet.
+type failedTryBuild struct {
+	brev   buildgo.BuilderRev
+	detail commitDetail
+	xrepo  bool // whether it's one of the trySet's opt-in x/ repo builds
+}
+
+// result returns the outcome of the trySet, which must have finished.
+// If the trySet reran the failed builds of an earlier one, the builds
+// that passed in the earlier one are included as passed.
+func (ts *trySet) result() *tryResult {
+	ts.mu.Lock()
+	builds := append([]*buildStatus(nil), ts.builds...)
+	ts.mu.Unlock()
+
+	r := &tryResult{slowBots: ts.slowBots}
+	isXrepo := make(map[*buildStatus]bool)
+	for _, bs := range ts.xrepos {
+		isXrepo[bs] = true
+	}
+	if ts.previous != nil {
+		r.passed = append(r.passed, ts.previous.passed...)
+		r.xrepos = ts.previous.xrepos
+	} else {
+		for _, bs := range ts.xrepos {
+			r.xrepos = append(r.xrepos, bs.NameAndBranch())
+		}
+	}
+	for _, bs := range builds {
+		bs.mu.Lock()
+		succeeded := bs.succeeded
+		bs.mu.Unlock()
+		if succeeded {
+			r.passed = append(r.passed, bs.NameAndBranch())
+			continue
+		}
+		r.failed = append(r.failed, failedTryBuild{
+			brev:   bs.BuilderRev,
+			detail: bs.commitDetail,
+			xrepo:  isXrepo[bs],
+		})
+	}
+	return r
+}
+
+// rerunFailedFromComments reports whether the latest TRY= comment in
+// work asks to rerun only the builds that failed in the latest try run
+// of the same commit, with the "failed" term (TRY=failed). Any other
+// terms in the comment are ignored in that case; the rerun uses the
+// same builders as the run it repeats.
+func rerunFailedFromComments(work *apipb.GerritTryWorkItem) bool {
+	for _, term := range latestTryTerms(work) {
+		if term == "failed" {
+			return true
+		}
+	}
+	return false
+}
+
 func tryWorkItemKey(work *apipb.GerritTryWorkItem) tryKey {
 	return tryKey{
 		Project:  work.Project,
@@ -1191,6 +1334,50 @@ func tryWorkItemKey(work *apipb.GerritTryWorkItem) tryKey {
 
 var testingKnobSkipBuilds bool
 
+// owner returns the email address of the owner of the CL being
+// tested, which the scheduler uses to share TryBot buildlets fairly
+// between CL owners. It returns the empty string if it's unknown.
+func (ts *trySet) owner() string {
+	ts.ownerOnce.Do(func() {
+		gerritClient := pool.NewGCEConfiguration().GerritClient()
+		if gerritClient == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		ci, err := gerritClient.GetChange(ctx, ts.ChangeTriple())
+		if err != nil {
+			log.Printf("trybots for %v: looking up CL owner: %v", ts.tryKey, err)
+			return
+		}
+		if ci.Owner != nil {
+			ts.ownerEmail = ci.Owner.Email
+		}
+	})
+	return ts.ownerEmail
+}
+
+// parseOwnerWeights parses the -sched_owner_weights flag value.
+func parseOwnerWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if s == "" {
+		return weights, nil
+	}
+	for _, f := range strings.Split(s, ",") {
+		i := strings.Index(f, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not of the form owner=weight", f)
+		}
+		owner := strings.TrimSpace(f[:i])
+		w, err := strconv.ParseFloat(strings.TrimSpace(f[i+1:]), 64)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("%q: weight must be a positive number", f)
+		}
+		weights[owner] = w
+	}
+	return weights, nil
+}
+
 // newTrySet creates a new trySet group of builders for a given
 // work item, the (Project, Branch, Change-ID, Commit) tuple.
 // It also starts goroutines for each build.
@@ -1210,6 +1397,15 @@ func newTrySet(work *apipb.GerritTryWorkItem) *trySet {
 	builders := joinBuilders(tryBots, slowBots)
 
 	key := tryWorkItemKey(work)
+	var previous *tryResult
+	if rerunFailedFromComments(work) {
+		if v, ok := lastTryResults.Get(key); ok && len(v.(*tryResult).failed) > 0 {
+			previous = v.(*tryResult)
+			slowBots = previous.slowBots
+		} else {
+			log.Printf("TRY=failed for %v, but no earlier failed builds are known; running all builders", key)
+		}
+	}
 	log.Printf("Starting new trybot set for %v", key)
 	ts := &trySet{
 		tryKey: key,
@@ -1218,6 +1414,7 @@ func newTrySet(work *apipb.GerritTryWorkItem) *trySet {
 			builds: make([]*buildStatus, 0, len(builders)),
 		},
 		slowBots: slowBots,
+		previous: previous,
 	}
 
 	// Defensive check that the input is well-formed.
@@ -1262,6 +1459,25 @@ func newTrySet(work *apipb.GerritTryWorkItem) *trySet {
 	if !testingKnobSkipBuilds {
 		go ts.notifyStarting()
 	}
+
+	// For TRY=failed, rerun only the builds that failed last time,
+	// at the same revisions. The results of those that passed are
+	// merged in by noteBuildComplete.
+	if previous != nil {
+		for _, fb := range previous.failed {
+			bs, err := newBuild(fb.brev, fb.detail)
+			if err != nil {
+				log.Printf("can't create build for %q: %v", fb.brev, err)
+				continue
+			}
+			addBuilderToSet(bs, fb.brev)
+			if fb.xrepo {
+				ts.xrepos = append(ts.xrepos, bs)
+			}
+		}
+		return ts
+	}
+
 	for _, bconf := range builders {
 		goVersion := types.MajorMinor{int(work.GoVersion[0].Major), int(work.GoVersion[0].Minor)}
 		if goVersion.Less(bconf.MinimumGoVersion) {
@@ -1496,6 +1712,9 @@ func (ts *trySet) notifyStarting() {
 		name = "SlowBots"
 	}
 	msg := name + " beginning. Status page: " + ts.statusPage() + "\n"
+	if ts.previous != nil {
+		msg += fmt.Sprintf("Rerunning only the %d builds that failed in the previous run (TRY=failed).\n", len(ts.previous.failed))
+	}
 
 	// If any of the requested SlowBot builders
 	// have a known issue, give users a warning.
@@ -1648,6 +1867,10 @@ func (ts *trySet) noteBuildComplete(bs *buildStatus) {
 		return
 	}
 
+	if remain == 0 {
+		lastTryResults.Add(ts.tryKey, ts.result())
+	}
+
 	const failureFooter = "Consult https://build.golang.org/ to see whether they are new failures. Keep in mind that TryBots currently test *exactly* your git commit, without rebasing. If your commit's git parent is old, the failure might've already been fixed.\n"
 
 	s1 := sha1.New()
@@ -1701,6 +1924,13 @@ func (ts *trySet) noteBuildComplete(bs *buildStatus) {
 			name = "SlowBots"
 		}
 
+		// A rerun of failed builds reports on all the builds of
+		// the run it repeats, counting those that passed then.
+		total := len(ts.builds)
+		if ts.previous != nil {
+			total += len(ts.previous.passed)
+		}
+
 		if numFail == 0 {
 			gerritScore = 1
 			fmt.Fprintf(gerritMsg, "%s are happy.\n", name)
@@ -1711,17 +1941,30 @@ func (ts *trySet) noteBuildComplete(bs *buildStatus) {
 			errMsg := ts.errMsg.String()
 			ts.mu.Unlock()
 			fmt.Fprintf(gerritMsg, "%d of %d %s failed.\n%s\n"+failureFooter,
-				numFail, len(ts.builds), name, errMsg)
+				numFail, total, name, errMsg)
 			gerritTag = tryBotsTag("failed")
 		}
 		fmt.Fprintln(gerritMsg)
+		if ts.previous != nil {
+			fmt.Fprintf(gerritMsg, "Reran the %d builds that failed in the previous run (TRY=failed). Builds that passed then:\n", len(ts.builds))
+			for _, name := range ts.previous.passed {
+				fmt.Fprintf(gerritMsg, "* %s\n", name)
+			}
+		}
 		if len(ts.slowBots) > 0 {
 			fmt.Fprintf(gerritMsg, "SlowBot builds that ran:\n")
 			for _, c := range ts.slowBots {
 				fmt.Fprintf(gerritMsg, "* %s\n", c.Name)
 			}
 		}
-		if len(ts.xrepos) > 0 {
+		if ts.previous != nil {
+			if len(ts.previous.xrepos) > 0 {
+				fmt.Fprintf(gerritMsg, "Also tested the following repos:\n")
+				for _, name := range ts.previous.xrepos {
+					fmt.Fprintf(gerritMsg, "* %s\n", name)
+				}
+			}
+		} else if len(ts.xrepos) > 0 {
 			fmt.Fprintf(gerritMsg, "Also tested the following repos:\n")
 			for _, st := range ts.xrepos {
 				fmt.Fprintf(gerritMsg, "* %s\n", st.NameAndBranch())
@@ -1808,7 +2051,23 @@ type testSet struct {
 
 	mu           sync.Mutex
 	inOrder      [][]*testItem
-	biggestFirst [][]*testItem
+	biggestFirst []*testItem // all items, longest expected duration first
+
+	// retryBudget is how many more times failed tests may be
+	// retried before a failure fails the build.
+	retryBudget  int
+	retries      []*testRetry // failed chunks waiting to run again
+	running      int          // chunks handed out and not yet finished
+	buildlets    int          // buildlets currently taking tests from the set
+	maxBuildlets int          // most buildlets ever taking tests at once
+}
+
+// A testRetry is a chunk of tests that failed together on one
+// buildlet and is waiting to run again on another. The tests
+// remain owned (taken) while they wait.
+type testRetry struct {
+	items []*testItem
+	notOn buildlet.Client // buildlet where the chunk failed
 }
 
 // cancelAll cancels all pending tests.
@@ -1818,22 +2077,122 @@ func (s *testSet) cancelAll() {
 	}
 }
 
-func (s *testSet) testsToRunInOrder() (chunk []*testItem, ok bool) {
+// testsToRunInOrder returns the next chunk of tests for bc to run,
+// in the order their output is shown while bc is the only buildlet,
+// and as testsToRunBiggestFirst does once helpers have arrived.
+// The caller must call chunkDone when it's done running them.
+func (s *testSet) testsToRunInOrder(bc buildlet.Client) (chunk []*testItem, ok bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if chunk := s.takeRetryLocked(bc); chunk != nil {
+		return chunk, true
+	}
+	if s.buildlets > 1 {
+		// Running in order only makes the output stream
+		// smoothly; with helpers, finishing sooner matters more.
+		return s.testsLongestFirstLocked()
+	}
 	if s.inOrder == nil {
 		s.initInOrder()
 	}
 	return s.testsFromSlice(s.inOrder)
 }
 
-func (s *testSet) testsToRunBiggestFirst() (chunk []*testItem, ok bool) {
+// testsToRunBiggestFirst returns the next chunk of tests for bc to
+// run, longest first. The caller must call chunkDone when it's done
+// running them.
+func (s *testSet) testsToRunBiggestFirst(bc buildlet.Client) (chunk []*testItem, ok bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if chunk := s.takeRetryLocked(bc); chunk != nil {
+		return chunk, true
+	}
+	return s.testsLongestFirstLocked()
+}
+
+// takeRetryLocked returns the next chunk of failed tests for bc to
+// run again, if any. Retries go first; they're already late.
+func (s *testSet) takeRetryLocked(bc buildlet.Client) []*testItem {
+	for i, r := range s.retries {
+		// Run a retry on a buildlet other than the one where
+		// it failed, unless that's the only buildlet left.
+		if r.notOn != bc || s.buildlets <= 1 {
+			s.retries = append(s.retries[:i], s.retries[i+1:]...)
+			s.running++
+			return r.items
+		}
+	}
+	return nil
+}
+
+// maxChunkDuration is the most expected run time of go_test:*
+// tests that are merged into one "go tool dist test" run.
+const maxChunkDuration = 10 * time.Second
+
+// testsLongestFirstLocked returns the untaken test with the longest
+// expected duration, as in longest-processing-time-first (LPT)
+// scheduling: handed to buildlets as they become free, this keeps
+// the last buildlet to finish from finishing much later than the
+// others. Short go_test:* tests are merged into the chunk, up to
+// chunkTargetLocked, to save the overhead of running each alone.
+func (s *testSet) testsLongestFirstLocked() (chunk []*testItem, ok bool) {
 	if s.biggestFirst == nil {
 		s.initBiggestFirst()
 	}
-	return s.testsFromSlice(s.biggestFirst)
+	target := s.chunkTargetLocked()
+	var first int
+	for first = 0; first < len(s.biggestFirst); first++ {
+		if s.biggestFirst[first].tryTake() {
+			break
+		}
+	}
+	if first == len(s.biggestFirst) {
+		return nil, false
+	}
+	s.running++
+	ti := s.biggestFirst[first]
+	chunk = []*testItem{ti}
+	if !strings.HasPrefix(ti.name, "go_test:") {
+		return chunk, true
+	}
+	total := ti.duration
+	for i := len(s.biggestFirst) - 1; i > first; i-- {
+		ti := s.biggestFirst[i]
+		if !strings.HasPrefix(ti.name, "go_test:") || !ti.untaken() {
+			continue
+		}
+		if total+ti.duration > target {
+			// The rest are at least as long.
+			break
+		}
+		if ti.tryTake() {
+			chunk = append(chunk, ti)
+			total += ti.duration
+		}
+	}
+	return chunk, true
+}
+
+// chunkTargetLocked returns how long the chunks of merged tests
+// should be expected to run. It's a fraction of each buildlet's share
+// of the remaining work, so chunks get smaller as more helpers arrive
+// and toward the end of the build, and the work stays balanced.
+func (s *testSet) chunkTargetLocked() time.Duration {
+	var remain time.Duration
+	for _, ti := range s.items {
+		if ti.untaken() {
+			remain += ti.duration
+		}
+	}
+	n := s.buildlets
+	if n < 1 {
+		n = 1
+	}
+	target := remain / time.Duration(4*n)
+	if target > maxChunkDuration {
+		target = maxChunkDuration
+	}
+	return target
 }
 
 func (s *testSet) testsFromSlice(chunkList [][]*testItem) (chunk []*testItem, ok bool) {
@@ -1844,12 +2203,120 @@ func (s *testSet) testsFromSlice(chunkList [][]*testItem) (chunk []*testItem, ok
 			}
 		}
 		if len(chunk) > 0 {
+			s.running++
 			return chunk, true
 		}
 	}
 	return nil, false
 }
 
+// chunkDone records that a chunk returned by testsToRunInOrder or
+// testsToRunBiggestFirst has finished running or been retried.
+func (s *testSet) chunkDone() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running--
+}
+
+// addBuildlet records that a buildlet started taking tests from s,
+// and removeBuildlet that it stopped.
+func (s *testSet) addBuildlet() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buildlets++
+	if s.buildlets > s.maxBuildlets {
+		s.maxBuildlets = s.buildlets
+	}
+}
+
+func (s *testSet) removeBuildlet() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buildlets--
+}
+
+// mayRetry reports whether a buildlet that found no tests to run
+// should wait for tests to be retried rather than give up.
+func (s *testSet) mayRetry() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.retries) > 0 || (s.running > 0 && s.retryBudget > 0)
+}
+
+// retryFailed queues failed, the tests of a chunk that failed remotely
+// on bc with output out, to run again on another buildlet, if the
+// retry budget allows. The chunk's tests in notRun, which never ran,
+// are made available to run again like any others. It reports whether
+// it did so, in which case the tests stay owned by the set rather than
+// being marked done.
+func (s *testSet) retryFailed(bc buildlet.Client, failed, notRun []*testItem, out []byte, remoteErr error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retryBudget <= 0 {
+		return false
+	}
+	s.retryBudget--
+	for _, ti := range failed {
+		ti.attempts = append(ti.attempts, testAttempt{
+			buildlet: bc.Name(),
+			output:   out,
+			err:      remoteErr,
+		})
+		out = nil
+	}
+	s.retries = append(s.retries, &testRetry{items: failed, notOn: bc})
+	for _, ti := range notRun {
+		ti.retry()
+	}
+	return true
+}
+
+// splitFailedTests sorts tis, a chunk of tests that failed together,
+// into those that failed, passed, or didn't run, going by the package
+// results of the "go_test:" tests in results, from -json output, or
+// else the "ok" and "FAIL" lines of go test in out. When no test can
+// be shown to have failed, all of them but those shown to have passed
+// are taken to have failed.
+func splitFailedTests(tis []*testItem, results []*types.TestResult, out []byte) (failed, passed, notRun []*testItem) {
+	if len(tis) == 1 {
+		return tis, nil, nil
+	}
+	pkgResult := map[string]string{} // package -> "pass", "fail" or "skip"
+	for _, tr := range results {
+		if tr.Test == "" {
+			pkgResult[tr.Package] = tr.Result
+		}
+	}
+	if len(results) == 0 {
+		for _, line := range strings.Split(string(out), "\n") {
+			f := strings.Fields(line)
+			if len(f) < 2 {
+				continue
+			}
+			switch f[0] {
+			case "ok", "?":
+				pkgResult[f[1]] = "pass"
+			case "FAIL":
+				pkgResult[f[1]] = "fail"
+			}
+		}
+	}
+	for _, ti := range tis {
+		switch pkgResult[strings.TrimPrefix(ti.name, "go_test:")] {
+		case "pass", "skip":
+			passed = append(passed, ti)
+		case "fail":
+			failed = append(failed, ti)
+		default:
+			notRun = append(notRun, ti)
+		}
+	}
+	if len(failed) == 0 {
+		return notRun, passed, nil
+	}
+	return failed, passed, notRun
+}
+
 func (s *testSet) initInOrder() {
 	names := make([]string, len(s.items))
 	namedItem := map[string]*testItem{}
@@ -1918,11 +2385,35 @@ func partitionGoTests(testDuration func(string, string) time.Duration, builderNa
 }
 
 func (s *testSet) initBiggestFirst() {
+	s.biggestFirst = append([]*testItem(nil), s.items...)
+	sort.Stable(sort.Reverse(byTestDuration(s.biggestFirst)))
+}
+
+// predictedMakespan returns how long the tests in s are expected to
+// take on n buildlets, scheduling them longest first.
+func (s *testSet) predictedMakespan(n int) time.Duration {
+	if n < 1 {
+		n = 1
+	}
 	items := append([]*testItem(nil), s.items...)
-	sort.Sort(sort.Reverse(byTestDuration(items)))
-	for _, item := range items {
-		s.biggestFirst = append(s.biggestFirst, []*testItem{item})
+	sort.Stable(sort.Reverse(byTestDuration(items)))
+	load := make([]time.Duration, n)
+	for _, ti := range items {
+		min := 0
+		for i := range load {
+			if load[i] < load[min] {
+				min = i
+			}
+		}
+		load[min] += ti.duration
 	}
+	var max time.Duration
+	for _, d := range load {
+		if d > max {
+			max = d
+		}
+	}
+	return max
 }
 
 type testItem struct {
@@ -1945,6 +2436,23 @@ type testItem struct {
 	output       []byte
 	remoteErr    error         // real test failure (not a communications failure)
 	execDuration time.Duration // actual time
+
+	// attempts are the earlier runs of this test that failed
+	// remotely and were retried, oldest first.
+	attempts []testAttempt
+}
+
+// A testAttempt is a run of a test that failed remotely.
+type testAttempt struct {
+	buildlet string // name of the buildlet it ran on
+	output   []byte // only set for the first item in a group
+	err      error
+}
+
+// untaken reports whether nobody has taken ti yet. It's only a hint,
+// as another goroutine may take it right after.
+func (ti *testItem) untaken() bool {
+	return len(ti.take) == 0
 }
 
 func (ti *testItem) tryTake() bool {
@@ -2165,6 +2673,13 @@ func handlePostSubmitActiveJSON(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(activePostSubmitBuilds())
 }
 
+// handleSchedulerStateJSON serves the scheduler's state, including
+// how long each waiter has been waiting for a buildlet.
+func handleSchedulerStateJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sched.State())
+}
+
 func activePostSubmitBuilds() []types.ActivePostSubmitBuild {
 	var ret []types.ActivePostSubmitBuild
 	statusMu.Lock()
@@ -2248,6 +2763,33 @@ func fromSecret(ctx context.Context, sc *secret.Client, secretName string) (stri
 	return sc.Retrieve(ctx, secretName)
 }
 
+// loadSessionCA returns the CA that issues the client certificates
+// with which the coordinator authenticates to GCE and EC2 buildlets in
+// mutual TLS mode. It returns nil if no CA is configured in Secret
+// Manager, in which case buildlets use passwords.
+func loadSessionCA(sc *secret.Client) *buildlet.SessionCA {
+	if sc == nil {
+		return nil
+	}
+	ctx := context.Background()
+	certPEM, err := fromSecret(ctx, sc, secret.NameBuildletSessionCACert)
+	if err != nil {
+		log.Printf("buildlet session CA not loaded, using passwords: %v", err)
+		return nil
+	}
+	keyPEM, err := fromSecret(ctx, sc, secret.NameBuildletSessionCAKey)
+	if err != nil {
+		log.Printf("buildlet session CA not loaded, using passwords: %v", err)
+		return nil
+	}
+	ca := &buildlet.SessionCA{CertPEM: certPEM, KeyPEM: keyPEM}
+	if _, err := ca.IssueClientCert("coordinator-startup-check", time.Minute); err != nil {
+		log.Fatalf("invalid buildlet session CA: %v", err)
+	}
+	log.Printf("using mutual TLS with GCE and EC2 buildlets")
+	return ca
+}
+
 func retrieveSSHKeys(ctx context.Context, sc *secret.Client, m string) (publicKey, privateKey []byte, err error) {
 	if m == "dev" {
 		return remote.SSHKeyPair()
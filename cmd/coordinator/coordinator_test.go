@@ -10,20 +10,27 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/build/buildenv"
+	"golang.org/x/build/buildlet"
 	"golang.org/x/build/gerrit"
 	"golang.org/x/build/internal/buildgo"
+	"golang.org/x/build/internal/buildstats"
 	"golang.org/x/build/internal/coordinator/pool"
+	"golang.org/x/build/internal/lru"
 	"golang.org/x/build/maintner/maintnerd/apipb"
+	"golang.org/x/build/types"
 )
 
 type Seconds float64
@@ -70,6 +77,199 @@ func TestPartitionGoTests(t *testing.T) {
 	}
 }
 
+func newTestTestSet(durations map[string]time.Duration) *testSet {
+	set := &testSet{
+		st: &buildStatus{},
+		testStats: &buildstats.TestStats{BuilderTestStats: map[string]*buildstats.BuilderTestStats{
+			"": {MedianDuration: durations},
+		}},
+	}
+	for name, d := range durations {
+		set.items = append(set.items, &testItem{
+			set:      set,
+			name:     name,
+			duration: d,
+			take:     make(chan token, 1),
+			done:     make(chan token),
+		})
+	}
+	sort.Slice(set.items, func(i, j int) bool { return set.items[i].name < set.items[j].name })
+	return set
+}
+
+// nextChunk returns the names of the tests that take returns for bc.
+func nextChunk(t *testing.T, take func(buildlet.Client) ([]*testItem, bool), bc buildlet.Client) []string {
+	t.Helper()
+	chunk, ok := take(bc)
+	if !ok {
+		t.Fatal("no tests to run")
+	}
+	var names []string
+	for _, ti := range chunk {
+		names = append(names, ti.name)
+	}
+	return names
+}
+
+func TestTestSetLongestFirst(t *testing.T) {
+	set := newTestTestSet(map[string]time.Duration{
+		"go_test:a": 1 * time.Second,
+		"go_test:b": 2 * time.Second,
+		"go_test:c": 3 * time.Second,
+		"go_test:d": 30 * time.Second,
+		"go_test:e": 4 * time.Second,
+		"misc_big":  40 * time.Second,
+	})
+	var main, helper buildlet.Client = &buildlet.FakeClient{}, &buildlet.FakeClient{}
+	set.addBuildlet()
+
+	// Alone, the main buildlet runs the tests in order.
+	if got, want := nextChunk(t, set.testsToRunInOrder, main), []string{"go_test:a", "go_test:b", "go_test:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first in-order chunk = %q; want %q", got, want)
+	}
+
+	// Once a helper arrives, both take the longest test first.
+	set.addBuildlet()
+	for _, tt := range []struct {
+		take func(buildlet.Client) ([]*testItem, bool)
+		bc   buildlet.Client
+		want string
+	}{
+		{set.testsToRunInOrder, main, "misc_big"},
+		{set.testsToRunBiggestFirst, helper, "go_test:d"},
+		{set.testsToRunBiggestFirst, helper, "go_test:e"},
+	} {
+		if got := nextChunk(t, tt.take, tt.bc); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("chunk = %q; want [%q]", got, tt.want)
+		}
+	}
+	if chunk, ok := set.testsToRunBiggestFirst(helper); ok {
+		t.Errorf("got %d tests with none left", len(chunk))
+	}
+}
+
+func TestTestSetChunkTarget(t *testing.T) {
+	durations := map[string]time.Duration{}
+	for i := 0; i < 20; i++ {
+		durations[fmt.Sprintf("go_test:%02d", i)] = time.Second
+	}
+	set := newTestTestSet(durations)
+	bc := &buildlet.FakeClient{}
+	set.addBuildlet()
+
+	// 20s of work on one buildlet makes for 5s chunks.
+	if got := nextChunk(t, set.testsToRunBiggestFirst, bc); len(got) != 5 {
+		t.Errorf("chunk with 1 buildlet has %d tests; want 5", len(got))
+	}
+	// With a helper, the 15s left make for 1.875s chunks.
+	set.addBuildlet()
+	if got := nextChunk(t, set.testsToRunBiggestFirst, bc); len(got) != 1 {
+		t.Errorf("chunk with 2 buildlets has %d tests; want 1", len(got))
+	}
+}
+
+func TestPredictedMakespan(t *testing.T) {
+	set := newTestTestSet(map[string]time.Duration{
+		"a": 5 * time.Second,
+		"b": 4 * time.Second,
+		"c": 3 * time.Second,
+		"d": 3 * time.Second,
+		"e": 3 * time.Second,
+	})
+	for n, want := range map[int]time.Duration{
+		0: 18 * time.Second,
+		1: 18 * time.Second,
+		2: 10 * time.Second, // {5, 3} and {4, 3, 3}
+		5: 5 * time.Second,
+	} {
+		if got := set.predictedMakespan(n); got != want {
+			t.Errorf("predictedMakespan(%d) = %v; want %v", n, got, want)
+		}
+	}
+}
+
+func TestTestSetRetry(t *testing.T) {
+	set := &testSet{retryBudget: 1}
+	for _, name := range []string{"misc_a", "misc_b"} {
+		set.items = append(set.items, &testItem{
+			set:  set,
+			name: name,
+			take: make(chan token, 1),
+			done: make(chan token),
+		})
+	}
+	a, b := &buildlet.FakeClient{}, &buildlet.FakeClient{}
+	set.addBuildlet()
+	set.addBuildlet()
+
+	chunk, _ := set.testsToRunBiggestFirst(a)
+	if !set.retryFailed(a, chunk, nil, []byte("FAIL"), errors.New("exit status 1")) {
+		t.Fatal("retryFailed = false; want true")
+	}
+	set.chunkDone()
+	if !set.mayRetry() {
+		t.Error("mayRetry = false with a retry queued")
+	}
+	// The failed chunk isn't retried on the buildlet where it failed.
+	if got, _ := set.testsToRunBiggestFirst(a); len(got) != 1 || got[0] == chunk[0] {
+		t.Fatalf("testsToRunBiggestFirst(a) = %v; want the other test", got)
+	}
+	set.chunkDone()
+	if got, _ := set.testsToRunBiggestFirst(b); len(got) != 1 || got[0] != chunk[0] {
+		t.Fatalf("testsToRunBiggestFirst(b) = %v; want the retry", got)
+	}
+	if set.retryFailed(b, chunk, nil, nil, errors.New("exit status 1")) {
+		t.Error("retryFailed = true with no budget left; want false")
+	}
+	set.chunkDone()
+	if set.mayRetry() {
+		t.Error("mayRetry = true with nothing running or queued")
+	}
+	if n := len(chunk[0].attempts); n != 1 {
+		t.Errorf("%d attempts recorded; want 1", n)
+	}
+}
+
+func TestSplitFailedTests(t *testing.T) {
+	var tis []*testItem
+	for _, name := range []string{"go_test:a", "go_test:b", "go_test:c"} {
+		tis = append(tis, &testItem{name: name})
+	}
+	names := func(tis []*testItem) string { return strings.Join(testNames(tis), " ") }
+	for _, tc := range []struct {
+		desc                   string
+		results                []*types.TestResult
+		out                    string
+		failed, passed, notRun string
+	}{
+		{
+			desc: "json",
+			results: []*types.TestResult{
+				{Package: "a", Result: "pass"},
+				{Package: "b", Test: "TestB", Result: "fail"},
+				{Package: "b", Result: "fail"},
+			},
+			failed: "go_test:b", passed: "go_test:a", notRun: "go_test:c",
+		},
+		{
+			desc:   "text",
+			out:    "ok  \ta\t0.1s\n--- FAIL: TestB (0.00s)\nFAIL\nFAIL\tb\t0.2s\n",
+			failed: "go_test:b", passed: "go_test:a", notRun: "go_test:c",
+		},
+		{
+			desc:   "unattributed",
+			out:    "ok  \ta\t0.1s\nsomething went wrong\n",
+			failed: "go_test:b go_test:c", passed: "go_test:a",
+		},
+	} {
+		failed, passed, notRun := splitFailedTests(tis, tc.results, []byte(tc.out))
+		if names(failed) != tc.failed || names(passed) != tc.passed || names(notRun) != tc.notRun {
+			t.Errorf("%s: failed, passed, not run = %q, %q, %q; want %q, %q, %q", tc.desc,
+				names(failed), names(passed), names(notRun), tc.failed, tc.passed, tc.notRun)
+		}
+	}
+}
+
 func TestTryStatusJSON(t *testing.T) {
 	testCases := []struct {
 		desc   string
@@ -283,6 +483,68 @@ func TestIssue42084(t *testing.T) {
 	}
 }
 
+// Test that TRY=failed reruns only the builds that failed in the
+// latest try run of the same commit.
+func TestTryRerunFailed(t *testing.T) {
+	testingKnobSkipBuilds = true
+
+	work := &apipb.GerritTryWorkItem{
+		Project:   "go",
+		Branch:    "master",
+		ChangeId:  "I023d5208374f867552ba68b45011f7990159868f",
+		Commit:    "0123456789abcdef0123456789abcdef01234567",
+		Version:   1,
+		GoCommit:  []string{"9995c6b50aa55c1cc1236d1d688929df512dad53"},
+		GoBranch:  []string{"master"},
+		GoVersion: []*apipb.MajorMinor{{Major: 1, Minor: 17}},
+	}
+	key := tryWorkItemKey(work)
+	defer func(old *lru.Cache) { lastTryResults = old }(lastTryResults)
+	lastTryResults = lru.New(10)
+
+	ts := newTrySet(work)
+	if len(ts.builds) < 3 {
+		t.Fatalf("got %d builds, want at least 3", len(ts.builds))
+	}
+	wantRerun := map[string]bool{
+		ts.builds[0].Name: true,
+		ts.builds[2].Name: true,
+	}
+	for _, bs := range ts.builds {
+		bs.succeeded = !wantRerun[bs.Name]
+	}
+	lastTryResults.Add(key, ts.result())
+
+	work.TryMessage = []*apipb.TryVoteMessage{{Message: "TRY=failed", AuthorId: 1234, Version: 1}}
+	rerun := newTrySet(work)
+	if rerun.previous == nil {
+		t.Fatal("TRY=failed trySet has no previous results")
+	}
+	if len(rerun.builds) != len(wantRerun) {
+		t.Errorf("TRY=failed ran %d builds, want %d", len(rerun.builds), len(wantRerun))
+	}
+	for _, bs := range rerun.builds {
+		if !wantRerun[bs.Name] {
+			t.Errorf("TRY=failed reran %s, which passed", bs.Name)
+		}
+		bs.succeeded = true
+	}
+	if got, want := len(rerun.previous.passed), len(ts.builds)-len(wantRerun); got != want {
+		t.Errorf("previous run has %d passed builds, want %d", got, want)
+	}
+
+	// The rerun's result includes the builds that passed before,
+	// so another TRY=failed has nothing to rerun, and runs all.
+	r := rerun.result()
+	if len(r.passed) != len(ts.builds) || len(r.failed) != 0 {
+		t.Errorf("rerun result has %d passed and %d failed builds, want %d and 0", len(r.passed), len(r.failed), len(ts.builds))
+	}
+	lastTryResults.Add(key, r)
+	if all := newTrySet(work); all.previous != nil || len(all.builds) != len(ts.builds) {
+		t.Errorf("TRY=failed with no failed builds ran %d builds, want all %d", len(all.builds), len(ts.builds))
+	}
+}
+
 func TestFindWork(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping in short mode")
@@ -350,6 +612,19 @@ func TestSlowBotsFromComments(t *testing.T) {
 	}
 }
 
+func TestParseOwnerWeights(t *testing.T) {
+	got, err := parseOwnerWeights("a@golang.org=2, b@golang.org=0.5")
+	want := map[string]float64{"a@golang.org": 2, "b@golang.org": 0.5}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOwnerWeights = %v, %v; want %v", got, err, want)
+	}
+	for _, bad := range []string{"a@golang.org", "=2", "a@golang.org=0", "a@golang.org=x"} {
+		if _, err := parseOwnerWeights(bad); err == nil {
+			t.Errorf("parseOwnerWeights(%q) succeeded; want error", bad)
+		}
+	}
+}
+
 func TestSubreposFromComments(t *testing.T) {
 	work := &apipb.GerritTryWorkItem{
 		Version: 2,
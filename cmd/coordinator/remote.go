@@ -129,6 +129,7 @@ func handleBuildletCreate(w http.ResponseWriter, r *http.Request) {
 	si := &schedule.SchedItem{
 		HostType: bconf.HostType,
 		IsGomote: true,
+		Owner:    user,
 	}
 
 	ctx := r.Context()
@@ -262,6 +263,60 @@ func handleBuildletList(w http.ResponseWriter, r *http.Request) {
 	w.Write(jenc)
 }
 
+// handleReverseDrain starts or stops draining a reverse buildlet host.
+// It takes the form values "hostname"; "drain", which is "1" to start
+// draining and "0" to stop; and "halt", which is "1" to also halt the
+// host's idle buildlets, once. See pool.ReverseBuildletPool.SetDraining.
+//
+// always wrapped in requireBuildletProxyAuth.
+func handleReverseDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", 400)
+		return
+	}
+	user, _, _ := r.BasicAuth()
+	if !isReverseAdmin(user) {
+		http.Error(w, "not a reverse buildlet admin", http.StatusForbidden)
+		return
+	}
+	hostname := r.FormValue("hostname")
+	if hostname == "" {
+		http.Error(w, "missing hostname", 400)
+		return
+	}
+	p := pool.ReversePool()
+	switch r.FormValue("drain") {
+	case "1":
+		halt := r.FormValue("halt") == "1"
+		n := p.SetDraining(hostname, halt)
+		log.Printf("%s set reverse host %q draining (halt=%v)", user, hostname, halt)
+		fmt.Fprintf(w, "draining %s; %d buildlets connected\n", hostname, n)
+	case "0":
+		if !p.ClearDraining(hostname) {
+			http.Error(w, "host is not draining", 400)
+			return
+		}
+		log.Printf("%s put reverse host %q back in service", user, hostname)
+		fmt.Fprintf(w, "%s back in service\n", hostname)
+	default:
+		http.Error(w, `drain must be "0" or "1"`, 400)
+	}
+}
+
+// isReverseAdmin reports whether the gomote user may drain reverse
+// buildlet hosts.
+func isReverseAdmin(user string) bool {
+	if *mode == "dev" {
+		return true
+	}
+	for _, u := range strings.Split(*reverseAdmins, ",") {
+		if u != "" && u == user {
+			return true
+		}
+	}
+	return false
+}
+
 type byBuildletName []*remote.Buildlet
 
 func (s byBuildletName) Len() int           { return len(s) }
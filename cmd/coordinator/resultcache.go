@@ -0,0 +1,328 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.16 && (linux || darwin)
+// +build go1.16
+// +build linux darwin
+
+// Code related to reusing the results of earlier builds of the same
+// inputs.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/build/dashboard"
+	"golang.org/x/build/internal/buildgo"
+	clog "golang.org/x/build/internal/coordinator/log"
+	"golang.org/x/build/internal/coordinator/pool"
+	"golang.org/x/build/internal/loghash"
+	"golang.org/x/build/internal/lru"
+	"golang.org/x/build/types"
+)
+
+// gitilesURL is the base URL of the Gitiles server for the Go repos.
+var gitilesURL = "https://go.googlesource.com"
+
+// treeHashes maps from a repo and commit (a treeHashKey) to the hash
+// of the commit's tree. Commits never change, so they're cached.
+var treeHashes = lru.New(4096)
+
+type treeHashKey struct{ repo, rev string }
+
+// treeHash returns the hash of the tree of the commit rev in repo
+// ("go", "net", etc).
+func treeHash(ctx context.Context, repo, rev string) (string, error) {
+	k := treeHashKey{repo, rev}
+	if v, ok := treeHashes.Get(k); ok {
+		return v.(string), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/%s/+/%s?format=JSON", gitilesURL, repo, rev), nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching commit %s of %s: %v", rev, repo, res.Status)
+	}
+	// Gitiles prefixes JSON with this, to prevent XSSI.
+	body = bytes.TrimPrefix(body, []byte(")]}'\n"))
+	var commit struct {
+		Tree string `json:"tree"`
+	}
+	if err := json.Unmarshal(body, &commit); err != nil {
+		return "", fmt.Errorf("decoding commit %s of %s: %v", rev, repo, err)
+	}
+	if commit.Tree == "" {
+		return "", fmt.Errorf("commit %s of %s has no tree", rev, repo)
+	}
+	treeHashes.Add(k, commit.Tree)
+	return commit.Tree, nil
+}
+
+// maxTreeHashFetches is how many tree hashes fetchTreeHashes fetches at
+// once.
+const maxTreeHashFetches = 8
+
+// fetchTreeHashes returns the hashes of the trees of commits, fetching
+// those that aren't cached in parallel. Commits whose tree can't be
+// fetched are left out.
+func fetchTreeHashes(ctx context.Context, commits map[treeHashKey]bool) map[treeHashKey]string {
+	var (
+		mu    sync.Mutex
+		trees = make(map[treeHashKey]string)
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, maxTreeHashFetches)
+	)
+	for k := range commits {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(k treeHashKey) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			tree, err := treeHash(ctx, k.repo, k.rev)
+			if err != nil {
+				log.Printf("build result cache: tree of %s commit %s: %v", k.repo, k.rev, err)
+				return
+			}
+			mu.Lock()
+			trees[k] = tree
+			mu.Unlock()
+		}(k)
+	}
+	wg.Wait()
+	return trees
+}
+
+// buildResultKey returns the key of br's result in the build result
+// cache: a hash of its Go tree, its repo tree, and the builder's
+// configuration.
+func buildResultKey(ctx context.Context, br buildgo.BuilderRev) (string, error) {
+	goTree, err := treeHash(ctx, "go", br.Rev)
+	if err != nil {
+		return "", err
+	}
+	var repoTree string
+	if br.IsSubrepo() {
+		repoTree, err = treeHash(ctx, br.SubName, br.SubRev)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buildResultKeyForTrees(br, goTree, repoTree)
+}
+
+// buildResultKeyForTrees is like buildResultKey, given the hashes of
+// br's Go tree and, for subrepo builds, its repo tree.
+func buildResultKeyForTrees(br buildgo.BuilderRev, goTree, repoTree string) (string, error) {
+	conf, ok := dashboard.Builders[br.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown builder %q", br.Name)
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "go=%s\nrepo=%s\n%s=%s\n", goTree, repoTree, br.Name, conf.InputHash())
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+var (
+	// lookupBuildResults returns the build results cached under
+	// keys, with nil for keys that have none. It's a variable for
+	// tests.
+	lookupBuildResults = clog.CachedBuildResults
+
+	// buildResultMisses holds the BuilderRevs that were looked up
+	// in the build result cache and not found, so that findWork
+	// doesn't look them up again each time it sees them.
+	buildResultMisses = lru.New(8192)
+
+	forcedMu sync.Mutex
+	forced   = map[forcedRebuild]*forcedState{}
+)
+
+// A forcedRebuild is a builder and a commit of the repo it's testing
+// for which the build result cache isn't used.
+type forcedRebuild struct {
+	builder, rev string
+}
+
+// forcedState is the state of a forcedRebuild. A commit of an x/ repo
+// is built against several Go commits, and each of those builds is
+// forced.
+type forcedState struct {
+	at   time.Time                   // when the rebuild was forced
+	done map[buildgo.BuilderRev]bool // builds that have run since
+}
+
+// forcedRebuildExpiry is how long a forced rebuild is remembered, if
+// no build happens to use it.
+const forcedRebuildExpiry = 24 * time.Hour
+
+// forceRebuild makes the next build of rev on builder run, even if
+// the build result cache has a result for it. rev is a commit of the
+// repo being tested, such as the SubRev of an x/ repo build.
+func forceRebuild(builder, rev string) {
+	forcedMu.Lock()
+	defer forcedMu.Unlock()
+	forced[forcedRebuild{builder, rev}] = &forcedState{at: time.Now(), done: map[buildgo.BuilderRev]bool{}}
+	for k, fs := range forced {
+		if time.Since(fs.at) > forcedRebuildExpiry {
+			delete(forced, k)
+		}
+	}
+}
+
+func forcedRebuildKey(br buildgo.BuilderRev) forcedRebuild {
+	if br.IsSubrepo() {
+		return forcedRebuild{br.Name, br.SubRev}
+	}
+	return forcedRebuild{br.Name, br.Rev}
+}
+
+func isForcedRebuild(br buildgo.BuilderRev) bool {
+	forcedMu.Lock()
+	defer forcedMu.Unlock()
+	fs, ok := forced[forcedRebuildKey(br)]
+	return ok && time.Since(fs.at) < forcedRebuildExpiry && !fs.done[br]
+}
+
+// doneForcedRebuild records that br has been rebuilt.
+func doneForcedRebuild(br buildgo.BuilderRev) {
+	forcedMu.Lock()
+	defer forcedMu.Unlock()
+	if fs, ok := forced[forcedRebuildKey(br)]; ok {
+		fs.done[br] = true
+	}
+}
+
+// cachedBuildResults returns the cached results of earlier builds of
+// the same inputs as brs, for those that have one, if the build
+// result cache is enabled. Forced rebuilds aren't looked up. It
+// resolves the tree of each commit once, and looks up all the
+// results in one batch.
+func cachedBuildResults(brs []buildgo.BuilderRev) map[buildgo.BuilderRev]*types.CachedBuildResult {
+	if !*resultCache {
+		return nil
+	}
+	var todo []buildgo.BuilderRev
+	commits := make(map[treeHashKey]bool)
+	for _, br := range brs {
+		if isBuilding(br) || isForcedRebuild(br) {
+			continue
+		}
+		if _, ok := buildResultMisses.Get(br); ok {
+			continue
+		}
+		todo = append(todo, br)
+		commits[treeHashKey{"go", br.Rev}] = true
+		if br.IsSubrepo() {
+			commits[treeHashKey{br.SubName, br.SubRev}] = true
+		}
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	trees := fetchTreeHashes(ctx, commits)
+
+	var keyed []buildgo.BuilderRev
+	var keys []string
+	for _, br := range todo {
+		goTree, ok := trees[treeHashKey{"go", br.Rev}]
+		if !ok {
+			// Don't remember this as a miss; it may work next time.
+			continue
+		}
+		var repoTree string
+		if br.IsSubrepo() {
+			if repoTree, ok = trees[treeHashKey{br.SubName, br.SubRev}]; !ok {
+				continue
+			}
+		}
+		key, err := buildResultKeyForTrees(br, goTree, repoTree)
+		if err != nil {
+			log.Printf("build result cache key for %v: %v", br, err)
+			continue
+		}
+		keyed = append(keyed, br)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	crs, err := lookupBuildResults(ctx, keys)
+	if err != nil {
+		log.Printf("looking up %d cached build results: %v", len(keys), err)
+		return nil
+	}
+	found := make(map[buildgo.BuilderRev]*types.CachedBuildResult)
+	for i, br := range keyed {
+		if cr := crs[i]; cr != nil {
+			found[br] = cr
+		} else {
+			buildResultMisses.Add(br, true)
+		}
+	}
+	return found
+}
+
+// reuseBuildResult reports cr, the result of an earlier build of the
+// same inputs, to the dashboard as the result of br.
+func reuseBuildResult(br buildgo.BuilderRev, cr *types.CachedBuildResult) {
+	buildLog := fmt.Sprintf("Reused result of build %s of %s at Go commit %s (the same trees and builder configuration).\n", cr.BuildID, cr.Rev, cr.GoRev)
+	if cr.LogURL != "" {
+		buildLog += "Original build log: " + cr.LogURL + "\n"
+	}
+	if err := recordResult(br, true, buildLog, 0); err != nil {
+		log.Printf("recording reused result for %v: %v", br, err)
+		return
+	}
+	log.Printf("%v: reused result of build %s", br, cr.BuildID)
+	// Don't reuse it again, if the dashboard is slow to show it.
+	buildResultMisses.Add(br, true)
+}
+
+// putBuildResult adds the successful result of the post-submit build
+// st, with log buildLog, to the build result cache.
+func (st *buildStatus) putBuildResult(buildLog string) {
+	if !*resultCache {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	key, err := buildResultKey(ctx, st.BuilderRev)
+	if err != nil {
+		log.Printf("build result cache key for %v: %v", st.BuilderRev, err)
+		return
+	}
+	clog.CoordinatorProcess().PutCachedBuildResult(key, &types.CachedBuildResult{
+		BuildID: st.buildID,
+		Builder: st.Name,
+		GoRev:   st.Rev,
+		Rev:     st.SubRevOrGoRev(),
+		Repo:    st.RepoOrGo(),
+		LogURL:  pool.NewGCEConfiguration().BuildEnv().DashBase() + "log/" + loghash.New(buildLog),
+		Time:    time.Now(),
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.16 && (linux || darwin)
+// +build go1.16
+// +build linux darwin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/build/internal/buildgo"
+	"golang.org/x/build/internal/lru"
+	"golang.org/x/build/types"
+)
+
+func TestBuildResultCache(t *testing.T) {
+	// A fake Gitiles where go commits "go1" and "go1-revert" have
+	// the same tree.
+	trees := map[string]string{
+		"/go/+/go1":        "tree-a",
+		"/go/+/go1-revert": "tree-a",
+		"/go/+/go2":        "tree-b",
+		"/net/+/net1":      "tree-n",
+	}
+	var (
+		mu      sync.Mutex
+		fetches = map[string]int{} // by path
+	)
+	gitiles := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		tree, ok := trees[r.URL.Path]
+		if !ok || r.FormValue("format") != "JSON" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, ")]}'\n{\"commit\": \"x\", \"tree\": %q}", tree)
+	}))
+	defer gitiles.Close()
+	defer func(old string) { gitilesURL = old }(gitilesURL)
+	gitilesURL = gitiles.URL
+	treeHashes = lru.New(10)
+	buildResultMisses = lru.New(10)
+	defer func(old bool) { *resultCache = old }(*resultCache)
+	*resultCache = true
+
+	ctx := context.Background()
+	key := func(br buildgo.BuilderRev) string {
+		t.Helper()
+		k, err := buildResultKey(ctx, br)
+		if err != nil {
+			t.Fatalf("buildResultKey(%v): %v", br, err)
+		}
+		return k
+	}
+	orig := buildgo.BuilderRev{Name: "linux-amd64", Rev: "go1"}
+	revert := buildgo.BuilderRev{Name: "linux-amd64", Rev: "go1-revert"}
+	if key(orig) != key(revert) {
+		t.Error("commits with the same tree have different keys")
+	}
+	for _, br := range []buildgo.BuilderRev{
+		{Name: "linux-amd64", Rev: "go2"},
+		{Name: "linux-386", Rev: "go1"},
+		{Name: "linux-amd64", Rev: "go1", SubName: "net", SubRev: "net1"},
+	} {
+		if key(br) == key(orig) {
+			t.Errorf("%v has the same key as %v", br, orig)
+		}
+	}
+	if _, err := buildResultKey(ctx, buildgo.BuilderRev{Name: "linux-amd64", Rev: "missing"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("buildResultKey of missing commit: err = %v; want a 404", err)
+	}
+
+	netOnGo1 := buildgo.BuilderRev{Name: "linux-amd64", Rev: "go1", SubName: "net", SubRev: "net1"}
+	netOnGo2 := buildgo.BuilderRev{Name: "linux-amd64", Rev: "go2", SubName: "net", SubRev: "net1"}
+	cached := map[string]*types.CachedBuildResult{
+		key(orig):     {BuildID: "B1", Rev: "go1"},
+		key(netOnGo1): {BuildID: "B2", Rev: "net1"},
+		key(netOnGo2): {BuildID: "B3", Rev: "net1"},
+	}
+	lookups := 0
+	defer func(old func(context.Context, []string) ([]*types.CachedBuildResult, error)) {
+		lookupBuildResults = old
+	}(lookupBuildResults)
+	lookupBuildResults = func(ctx context.Context, keys []string) ([]*types.CachedBuildResult, error) {
+		lookups++
+		crs := make([]*types.CachedBuildResult, len(keys))
+		for i, k := range keys {
+			crs[i] = cached[k]
+		}
+		return crs, nil
+	}
+	lookup := func(br buildgo.BuilderRev) *types.CachedBuildResult {
+		return cachedBuildResults([]buildgo.BuilderRev{br})[br]
+	}
+
+	if cr := lookup(revert); cr == nil || cr.BuildID != "B1" {
+		t.Errorf("cached result of %v = %+v; want build B1", revert, cr)
+	}
+
+	// Misses are remembered.
+	other := buildgo.BuilderRev{Name: "linux-amd64", Rev: "go2"}
+	lookups = 0
+	for i := 0; i < 2; i++ {
+		if cr := lookup(other); cr != nil {
+			t.Errorf("cached result of %v = %+v; want nil", other, cr)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups for two misses; want 1", lookups)
+	}
+
+	// A forced rebuild doesn't use the cache until it's done.
+	forceRebuild("linux-amd64", "go1-revert")
+	if cr := lookup(revert); cr != nil {
+		t.Errorf("cached result of forced rebuild = %+v; want nil", cr)
+	}
+	doneForcedRebuild(revert)
+	if cr := lookup(revert); cr == nil {
+		t.Error("cached result after forced rebuild = nil; want build B1")
+	}
+
+	// Forcing the rebuild of an x/ repo commit forces its build
+	// against each Go commit.
+	forceRebuild("linux-amd64", "net1")
+	doneForcedRebuild(netOnGo1)
+	if cr := lookup(netOnGo2); cr != nil {
+		t.Errorf("cached result of %v after forced rebuild of %v = %+v; want nil", netOnGo2, netOnGo1, cr)
+	}
+
+	// Work is looked up in one batch, fetching each commit's tree
+	// once.
+	treeHashes = lru.New(10)
+	buildResultMisses = lru.New(10)
+	mu.Lock()
+	fetches = map[string]int{}
+	mu.Unlock()
+	lookups = 0
+	got := cachedBuildResults([]buildgo.BuilderRev{orig, revert, netOnGo1, other, {Name: "linux-386", Rev: "go1"}})
+	if len(got) != 3 || got[orig].BuildID != "B1" || got[revert].BuildID != "B1" || got[netOnGo1].BuildID != "B2" {
+		t.Errorf("cachedBuildResults = %+v; want B1, B1 and B2", got)
+	}
+	if lookups != 1 {
+		t.Errorf("%d lookups for a batch; want 1", lookups)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for path, n := range fetches {
+		if n != 1 {
+			t.Errorf("fetched %s %d times; want once", path, n)
+		}
+	}
+	if len(fetches) != 4 {
+		t.Errorf("fetched trees of %d commits; want 4", len(fetches))
+	}
+}
@@ -36,6 +36,7 @@ type gRPCServer struct {
 // ClearResults implements the ClearResults RPC call from the CoordinatorService.
 //
 // It currently hits the build Dashboard service to clear a result.
+// The next build of the commit bypasses the build result cache.
 // TODO(golang.org/issue/34744) - Change to wipe build status from the Coordinator itself after findWork
 // starts using maintner.
 func (g *gRPCServer) ClearResults(ctx context.Context, req *protos.ClearResultsRequest) (*protos.ClearResultsResponse, error) {
@@ -49,6 +50,9 @@ func (g *gRPCServer) ClearResults(ctx context.Context, req *protos.ClearResultsR
 	if err := g.clearFromDashboard(ctx, req.GetBuilder(), req.GetHash(), key); err != nil {
 		return nil, err
 	}
+	// The result was cleared to build it again, so don't just
+	// reuse a cached one.
+	forceRebuild(req.GetBuilder(), req.GetHash())
 	return &protos.ClearResultsResponse{}, nil
 }
 
@@ -704,6 +704,10 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	buf.Reset()
+	pool.WriteHTMLCapacity(&buf, pool.PoolCapacity())
+	data.CapacityTable = template.HTML(buf.String())
+
 	buf.Reset()
 	gce.BuildletPool().WriteHTMLStatus(&buf)
 	data.GCEPoolStatus = template.HTML(buf.String())
@@ -718,6 +722,12 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 	data.KubePoolStatus = template.HTML(buf.String())
 	buf.Reset()
 
+	if lp := pool.LocalContainerPool(); lp != nil {
+		lp.WriteHTMLStatus(&buf)
+		data.LocalContainerPoolStatus = template.HTML(buf.String())
+		buf.Reset()
+	}
+
 	pool.ReversePool().WriteHTMLStatus(&buf)
 	data.ReversePoolStatus = template.HTML(buf.String())
 
@@ -770,27 +780,29 @@ func diskFree() string {
 
 // statusData is the data that fills out statusTmpl.
 type statusData struct {
-	Total             int // number of total builds (including those waiting for a buildlet)
-	ActiveBuilds      int // number of running builds (subset of Total with a buildlet)
-	ActiveReverse     int // subset of ActiveBuilds that are reverse buildlets
-	NumFD             int
-	NumGoroutine      int
-	Uptime            time.Duration
-	Active            []*buildStatus // have a buildlet
-	Pending           []*buildStatus // waiting on a buildlet
-	Recent            []*buildStatus
-	TrybotsErr        string
-	Trybots           template.HTML
-	GCEPoolStatus     template.HTML // TODO: embed template
-	EC2PoolStatus     template.HTML // TODO: embed template
-	KubePoolStatus    template.HTML // TODO: embed template
-	ReversePoolStatus template.HTML // TODO: embed template
-	RemoteBuildlets   template.HTML
-	GomoteInstances   template.HTML
-	SchedState        schedule.SchedulerState
-	DiskFree          string
-	Version           string
-	HealthCheckers    []*healthChecker
+	Total                    int // number of total builds (including those waiting for a buildlet)
+	ActiveBuilds             int // number of running builds (subset of Total with a buildlet)
+	ActiveReverse            int // subset of ActiveBuilds that are reverse buildlets
+	NumFD                    int
+	NumGoroutine             int
+	Uptime                   time.Duration
+	Active                   []*buildStatus // have a buildlet
+	Pending                  []*buildStatus // waiting on a buildlet
+	Recent                   []*buildStatus
+	TrybotsErr               string
+	Trybots                  template.HTML
+	CapacityTable            template.HTML // TODO: embed template
+	GCEPoolStatus            template.HTML // TODO: embed template
+	EC2PoolStatus            template.HTML // TODO: embed template
+	KubePoolStatus           template.HTML // TODO: embed template
+	LocalContainerPoolStatus template.HTML // TODO: embed template
+	ReversePoolStatus        template.HTML // TODO: embed template
+	RemoteBuildlets          template.HTML
+	GomoteInstances          template.HTML
+	SchedState               schedule.SchedulerState
+	DiskFree                 string
+	Version                  string
+	HealthCheckers           []*healthChecker
 }
 
 var statusTmpl = template.Must(template.New("status").Parse(`
@@ -852,10 +864,12 @@ var statusTmpl = template.Must(template.New("status").Parse(`
 </ul>
 
 <h2 id=pools>Buildlet pools <a href='#pools'>¶</a></h2>
+{{.CapacityTable}}
 <ul>
 	<li>{{.GCEPoolStatus}}</li>
 	<li>{{.EC2PoolStatus}}</li>
 	<li>{{.KubePoolStatus}}</li>
+	{{if .LocalContainerPoolStatus}}<li>{{.LocalContainerPoolStatus}}</li>{{end}}
 	<li>{{.ReversePoolStatus}}</li>
 </ul>
 
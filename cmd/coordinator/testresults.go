@@ -0,0 +1,251 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.16 && (linux || darwin)
+// +build go1.16
+// +build linux darwin
+
+// Code related to structured (test2json) test results.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/build/internal/buildgo"
+	clog "golang.org/x/build/internal/coordinator/log"
+	"golang.org/x/build/internal/sourcecache"
+	"golang.org/x/build/maintner/maintnerd/maintapi/version"
+	"golang.org/x/build/types"
+)
+
+// useJSONFlag reports whether this build should use the -json flag of
+// 'go tool dist test', which makes it report test results as a
+// test2json stream, as it does starting with Go 1.21.
+func (st *buildStatus) useJSONFlag() bool {
+	if maj, min, ok := version.ParseReleaseBranch(st.RevBranch); ok {
+		return maj > 1 || min >= 21
+	}
+	// Master and other branches may be at any version, so go by
+	// the Go tree being built.
+	return st.goMinorVersion() >= 21
+}
+
+// goMinorVersion returns the minor version of Go 1.x that the Go tree
+// at st.Rev is, from its internal/goversion package, or 0 if that
+// can't be determined.
+func (st *buildStatus) goMinorVersion() int {
+	st.onceGoVersion.Do(func() {
+		tgz, err := sourcecache.GetSourceTgz(st, "go", st.Rev)
+		if err == nil {
+			st.goMinor, err = goVersionFromTgz(tgz)
+		}
+		if err != nil {
+			log.Printf("%v: determining Go version of %v: %v", st.BuilderRev, st.Rev, err)
+		}
+	})
+	return st.goMinor
+}
+
+var goVersionRx = regexp.MustCompile(`(?m)^const Version = (\d+)`)
+
+// goVersionFromTgz returns the minor version of Go 1.x that the Go
+// source tarball tgz is, from src/internal/goversion/goversion.go.
+func goVersionFromTgz(tgz io.Reader) (int, error) {
+	zr, err := gzip.NewReader(tgz)
+	if err != nil {
+		return 0, err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return 0, errors.New("no src/internal/goversion/goversion.go")
+		}
+		if err != nil {
+			return 0, err
+		}
+		if strings.TrimPrefix(h.Name, "./") != "src/internal/goversion/goversion.go" {
+			continue
+		}
+		src, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return 0, err
+		}
+		m := goVersionRx.FindSubmatch(src)
+		if m == nil {
+			return 0, errors.New("no Version constant in goversion.go")
+		}
+		return strconv.Atoi(string(m[1]))
+	}
+}
+
+// testEvent is an event in a test2json stream.
+// See https://pkg.go.dev/cmd/test2json.
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64 // seconds
+	Output  string
+}
+
+// maxFailureOutput is how much of a failed test's output is kept
+// in its TestResult.
+const maxFailureOutput = 16 << 10
+
+// parseTestJSON parses the output of 'go tool dist test -json',
+// returning the plain text output it stands for and the results of
+// the packages and tests that finished. Lines that aren't test2json
+// events, such as from tests that don't support -json, are passed
+// through to text as is.
+func parseTestJSON(b []byte) (text []byte, results []*types.TestResult) {
+	var buf bytes.Buffer
+	type key struct{ pkg, test string }
+	running := map[key]*strings.Builder{} // output of unfinished tests
+	for len(b) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, b = b[:i+1], b[i+1:]
+		} else {
+			line, b = b, nil
+		}
+		var ev testEvent
+		if !bytes.HasPrefix(line, []byte("{")) || json.Unmarshal(line, &ev) != nil || ev.Action == "" {
+			buf.Write(line)
+			continue
+		}
+		k := key{ev.Package, ev.Test}
+		switch ev.Action {
+		case "output":
+			buf.WriteString(ev.Output)
+			out := running[k]
+			if out == nil {
+				out = new(strings.Builder)
+				running[k] = out
+			}
+			if out.Len() < maxFailureOutput {
+				out.WriteString(ev.Output)
+			}
+		case "pass", "fail", "skip":
+			tr := &types.TestResult{
+				Package: ev.Package,
+				Test:    ev.Test,
+				Result:  ev.Action,
+				Elapsed: ev.Elapsed,
+			}
+			if out := running[k]; out != nil && ev.Action == "fail" {
+				tr.Output = out.String()
+				if len(tr.Output) > maxFailureOutput {
+					tr.Output = tr.Output[:maxFailureOutput]
+				}
+			}
+			delete(running, k)
+			results = append(results, tr)
+		}
+	}
+	return buf.Bytes(), results
+}
+
+// addTestResults records the results of some of the build's tests.
+func (st *buildStatus) addTestResults(results []*types.TestResult) {
+	for _, tr := range results {
+		tr.BuildID = st.buildID
+		tr.Builder = st.Name
+		tr.GoRev = st.Rev
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.testResults = append(st.testResults, results...)
+}
+
+// putTestResults writes the build's package results and test
+// failures to datastore. The results of the individual tests that
+// passed or were skipped are too many to keep beyond the build's
+// lifetime in memory.
+func (st *buildStatus) putTestResults() {
+	st.mu.Lock()
+	var results []*types.TestResult
+	for _, tr := range st.testResults {
+		if tr.Test == "" || tr.Result == "fail" {
+			results = append(results, tr)
+		}
+	}
+	st.mu.Unlock()
+	clog.CoordinatorProcess().PutTestResults(st.buildID, results)
+}
+
+// handleTestResultsJSON serves the test results of a build as
+// types.TestResults.
+//
+// The build is either a recent one, given as in handleLogs, or any
+// build whose results were written to datastore, given by its ID in
+// the "id" parameter. With "failed=1", only failed tests and packages
+// are included.
+func handleTestResultsJSON(w http.ResponseWriter, r *http.Request) {
+	var res types.TestResults
+	if id := r.FormValue("id"); id != "" {
+		results, err := clog.TestResults(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(results) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		res = types.TestResults{
+			BuildID: id,
+			Builder: results[0].Builder,
+			GoRev:   results[0].GoRev,
+			Done:    true,
+			Results: results,
+		}
+	} else {
+		br := buildgo.BuilderRev{
+			Name:    r.FormValue("name"),
+			Rev:     r.FormValue("rev"),
+			SubName: r.FormValue("subName"), // may be empty
+			SubRev:  r.FormValue("subRev"),  // may be empty
+		}
+		st := getStatus(br, r.FormValue("st"))
+		if st == nil {
+			http.NotFound(w, r)
+			return
+		}
+		st.mu.Lock()
+		res = types.TestResults{
+			BuildID: st.buildID,
+			Builder: st.Name,
+			GoRev:   st.Rev,
+			Done:    !st.done.IsZero(),
+			Results: append([]*types.TestResult(nil), st.testResults...),
+		}
+		st.mu.Unlock()
+	}
+	if r.FormValue("failed") == "1" {
+		var failed []*types.TestResult
+		for _, tr := range res.Results {
+			if tr.Result == "fail" {
+				failed = append(failed, tr)
+			}
+		}
+		res.Results = failed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.16 && (linux || darwin)
+// +build go1.16
+// +build linux darwin
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+
+	"golang.org/x/build/types"
+)
+
+func TestParseTestJSON(t *testing.T) {
+	in := `
+XXXBANNERXXX:Testing packages.
+{"Action":"start","Package":"strings"}
+{"Action":"run","Package":"strings","Test":"TestFoo"}
+{"Action":"output","Package":"strings","Test":"TestFoo","Output":"=== RUN   TestFoo\n"}
+{"Action":"output","Package":"strings","Test":"TestFoo","Output":"    foo_test.go:10: bad\n"}
+{"Action":"output","Package":"strings","Test":"TestFoo","Output":"--- FAIL: TestFoo (0.10s)\n"}
+{"Action":"fail","Package":"strings","Test":"TestFoo","Elapsed":0.1}
+{"Action":"output","Package":"strings","Test":"TestBar","Output":"--- SKIP: TestBar (0.00s)\n"}
+{"Action":"skip","Package":"strings","Test":"TestBar","Elapsed":0}
+{"Action":"output","Package":"strings","Output":"FAIL\tstrings\t0.2s\n"}
+{"Action":"fail","Package":"strings","Elapsed":0.2}
+{"Action":"output","Package":"sort","Output":"ok  \tsort\t0.3s\n"}
+{"Action":"pass","Package":"sort","Elapsed":0.3}
+{not json
+`
+	wantText := `
+XXXBANNERXXX:Testing packages.
+=== RUN   TestFoo
+    foo_test.go:10: bad
+--- FAIL: TestFoo (0.10s)
+--- SKIP: TestBar (0.00s)
+FAIL	strings	0.2s
+ok  	sort	0.3s
+{not json
+`
+	wantResults := []*types.TestResult{
+		{Package: "strings", Test: "TestFoo", Result: "fail", Elapsed: 0.1,
+			Output: "=== RUN   TestFoo\n    foo_test.go:10: bad\n--- FAIL: TestFoo (0.10s)\n"},
+		{Package: "strings", Test: "TestBar", Result: "skip"},
+		{Package: "strings", Result: "fail", Elapsed: 0.2, Output: "FAIL\tstrings\t0.2s\n"},
+		{Package: "sort", Result: "pass", Elapsed: 0.3},
+	}
+	text, results := parseTestJSON([]byte(in))
+	if string(text) != wantText {
+		t.Errorf("text:\n%s\nwant:\n%s", text, wantText)
+	}
+	if !reflect.DeepEqual(results, wantResults) {
+		for _, tr := range results {
+			t.Logf("got %+v", tr)
+		}
+		t.Errorf("wrong results")
+	}
+}
+
+func TestGoVersionFromTgz(t *testing.T) {
+	tgz := func(files map[string]string) *bytes.Buffer {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(zw)
+		for name, body := range files {
+			if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(body))}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write([]byte(body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return &buf
+	}
+	const goversion = "package goversion\n\n// Version is the Go 1.x version.\nconst Version = 21\n"
+	got, err := goVersionFromTgz(tgz(map[string]string{
+		"VERSION":                             "devel",
+		"src/internal/goversion/goversion.go": goversion,
+	}))
+	if err != nil || got != 21 {
+		t.Errorf("goVersionFromTgz = %d, %v; want 21, nil", got, err)
+	}
+	if got, err := goVersionFromTgz(tgz(map[string]string{"src/go/build/build.go": "package build\n"})); err == nil {
+		t.Errorf("goVersionFromTgz of tree without goversion.go = %d, nil; want error", got)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/build/buildlet"
+)
+
+func drain(args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "drain usage: gomote drain [--halt | --undo] <reverse-buildlet-hostname>")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Takes a reverse buildlet host out of service once its current work")
+		fmt.Fprintln(os.Stderr, "finishes. Its buildlets are asked to halt after their builds, as after")
+		fmt.Fprintln(os.Stderr, "any build; --halt also halts its idle ones, once. It requires being a")
+		fmt.Fprintln(os.Stderr, "reverse buildlet admin on the coordinator.")
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	var halt, undo bool
+	fs.BoolVar(&halt, "halt", false, "also halt the host's idle buildlets, once")
+	fs.BoolVar(&undo, "undo", false, "put the host back in service")
+	fs.Parse(args)
+	if fs.NArg() != 1 || (halt && undo) {
+		fs.Usage()
+	}
+
+	cc, err := buildlet.NewCoordinatorClientFromFlags()
+	if err != nil {
+		return err
+	}
+	msg, err := cc.DrainReverseHost(fs.Arg(0), !undo, halt)
+	if err != nil {
+		return err
+	}
+	fmt.Print(msg)
+	return nil
+}
@@ -25,6 +25,7 @@ To list the subcommands, run "gomote" without arguments:
 
 	  create     create a buildlet; with no args, list types of buildlets
 	  destroy    destroy a buildlet
+	  drain      take a reverse buildlet host out of service
 	  gettar     extract a tar.gz from a buildlet
 	  list       list active buildlets
 	  ls         list the contents of a directory on a buildlet
@@ -151,6 +152,7 @@ func registerCommand(name, des string, run func([]string) error) {
 func registerCommands() {
 	registerCommand("create", "create a buildlet; with no args, list types of buildlets", legacyCreate)
 	registerCommand("destroy", "destroy a buildlet", legacyDestroy)
+	registerCommand("drain", "take a reverse buildlet host out of service", drain)
 	registerCommand("gettar", "extract a tar.gz from a buildlet", legacyGetTar)
 	registerCommand("ls", "list the contents of a directory on a buildlet", legacyLs)
 	registerCommand("list", "list active buildlets", legacyList)
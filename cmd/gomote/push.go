@@ -277,15 +277,36 @@ func legacyPush(args []string) error {
 	}
 	if len(toSend) > 0 {
 		sort.Strings(toSend)
+		if dryRun {
+			log.Printf("(Dry-run) Would have uploaded %d new/changed files.", len(toSend))
+			return nil
+		}
+		files := make([]buildlet.BlobFile, 0, len(toSend))
+		for _, rel := range toSend {
+			if rel == "VERSION" {
+				files = append(files, buildlet.BlobFile{Path: rel, Mode: 0644, Digest: fmt.Sprintf("%x", sha1.Sum([]byte(fakeVersion))), Size: int64(len(fakeVersion))})
+				continue
+			}
+			files = append(files, buildlet.BlobFile{Path: rel, Mode: local[rel].fi.Mode().Perm(), Digest: local[rel].sha1, Size: local[rel].fi.Size()})
+		}
+		log.Printf("Writing %d new/changed files, uploading only those not in the buildlet's cache", len(toSend))
+		err := buildlet.PutFiles(ctx, bc, "go", files, func(f buildlet.BlobFile) (io.ReadCloser, error) {
+			if f.Path == "VERSION" {
+				return io.NopCloser(strings.NewReader(fakeVersion)), nil
+			}
+			return os.Open(filepath.Join(goroot, filepath.FromSlash(f.Path)))
+		})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, buildlet.ErrBlobsUnsupported) && !errors.Is(err, buildlet.ErrBlobsMissing) {
+			return fmt.Errorf("writing files to buildlet: %v", err)
+		}
 		tgz, err := generateDeltaTgz(goroot, toSend)
 		if err != nil {
 			return err
 		}
 		log.Printf("Uploading %d new/changed files; %d byte .tar.gz", len(toSend), tgz.Len())
-		if dryRun {
-			log.Printf("(Dry-run mode; not doing anything.")
-			return nil
-		}
 		if err := bc.PutTar(ctx, tgz, "go"); err != nil {
 			return fmt.Errorf("writing tarball to buildlet: %v", err)
 		}
@@ -596,6 +617,14 @@ func isEditorBackup(path string) bool {
 	return false
 }
 
+// fakeVersion is the contents of the VERSION file sent to buildlets
+// that lack one.
+//
+// TODO(bradfitz): a dummy VERSION file's contents to make things
+// happy. Notably it starts with "devel ". Do we care about it
+// being accurate beyond that?
+const fakeVersion = "devel gomote.XXXXX"
+
 // file is forward-slash separated
 func generateDeltaTgz(goroot string, files []string) (*bytes.Buffer, error) {
 	var buf bytes.Buffer
@@ -604,18 +633,14 @@ func generateDeltaTgz(goroot string, files []string) (*bytes.Buffer, error) {
 	for _, file := range files {
 		// Special.
 		if file == "VERSION" {
-			// TODO(bradfitz): a dummy VERSION file's contents to make things
-			// happy. Notably it starts with "devel ". Do we care about it
-			// being accurate beyond that?
-			version := "devel gomote.XXXXX"
 			if err := tw.WriteHeader(&tar.Header{
 				Name: "VERSION",
 				Mode: 0644,
-				Size: int64(len(version)),
+				Size: int64(len(fakeVersion)),
 			}); err != nil {
 				return nil, err
 			}
-			if _, err := io.WriteString(tw, version); err != nil {
+			if _, err := io.WriteString(tw, fakeVersion); err != nil {
 				return nil, err
 			}
 			continue
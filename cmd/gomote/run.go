@@ -42,6 +42,8 @@ func legacyRun(args []string) error {
 	fs.StringVar(&dir, "dir", "", "Directory to run from. Defaults to the directory of the command, or the work directory if -system is true.")
 	var builderEnv string
 	fs.StringVar(&builderEnv, "builderenv", "", "Optional alternate builder to act like. Must share the same underlying buildlet host type, or it's an error. For instance, linux-amd64-race or linux-386-387 are compatible with linux-amd64, but openbsd-amd64 and openbsd-386 are different hosts.")
+	var stdin bool
+	fs.BoolVar(&stdin, "stdin", false, "Pipe gomote's standard input to the command. Requires buildlet version 29 or later.")
 
 	fs.Parse(args)
 	if fs.NArg() < 2 {
@@ -76,7 +78,7 @@ func legacyRun(args []string) error {
 	}
 	env = append(env, "GO_DISABLE_OUTBOUND_NETWORK="+fmt.Sprint(firewall))
 
-	remoteErr, execErr := bc.Exec(context.Background(), cmd, buildlet.ExecOpts{
+	opts := buildlet.ExecOpts{
 		Dir:         dir,
 		SystemLevel: sys || strings.HasPrefix(cmd, "/"),
 		Output:      os.Stdout,
@@ -84,7 +86,11 @@ func legacyRun(args []string) error {
 		ExtraEnv:    envutil.Dedup(conf.GOOS(), append(conf.Env(), []string(env)...)),
 		Debug:       debug,
 		Path:        pathOpt,
-	})
+	}
+	if stdin {
+		opts.Stdin = os.Stdin
+	}
+	remoteErr, execErr := bc.Exec(context.Background(), cmd, opts)
 	if execErr != nil {
 		return fmt.Errorf("Error trying to execute %s: %v", cmd, execErr)
 	}
@@ -7,6 +7,7 @@
 package dashboard
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"sort"
@@ -792,6 +793,14 @@ type HostConfig struct {
 	// (This is generally an internal implementation detail, currently left behind only for the -perf builder.)
 	CustomDeleteTimeout time.Duration
 
+	// WarmBuildlets is how many idle buildlets of this host type the
+	// coordinator keeps started ahead of demand, so that builds
+	// needn't wait for a VM or pod to boot. Zero means none, except
+	// as derived from recent demand if the coordinator is configured
+	// to do so. It applies to GCE, Kubernetes and EC2 host types
+	// without a CustomDeleteTimeout.
+	WarmBuildlets int
+
 	// Reverse options
 	ExpectNum       int  // expected number of reverse buildlets of this type
 	HermeticReverse bool // whether reverse buildlet has fresh env per conn
@@ -956,6 +965,13 @@ type BuildConfig struct {
 	numTestHelpers    int
 	numTryTestHelpers int // For TryBots/SlowBots. If 0, numTestHelpers is used.
 
+	// flakyTestRetries is how many times, in total, a post-submit
+	// build may retry cmd/dist tests that failed, each time on a
+	// different buildlet when one is available, before the build
+	// fails. If zero, failed tests aren't retried. TryBots and
+	// SlowBots never retry them.
+	flakyTestRetries int
+
 	env           []string // extra environment ("key=value") pairs
 	allScriptArgs []string
 
@@ -1335,6 +1351,32 @@ func defaultPlus(repos ...string) func(repo, branch, goBranch string) bool {
 	}
 }
 
+// inputHashVersion is part of every InputHash. It must be incremented
+// by hand whenever a change that can affect build results isn't
+// otherwise reflected in InputHash, such as a change to a
+// function-valued field of BuildConfig or HostConfig, so that results
+// cached under the old hashes aren't reused.
+const inputHashVersion = 1
+
+// InputHash returns a hash of the parts of the builder's
+// configuration that can affect the result of its builds, so that a
+// build of the same source trees with a config of the same InputHash
+// can be expected to have the same result.
+//
+// Function-valued fields, such as distTestAdjust, can't be hashed;
+// changes to them are only covered by bumping inputHashVersion.
+func (c *BuildConfig) InputHash() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "version=%d\n", inputHashVersion)
+	hc := c.HostConfig()
+	fmt.Fprintf(h, "name=%s\nhost=%s\nvm=%s\ncontainer=%s\nkonlet=%s\nbootstrap=%s\n",
+		c.Name, hc.HostType, hc.VMImage, hc.ContainerImage, hc.KonletVMImage, hc.goBootstrapURLTmpl)
+	fmt.Fprintf(h, "env=%q\nargs=%q\n", c.Env(), c.AllScriptArgs())
+	fmt.Fprintf(h, "compile=%v\nbench=%v\nstop=%v\nretries=%d\n",
+		c.CompileOnly, c.RunBench, c.StopAfterMake, c.FlakyTestRetries(false))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 // AllScriptArgs returns the set of arguments that should be passed to the
 // all.bash-equivalent script. Usually empty.
 func (c *BuildConfig) AllScriptArgs() []string {
@@ -1467,6 +1509,16 @@ func (c *BuildConfig) NumTestHelpers(isTry bool) int {
 	return c.numTestHelpers
 }
 
+// FlakyTestRetries returns how many times in total a build may retry
+// cmd/dist tests that failed before the build fails. It's zero for
+// TryBots and SlowBots, whose failures their authors should see.
+func (c *BuildConfig) FlakyTestRetries(isTry bool) int {
+	if isTry {
+		return 0
+	}
+	return c.flakyTestRetries
+}
+
 // defaultTrySet returns a trybot policy function that reports whether
 // a project should use trybots. All the default projects are included,
 // plus any given in extraProj.
@@ -1972,6 +2024,9 @@ func init() {
 			"GO_TEST_TIMEOUT_SCALE=5", // give them lots of time
 		},
 		numTryTestHelpers: 4, // Target time is < 15 min for golang.org/issue/42661.
+		// Long tests are the most prone to flaking, and the
+		// costliest to rerun as a whole build.
+		flakyTestRetries: 2,
 	})
 	addBuilder(BuildConfig{
 		Name:     "linux-386-longtest",
@@ -177,16 +177,32 @@ func SyncBuilds(ctx context.Context, env *buildenv.Environment) error {
 // SyncSpans syncs the datastore "Span" entities to the BigQuery "Spans" table.
 // These contain the fine-grained timing details of how a build ran.
 func SyncSpans(ctx context.Context, env *buildenv.Environment) error {
+	return syncByEndTime(ctx, env, "Span", "Spans", types.SpanRecord{})
+}
+
+// SyncTestRetries syncs the datastore "TestRetry" entities to the
+// BigQuery "TestRetries" table. Each records one retry of a cmd/dist
+// test that failed, and whether it then passed.
+func SyncTestRetries(ctx context.Context, env *buildenv.Environment) error {
+	return syncByEndTime(ctx, env, "TestRetry", "TestRetries", types.TestRetryRecord{})
+}
+
+// syncByEndTime syncs the datastore entities of kind to the BigQuery
+// table tableName in the "builds" dataset, creating the table if
+// needed. The entities are of the struct type of record, which must
+// have an EndTime time.Time field; entities that ended after the
+// latest EndTime in the table are added.
+func syncByEndTime(ctx context.Context, env *buildenv.Environment, kind, tableName string, record interface{}) error {
 	bq, err := bigquery.NewClient(ctx, env.ProjectName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer bq.Close()
 
-	table := bq.Dataset("builds").Table("Spans")
+	table := bq.Dataset("builds").Table(tableName)
 	meta, err := table.Metadata(ctx)
 	if ae, ok := err.(*googleapi.Error); ok && ae.Code == 404 {
-		log.Printf("Creating table Spans...")
+		log.Printf("Creating table %s...", tableName)
 		err = table.Create(ctx, nil)
 		if err == nil {
 			meta, err = table.Metadata(ctx)
@@ -196,26 +212,24 @@ func SyncSpans(ctx context.Context, env *buildenv.Environment) error {
 		return fmt.Errorf("Metadata: %#v", err)
 	}
 	if Verbose {
-		log.Printf("buildstats: Spans metadata: %#v", meta)
+		log.Printf("buildstats: %s metadata: %#v", tableName, meta)
 	}
-	schema := meta.Schema
-	if len(schema) == 0 {
+	if len(meta.Schema) == 0 {
 		if Verbose {
 			log.Printf("EMPTY SCHEMA")
 		}
-		schema, err = bigquery.InferSchema(types.SpanRecord{})
+		schema, err := bigquery.InferSchema(record)
 		if err != nil {
 			return fmt.Errorf("InferSchema: %v", err)
 		}
 		blindWrite := ""
-		meta, err := table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, blindWrite)
+		meta, err = table.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, blindWrite)
 		if err != nil {
 			return fmt.Errorf("table.Update schema: %v", err)
 		}
-		schema = meta.Schema
 	}
 	if Verbose {
-		for i, fs := range schema {
+		for i, fs := range meta.Schema {
 			log.Printf("  schema[%v]: %+v", i, fs)
 			for j, fs := range fs.Schema {
 				log.Printf("     .. schema[%v]: %+v", j, fs)
@@ -223,32 +237,34 @@ func SyncSpans(ctx context.Context, env *buildenv.Environment) error {
 		}
 	}
 
-	q := bq.Query("SELECT MAX(EndTime) FROM builds.Spans")
+	q := bq.Query("SELECT MAX(EndTime) FROM builds." + tableName)
 	it, err := q.Read(ctx)
 	if err != nil {
 		return fmt.Errorf("Read: %v", err)
 	}
-
-	var since time.Time
 	var values []bigquery.Value
 	if err := it.Next(&values); err != nil {
 		if err == iterator.Done {
-			return fmt.Errorf("Expected at least one row fro MAX(EndTime) query; got none.")
+			return fmt.Errorf("Expected at least one row for MAX(EndTime) query; got none.")
 		}
 		return fmt.Errorf("Next: %v", err)
 	}
+	var since time.Time
 	switch t := values[0].(type) {
 	case nil:
 		// NULL. No rows.
 		log.Printf("starting from the beginning...")
 	case time.Time:
-		since = values[0].(time.Time)
+		since = t
 	default:
-		return fmt.Errorf("MAX(EndType) = %T: want nil or time.Time", t)
+		return fmt.Errorf("MAX(EndTime) = %T: want nil or time.Time", t)
 	}
 	if since.IsZero() {
 		since = time.Unix(1, 0) // arbitrary
 	}
+	if Verbose {
+		log.Printf("buildstats: %s max time: %v", kind, since)
+	}
 
 	ds, err := datastore.NewClient(ctx, env.ProjectName)
 	if err != nil {
@@ -257,61 +273,168 @@ func SyncSpans(ctx context.Context, env *buildenv.Environment) error {
 	defer ds.Close()
 
 	up := table.Uploader()
-
-	if Verbose {
-		log.Printf("buildstats: Span max time: %v", since)
-	}
-	dsit := ds.Run(ctx, datastore.NewQuery("Span").Filter("EndTime >", since).Order("EndTime"))
-	var maxPut time.Time
+	recType := reflect.TypeOf(record)
+	dsit := ds.Run(ctx, datastore.NewQuery(kind).Filter("EndTime >", since).Order("EndTime"))
 	for {
-		n := 0
 		var rows []*bigquery.ValuesSaver
-		for {
-			var s types.SpanRecord
-			key, err := dsit.Next(&s)
+		var maxPut time.Time
+		for len(rows) < 1000 {
+			rp := reflect.New(recType)
+			key, err := dsit.Next(rp.Interface())
 			if err == iterator.Done {
 				break
 			}
-			n++
 			if err != nil {
-				log.Fatal(err)
+				return fmt.Errorf("querying %s: %v", kind, err)
 			}
-			if s.EndTime.IsZero() {
+			rv := rp.Elem()
+			endTime := rv.FieldByName("EndTime").Interface().(time.Time)
+			if endTime.IsZero() {
 				return fmt.Errorf("got zero endtime")
 			}
 
 			var row []bigquery.Value
 			var putSchema bigquery.Schema
-			rv := reflect.ValueOf(s)
 			for _, fs := range meta.Schema {
 				if fs.Name[0] == '_' {
 					continue
 				}
 				putSchema = append(putSchema, fs)
 				row = append(row, rv.FieldByName(fs.Name).Interface())
-				maxPut = s.EndTime
 			}
-
+			maxPut = endTime
 			rows = append(rows, &bigquery.ValuesSaver{
 				Schema:   putSchema,
 				InsertID: key.Encode(),
 				Row:      row,
 			})
-			if len(rows) == 1000 {
-				break
-			}
 		}
-		if n == 0 {
+		if len(rows) == 0 {
 			return nil
 		}
 		err = up.Put(ctx, rows)
-		log.Printf("buildstats: Spans sync put %d rows, up to %v. error = %v", len(rows), maxPut, err)
+		log.Printf("buildstats: %s sync put %d rows, up to %v. error = %v", tableName, len(rows), maxPut, err)
 		if err != nil {
 			return err
 		}
 	}
 }
 
+// FlakeStats describes how often cmd/dist tests failed and then
+// passed when retried, per builder.
+type FlakeStats struct {
+	// AsOf is the time that the stats were queried from BigQuery.
+	AsOf time.Time
+
+	// Builders maps from a builder name to its tests' flake stats,
+	// keyed by cmd/dist test name.
+	Builders map[string]map[string]*TestFlakes
+}
+
+// TestFlakes counts the retries of one cmd/dist test on one builder.
+type TestFlakes struct {
+	Runs    int // runs of the test, from the Spans table; 0 if unknown
+	Retries int // times the test was retried after failing
+	Passed  int // retries that passed
+}
+
+// FlakeRate returns the fraction of the test's runs that failed but
+// passed when retried, or of its retries if the number of runs isn't
+// known.
+func (tf *TestFlakes) FlakeRate() float64 {
+	switch {
+	case tf.Runs > 0:
+		return float64(tf.Passed) / float64(tf.Runs)
+	case tf.Retries > 0:
+		return float64(tf.Passed) / float64(tf.Retries)
+	}
+	return 0
+}
+
+func (fs *FlakeStats) BuilderNames() []string {
+	s := make([]string, 0, len(fs.Builders))
+	for k := range fs.Builders {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
+
+// Tests returns the names of the tests with flake stats for builder,
+// most flaky first.
+func (fs *FlakeStats) Tests(builder string) []string {
+	tests := fs.Builders[builder]
+	s := make([]string, 0, len(tests))
+	for k := range tests {
+		s = append(s, k)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		ri, rj := tests[s[i]].FlakeRate(), tests[s[j]].FlakeRate()
+		if ri != rj {
+			return ri > rj
+		}
+		return s[i] < s[j]
+	})
+	return s
+}
+
+// QueryFlakeStats returns flake stats on all retried tests for all
+// builders. Runs are counted from ts, if non-nil.
+func QueryFlakeStats(ctx context.Context, env *buildenv.Environment, ts *TestStats) (*FlakeStats, error) {
+	fs := &FlakeStats{
+		AsOf:     time.Now(),
+		Builders: map[string]map[string]*TestFlakes{},
+	}
+	bq, err := bigquery.NewClient(ctx, env.ProjectName)
+	if err != nil {
+		return nil, err
+	}
+	defer bq.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	q := bq.Query(`
+SELECT
+    Builder, TestName, COUNT(*) as Retries, COUNTIF(Passed) as Passed
+FROM
+    builds.TestRetries
+WHERE
+    StartTime > TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL 500 HOUR)
+GROUP BY 1, 2
+`)
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var row struct {
+			Builder  string
+			TestName string
+			Retries  int
+			Passed   int
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		tests := fs.Builders[row.Builder]
+		if tests == nil {
+			tests = map[string]*TestFlakes{}
+			fs.Builders[row.Builder] = tests
+		}
+		tf := &TestFlakes{Retries: row.Retries, Passed: row.Passed}
+		if ts != nil {
+			if bs, ok := ts.BuilderTestStats[row.Builder]; ok {
+				tf.Runs = bs.Runs[row.TestName]
+			}
+		}
+		tests[row.TestName] = tf
+	}
+	return fs, nil
+}
+
 // TestStats describes stats for a cmd/dist test on a particular build
 // configuration (a "builder").
 type TestStats struct {
@@ -9,6 +9,7 @@ package log
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
@@ -103,3 +104,107 @@ func (p *Process) PutSpanRecord(sr *types.SpanRecord) {
 		log.Printf("datastore Span Put: %v", err)
 	}
 }
+
+func (p *Process) PutTestRetryRecord(tr *types.TestRetryRecord) {
+	dsClient := pool.NewGCEConfiguration().DSClient()
+	if dsClient == nil {
+		return
+	}
+	ctx := context.Background()
+	key := datastore.NameKey("TestRetry", fmt.Sprintf("%s-%s-%d", tr.BuildID, tr.TestName, tr.Attempt), nil)
+	if _, err := dsClient.Put(ctx, key, tr); err != nil {
+		log.Printf("datastore TestRetry Put: %v", err)
+	}
+}
+
+// PutTestResults writes the results of a build's tests, as children
+// of its Build entity.
+func (p *Process) PutTestResults(buildID string, results []*types.TestResult) {
+	dsClient := pool.NewGCEConfiguration().DSClient()
+	if dsClient == nil || len(results) == 0 {
+		return
+	}
+	ctx := context.Background()
+	parent := datastore.NameKey("Build", buildID, nil)
+	const maxPut = 500 // datastore's limit per PutMulti
+	for len(results) > 0 {
+		n := len(results)
+		if n > maxPut {
+			n = maxPut
+		}
+		keys := make([]*datastore.Key, n)
+		for i, tr := range results[:n] {
+			keys[i] = datastore.NameKey("TestResult", tr.Package+" "+tr.Test, parent)
+		}
+		if _, err := dsClient.PutMulti(ctx, keys, results[:n]); err != nil {
+			log.Printf("datastore TestResult PutMulti: %v", err)
+			return
+		}
+		results = results[n:]
+	}
+}
+
+// TestResults returns the test results written by PutTestResults for
+// the build with the given ID.
+func TestResults(ctx context.Context, buildID string) ([]*types.TestResult, error) {
+	dsClient := pool.NewGCEConfiguration().DSClient()
+	if dsClient == nil {
+		return nil, errors.New("no datastore client")
+	}
+	var results []*types.TestResult
+	q := datastore.NewQuery("TestResult").Ancestor(datastore.NameKey("Build", buildID, nil))
+	if _, err := dsClient.GetAll(ctx, q, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (p *Process) PutCachedBuildResult(key string, cr *types.CachedBuildResult) {
+	dsClient := pool.NewGCEConfiguration().DSClient()
+	if dsClient == nil {
+		return
+	}
+	ctx := context.Background()
+	if _, err := dsClient.Put(ctx, datastore.NameKey("CachedBuildResult", key, nil), cr); err != nil {
+		log.Printf("datastore CachedBuildResult Put: %v", err)
+	}
+}
+
+// CachedBuildResults returns the build results written by
+// PutCachedBuildResult with the given keys, in the same order, with
+// nil for keys that have none.
+func CachedBuildResults(ctx context.Context, keys []string) ([]*types.CachedBuildResult, error) {
+	dsClient := pool.NewGCEConfiguration().DSClient()
+	if dsClient == nil {
+		return nil, errors.New("no datastore client")
+	}
+	results := make([]*types.CachedBuildResult, len(keys))
+	// Datastore gets at most 1000 entities per call.
+	const maxGet = 1000
+	for start := 0; start < len(keys); start += maxGet {
+		end := start + maxGet
+		if end > len(keys) {
+			end = len(keys)
+		}
+		dsKeys := make([]*datastore.Key, end-start)
+		for i := range dsKeys {
+			dsKeys[i] = datastore.NameKey("CachedBuildResult", keys[start+i], nil)
+		}
+		crs := make([]types.CachedBuildResult, len(dsKeys))
+		err := dsClient.GetMulti(ctx, dsKeys, crs)
+		merr, _ := err.(datastore.MultiError)
+		if err != nil && merr == nil {
+			return nil, err
+		}
+		for i := range crs {
+			if merr != nil && merr[i] != nil {
+				if merr[i] == datastore.ErrNoSuchEntity {
+					continue
+				}
+				return nil, merr[i]
+			}
+			results[start+i] = &crs[i]
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"fmt"
+	"html"
+	"io"
+)
+
+// Capacity is the resource usage and limits of a cloud buildlet pool,
+// or of one of its quotas, as tracked by its ledger. All cloud pools
+// report their capacity in this form.
+type Capacity struct {
+	// Name is the pool, and quota if the pool has several,
+	// such as "GCE N2_CPUS".
+	Name string
+
+	// Instances is the number of instances the pool has created or
+	// is creating, and InstanceLimit how many it may. An
+	// InstanceLimit of zero means there is no limit, and a negative
+	// one that none may be created.
+	Instances, InstanceLimit int64
+
+	// CPUs is the number of vCPUs reserved by the pool's instances,
+	// and CPULimit how many may be.
+	CPUs, CPULimit int64
+
+	// MemoryMiB is the memory, in MiB, reserved by the pool's
+	// instances, and MemoryLimitMiB how much may be. A
+	// MemoryLimitMiB of zero means memory isn't tracked or limited.
+	MemoryMiB, MemoryLimitMiB int64
+}
+
+// PoolCapacity returns the capacity of the GCE, EC2 and Kubernetes
+// pools.
+func PoolCapacity() []Capacity {
+	caps := gcePool.Capacity()
+	if ec2Buildlet != nil {
+		caps = append(caps, ec2Buildlet.Capacity()...)
+	}
+	if kubeErr == nil {
+		caps = append(caps, kubePool.Capacity()...)
+	}
+	return caps
+}
+
+// WriteHTMLCapacity writes caps as an HTML table.
+func WriteHTMLCapacity(w io.Writer, caps []Capacity) {
+	io.WriteString(w, "<table class=capacity><tr><th>Pool</th><th>Instances</th><th>CPUs</th><th>Memory</th></tr>\n")
+	for _, c := range caps {
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			html.EscapeString(c.Name),
+			usedOf(c.Instances, c.InstanceLimit, ""),
+			usedOf(c.CPUs, c.CPULimit, ""),
+			usedOf(c.MemoryMiB, c.MemoryLimitMiB, " MiB"))
+	}
+	io.WriteString(w, "</table>\n")
+}
+
+// usedOf formats used out of limit, where a zero limit is no limit.
+func usedOf(used, limit int64, unit string) string {
+	switch {
+	case limit == 0 && used == 0:
+		return "-"
+	case limit == 0:
+		return fmt.Sprintf("%d%s", used, unit)
+	case limit < 0:
+		return fmt.Sprintf("%d/0%s", used, unit)
+	}
+	return fmt.Sprintf("%d/%d%s", used, limit, unit)
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/build/dashboard"
+)
+
+func TestGCEQuotaLedgers(t *testing.T) {
+	p := &GCEBuildlet{instLimit: 2}
+	p.mu.Lock()
+	p.cpuQuotaLocked("CPUS").SetCPULimit(2)
+	p.cpuQuotaLocked("N2_CPUS").SetCPULimit(4)
+	p.mu.Unlock()
+	e2 := &dashboard.HostConfig{}                 // e2-highcpu-2
+	n2 := &dashboard.HostConfig{NestedVirt: true} // n2-standard-4
+
+	if !p.tryAllocateQuota("vm-e2", e2) {
+		t.Fatal("tryAllocateQuota(vm-e2) = false; want true")
+	}
+	if p.hasQuota(e2) {
+		t.Error("hasQuota(e2) with no CPUS left = true; want false")
+	}
+	if !p.tryAllocateQuota("vm-n2", n2) {
+		t.Fatal("tryAllocateQuota(vm-n2) = false; want true")
+	}
+	p.mu.Lock()
+	p.cpuQuotaLocked("N2_CPUS").SetCPULimit(100)
+	p.mu.Unlock()
+	if p.hasQuota(n2) {
+		t.Error("hasQuota(n2) with no INSTANCES left = true; want false")
+	}
+
+	caps := p.Capacity()
+	if len(caps) != 1+len(gceCPUQuotas) {
+		t.Fatalf("Capacity has %d entries; want %d", len(caps), 1+len(gceCPUQuotas))
+	}
+	if c := caps[0]; c.Instances != 2 || c.InstanceLimit != 2 {
+		t.Errorf("instances = %d/%d; want 2/2", c.Instances, c.InstanceLimit)
+	}
+
+	p.putVMCountQuota("vm-e2", e2)
+	if !p.hasQuota(e2) {
+		t.Error("hasQuota(e2) after putVMCountQuota = false; want true")
+	}
+}
+
+func TestWriteHTMLCapacity(t *testing.T) {
+	var buf bytes.Buffer
+	WriteHTMLCapacity(&buf, []Capacity{
+		{Name: "EC2", Instances: 1, CPUs: 4, CPULimit: 16},
+		{Name: "Kubernetes", Instances: 3, CPUs: 6, CPULimit: 100, MemoryMiB: 300, MemoryLimitMiB: 1000},
+	})
+	for _, want := range []string{
+		"<tr><td>EC2</td><td>1</td><td>4/16</td><td>-</td></tr>",
+		"<tr><td>Kubernetes</td><td>3</td><td>6/100</td><td>300/1000 MiB</td></tr>",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("capacity table doesn't contain %q:\n%s", want, buf.String())
+		}
+	}
+}
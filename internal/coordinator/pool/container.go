@@ -0,0 +1,328 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/build/buildenv"
+	"golang.org/x/build/buildlet"
+	"golang.org/x/build/dashboard"
+)
+
+/*
+This file implements a buildlet pool that runs container-based
+buildlets on the local machine with Docker or Podman, so the whole
+coordinator, scheduler and buildlet path can run on a developer's
+machine.
+*/
+
+// containerDeleteAtLabel is the label on containers started by the
+// local container pool. Its value is the Unix time after which the
+// container may be deleted even if it's still in use, as a safety
+// mechanism like GCE's "delete-at" metadata attribute.
+const containerDeleteAtLabel = "golang.org/x/build/delete-at"
+
+// localContainers is the local container pool, if initialized by
+// InitLocalContainers.
+var localContainers *localContainerPool
+
+// LocalContainerOpts configures the local container pool.
+type LocalContainerOpts struct {
+	// Runtime is the container runtime command: "docker" or
+	// "podman", or another with a compatible command line.
+	Runtime string
+
+	// Registry is the prefix of the buildlets' container images,
+	// such as "gcr.io/symbolic-datum-552". If empty, it's
+	// "gcr.io/" and the project of BuildEnv.
+	Registry string
+
+	// BuildEnv is the build environment the buildlet binaries
+	// are fetched from. If nil, it's buildenv.Production.
+	BuildEnv *buildenv.Environment
+
+	// Capacity is the most containers that may run at once. If
+	// zero, it's the number of CPUs.
+	Capacity int
+}
+
+// InitLocalContainers starts the local container pool, which is
+// then used for all container host types, removing any containers
+// left over from earlier runs. Only one coordinator using the pool
+// should run on a machine at once.
+func InitLocalContainers(opts LocalContainerOpts) error {
+	if _, err := exec.LookPath(opts.Runtime); err != nil {
+		return fmt.Errorf("local containers: %v", err)
+	}
+	p := newLocalContainerPool(opts, func(ctx context.Context, args ...string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, opts.Runtime, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return out, fmt.Errorf("%s %s: %v; stderr: %s", opts.Runtime, args[0], err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return out, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := p.cleanUpContainers(ctx, true); err != nil {
+		return err
+	}
+	go p.cleanUpContainersLoop()
+	localContainers = p
+	return nil
+}
+
+// LocalContainerPool returns the local container pool, or nil if
+// InitLocalContainers hasn't been called.
+func LocalContainerPool() *localContainerPool {
+	return localContainers
+}
+
+// localContainerPool is the local container buildlet pool.
+type localContainerPool struct {
+	runtime  string
+	registry string
+	env      *buildenv.Environment
+	capacity int
+	sem      chan struct{} // a token is held by each container
+
+	// run runs the container runtime with args and returns its
+	// standard output.
+	run func(ctx context.Context, args ...string) ([]byte, error)
+
+	mu     sync.Mutex
+	active map[string]time.Time // container name -> creation time
+}
+
+func newLocalContainerPool(opts LocalContainerOpts, run func(ctx context.Context, args ...string) ([]byte, error)) *localContainerPool {
+	p := &localContainerPool{
+		runtime:  opts.Runtime,
+		registry: opts.Registry,
+		env:      opts.BuildEnv,
+		capacity: opts.Capacity,
+		run:      run,
+		active:   make(map[string]time.Time),
+	}
+	if p.env == nil {
+		p.env = buildenv.Production
+	}
+	if p.registry == "" {
+		p.registry = "gcr.io/" + p.env.ProjectName
+	}
+	if p.capacity <= 0 {
+		p.capacity = runtime.NumCPU()
+	}
+	p.sem = make(chan struct{}, p.capacity)
+	return p
+}
+
+func (p *localContainerPool) GetBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	hconf, ok := dashboard.Hosts[hostType]
+	if !ok || !hconf.IsContainer() {
+		return nil, fmt.Errorf("local containers: invalid host type %q", hostType)
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		lg.LogEventTime("waiting_for_capacity", fmt.Sprintf("%d local containers running", p.capacity))
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() { <-p.sem }
+
+	name := instanceName(hostType, 7)
+	deleteAt := time.Now().Add(determineDeleteTimeout(hconf))
+	lg.LogEventTime("creating_container", name)
+	log.Printf("Creating local container %q for %s", name, hostType)
+	_, err := p.run(ctx, "run", "--detach",
+		"--name", name,
+		"--label", containerDeleteAtLabel+"="+strconv.FormatInt(deleteAt.Unix(), 10),
+		"--publish", "127.0.0.1::80",
+		"--env", "META_BUILDLET_BINARY_URL="+hconf.BuildletBinaryURL(p.env),
+		"--env", "META_BUILDLET_HOST_TYPE="+hostType,
+		strings.TrimRight(p.registry, "/")+"/"+strings.TrimLeft(hconf.ContainerImage, "/"),
+		"/usr/local/bin/stage0")
+	if err != nil {
+		lg.LogEventTime("container_create_failure", err.Error())
+		p.removeContainer(name)
+		release()
+		return nil, err
+	}
+	p.setActive(name, true)
+	lg.LogEventTime("container_created", "waiting_for_buildlet...")
+
+	bc, err := p.waitForBuildlet(ctx, name)
+	if err != nil {
+		lg.LogEventTime("container_buildlet_failure", err.Error())
+		p.removeContainer(name)
+		release()
+		return nil, err
+	}
+	bc.SetDescription("Local container: " + name)
+	bc.SetOnHeartbeatFailure(func() {
+		log.Printf("Deleting local container %q after its buildlet was closed", name)
+		p.removeContainer(name)
+		release()
+	})
+	return bc, nil
+}
+
+// waitForBuildlet waits for the buildlet in container name to come
+// up, and returns a client for it.
+func (p *localContainerPool) waitForBuildlet(ctx context.Context, name string) (buildlet.Client, error) {
+	out, err := p.run(ctx, "port", name, "80/tcp")
+	if err != nil {
+		return nil, err
+	}
+	// Newer runtimes list an address per IP family; take the first.
+	ipPort := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if ipPort == "" {
+		return nil, fmt.Errorf("local containers: no published port for %q", name)
+	}
+	bc := buildlet.NewClient(ipPort, buildlet.NoKeyPair)
+	if err := waitForBuildletUp(ctx, bc, 3*time.Minute); err != nil {
+		if logs, lerr := p.run(context.Background(), "logs", "--tail", "20", name); lerr == nil {
+			log.Printf("log from local container %q: %s", name, logs)
+		}
+		bc.Close()
+		return nil, fmt.Errorf("local container %q: %v", name, err)
+	}
+	return bc, nil
+}
+
+// waitForBuildletUp polls bc until its buildlet responds, ctx is done,
+// or timeout elapses.
+func waitForBuildletUp(ctx context.Context, bc buildlet.Client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		sctx, scancel := context.WithTimeout(ctx, 5*time.Second)
+		_, err := bc.Status(sctx)
+		scancel()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("buildlet didn't come up: %v", err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func (p *localContainerPool) setActive(name string, active bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if active {
+		p.active[name] = time.Now()
+	} else {
+		delete(p.active, name)
+	}
+}
+
+// removeContainer forcibly removes container name.
+func (p *localContainerPool) removeContainer(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := p.run(ctx, "rm", "--force", name); err != nil {
+		log.Printf("Error deleting local container %q: %v", name, err)
+	}
+	p.setActive(name, false)
+}
+
+// cleanUpContainersLoop periodically removes containers past their
+// delete-at time.
+func (p *localContainerPool) cleanUpContainersLoop() {
+	for {
+		time.Sleep(time.Minute)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		if err := p.cleanUpContainers(ctx, false); err != nil {
+			log.Printf("local containers: %v", err)
+		}
+		cancel()
+	}
+}
+
+// cleanUpContainers removes the pool's containers that are past
+// their delete-at time, or, if all is true, all those not in use by
+// this pool, such as those left over from an earlier run.
+func (p *localContainerPool) cleanUpContainers(ctx context.Context, all bool) error {
+	out, err := p.run(ctx, "ps", "--all",
+		"--filter", "label="+containerDeleteAtLabel,
+		"--format", `{{.Names}}	{{.Label "`+containerDeleteAtLabel+`"}}`)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) != 2 || !isBuildlet(f[0]) {
+			continue
+		}
+		name := f[0]
+		deleteAt, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		p.mu.Lock()
+		_, active := p.active[name]
+		p.mu.Unlock()
+		if (all && !active) || now.Unix() >= deleteAt {
+			log.Printf("Deleting local container %q: left over or past its delete-at time", name)
+			p.removeContainer(name)
+		}
+	}
+	return sc.Err()
+}
+
+func (p *localContainerPool) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("Local %s container pool: %d/%d running", p.runtime, len(p.active), p.capacity)
+}
+
+// WriteHTMLStatus writes the pool's status as HTML.
+func (p *localContainerPool) WriteHTMLStatus(w io.Writer) {
+	p.mu.Lock()
+	names := make([]string, 0, len(p.active))
+	created := make(map[string]time.Time, len(p.active))
+	for name, t := range p.active {
+		names = append(names, name)
+		created[name] = t
+	}
+	p.mu.Unlock()
+	sort.Strings(names)
+	fmt.Fprintf(w, "<b>Local %s containers</b>: %d/%d running", p.runtime, len(names), p.capacity)
+	if len(names) > 0 {
+		fmt.Fprintf(w, "<ul>")
+		for _, name := range names {
+			fmt.Fprintf(w, "<li>%v, %v</li>\n", name, friendlyDuration(time.Since(created[name])))
+		}
+		fmt.Fprintf(w, "</ul>")
+	}
+}
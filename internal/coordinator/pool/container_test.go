@@ -0,0 +1,131 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeContainerRuntime records the container runtime commands run by
+// a localContainerPool and answers them.
+type fakeContainerRuntime struct {
+	addr string // host:port published for each container
+	ps   string // output of "ps"
+
+	mu      sync.Mutex
+	removed []string
+	images  []string
+}
+
+func (f *fakeContainerRuntime) run(ctx context.Context, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch args[0] {
+	case "run":
+		f.images = append(f.images, args[len(args)-2])
+		return []byte("0123abcd\n"), nil
+	case "port":
+		return []byte(f.addr + "\n[::1]:1\n"), nil
+	case "rm":
+		f.removed = append(f.removed, args[len(args)-1])
+		return nil, nil
+	case "ps":
+		return []byte(f.ps), nil
+	case "logs":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected command %q", args)
+}
+
+func (f *fakeContainerRuntime) numRemoved() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.removed)
+}
+
+func TestLocalContainerPoolGetBuildlet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer ts.Close()
+
+	rt := &fakeContainerRuntime{addr: strings.TrimPrefix(ts.URL, "http://")}
+	p := newLocalContainerPool(LocalContainerOpts{Runtime: "docker", Registry: "gcr.io/test", Capacity: 1}, rt.run)
+
+	ctx := context.Background()
+	bc, err := p.GetBuildlet(ctx, "host-linux-bullseye", noopEventTimeLogger{})
+	if err != nil {
+		t.Fatalf("GetBuildlet = %v", err)
+	}
+	if want := "gcr.io/test/linux-x86-bullseye:latest"; len(rt.images) != 1 || rt.images[0] != want {
+		t.Errorf("images run = %q; want [%q]", rt.images, want)
+	}
+
+	// The pool is at capacity until the first buildlet is closed.
+	tctx, tcancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer tcancel()
+	if _, err := p.GetBuildlet(tctx, "host-linux-bullseye", noopEventTimeLogger{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetBuildlet at capacity = %v; want deadline exceeded", err)
+	}
+
+	bc.Close()
+	for deadline := time.Now().Add(5 * time.Second); rt.numRemoved() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("container not removed after its buildlet was closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := p.GetBuildlet(context.Background(), "host-linux-bullseye", noopEventTimeLogger{}); err != nil {
+		t.Fatalf("GetBuildlet after removal = %v", err)
+	}
+
+	if _, err := p.GetBuildlet(context.Background(), "host-linux-amd64-localdev", noopEventTimeLogger{}); err == nil {
+		t.Errorf("GetBuildlet for a reverse host type succeeded")
+	}
+}
+
+func TestLocalContainerPoolCleanUp(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+	rt := &fakeContainerRuntime{ps: fmt.Sprintf(""+
+		"buildlet-active\t%d\n"+
+		"buildlet-leftover\t%d\n"+
+		"buildlet-expired\t%d\n"+
+		"other-container\t%d\n", future, future, past, past)}
+	p := newLocalContainerPool(LocalContainerOpts{Runtime: "docker"}, rt.run)
+	p.setActive("buildlet-active", true)
+
+	if err := p.cleanUpContainers(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"buildlet-expired"}; fmt.Sprint(rt.removed) != fmt.Sprint(want) {
+		t.Errorf("periodic clean-up removed %q; want %q", rt.removed, want)
+	}
+
+	rt.removed = nil
+	if err := p.cleanUpContainers(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rt.removed)
+	if want := []string{"buildlet-expired", "buildlet-leftover"}; fmt.Sprint(rt.removed) != fmt.Sprint(want) {
+		t.Errorf("start-up clean-up removed %q; want %q", rt.removed, want)
+	}
+}
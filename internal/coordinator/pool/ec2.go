@@ -87,6 +87,8 @@ type EC2Buildlet struct {
 	cancelPoll context.CancelFunc
 	// pollWait waits for all pollers to terminate polling.
 	pollWait sync.WaitGroup
+	// warm is the pool's warm pool, if set by EnableWarmPools.
+	warm *warmPool
 }
 
 // ec2BuildletClient represents an EC2 buildlet client in the buildlet package.
@@ -153,6 +155,14 @@ func NewEC2Buildlet(client *cloud.AWSClient, buildEnv *buildenv.Environment, hos
 
 // GetBuildlet retrieves a buildlet client for a newly created buildlet.
 func (eb *EC2Buildlet) GetBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	if eb.warm != nil {
+		return eb.warm.GetBuildlet(ctx, hostType, lg)
+	}
+	return eb.createBuildlet(ctx, hostType, lg)
+}
+
+// createBuildlet creates an EC2 instance for a buildlet of hostType.
+func (eb *EC2Buildlet) createBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
 	hconf, ok := eb.hosts[hostType]
 	if !ok {
 		return nil, fmt.Errorf("ec2 pool: unknown host type %q", hostType)
@@ -182,10 +192,11 @@ func (eb *EC2Buildlet) GetBuildlet(ctx context.Context, hostType string, lg Logg
 		instanceCreated bool
 	)
 	bc, err := eb.buildletClient.StartNewVM(ctx, eb.buildEnv, hconf, instName, hostType, &buildlet.VMOpts{
-		Zone:     "", // allow the EC2 api pick an availability zone with capacity
-		TLS:      kp,
-		Meta:     make(map[string]string),
-		DeleteIn: determineDeleteTimeout(hconf),
+		Zone:      "", // allow the EC2 api pick an availability zone with capacity
+		TLS:       kp,
+		SessionCA: sessionCA,
+		Meta:      make(map[string]string),
+		DeleteIn:  determineDeleteTimeout(hconf),
 		OnInstanceRequested: func() {
 			log.Printf("EC2 VM %q now booting", instName)
 		},
@@ -232,11 +243,16 @@ func (eb *EC2Buildlet) capacityString() string {
 	return fmt.Sprintf("%d instances; %d/%d CPUs", r.InstCount, r.CPUUsed, r.CPULimit)
 }
 
+// Capacity returns the pool's capacity.
+func (eb *EC2Buildlet) Capacity() []Capacity {
+	return []Capacity{eb.ledger.capacity("EC2")}
+}
+
 // WriteHTMLStatus writes the status of the EC2 buildlet pool to an io.Writer.
 func (eb *EC2Buildlet) WriteHTMLStatus(w io.Writer) {
-	fmt.Fprintf(w, "<b>EC2 pool</b> capacity: %s", eb.capacityString())
-
 	active := eb.ledger.ResourceTime()
+	fmt.Fprintf(w, "<b>EC2 pool</b>: %d instances", len(active))
+
 	if len(active) > 0 {
 		fmt.Fprintf(w, "<ul>")
 		for _, inst := range active {
@@ -244,6 +260,9 @@ func (eb *EC2Buildlet) WriteHTMLStatus(w io.Writer) {
 		}
 		fmt.Fprintf(w, "</ul>")
 	}
+	if eb.warm != nil {
+		eb.warm.writeHTMLStatus(w)
+	}
 }
 
 // buildletDone issues a call to destroy the EC2 instance and removes
@@ -324,20 +324,17 @@ type GCEBuildlet struct {
 
 	disabled bool
 
-	// CPU quota usage & limits. pollQuota updates quotas periodically.
-	// The values recorded here reflect the updates as well as our own
-	// bookkeeping of instances as they are created and destroyed.
-	cpuLeft     int
-	instLeft    int
-	c2cpuLeft   int
-	n2cpuLeft   int
-	n2dcpuLeft  int
-	instUsage   int
-	cpuUsage    int
-	c2cpuUsage  int
-	n2cpuUsage  int
-	n2dcpuUsage int
-	inst        map[string]time.Time // GCE VM instance name -> creationTime
+	// cpuQuota maps the CPU quota metrics we use, such as
+	// "N2_CPUS", to a ledger of our VMs counted against them.
+	// pollQuota updates their limits periodically, leaving out
+	// usage by VMs the pool didn't create.
+	cpuQuota map[string]*ledger
+	// instLimit is how many VMs the pool may have in total, from
+	// the INSTANCES quota, likewise updated by pollQuota.
+	instLimit int64
+	inst      map[string]time.Time // GCE VM instance name -> creationTime
+
+	warm *warmPool // or nil; set by EnableWarmPools
 }
 
 func (p *GCEBuildlet) pollQuotaLoop() {
@@ -358,21 +355,10 @@ func (p *GCEBuildlet) pollQuota() {
 	defer p.mu.Unlock()
 	for _, quota := range reg.Quotas {
 		switch quota.Metric {
-		case "CPUS":
-			p.cpuLeft = int(quota.Limit) - int(quota.Usage)
-			p.cpuUsage = int(quota.Usage)
-		case "C2_CPUS":
-			p.c2cpuLeft = int(quota.Limit) - int(quota.Usage)
-			p.c2cpuUsage = int(quota.Usage)
-		case "N2_CPUS":
-			p.n2cpuLeft = int(quota.Limit) - int(quota.Usage)
-			p.n2cpuUsage = int(quota.Usage)
-		case "N2D_CPUS":
-			p.n2dcpuLeft = int(quota.Limit) - int(quota.Usage)
-			p.n2dcpuUsage = int(quota.Usage)
+		case "CPUS", "C2_CPUS", "N2_CPUS", "N2D_CPUS":
+			p.cpuQuotaLocked(quota.Metric).SetCPULimitFromQuota(int64(quota.Limit), int64(quota.Usage))
 		case "INSTANCES":
-			p.instLeft = int(quota.Limit) - int(quota.Usage)
-			p.instUsage = int(quota.Usage)
+			p.instLimit = int64(quota.Limit) - untracked(int64(quota.Usage), p.numQuotaInstancesLocked())
 		}
 	}
 }
@@ -385,20 +371,28 @@ func (p *GCEBuildlet) SetEnabled(enabled bool) {
 }
 
 // GetBuildlet retrieves a buildlet client for an available buildlet.
-func (p *GCEBuildlet) GetBuildlet(ctx context.Context, hostType string, lg Logger) (bc buildlet.Client, err error) {
+func (p *GCEBuildlet) GetBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	if p.warm != nil {
+		return p.warm.GetBuildlet(ctx, hostType, lg)
+	}
+	return p.createBuildlet(ctx, hostType, lg)
+}
+
+// createBuildlet creates a VM for a buildlet of hostType.
+func (p *GCEBuildlet) createBuildlet(ctx context.Context, hostType string, lg Logger) (bc buildlet.Client, err error) {
 	hconf, ok := dashboard.Hosts[hostType]
 	if !ok {
 		return nil, fmt.Errorf("gcepool: unknown host type %q", hostType)
 	}
+	instName := instanceName(hostType, 7)
+	instName = strings.Replace(instName, "_", "-", -1) // Issue 22905; can't use underscores in GCE VMs
+
 	qsp := lg.CreateSpan("awaiting_gce_quota")
-	err = p.awaitVMCountQuota(ctx, hconf)
+	err = p.awaitVMCountQuota(ctx, instName, hconf)
 	qsp.Done(err)
 	if err != nil {
 		return nil, err
 	}
-
-	instName := instanceName(hostType, 7)
-	instName = strings.Replace(instName, "_", "-", -1) // Issue 22905; can't use underscores in GCE VMs
 	p.setInstanceUsed(instName, true)
 
 	gceBuildletSpan := lg.CreateSpan("create_gce_buildlet", instName)
@@ -413,9 +407,23 @@ func (p *GCEBuildlet) GetBuildlet(ctx context.Context, hostType string, lg Logge
 
 	zone := buildEnv.RandomVMZone()
 
+	// Mutual TLS needs the VM to serve TLS, which StartNewVM then
+	// reaches on its external IP. Otherwise it's plain HTTP on the
+	// internal network.
+	var kp buildlet.KeyPair
+	if sessionCA != nil {
+		if kp, err = buildlet.NewKeyPair(); err != nil {
+			p.putVMCountQuota(instName, hconf)
+			p.setInstanceUsed(instName, false)
+			return nil, fmt.Errorf("failed to create TLS key pair: %w", err)
+		}
+	}
+
 	log.Printf("Creating GCE VM %q for %s at %s", instName, hostType, zone)
 	bc, err = buildlet.StartNewVM(gcpCreds, buildEnv, instName, hostType, buildlet.VMOpts{
-		DeleteIn: determineDeleteTimeout(hconf),
+		TLS:       kp,
+		SessionCA: sessionCA,
+		DeleteIn:  determineDeleteTimeout(hconf),
 		OnInstanceRequested: func() {
 			log.Printf("GCE VM %q now booting", instName)
 		},
@@ -436,8 +444,8 @@ func (p *GCEBuildlet) GetBuildlet(ctx context.Context, hostType string, lg Logge
 		log.Printf("Failed to create VM for %s at %s: %v", hostType, zone, err)
 		if needDelete {
 			deleteVM(zone, instName)
-			p.putVMCountQuota(hconf)
 		}
+		p.putVMCountQuota(instName, hconf)
 		p.setInstanceUsed(instName, false)
 		return nil, err
 	}
@@ -467,13 +475,13 @@ func (p *GCEBuildlet) putBuildlet(bc buildlet.Client, hostType, zone, instName s
 	if !ok {
 		panic("failed to lookup conf") // should've worked if we did it before
 	}
-	p.putVMCountQuota(hconf)
+	p.putVMCountQuota(instName, hconf)
 	return nil
 }
 
 // WriteHTMLStatus writes the status of the buildlet pool to an io.Writer.
 func (p *GCEBuildlet) WriteHTMLStatus(w io.Writer) {
-	fmt.Fprintf(w, "<b>GCE pool</b> capacity: %s", p.capacityString())
+	fmt.Fprintf(w, "<b>GCE pool</b>: %d instances", len(p.instancesActive()))
 	const show = 6 // must be even
 	active := p.instancesActive()
 	if len(active) > 0 {
@@ -487,6 +495,9 @@ func (p *GCEBuildlet) WriteHTMLStatus(w io.Writer) {
 		}
 		fmt.Fprintf(w, "</ul>")
 	}
+	if p.warm != nil {
+		p.warm.writeHTMLStatus(w)
+	}
 }
 
 func (p *GCEBuildlet) String() string {
@@ -494,23 +505,46 @@ func (p *GCEBuildlet) String() string {
 }
 
 func (p *GCEBuildlet) capacityString() string {
+	var buf strings.Builder
+	for i, c := range p.Capacity() {
+		if i == 0 {
+			fmt.Fprintf(&buf, "%s instances", usedOf(c.Instances, c.InstanceLimit, ""))
+			continue
+		}
+		fmt.Fprintf(&buf, ", %s %s", usedOf(c.CPUs, c.CPULimit, ""), strings.TrimPrefix(c.Name, "GCE "))
+	}
+	return buf.String()
+}
+
+// gceCPUQuotas are the CPU quota metrics used by the pool's VMs.
+var gceCPUQuotas = []string{"CPUS", "C2_CPUS", "N2_CPUS", "N2D_CPUS"}
+
+// Capacity returns the pool's capacity: its VMs and INSTANCES quota,
+// followed by each of its CPU quotas.
+func (p *GCEBuildlet) Capacity() []Capacity {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return fmt.Sprintf("%d/%d instances; %d/%d CPUs, %d/%d C2_CPUS, %d/%d N2_CPUS, %d/%d N2D_CPUS",
-		len(p.inst), p.instUsage+p.instLeft,
-		p.cpuUsage, p.cpuUsage+p.cpuLeft,
-		p.c2cpuUsage, p.c2cpuUsage+p.c2cpuLeft,
-		p.n2cpuUsage, p.n2cpuUsage+p.n2cpuLeft,
-		p.n2dcpuUsage, p.n2dcpuUsage+p.n2dcpuLeft)
+	caps := []Capacity{{
+		Name:          "GCE",
+		Instances:     p.numQuotaInstancesLocked(),
+		InstanceLimit: p.instLimit,
+	}}
+	if p.instLimit == 0 {
+		caps[0].InstanceLimit = -1 // none, until pollQuota sets it
+	}
+	for _, metric := range gceCPUQuotas {
+		caps = append(caps, p.cpuQuotaLocked(metric).capacity("GCE "+metric))
+	}
+	return caps
 }
 
-// awaitVMCountQuota waits for numCPU CPUs of quota to become available,
-// or returns ctx.Err.
-func (p *GCEBuildlet) awaitVMCountQuota(ctx context.Context, hconf *dashboard.HostConfig) error {
+// awaitVMCountQuota waits for quota for VM instName of hconf to become
+// available and allocates it, or returns ctx.Err.
+func (p *GCEBuildlet) awaitVMCountQuota(ctx context.Context, instName string, hconf *dashboard.HostConfig) error {
 	// Poll every 2 seconds, which could be better, but works and
 	// is simple.
 	for {
-		if p.tryAllocateQuota(hconf) {
+		if p.tryAllocateQuota(instName, hconf) {
 			return nil
 		}
 		select {
@@ -521,78 +555,88 @@ func (p *GCEBuildlet) awaitVMCountQuota(ctx context.Context, hconf *dashboard.Ho
 	}
 }
 
-func (p *GCEBuildlet) tryAllocateQuota(hconf *dashboard.HostConfig) bool {
+// tryAllocateQuota allocates quota for VM instName of hconf, if
+// there's enough left, and reports whether it did.
+func (p *GCEBuildlet) tryAllocateQuota(instName string, hconf *dashboard.HostConfig) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.disabled {
+	if !p.hasQuotaLocked(hconf) {
 		return false
 	}
-	if p.instLeft < 1 {
+	mt := hconf.MachineType()
+	return p.cpuQuotaLocked(gceCPUQuotaMetric(mt)).allocateResources(int64(hconf.GCENumCPU()), instName, mt)
+}
+
+// hasQuota reports whether there's quota left for a VM of hconf,
+// without allocating it.
+func (p *GCEBuildlet) hasQuota(hconf *dashboard.HostConfig) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hasQuotaLocked(hconf)
+}
+
+// hasQuotaLocked is like hasQuota.
+//
+// It requires that p.mu be held.
+func (p *GCEBuildlet) hasQuotaLocked(hconf *dashboard.HostConfig) bool {
+	if p.disabled || p.numQuotaInstancesLocked() >= p.instLimit {
 		return false
 	}
-	numCPU := hconf.GCENumCPU()
 	mt := hconf.MachineType()
-	if strings.HasPrefix(mt, "n2-") {
-		if p.n2cpuLeft < numCPU {
-			return false
-		}
-		p.n2cpuUsage += numCPU
-		p.n2cpuLeft -= numCPU
-		p.instLeft--
-	} else if strings.HasPrefix(mt, "n2d-") {
-		if p.n2dcpuLeft < numCPU {
-			return false
-		}
-		p.n2dcpuUsage += numCPU
-		p.n2dcpuLeft -= numCPU
-		p.instLeft--
-	} else if strings.HasPrefix(mt, "c2-") {
-		if p.c2cpuLeft < numCPU {
-			return false
-		}
-		p.c2cpuUsage += numCPU
-		p.c2cpuLeft -= numCPU
-		p.instLeft--
-	} else {
-		// E2 and N1 instances are counted here. We do not use M1, M2,
-		// or A2 quotas. See
-		// https://cloud.google.com/compute/quotas#cpu_quota.
-		if p.cpuLeft < numCPU {
-			return false
+	return p.cpuQuotaLocked(gceCPUQuotaMetric(mt)).canAllocate(int64(hconf.GCENumCPU()), 0, mt)
+}
+
+// gceCPUQuotaMetric returns the CPU quota metric that counts VMs of
+// machine type mt.
+func gceCPUQuotaMetric(mt string) string {
+	switch {
+	case strings.HasPrefix(mt, "n2-"):
+		return "N2_CPUS"
+	case strings.HasPrefix(mt, "n2d-"):
+		return "N2D_CPUS"
+	case strings.HasPrefix(mt, "c2-"):
+		return "C2_CPUS"
+	}
+	// E2 and N1 instances are counted here. We do not use M1, M2,
+	// or A2 quotas. See
+	// https://cloud.google.com/compute/quotas#cpu_quota.
+	return "CPUS"
+}
+
+// cpuQuotaLocked returns the ledger of the CPU quota metric.
+//
+// It requires that p.mu be held.
+func (p *GCEBuildlet) cpuQuotaLocked(metric string) *ledger {
+	l, ok := p.cpuQuota[metric]
+	if !ok {
+		if p.cpuQuota == nil {
+			p.cpuQuota = make(map[string]*ledger)
 		}
-		p.cpuUsage += numCPU
-		p.cpuLeft -= numCPU
-		p.instLeft--
+		l = newLedger()
+		p.cpuQuota[metric] = l
 	}
-	return true
+	return l
 }
 
-// putVMCountQuota adjusts the dead-reckoning of our quota usage by
-// one instance and cpu CPUs.
-func (p *GCEBuildlet) putVMCountQuota(hconf *dashboard.HostConfig) {
+// numQuotaInstancesLocked returns the number of VMs that have quota
+// allocated.
+//
+// It requires that p.mu be held.
+func (p *GCEBuildlet) numQuotaInstancesLocked() int64 {
+	var n int64
+	for _, l := range p.cpuQuota {
+		n += l.Resources().InstCount
+	}
+	return n
+}
+
+// putVMCountQuota releases the quota allocated for VM instName of
+// hconf.
+func (p *GCEBuildlet) putVMCountQuota(instName string, hconf *dashboard.HostConfig) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	mt := hconf.MachineType()
-	numCPU := hconf.GCENumCPU()
-	if strings.HasPrefix(mt, "n2-") {
-		p.n2cpuUsage -= numCPU
-		p.n2cpuLeft += numCPU
-		p.instLeft++
-	} else if strings.HasPrefix(mt, "n2d-") {
-		p.n2dcpuUsage -= numCPU
-		p.n2dcpuLeft += numCPU
-		p.instLeft++
-	} else if strings.HasPrefix(mt, "c2-") {
-		p.c2cpuUsage -= numCPU
-		p.c2cpuLeft += numCPU
-		p.instLeft++
-	} else {
-		// E2 and N1 instances are counted here. We do not use M1, M2,
-		// or A2 quotas. See
-		// https://cloud.google.com/compute/quotas#cpu_quota.
-		p.cpuUsage -= numCPU
-		p.cpuLeft += numCPU
-		p.instLeft++
+	if err := p.cpuQuotaLocked(gceCPUQuotaMetric(hconf.MachineType())).Remove(instName); err != nil {
+		log.Printf("gcepool: %v", err)
 	}
 }
 
@@ -118,6 +118,12 @@ type kubeBuildletPool struct {
 	clusterResources *kubeResource         // cpu and memory resources of the Kubernetes cluster
 	pendingResources *kubeResource         // cpu and memory resources waiting to be scheduled
 	runningResources *kubeResource         // cpu and memory resources already running (periodically updated from API)
+
+	// ledger records the resources requested by the pool's pods,
+	// with the cluster's resources as its limits.
+	ledger *ledger
+
+	warm *warmPool // or nil; set by EnableWarmPools
 }
 
 var kubePool = &kubeBuildletPool{
@@ -133,6 +139,7 @@ var kubePool = &kubeBuildletPool{
 		cpu:    api.NewQuantity(0, api.DecimalSI),
 		memory: api.NewQuantity(0, api.BinarySI),
 	},
+	ledger: newLedger(),
 }
 
 type kubeResource struct {
@@ -232,11 +239,21 @@ func (p *kubeBuildletPool) pollCapacity(ctx context.Context) {
 		provisioned.memory.Add(n.Status.Capacity[api.ResourceMemory])
 	}
 	p.clusterResources = provisioned
+	p.ledger.SetCPULimit(provisioned.cpu.Value())
+	p.ledger.SetMemoryLimit(provisioned.memory.Value() >> 20)
 	p.mu.Unlock()
 
 }
 
 func (p *kubeBuildletPool) GetBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	if p.warm != nil {
+		return p.warm.GetBuildlet(ctx, hostType, lg)
+	}
+	return p.createBuildlet(ctx, hostType, lg)
+}
+
+// createBuildlet creates a pod for a buildlet of hostType.
+func (p *kubeBuildletPool) createBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
 	hconf, ok := dashboard.Hosts[hostType]
 	if !ok || !hconf.IsContainer() {
 		return nil, fmt.Errorf("kubepool: invalid host type %q", hostType)
@@ -265,6 +282,10 @@ func (p *kubeBuildletPool) GetBuildlet(ctx context.Context, hostType string, lg
 		OnPodCreating: func() {
 			lg.LogEventTime("pod_creating")
 			p.setPodUsed(podName, true)
+			// Kubernetes queues pods until the cluster has room
+			// for them, so record their resources regardless of
+			// the limits.
+			p.ledger.recordResources(kubePodCPUs(), buildlet.BuildletMemory.Value()>>20, podName, hostType)
 			p.updatePodHistory(podName, podHistory{requestedAt: time.Now()})
 			needDelete = true
 		},
@@ -306,9 +327,9 @@ func (p *kubeBuildletPool) GetBuildlet(ctx context.Context, hostType string, lg
 }
 
 func (p *kubeBuildletPool) WriteHTMLStatus(w io.Writer) {
-	fmt.Fprintf(w, "<b>Kubernetes pool</b> capacity: %s", p.capacityString())
 	const show = 6 // must be even
 	active := p.podsActive()
+	fmt.Fprintf(w, "<b>Kubernetes pool</b>: %d pods; cluster: %s", len(active), p.capacityString())
 	if len(active) > 0 {
 		fmt.Fprintf(w, "<ul>")
 		for i, pod := range active {
@@ -320,6 +341,20 @@ func (p *kubeBuildletPool) WriteHTMLStatus(w io.Writer) {
 		}
 		fmt.Fprintf(w, "</ul>")
 	}
+	if p.warm != nil {
+		p.warm.writeHTMLStatus(w)
+	}
+}
+
+// hasCapacity reports whether the cluster has no pods waiting for
+// resources, so a new pod for hostType would likely start promptly.
+func (p *kubeBuildletPool) hasCapacity(hostType string) bool {
+	if kubeErr != nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pendingResources.cpu.MilliValue() == 0
 }
 
 func (p *kubeBuildletPool) capacityString() string {
@@ -343,9 +378,22 @@ func (p *kubeBuildletPool) setPodUsed(podName string, used bool) {
 		p.pods[podName] = podHistory{deletedAt: time.Now()}
 		// TODO(evanbrown): log this podHistory data for analytics purposes before deleting
 		delete(p.pods, podName)
+		p.ledger.Remove(podName) // not in the ledger if its creation failed early
 	}
 }
 
+// kubePodCPUs returns the CPUs requested by a buildlet pod, rounded up
+// to a whole number as the ledger counts them.
+func kubePodCPUs() int64 {
+	return (buildlet.BuildletCPU.MilliValue() + 999) / 1000
+}
+
+// Capacity returns the pool's capacity: the resources requested by its
+// pods, out of those of the whole cluster.
+func (p *kubeBuildletPool) Capacity() []Capacity {
+	return []Capacity{p.ledger.capacity("Kubernetes")}
+}
+
 func (p *kubeBuildletPool) updatePodHistory(podName string, updatedHistory podHistory) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -26,17 +26,29 @@ type entry struct {
 	instanceName string
 	instanceType string
 	vCPUCount    int64
+	memoryMiB    int64
 }
 
 // ledger contains a record of the instances and their resource
 // consumption. Before an instance is created, a call to the ledger
 // will ensure that there are available resources for the new instance.
+// It is shared by the cloud buildlet pools: the EC2 pool keeps one,
+// the GCE pool one per CPU quota and the Kubernetes pool one for its
+// cluster.
 type ledger struct {
 	mu sync.RWMutex
 	// cpuLimit is the limit of how many on-demand vCPUs can be created on EC2.
 	cpuLimit int64
 	// cpuUsed is the current count of vCPUs reserved for on-demand instances.
 	cpuUsed int64
+	// memoryLimitMiB is the limit of how much memory, in MiB, can be
+	// reserved. Zero means there is no limit.
+	memoryLimitMiB int64
+	// memoryUsedMiB is the current amount of memory, in MiB, reserved for instances.
+	memoryUsedMiB int64
+	// instanceLimit is the limit of how many instances can be created.
+	// Zero means there is no limit, and a negative limit that none can be.
+	instanceLimit int64
 	// entries contains a mapping of instance name to entries for each instance
 	// that has resources allocated to it.
 	entries map[string]*entry
@@ -101,6 +113,43 @@ func (l *ledger) PrepareReservationRequest(instName, vmType string) (*cloud.Inst
 
 const a1MetalInstance = "a1.metal" // added for golang.org/issue/42604
 
+// canReserve reports whether there are resources left to reserve for
+// an instance of vmType, without reserving them.
+func (l *ledger) canReserve(vmType string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	instType, ok := l.types[vmType]
+	if !ok {
+		return false
+	}
+	return l.fitsLocked(instType.CPU, 0, vmType)
+}
+
+// canAllocate reports whether there are resources left to allocate
+// numCPU vCPUs and memoryMiB MiB of memory to a new instance of type
+// instType, without allocating them.
+func (l *ledger) canAllocate(numCPU, memoryMiB int64, instType string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.fitsLocked(numCPU, memoryMiB, instType)
+}
+
+// fitsLocked reports whether a new instance of type instType using
+// numCPU vCPUs and memoryMiB MiB of memory fits within the limits.
+// Lock l.mu must be held by the caller.
+func (l *ledger) fitsLocked(numCPU, memoryMiB int64, instType string) bool {
+	if instType == a1MetalInstance && l.instanceA1Used >= l.instanceA1Limit {
+		return false
+	}
+	if l.instanceLimit != 0 && int64(len(l.entries)) >= l.instanceLimit {
+		return false
+	}
+	if l.memoryLimitMiB > 0 && memoryMiB+l.memoryUsedMiB > l.memoryLimitMiB {
+		return false
+	}
+	return numCPU+l.cpuUsed <= l.cpuLimit
+}
+
 // releaseResources deletes the entry associated with an instance. The resources associated with the
 // instance will also be released. An error is returned if the instance entry is not found.
 // Lock l.mu must be held by the caller.
@@ -113,6 +162,7 @@ func (l *ledger) releaseResources(instName string) error {
 		l.instanceA1Used--
 	}
 	l.deallocateCPU(e.vCPUCount)
+	l.memoryUsedMiB -= e.memoryMiB
 	return nil
 }
 
@@ -123,37 +173,58 @@ func (l *ledger) releaseResources(instName string) error {
 // an entry will be added in the entries map for the instance.
 // It also enforces instance type limits.
 func (l *ledger) allocateResources(numCPU int64, instName, instType string) bool {
+	return l.allocateCPUAndMemory(numCPU, 0, instName, instType)
+}
+
+// allocateCPUAndMemory is like allocateResources, but also allocates
+// memoryMiB MiB of memory, within the memory limit.
+func (l *ledger) allocateCPUAndMemory(numCPU, memoryMiB int64, instName, instType string) bool {
 	// should never happen
 	if numCPU <= 0 {
 		log.Printf("invalid allocation requested: %d", numCPU)
 		return false
 	}
-	isA1Metal := instType == a1MetalInstance
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if isA1Metal && l.instanceA1Used >= l.instanceA1Limit {
-		return false
-	}
-	if numCPU+l.cpuUsed > l.cpuLimit {
+	if !l.fitsLocked(numCPU, memoryMiB, instType) {
 		return false
 	}
+	l.addLocked(numCPU, memoryMiB, instName, instType)
+	return true
+}
+
+// recordResources records that numCPU vCPUs and memoryMiB MiB of memory
+// are used by instance instName, whether or not they're within the
+// limits. It's for pools whose platform queues instances until there
+// are resources for them, as Kubernetes does.
+func (l *ledger) recordResources(numCPU, memoryMiB int64, instName, instType string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addLocked(numCPU, memoryMiB, instName, instType)
+}
+
+// addLocked adds the resources of an instance to the ledger.
+// Lock l.mu must be held by the caller.
+func (l *ledger) addLocked(numCPU, memoryMiB int64, instName, instType string) {
 	l.cpuUsed += numCPU
-	if isA1Metal {
+	l.memoryUsedMiB += memoryMiB
+	if instType == a1MetalInstance {
 		l.instanceA1Used++
 	}
 	e, ok := l.entries[instName]
 	if ok {
 		e.vCPUCount = numCPU
+		e.memoryMiB = memoryMiB
 	} else {
 		l.entries[instName] = &entry{
 			instanceName: instName,
 			vCPUCount:    numCPU,
+			memoryMiB:    memoryMiB,
 			instanceType: instType,
 		}
 	}
-	return true
 }
 
 // deallocateCPU releases the CPU allocated to an instance associated with an entry. When an instance
@@ -224,6 +295,45 @@ func (l *ledger) SetCPULimit(numCPU int64) {
 	l.cpuLimit = numCPU
 }
 
+// SetMemoryLimit sets the limit, in MiB, of memory that can be
+// reserved. Zero means there is no limit.
+func (l *ledger) SetMemoryLimit(mib int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.memoryLimitMiB = mib
+}
+
+// SetInstanceLimit sets the limit of how many instances can be created.
+// Zero means there is no limit, and a negative limit that none can be.
+func (l *ledger) SetInstanceLimit(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.instanceLimit = n
+}
+
+// SetCPULimitFromQuota sets the vCPU limit from a cloud CPU quota's
+// limit and usage, where the usage includes the ledger's instances as
+// well as others the ledger doesn't track, which reduce the vCPUs
+// available to the ledger.
+func (l *ledger) SetCPULimitFromQuota(limit, usage int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cpuLimit = limit - untracked(usage, l.cpuUsed)
+}
+
+// untracked returns how much of a quota's usage, of which a ledger
+// tracks tracked, the ledger doesn't track. It's never negative, since
+// the quota usage may not yet reflect recently reserved instances.
+func untracked(usage, tracked int64) int64 {
+	if usage < tracked {
+		return 0
+	}
+	return usage - tracked
+}
+
 // UpdateInstanceTypes updates the map of instance types used to map instance
 // type to the resources required for the instance.
 func (l *ledger) UpdateInstanceTypes(types []*cloud.InstanceType) {
@@ -239,10 +349,18 @@ func (l *ledger) UpdateInstanceTypes(types []*cloud.InstanceType) {
 type resources struct {
 	// InstCount is the count of how many on-demand instances are tracked in the ledger.
 	InstCount int64
+	// InstLimit is the limit of how many instances can be created, or
+	// zero if there is no limit, or negative if none can be.
+	InstLimit int64
 	// CPUUsed is a count of the vCPU's for on-demand instances are currently allocated in the ledger.
 	CPUUsed int64
 	// CPULimit is the limit of how many vCPU's for on-demand instances can be allocated.
 	CPULimit int64
+	// MemoryUsedMiB is the memory, in MiB, allocated in the ledger.
+	MemoryUsedMiB int64
+	// MemoryLimitMiB is the limit of memory, in MiB, that can be
+	// allocated, or zero if there is no limit.
+	MemoryLimitMiB int64
 }
 
 // Resources retrives the resource usage and limits for instances in the
@@ -252,9 +370,27 @@ func (l *ledger) Resources() *resources {
 	defer l.mu.RUnlock()
 
 	return &resources{
-		InstCount: int64(len(l.entries)),
-		CPUUsed:   l.cpuUsed,
-		CPULimit:  l.cpuLimit,
+		InstCount:      int64(len(l.entries)),
+		InstLimit:      l.instanceLimit,
+		CPUUsed:        l.cpuUsed,
+		CPULimit:       l.cpuLimit,
+		MemoryUsedMiB:  l.memoryUsedMiB,
+		MemoryLimitMiB: l.memoryLimitMiB,
+	}
+}
+
+// capacity returns the ledger's usage and limits as the capacity
+// named name.
+func (l *ledger) capacity(name string) Capacity {
+	r := l.Resources()
+	return Capacity{
+		Name:           name,
+		Instances:      r.InstCount,
+		InstanceLimit:  r.InstLimit,
+		CPUs:           r.CPUUsed,
+		CPULimit:       r.CPULimit,
+		MemoryMiB:      r.MemoryUsedMiB,
+		MemoryLimitMiB: r.MemoryLimitMiB,
 	}
 }
 
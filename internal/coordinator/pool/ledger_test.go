@@ -635,3 +635,53 @@ func TestLedgerResourceTime(t *testing.T) {
 		})
 	}
 }
+
+func TestLedgerMemoryAndInstanceLimits(t *testing.T) {
+	l := newLedger()
+	l.SetCPULimit(10)
+	l.SetMemoryLimit(8 << 10)
+	l.SetInstanceLimit(2)
+
+	if !l.allocateCPUAndMemory(2, 4<<10, "a", "t") {
+		t.Fatal("allocateCPUAndMemory(a) = false; want true")
+	}
+	if l.canAllocate(2, 5<<10, "t") {
+		t.Error("canAllocate over the memory limit = true; want false")
+	}
+	if !l.allocateCPUAndMemory(2, 4<<10, "b", "t") {
+		t.Fatal("allocateCPUAndMemory(b) = false; want true")
+	}
+	if l.canAllocate(1, 0, "t") {
+		t.Error("canAllocate over the instance limit = true; want false")
+	}
+	if err := l.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.capacity("test"), (Capacity{Name: "test", Instances: 1, InstanceLimit: 2, CPUs: 2, CPULimit: 10, MemoryMiB: 4 << 10, MemoryLimitMiB: 8 << 10}); got != want {
+		t.Errorf("capacity = %+v; want %+v", got, want)
+	}
+
+	// Recorded resources may exceed the limits.
+	l.recordResources(20, 20<<10, "c", "t")
+	if r := l.Resources(); r.CPUUsed != 22 || r.MemoryUsedMiB != 24<<10 {
+		t.Errorf("after recordResources, CPUUsed, MemoryUsedMiB = %d, %d; want 22, %d", r.CPUUsed, r.MemoryUsedMiB, 24<<10)
+	}
+}
+
+func TestLedgerSetCPULimitFromQuota(t *testing.T) {
+	l := newLedger()
+	l.SetCPULimit(100)
+	if !l.allocateResources(8, "a", "t") {
+		t.Fatal("allocateResources = false; want true")
+	}
+	// The quota is used by the ledger's 8 CPUs and 24 others.
+	l.SetCPULimitFromQuota(100, 32)
+	if got, want := l.Resources().CPULimit, int64(76); got != want {
+		t.Errorf("CPULimit = %d; want %d", got, want)
+	}
+	// The quota doesn't show the ledger's instance yet.
+	l.SetCPULimitFromQuota(100, 4)
+	if got, want := l.Resources().CPULimit, int64(100); got != want {
+		t.Errorf("CPULimit = %d; want %d", got, want)
+	}
+}
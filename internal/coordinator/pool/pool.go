@@ -41,6 +41,16 @@ type Buildlet interface {
 // functions are moved into a package.
 type IsRemoteBuildletFunc func(instanceName string) bool
 
+// sessionCA, if non-nil, is the CA whose short-lived client certificates
+// GCE and EC2 buildlets require instead of a password.
+var sessionCA *buildlet.SessionCA
+
+// SetSessionCA puts the GCE and EC2 buildlets created from now on in
+// mutual TLS mode, authenticating with certificates issued by ca.
+func SetSessionCA(ca *buildlet.SessionCA) {
+	sessionCA = ca
+}
+
 // randHex generates a random hex string.
 func randHex(n int) string {
 	buf := make([]byte, n/2+1)
@@ -128,6 +138,9 @@ func ForHost(conf *dashboard.HostConfig) Buildlet {
 	case conf.IsVM():
 		return NewGCEConfiguration().BuildletPool()
 	case conf.IsContainer():
+		if localContainers != nil {
+			return localContainers
+		}
 		if NewGCEConfiguration().BuildEnv().PreferContainersOnCOS || KubeErr() != nil {
 			return NewGCEConfiguration().BuildletPool() // it also knows how to do containers.
 		} else {
@@ -0,0 +1,271 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/build/buildlet"
+	"golang.org/x/build/dashboard"
+)
+
+/*
+This file implements a buildlet pool that runs each buildlet as a
+cmd/buildlet process on the local machine, with its own temporary
+work directory. It needs no cloud access, so tests can use it to run
+the coordinator's build and gomote code against real buildlets.
+*/
+
+// LocalProcessOpts configures a LocalProcessPool.
+type LocalProcessOpts struct {
+	// Buildlet is the path to a cmd/buildlet binary for the local
+	// machine, such as one made by BuildLocalBuildlet.
+	Buildlet string
+
+	// Capacity is the most buildlets that may run at once. If
+	// zero, it's the number of CPUs.
+	Capacity int
+
+	// Env is extra environment for the buildlet processes, and so
+	// for the commands they run, in the form "key=value".
+	Env []string
+}
+
+// LocalProcessPool is a buildlet pool that starts a cmd/buildlet
+// process on localhost for each buildlet. Whatever the host type,
+// the buildlets run on the local machine's operating system and
+// architecture.
+type LocalProcessPool struct {
+	opts LocalProcessOpts
+	sem  chan struct{} // a token is held by each process
+
+	mu    sync.Mutex
+	procs map[string]*localProcess // by buildlet name
+}
+
+// localProcess is a buildlet process started by a LocalProcessPool.
+type localProcess struct {
+	cmd     *exec.Cmd
+	dir     string        // holds the work directory, blob directory and log
+	exited  chan struct{} // closed when cmd exits
+	created time.Time
+}
+
+// NewLocalProcessPool returns a pool that runs buildlets as local
+// processes, as configured by opts.
+func NewLocalProcessPool(opts LocalProcessOpts) *LocalProcessPool {
+	if opts.Capacity <= 0 {
+		opts.Capacity = runtime.NumCPU()
+	}
+	return &LocalProcessPool{
+		opts:  opts,
+		sem:   make(chan struct{}, opts.Capacity),
+		procs: make(map[string]*localProcess),
+	}
+}
+
+// BuildLocalBuildlet builds cmd/buildlet for the local machine into
+// dir, and returns the path to the binary. It uses the go command in
+// $PATH, and must be run within the golang.org/x/build module, as its
+// tests are.
+func BuildLocalBuildlet(ctx context.Context, dir string) (string, error) {
+	bin := filepath.Join(dir, "buildlet")
+	out, err := exec.CommandContext(ctx, "go", "build", "-o", bin, "golang.org/x/build/cmd/buildlet").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("building cmd/buildlet: %v\n%s", err, out)
+	}
+	return bin, nil
+}
+
+// GetBuildlet starts a buildlet process and returns a client for it.
+// The process is stopped and its files deleted when the client is
+// closed.
+func (p *LocalProcessPool) GetBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	if _, ok := dashboard.Hosts[hostType]; !ok {
+		return nil, fmt.Errorf("local processes: unknown host type %q", hostType)
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+	default:
+		lg.LogEventTime("waiting_for_capacity", fmt.Sprintf("%d local buildlets running", p.opts.Capacity))
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	name := instanceName(hostType, 7)
+	lp, addr, err := p.start(name)
+	if err != nil {
+		lg.LogEventTime("process_start_failure", err.Error())
+		<-p.sem
+		return nil, err
+	}
+	lg.LogEventTime("process_started", "waiting_for_buildlet...")
+
+	// Give up early if the process dies.
+	wctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-lp.exited:
+			cancel()
+		case <-wctx.Done():
+		}
+	}()
+	bc := buildlet.NewClient(addr, buildlet.NoKeyPair)
+	err = waitForBuildletUp(wctx, bc, time.Minute)
+	cancel()
+	if err != nil {
+		lg.LogEventTime("process_buildlet_failure", err.Error())
+		if logs, lerr := ioutil.ReadFile(filepath.Join(lp.dir, "buildlet.log")); lerr == nil {
+			log.Printf("log from local buildlet %q: %s", name, logs)
+		}
+		p.stop(name)
+		return nil, fmt.Errorf("local buildlet %q: %v", name, err)
+	}
+	bc.SetDescription("Local process: " + name)
+	bc.SetOnHeartbeatFailure(func() {
+		p.stop(name)
+	})
+	return bc, nil
+}
+
+// start starts a buildlet process named name, listening on a free
+// localhost port, and returns it and its address.
+func (p *LocalProcessPool) start(name string) (*localProcess, string, error) {
+	addr, err := freeLocalAddr()
+	if err != nil {
+		return nil, "", err
+	}
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		return nil, "", err
+	}
+	workDir := filepath.Join(dir, "work")
+	if err := os.Mkdir(workDir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	logFile, err := os.Create(filepath.Join(dir, "buildlet.log"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(p.opts.Buildlet,
+		"--listen="+addr,
+		"--workdir="+workDir,
+		"--blobdir="+filepath.Join(dir, "blobs"),
+		"--halt=false")
+	cmd.Env = append(os.Environ(), p.opts.Env...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, "", err
+	}
+	lp := &localProcess{
+		cmd:     cmd,
+		dir:     dir,
+		exited:  make(chan struct{}),
+		created: time.Now(),
+	}
+	go func() {
+		cmd.Wait()
+		close(lp.exited)
+	}()
+	p.mu.Lock()
+	p.procs[name] = lp
+	p.mu.Unlock()
+	log.Printf("Started local buildlet %q on %s", name, addr)
+	return lp, addr, nil
+}
+
+// stop kills the buildlet process name, if it's still running,
+// deletes its files and frees its capacity.
+func (p *LocalProcessPool) stop(name string) {
+	p.mu.Lock()
+	lp, ok := p.procs[name]
+	delete(p.procs, name)
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	lp.cmd.Process.Kill()
+	<-lp.exited
+	if err := os.RemoveAll(lp.dir); err != nil {
+		log.Printf("Error deleting files of local buildlet %q: %v", name, err)
+	}
+	<-p.sem
+}
+
+// Close stops all of the pool's buildlet processes.
+func (p *LocalProcessPool) Close() {
+	p.mu.Lock()
+	names := make([]string, 0, len(p.procs))
+	for name := range p.procs {
+		names = append(names, name)
+	}
+	p.mu.Unlock()
+	for _, name := range names {
+		p.stop(name)
+	}
+}
+
+// freeLocalAddr returns a localhost address with a port that's free
+// for a buildlet to listen on.
+func freeLocalAddr() (string, error) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
+
+func (p *LocalProcessPool) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return fmt.Sprintf("Local process pool: %d/%d running", len(p.procs), p.opts.Capacity)
+}
+
+// WriteHTMLStatus writes the pool's status as HTML.
+func (p *LocalProcessPool) WriteHTMLStatus(w io.Writer) {
+	p.mu.Lock()
+	names := make([]string, 0, len(p.procs))
+	created := make(map[string]time.Time, len(p.procs))
+	for name, lp := range p.procs {
+		names = append(names, name)
+		created[name] = lp.created
+	}
+	p.mu.Unlock()
+	sort.Strings(names)
+	fmt.Fprintf(w, "<b>Local buildlet processes</b>: %d/%d running", len(names), p.opts.Capacity)
+	if len(names) > 0 {
+		fmt.Fprintf(w, "<ul>")
+		for _, name := range names {
+			fmt.Fprintf(w, "<li>%v, %v</li>\n", name, friendlyDuration(time.Since(created[name])))
+		}
+		fmt.Fprintf(w, "</ul>")
+	}
+}
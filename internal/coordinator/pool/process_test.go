@@ -0,0 +1,81 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+func TestLocalProcessPool(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode; builds and runs cmd/buildlet")
+	}
+	bin, err := BuildLocalBuildlet(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewLocalProcessPool(LocalProcessOpts{Buildlet: bin, Capacity: 1, Env: []string{"POOL_TEST=hello"}})
+	defer p.Close()
+
+	ctx := context.Background()
+	bc, err := p.GetBuildlet(ctx, "host-linux-bullseye", noopEventTimeLogger{})
+	if err != nil {
+		t.Fatalf("GetBuildlet = %v", err)
+	}
+	workDir, err := bc.WorkDir(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.Put(ctx, strings.NewReader("echo $POOL_TEST from $PWD\n"), "hello.sh", 0755); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	remoteErr, err := bc.Exec(ctx, "/bin/sh", buildlet.ExecOpts{
+		SystemLevel: true,
+		Dir:         workDir,
+		Args:        []string{"hello.sh"},
+		Output:      &out,
+	})
+	if err != nil || remoteErr != nil {
+		t.Fatalf("Exec = %v, %v; output: %s", remoteErr, err, out.Bytes())
+	}
+	if got, want := strings.TrimSpace(out.String()), "hello from "+workDir; got != want {
+		t.Errorf("output = %q; want %q", got, want)
+	}
+
+	// The pool is at capacity until the buildlet is closed.
+	tctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetBuildlet(tctx, "host-linux-bullseye", noopEventTimeLogger{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetBuildlet at capacity = %v; want deadline exceeded", err)
+	}
+
+	// Closing the client stops the process, in the background.
+	bc.Close()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(workDir); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("work dir not deleted after Close")
+		}
+	}
+	bc, err = p.GetBuildlet(ctx, "host-linux-bullseye", noopEventTimeLogger{})
+	if err != nil {
+		t.Fatalf("GetBuildlet after Close = %v", err)
+	}
+	bc.Close()
+}
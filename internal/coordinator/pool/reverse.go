@@ -58,6 +58,8 @@ const minBuildletVersion = 23
 var (
 	reversePool = &ReverseBuildletPool{
 		hostLastGood: make(map[string]time.Time),
+		draining:     make(map[string]*drainState),
+		health:       make(map[string]*reverseHostHealth),
 	}
 
 	builderMasterKey []byte
@@ -76,7 +78,7 @@ func ReversePool() *ReverseBuildletPool {
 
 // ReverseBuildletPool manages the pool of reverse buildlet pools.
 type ReverseBuildletPool struct {
-	// mu guards all 5 fields below and also fields of
+	// mu guards all 7 fields below and also fields of
 	// *reverseBuildlet in buildlets
 	mu sync.Mutex
 
@@ -105,6 +107,17 @@ type ReverseBuildletPool struct {
 	// machines as both POWER8 and POWER9 host types, but with the
 	// same names).
 	hostLastGood map[string]time.Time
+
+	// draining are the hostnames of buildlets being taken out of
+	// service, which are given no new work. It's keyed by hostname
+	// rather than kept on each reverseBuildlet so that a host stays
+	// draining when its buildlet reconnects after a build.
+	draining map[string]*drainState
+
+	// health is the recent failure history of hosts, by hostname,
+	// used to quarantine hosts that fail repeatedly. Like
+	// hostLastGood, it's kept for hosts that aren't connected.
+	health map[string]*reverseHostHealth
 }
 
 // BuildletLastSeen gives the last time a buildlet was connected to the pool. If
@@ -133,6 +146,7 @@ func (p *ReverseBuildletPool) BuildReverseStatusJSON() *types.ReverseBuilderStat
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	now := time.Now()
 	for _, b := range p.buildlets {
 		hs := status.Host(b.hostType)
 		if hs.Machines == nil {
@@ -153,12 +167,29 @@ func (p *ReverseBuildletPool) BuildReverseStatusJSON() *types.ReverseBuilderStat
 			hs.Idle++
 			bs.IdleSec = time.Since(b.inUseTime).Seconds()
 		}
+		if _, ok := p.draining[b.hostname]; ok {
+			hs.Draining++
+			bs.Draining = true
+		}
+		if p.quarantinedLocked(b.hostname, now) {
+			hs.Quarantined++
+			bs.Quarantined = true
+		}
 
 		hs.Machines[b.hostname] = bs
 	}
 	for hostType, waiters := range p.waiters {
 		status.Host(hostType).Waiters = waiters
 	}
+	for hostname, h := range p.health {
+		if status.Health == nil {
+			status.Health = make(map[string]*types.ReverseHostHealth)
+		}
+		status.Health[hostname] = &types.ReverseHostHealth{
+			Failures:         append([]types.ReverseHealthEvent(nil), h.failures...),
+			QuarantinedUntil: h.quarantinedUntil,
+		}
+	}
 	for hostType, hc := range dashboard.Hosts {
 		if hc.ExpectNum > 0 {
 			status.Host(hostType).Expect = hc.ExpectNum
@@ -178,6 +209,12 @@ func (p *ReverseBuildletPool) tryToGrab(hostType string) (bc buildlet.Client, bu
 		if b.hostType != hostType {
 			continue
 		}
+		if _, ok := p.draining[b.hostname]; ok {
+			continue
+		}
+		if p.quarantinedLocked(b.hostname, time.Now()) {
+			continue
+		}
 		if b.inUse {
 			busy++
 			continue
@@ -190,6 +227,100 @@ func (p *ReverseBuildletPool) tryToGrab(hostType string) (bc buildlet.Client, bu
 	return nil, busy
 }
 
+// drainState is the state of a draining host.
+type drainState struct {
+	halt   bool // whether to halt the host's idle buildlets
+	halted bool // whether they've been halted, which is done once per drain
+}
+
+// SetDraining marks the buildlets of host hostname as draining: they
+// finish their current work but are given no new work, including
+// after they reconnect, until ClearDraining is called. It returns the
+// number of the host's buildlets that are currently connected.
+//
+// Draining alone doesn't keep a host's buildlets connected: as after
+// every build, a buildlet is asked to halt when its build finishes
+// (see buildlet.Client.Close), and how the host handles that decides
+// whether it reconnects. If halt is true, the host's idle buildlets
+// are halted too, once: those connected now, or if none are, those
+// that next connect. A host that keeps reconnecting after that is
+// left connected, idle.
+func (p *ReverseBuildletPool) SetDraining(hostname string, halt bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d := &drainState{halt: halt}
+	p.draining[hostname] = d
+	n := 0
+	for _, b := range p.buildlets {
+		if b.hostname == hostname {
+			n++
+		}
+	}
+	p.haltDrainedLocked()
+	for _, b := range p.buildlets {
+		if b.hostname == hostname && b.inUse && !b.inHealthCheck {
+			// It's asked to halt when its build finishes.
+			d.halted = true
+		}
+	}
+	return n
+}
+
+// ClearDraining puts the buildlets of host hostname back into
+// service. It reports whether the host was draining.
+func (p *ReverseBuildletPool) ClearDraining(hostname string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.draining[hostname]; !ok {
+		return false
+	}
+	delete(p.draining, hostname)
+	for _, b := range p.buildlets {
+		if b.hostname == hostname && !b.inUse {
+			go p.noteBuildletAvailable(b.hostType)
+		}
+	}
+	return true
+}
+
+// IsDraining reports whether the buildlets of host hostname are
+// draining.
+func (p *ReverseBuildletPool) IsDraining(hostname string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.draining[hostname]
+	return ok
+}
+
+// haltDrainedLocked halts idle buildlets that are draining and are to
+// be halted, by closing their clients, which asks them to halt. They
+// are marked in use so that nothing else grabs them meanwhile. Each
+// host is halted once per drain; see SetDraining.
+//
+// It requires that p.mu be held.
+func (p *ReverseBuildletPool) haltDrainedLocked() {
+	var halted []*drainState
+	defer func() {
+		for _, d := range halted {
+			d.halted = true
+		}
+	}()
+	for _, b := range p.buildlets {
+		d := p.draining[b.hostname]
+		if d == nil || !d.halt || d.halted || b.inUse {
+			continue
+		}
+		halted = append(halted, d)
+		log.Printf("Halting draining reverse buildlet %v (type %v)", b.hostname, b.hostType)
+		b.inUse = true
+		b.inUseTime = time.Now()
+		go func(bc buildlet.Client) {
+			bc.Close()
+			p.nukeBuildlet(bc)
+		}(b.client)
+	}
+}
+
 func (p *ReverseBuildletPool) getWakeChan(hostType string) chan token {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -281,6 +412,7 @@ func (p *ReverseBuildletPool) healthCheckBuildlet(b *reverseBuildlet) bool {
 	if err != nil {
 		// remove bad buildlet
 		log.Printf("Health check fail; removing reverse buildlet %v (type %v): %v", b.hostname, b.hostType, err)
+		p.recordFailure(b.client, b.hostname, b.hostType, reverseHealthCheckFailure, err.Error())
 		go b.client.Close()
 		go p.nukeBuildlet(b.client)
 		return false
@@ -297,6 +429,10 @@ func (p *ReverseBuildletPool) healthCheckBuildlet(b *reverseBuildlet) bool {
 	b.inHealthCheck = false
 	b.inUseTime = time.Now()
 	p.recordHealthy(b)
+	if _, ok := p.draining[b.hostname]; ok {
+		p.haltDrainedLocked()
+		return true
+	}
 	go p.noteBuildletAvailable(b.hostType)
 	return true
 }
@@ -377,6 +513,19 @@ func (p *ReverseBuildletPool) WriteHTMLStatus(w io.Writer) {
 			machStatus = "working"
 			numInUse++
 		}
+		if p.quarantinedLocked(b.hostname, time.Now()) {
+			machStatus += ", <b>quarantined</b>"
+		}
+		if d, ok := p.draining[b.hostname]; ok {
+			switch {
+			case d.halted:
+				machStatus += ", <b>draining, halted</b>"
+			case d.halt:
+				machStatus += ", <b>draining, to halt</b>"
+			default:
+				machStatus += ", <b>draining</b>"
+			}
+		}
 		fmt.Fprintf(&buf, "<li>%s (%s) version %s, %s: connected %s, %s for %s</li>\n",
 			b.hostname,
 			b.conn.RemoteAddr(),
@@ -489,6 +638,7 @@ func (p *ReverseBuildletPool) addBuildlet(b *reverseBuildlet) {
 	defer p.mu.Unlock()
 	p.buildlets = append(p.buildlets, b)
 	p.recordHealthy(b)
+	p.haltDrainedLocked()
 	go p.healthCheckBuildletLoop(b)
 }
 
@@ -606,12 +756,28 @@ func HandleReverse(w http.ResponseWriter, r *http.Request) {
 
 	var isDead struct {
 		sync.Mutex
-		v bool
+		v      bool
+		closed bool // client closed, so it's not dead from failed heartbeats
 	}
+	client.AddCloseFunc(func() {
+		isDead.Lock()
+		dead := isDead.v
+		isDead.closed = true
+		isDead.Unlock()
+		if !dead && client.IsBroken() {
+			reversePool.recordFailure(client, hostname, hostType, reverseMarkedBroken, "")
+		}
+	})
+	// The heartbeat failure func also runs when the client is
+	// closed; only the heartbeats failing counts as a failure.
 	client.SetOnHeartbeatFailure(func() {
 		isDead.Lock()
 		isDead.v = true
+		closed := isDead.closed
 		isDead.Unlock()
+		if !closed {
+			reversePool.recordFailure(client, hostname, hostType, reverseHeartbeatFailure, "")
+		}
 		conn.Close()
 		reversePool.nukeBuildlet(client)
 	})
@@ -622,8 +788,9 @@ func HandleReverse(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		<-revDialerDone
 		isDead.Lock()
-		defer isDead.Unlock()
-		if !isDead.v {
+		dead := isDead.v
+		isDead.Unlock()
+		if !dead {
 			client.Close()
 		}
 	}()
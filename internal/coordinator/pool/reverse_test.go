@@ -0,0 +1,195 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+)
+
+// closeRecordingClient is a fake buildlet client that records whether
+// it was closed.
+type closeRecordingClient struct {
+	*buildlet.FakeClient
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *closeRecordingClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *closeRecordingClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func newTestReversePool() *ReverseBuildletPool {
+	return &ReverseBuildletPool{
+		hostLastGood: make(map[string]time.Time),
+		draining:     make(map[string]*drainState),
+		health:       make(map[string]*reverseHostHealth),
+	}
+}
+
+func newTestReverseBuildlet(hostname, hostType string) *reverseBuildlet {
+	conn, _ := net.Pipe()
+	return &reverseBuildlet{
+		hostname: hostname,
+		hostType: hostType,
+		client:   &closeRecordingClient{FakeClient: &buildlet.FakeClient{}},
+		conn:     conn,
+		regTime:  time.Now(),
+	}
+}
+
+func TestReverseDraining(t *testing.T) {
+	const hostType = "host-darwin-amd64-12_0"
+	p := newTestReversePool()
+	a := newTestReverseBuildlet("mac-a", hostType)
+	b := newTestReverseBuildlet("mac-b", hostType)
+	p.buildlets = []*reverseBuildlet{a, b}
+
+	if n := p.SetDraining("mac-a", false); n != 1 {
+		t.Errorf("SetDraining = %d; want 1 connected", n)
+	}
+	if !p.IsDraining("mac-a") || p.IsDraining("mac-b") {
+		t.Errorf("IsDraining(mac-a), IsDraining(mac-b) = %v, %v; want true, false", p.IsDraining("mac-a"), p.IsDraining("mac-b"))
+	}
+	st := p.BuildReverseStatusJSON().Host(hostType)
+	if st.Draining != 1 || !st.Machines["mac-a"].Draining || st.Machines["mac-b"].Draining {
+		t.Errorf("status shows %d draining, mac-a %v, mac-b %v; want 1, true, false",
+			st.Draining, st.Machines["mac-a"].Draining, st.Machines["mac-b"].Draining)
+	}
+
+	// Only the host that isn't draining gets work.
+	if bc, _ := p.tryToGrab(hostType); bc != b.client {
+		t.Fatalf("tryToGrab = %v; want mac-b's client", bc)
+	}
+	if bc, busy := p.tryToGrab(hostType); bc != nil || busy != 1 {
+		t.Fatalf("tryToGrab = %v, %d busy; want nil, 1 busy", bc, busy)
+	}
+
+	if !p.ClearDraining("mac-a") {
+		t.Error("ClearDraining = false; want true")
+	}
+	if p.ClearDraining("mac-a") {
+		t.Error("second ClearDraining = true; want false")
+	}
+	if bc, _ := p.tryToGrab(hostType); bc != a.client {
+		t.Fatalf("tryToGrab after ClearDraining = %v; want mac-a's client", bc)
+	}
+	if a.client.(*closeRecordingClient).isClosed() {
+		t.Error("draining without halt closed the buildlet")
+	}
+}
+
+func TestReverseDrainingHalt(t *testing.T) {
+	const hostType = "host-darwin-amd64-12_0"
+	p := newTestReversePool()
+	idle := newTestReverseBuildlet("mac-idle", hostType)
+	busy := newTestReverseBuildlet("mac-busy", hostType)
+	busy.inUse = true
+	p.buildlets = []*reverseBuildlet{idle, busy}
+
+	p.SetDraining("mac-idle", true)
+	p.SetDraining("mac-busy", true)
+	for deadline := time.Now().Add(5 * time.Second); p.SingleHostTypeCount(hostType) != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("idle draining buildlet not removed")
+		}
+	}
+	if !idle.client.(*closeRecordingClient).isClosed() {
+		t.Error("idle draining buildlet not halted")
+	}
+	if busy.client.(*closeRecordingClient).isClosed() {
+		t.Error("busy draining buildlet halted before finishing its work")
+	}
+
+	// A host is halted once per drain: one that reconnects after
+	// being halted stays connected, and so does one that was busy,
+	// which is asked to halt when its build finishes.
+	again := newTestReverseBuildlet("mac-idle", hostType)
+	p.addBuildlet(again)
+	busyAgain := newTestReverseBuildlet("mac-busy", hostType)
+	p.addBuildlet(busyAgain)
+	time.Sleep(10 * time.Millisecond)
+	if again.client.(*closeRecordingClient).isClosed() || busyAgain.client.(*closeRecordingClient).isClosed() {
+		t.Error("reconnected draining buildlet halted again")
+	}
+	if bc, _ := p.tryToGrab(hostType); bc != nil {
+		t.Errorf("tryToGrab = %v; want nil, as all hosts are draining", bc)
+	}
+
+	// A draining host with no buildlets connected is halted when
+	// it next connects.
+	p.SetDraining("mac-away", true)
+	away := newTestReverseBuildlet("mac-away", hostType)
+	p.addBuildlet(away)
+	for deadline := time.Now().Add(5 * time.Second); !away.client.(*closeRecordingClient).isClosed(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("draining buildlet that connected later not halted")
+		}
+	}
+}
+
+func TestReverseQuarantine(t *testing.T) {
+	const hostType = "host-darwin-amd64-12_0"
+	p := newTestReversePool()
+	b := newTestReverseBuildlet("mac-flaky", hostType)
+	p.buildlets = []*reverseBuildlet{b}
+
+	// Each failure is of a different connection of the host; more
+	// failures of the same connection only count once.
+	p.recordFailure(&buildlet.FakeClient{}, "mac-flaky", hostType, reverseHeartbeatFailure, "")
+	p.NoteCommunicationError(b.client, errors.New("connection reset"))
+	p.recordFailure(b.client, "mac-flaky", hostType, reverseMarkedBroken, "")
+	if bc, _ := p.tryToGrab(hostType); bc != b.client {
+		t.Fatalf("tryToGrab after %d failures = %v; want mac-flaky's client", reverseQuarantineFailures-1, bc)
+	}
+	b.inUse = false
+
+	p.recordFailure(&buildlet.FakeClient{}, "mac-flaky", hostType, reverseMarkedBroken, "")
+	if bc, busy := p.tryToGrab(hostType); bc != nil || busy != 0 {
+		t.Fatalf("tryToGrab of quarantined host = %v, %d busy; want nil, 0", bc, busy)
+	}
+	st := p.BuildReverseStatusJSON()
+	h := st.Health["mac-flaky"]
+	if h == nil || len(h.Failures) != 3 || !h.QuarantinedUntil.After(time.Now()) {
+		t.Fatalf("Health[mac-flaky] = %+v; want 3 failures and a quarantine", h)
+	}
+	if got, want := h.Failures[1].Kind, reverseCommunicationError; got != want {
+		t.Errorf("second failure kind = %q; want %q", got, want)
+	}
+	if hs := st.Host(hostType); hs.Quarantined != 1 || !hs.Machines["mac-flaky"].Quarantined {
+		t.Errorf("status shows %d quarantined; want mac-flaky", hs.Quarantined)
+	}
+
+	// Once the quarantine ends, failures from before it don't count
+	// toward another one, even within the window.
+	p.mu.Lock()
+	hh := p.health["mac-flaky"]
+	for i := range hh.failures {
+		hh.failures[i].Time = hh.failures[i].Time.Add(-reverseQuarantineWindow / 2)
+	}
+	hh.quarantinedUntil = time.Now().Add(-time.Minute)
+	p.mu.Unlock()
+	p.recordFailure(&buildlet.FakeClient{}, "mac-flaky", hostType, reverseHeartbeatFailure, "")
+	if bc, _ := p.tryToGrab(hostType); bc != b.client {
+		t.Fatalf("tryToGrab after quarantine = %v; want mac-flaky's client", bc)
+	}
+}
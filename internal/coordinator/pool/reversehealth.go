@@ -0,0 +1,123 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/build/buildlet"
+	"golang.org/x/build/types"
+)
+
+// Kinds of reverse buildlet failures, as recorded in
+// types.ReverseHealthEvent.Kind.
+const (
+	reverseHeartbeatFailure   = "heartbeat"
+	reverseHealthCheckFailure = "health_check"
+	reverseMarkedBroken       = "marked_broken"
+	reverseCommunicationError = "communication"
+)
+
+const (
+	// reverseQuarantineFailures is the number of failures within
+	// reverseQuarantineWindow after which a host is quarantined.
+	reverseQuarantineFailures = 3
+	reverseQuarantineWindow   = time.Hour
+
+	// reverseQuarantineDuration is how long a host is quarantined.
+	// Failures before or during a quarantine don't count toward
+	// the next one.
+	reverseQuarantineDuration = time.Hour
+
+	// reverseHealthHistory is the number of failures kept per host.
+	reverseHealthHistory = 20
+)
+
+// reverseHostHealth is the recent failure history of a reverse
+// buildlet host.
+type reverseHostHealth struct {
+	failures         []types.ReverseHealthEvent // oldest first
+	quarantinedUntil time.Time
+
+	// lastClient is the client of the last failure recorded. A
+	// failing connection typically fails in several ways at once
+	// (marked broken, heartbeats failing, a build's communication
+	// error), so only the first failure of each client is recorded.
+	lastClient buildlet.Client
+}
+
+// NoteCommunicationError records that a build using bc failed with
+// the communication error err, if bc is a reverse buildlet. Callers
+// should only pass errors in talking to the buildlet, not errors from
+// the build being canceled or on the coordinator's side.
+func (p *ReverseBuildletPool) NoteCommunicationError(bc buildlet.Client, err error) {
+	p.mu.Lock()
+	var hostname, hostType string
+	for _, b := range p.buildlets {
+		if b.client == bc {
+			hostname, hostType = b.hostname, b.hostType
+			break
+		}
+	}
+	p.mu.Unlock()
+	if hostname != "" {
+		p.recordFailure(bc, hostname, hostType, reverseCommunicationError, err.Error())
+	}
+}
+
+// recordFailure records a failure of kind of bc, a buildlet of host
+// hostname, unless a failure of bc was already recorded, and
+// quarantines the host if it has failed too often.
+func (p *ReverseBuildletPool) recordFailure(bc buildlet.Client, hostname, hostType, kind, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	h := p.health[hostname]
+	if h == nil {
+		h = new(reverseHostHealth)
+		p.health[hostname] = h
+	}
+	if h.lastClient == bc {
+		return
+	}
+	h.lastClient = bc
+	h.failures = append(h.failures, types.ReverseHealthEvent{
+		Time:     now,
+		HostType: hostType,
+		Kind:     kind,
+		Detail:   detail,
+	})
+	if len(h.failures) > reverseHealthHistory {
+		h.failures = h.failures[len(h.failures)-reverseHealthHistory:]
+	}
+
+	if now.Before(h.quarantinedUntil) {
+		return
+	}
+	n := 0
+	for _, f := range h.failures {
+		if now.Sub(f.Time) < reverseQuarantineWindow && f.Time.After(h.quarantinedUntil) {
+			n++
+		}
+	}
+	if n >= reverseQuarantineFailures {
+		h.quarantinedUntil = now.Add(reverseQuarantineDuration)
+		log.Printf("Quarantining reverse buildlet host %v until %v after %d failures; last: %s %s",
+			hostname, h.quarantinedUntil.Format(time.RFC3339), n, kind, detail)
+	}
+}
+
+// quarantinedLocked reports whether host hostname is quarantined at
+// time now.
+//
+// It requires that p.mu be held.
+func (p *ReverseBuildletPool) quarantinedLocked(hostname string, now time.Time) bool {
+	h := p.health[hostname]
+	return h != nil && now.Before(h.quarantinedUntil)
+}
@@ -0,0 +1,462 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/build/buildlet"
+	"golang.org/x/build/dashboard"
+	"golang.org/x/build/internal/spanlog"
+)
+
+/*
+This file implements warm pools: idle buildlets that the GCE,
+Kubernetes and EC2 pools start ahead of demand, so that GetBuildlet
+can hand one out immediately instead of waiting for a VM or pod to
+boot. Each pool keeps its own warm pool, and only starts a warm
+buildlet when its quota accounting shows room for one and no request
+is waiting for the pool to create a buildlet. When a request has to
+wait for quota, the pool's idle buildlets are destroyed to free it,
+so warm buildlets don't hold back builds.
+*/
+
+const (
+	// warmDemandWindow is how far back a warm pool looks at
+	// requests to estimate demand.
+	warmDemandWindow = 30 * time.Minute
+
+	// warmRetryDelay is how long a warm pool waits to start more
+	// buildlets of a host type after failing to start one.
+	warmRetryDelay = time.Minute
+
+	// maxWarmIdle is the most that WarmOpts.MaxIdle may be. A
+	// buildlet's delete-at deadline (see determineDeleteTimeout)
+	// counts from its creation, so any time it spends idle is taken
+	// from the build that then uses it. This keeps that well below
+	// the default delete timeout. Host types with their own
+	// CustomDeleteTimeout aren't warmed at all.
+	maxWarmIdle = 20 * time.Minute
+)
+
+// WarmOpts configures warm pools.
+type WarmOpts struct {
+	// MaxFromDemand is the most idle buildlets kept for a host type
+	// because of its recent demand. If zero, warm pools only keep
+	// the HostConfig.WarmBuildlets idle buildlets configured for each
+	// host type.
+	MaxFromDemand int
+
+	// MaxIdle is how long an idle buildlet is kept before it's
+	// destroyed, and replaced if still needed. If zero or more
+	// than 20 minutes, it's 20 minutes.
+	MaxIdle time.Duration
+}
+
+// EnableWarmPools makes the GCE, Kubernetes and EC2 pools keep idle
+// buildlets ready, as configured by opts and HostConfig.WarmBuildlets.
+// It must be called at most once, once the pools are initialized and
+// before they're used.
+func EnableWarmPools(opts WarmOpts) {
+	gcePool.warm = newWarmPool(opts, dashboard.Hosts, gcePool.createBuildlet, func(hostType string) bool {
+		hconf := dashboard.Hosts[hostType]
+		return hconf != nil && gcePool.hasQuota(hconf)
+	})
+	kubePool.warm = newWarmPool(opts, dashboard.Hosts, kubePool.createBuildlet, kubePool.hasCapacity)
+	if eb := ec2Buildlet; eb != nil && eb.hosts != nil {
+		eb.warm = newWarmPool(opts, eb.hosts, eb.createBuildlet, func(hostType string) bool {
+			hconf := eb.hosts[hostType]
+			return hconf != nil && eb.ledger.canReserve(hconf.MachineType())
+		})
+	}
+
+	// Start warming configured host types before they're first
+	// requested.
+	for hostType, hconf := range dashboard.Hosts {
+		if hconf.WarmBuildlets == 0 || hconf.CustomDeleteTimeout != 0 {
+			continue
+		}
+		var w *warmPool
+		switch p := ForHost(hconf).(type) {
+		case *GCEBuildlet:
+			w = p.warm
+		case *kubeBuildletPool:
+			w = p.warm
+		case *EC2Buildlet:
+			w = p.warm
+		}
+		if w != nil {
+			w.fill(hostType)
+		}
+	}
+	ws := []*warmPool{gcePool.warm, kubePool.warm}
+	if ec2Buildlet != nil {
+		ws = append(ws, ec2Buildlet.warm)
+	}
+	for _, w := range ws {
+		if w != nil {
+			go w.maintainLoop()
+		}
+	}
+}
+
+// warmPool keeps idle buildlets for a buildlet pool.
+type warmPool struct {
+	opts  WarmOpts
+	hosts map[string]*dashboard.HostConfig
+
+	// create creates a buildlet, as the pool's GetBuildlet would
+	// without a warm pool.
+	create func(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error)
+	// hasCapacity reports whether the pool has the quota to
+	// create a buildlet of hostType now.
+	hasCapacity func(hostType string) bool
+
+	mu      sync.Mutex
+	types   map[string]*warmHostType // by host type, once requested or configured
+	waiting map[string]int           // host type -> requests waiting in create
+}
+
+// warmHostType is a warm pool's state for one host type.
+type warmHostType struct {
+	idle     []*warmBuildlet // oldest first
+	starting int             // warm buildlets being started: 0 or 1
+	requests []time.Time     // times of requests within warmDemandWindow, oldest first
+	startDur time.Duration   // moving average of the time to create a buildlet
+	lastErr  time.Time       // when starting a warm buildlet last failed
+	hits     int             // requests served by an idle buildlet
+	misses   int             // requests that had to create one
+}
+
+// warmBuildlet is an idle buildlet in a warm pool.
+type warmBuildlet struct {
+	bc    buildlet.Client
+	ready time.Time
+}
+
+func newWarmPool(opts WarmOpts, hosts map[string]*dashboard.HostConfig, create func(context.Context, string, Logger) (buildlet.Client, error), hasCapacity func(string) bool) *warmPool {
+	if opts.MaxIdle == 0 || opts.MaxIdle > maxWarmIdle {
+		opts.MaxIdle = maxWarmIdle
+	}
+	return &warmPool{
+		opts:        opts,
+		hosts:       hosts,
+		create:      create,
+		hasCapacity: hasCapacity,
+		types:       make(map[string]*warmHostType),
+		waiting:     make(map[string]int),
+	}
+}
+
+// GetBuildlet hands out an idle buildlet of hostType if there is a
+// healthy one, and otherwise creates one. Either way, it then starts
+// more idle buildlets as needed. If creating one has to wait for
+// quota, it first destroys the pool's idle buildlets to free some.
+func (w *warmPool) GetBuildlet(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	w.mu.Lock()
+	wt := w.typeLocked(hostType)
+	wt.requests = append(wt.requests, time.Now())
+	w.mu.Unlock()
+
+	for {
+		wb := w.takeIdle(hostType)
+		if wb == nil {
+			break
+		}
+		if w.healthy(ctx, wb.bc) {
+			w.mu.Lock()
+			wt.hits++
+			w.mu.Unlock()
+			lg.LogEventTime("using_warm_buildlet", fmt.Sprintf("%s, idle for %v", wb.bc, time.Since(wb.ready).Round(time.Second)))
+			w.fill(hostType)
+			return wb.bc, nil
+		}
+		log.Printf("warm pool: discarding unhealthy %s buildlet %s", hostType, wb.bc)
+		wb.bc.Close()
+	}
+
+	w.mu.Lock()
+	wt.misses++
+	w.waiting[hostType]++
+	var released []*warmBuildlet
+	if w.quotaBlockedLocked() {
+		released = w.takeAllIdleLocked()
+	}
+	w.mu.Unlock()
+	w.release(released, "a request is waiting for quota")
+
+	t0 := time.Now()
+	bc, err := w.create(ctx, hostType, lg)
+	w.mu.Lock()
+	if w.waiting[hostType]--; w.waiting[hostType] == 0 {
+		delete(w.waiting, hostType)
+	}
+	w.mu.Unlock()
+	if err == nil {
+		w.observeStart(hostType, time.Since(t0))
+	}
+	w.fill(hostType)
+	return bc, err
+}
+
+// quotaBlockedLocked reports whether a request waiting in create is
+// likely waiting for quota, because its pool has no capacity for its
+// host type.
+//
+// It requires that w.mu be held.
+func (w *warmPool) quotaBlockedLocked() bool {
+	for hostType := range w.waiting {
+		if !w.hasCapacity(hostType) {
+			return true
+		}
+	}
+	return false
+}
+
+// takeAllIdleLocked removes and returns all idle buildlets.
+//
+// It requires that w.mu be held.
+func (w *warmPool) takeAllIdleLocked() []*warmBuildlet {
+	var all []*warmBuildlet
+	for _, wt := range w.types {
+		all = append(all, wt.idle...)
+		wt.idle = nil
+	}
+	return all
+}
+
+// release destroys the idle buildlets wbs, for the given reason.
+func (w *warmPool) release(wbs []*warmBuildlet, why string) {
+	for _, wb := range wbs {
+		log.Printf("warm pool: destroying idle buildlet %s: %s", wb.bc, why)
+		wb.bc.Close()
+	}
+}
+
+// typeLocked returns the state of hostType, adding it if needed.
+//
+// It requires that w.mu be held.
+func (w *warmPool) typeLocked(hostType string) *warmHostType {
+	wt := w.types[hostType]
+	if wt == nil {
+		wt = &warmHostType{}
+		w.types[hostType] = wt
+	}
+	return wt
+}
+
+// takeIdle removes and returns the oldest idle buildlet of hostType,
+// or nil if there are none.
+func (w *warmPool) takeIdle(hostType string) *warmBuildlet {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wt := w.typeLocked(hostType)
+	if len(wt.idle) == 0 {
+		return nil
+	}
+	wb := wt.idle[0]
+	wt.idle = wt.idle[1:]
+	return wb
+}
+
+// healthy reports whether the idle buildlet bc is still responding.
+func (w *warmPool) healthy(ctx context.Context, bc buildlet.Client) bool {
+	if bc.IsBroken() {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := bc.Status(ctx)
+	return err == nil
+}
+
+// observeStart updates the estimate of how long a buildlet of
+// hostType takes to create.
+func (w *warmPool) observeStart(hostType string, d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wt := w.typeLocked(hostType)
+	if wt.startDur == 0 {
+		wt.startDur = d
+	} else {
+		wt.startDur = (3*wt.startDur + d) / 4
+	}
+}
+
+// targetLocked returns how many idle buildlets of hostType to keep.
+// That's the configured number, or, if the pool derives targets from
+// demand and that's more, about the number of requests expected while
+// a buildlet starts.
+//
+// It requires that w.mu be held.
+func (w *warmPool) targetLocked(hostType string, wt *warmHostType, now time.Time) int {
+	n := 0
+	if hconf := w.hosts[hostType]; hconf != nil {
+		n = hconf.WarmBuildlets
+	}
+	i := sort.Search(len(wt.requests), func(i int) bool {
+		return now.Sub(wt.requests[i]) < warmDemandWindow
+	})
+	wt.requests = wt.requests[i:]
+	if w.opts.MaxFromDemand > 0 && wt.startDur > 0 {
+		// By Little's law, the mean number of requests that
+		// arrive while a buildlet starts.
+		d := int(math.Round(float64(len(wt.requests)) * float64(wt.startDur) / float64(warmDemandWindow)))
+		if d > w.opts.MaxFromDemand {
+			d = w.opts.MaxFromDemand
+		}
+		if d > n {
+			n = d
+		}
+	}
+	return n
+}
+
+// fill starts a buildlet of hostType if it's below its target number
+// of idle buildlets and the pool has quota for one. It starts none
+// while any request is waiting for the pool to create a buildlet, so
+// those are served first. Buildlets of a host type are started one at
+// a time, each starting the next, so that the pool's quota accounting
+// sees each one before the next is started.
+//
+// Host types with a CustomDeleteTimeout are never warmed, as MaxIdle
+// isn't capped relative to their delete timeout.
+func (w *warmPool) fill(hostType string) {
+	if hconf := w.hosts[hostType]; hconf != nil && hconf.CustomDeleteTimeout != 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	wt := w.typeLocked(hostType)
+	if wt.starting > 0 || now.Sub(wt.lastErr) < warmRetryDelay || len(w.waiting) > 0 {
+		return
+	}
+	if len(wt.idle) < w.targetLocked(hostType, wt, now) && w.hasCapacity(hostType) {
+		wt.starting++
+		go w.start(hostType)
+	}
+}
+
+// start starts a warm buildlet of hostType.
+func (w *warmPool) start(hostType string) {
+	t0 := time.Now()
+	// As with the scheduler's buildlets, the context isn't tied to
+	// a build. The buildlet is destroyed when its client is closed.
+	bc, err := w.create(context.Background(), hostType, warmLogger{})
+	if err == nil {
+		w.observeStart(hostType, time.Since(t0))
+	}
+	w.mu.Lock()
+	wt := w.typeLocked(hostType)
+	wt.starting--
+	if err != nil {
+		log.Printf("warm pool: error starting %s buildlet: %v", hostType, err)
+		wt.lastErr = time.Now()
+		w.mu.Unlock()
+		return
+	}
+	wb := &warmBuildlet{bc: bc, ready: time.Now()}
+	if w.quotaBlockedLocked() {
+		// It was started before a request began waiting for
+		// the quota it holds.
+		w.mu.Unlock()
+		w.release([]*warmBuildlet{wb}, "a request is waiting for quota")
+		return
+	}
+	wt.idle = append(wt.idle, wb)
+	w.mu.Unlock()
+	w.fill(hostType)
+}
+
+// maintainLoop periodically trims and refills the warm pool.
+func (w *warmPool) maintainLoop() {
+	for {
+		time.Sleep(30 * time.Second)
+		w.maintain()
+	}
+}
+
+// maintain destroys idle buildlets that have been idle too long, that
+// are beyond their host type's target, as demand falls, or that hold
+// quota a request is waiting for, and then starts more as needed.
+func (w *warmPool) maintain() {
+	now := time.Now()
+	var stale []buildlet.Client
+	w.mu.Lock()
+	if w.quotaBlockedLocked() {
+		blocked := w.takeAllIdleLocked()
+		w.mu.Unlock()
+		w.release(blocked, "a request is waiting for quota")
+		return
+	}
+	hostTypes := make([]string, 0, len(w.types))
+	for hostType, wt := range w.types {
+		hostTypes = append(hostTypes, hostType)
+		excess := len(wt.idle) - w.targetLocked(hostType, wt, now)
+		keep := wt.idle[:0]
+		for _, wb := range wt.idle {
+			if excess > 0 || now.Sub(wb.ready) > w.opts.MaxIdle {
+				stale = append(stale, wb.bc)
+				excess--
+				continue
+			}
+			keep = append(keep, wb)
+		}
+		wt.idle = keep
+	}
+	w.mu.Unlock()
+
+	for _, bc := range stale {
+		bc.Close()
+	}
+	for _, hostType := range hostTypes {
+		w.fill(hostType)
+	}
+}
+
+// writeHTMLStatus writes the warm pool's status as HTML.
+func (w *warmPool) writeHTMLStatus(wr io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hostTypes := make([]string, 0, len(w.types))
+	for hostType := range w.types {
+		hostTypes = append(hostTypes, hostType)
+	}
+	if len(hostTypes) == 0 {
+		return
+	}
+	sort.Strings(hostTypes)
+	now := time.Now()
+	fmt.Fprintf(wr, "<br>Warm pool:<ul>")
+	for _, hostType := range hostTypes {
+		wt := w.types[hostType]
+		fmt.Fprintf(wr, "<li>%s: %d idle, %d starting, target %d; %d hits, %d misses</li>\n",
+			hostType, len(wt.idle), wt.starting, w.targetLocked(hostType, wt, now), wt.hits, wt.misses)
+	}
+	fmt.Fprintf(wr, "</ul>")
+}
+
+// warmLogger is the Logger for creating warm buildlets, which aren't
+// for any build yet.
+type warmLogger struct{}
+
+func (warmLogger) LogEventTime(event string, optText ...string) {}
+
+func (warmLogger) CreateSpan(event string, optText ...string) spanlog.Span { return warmSpan{} }
+
+type warmSpan struct{}
+
+func (warmSpan) Done(err error) error { return err }
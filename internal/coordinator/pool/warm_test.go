@@ -0,0 +1,295 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package pool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/build/buildlet"
+	"golang.org/x/build/dashboard"
+)
+
+// warmTestClient is a fake buildlet that reports itself healthy and
+// records whether it was closed.
+type warmTestClient struct {
+	*buildlet.FakeClient
+	pool   *warmTestPool
+	mu     sync.Mutex
+	closed bool
+}
+
+func (c *warmTestClient) Status(ctx context.Context) (buildlet.Status, error) {
+	return buildlet.Status{}, nil
+}
+
+func (c *warmTestClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.pool.mu.Lock()
+		c.pool.closed++
+		c.pool.mu.Unlock()
+	}
+	return nil
+}
+
+func (c *warmTestClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// warmTestPool is a buildlet pool that creates warmTestClients,
+// waiting for capacity like the GCE and EC2 pools wait for quota.
+type warmTestPool struct {
+	mu       sync.Mutex
+	created  int
+	closed   int
+	capacity int
+}
+
+func (p *warmTestPool) create(ctx context.Context, hostType string, lg Logger) (buildlet.Client, error) {
+	for {
+		p.mu.Lock()
+		if p.created-p.closed < p.capacity {
+			p.created++
+			p.mu.Unlock()
+			return &warmTestClient{FakeClient: &buildlet.FakeClient{}, pool: p}, nil
+		}
+		p.mu.Unlock()
+		select {
+		case <-time.After(time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *warmTestPool) hasCapacity(hostType string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.created-p.closed < p.capacity
+}
+
+func (p *warmTestPool) numCreated() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.created
+}
+
+// waitIdle waits for w to have n idle buildlets of hostType.
+func waitIdle(t *testing.T, w *warmPool, hostType string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		w.mu.Lock()
+		wt := w.typeLocked(hostType)
+		idle, starting := len(wt.idle), wt.starting
+		w.mu.Unlock()
+		if idle == n && starting == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle, %d starting %s buildlets; want %d idle", idle, starting, hostType, n)
+		}
+	}
+}
+
+func TestWarmPoolConfigured(t *testing.T) {
+	const hostType = "host-warm"
+	hosts := map[string]*dashboard.HostConfig{hostType: {WarmBuildlets: 2}}
+	p := &warmTestPool{capacity: 10}
+	w := newWarmPool(WarmOpts{}, hosts, p.create, p.hasCapacity)
+
+	w.fill(hostType)
+	waitIdle(t, w, hostType, 2)
+
+	// A request is served by an idle buildlet, which is replaced.
+	bc, err := w.GetBuildlet(context.Background(), hostType, noopEventTimeLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.(*warmTestClient).isClosed() {
+		t.Error("GetBuildlet returned a closed buildlet")
+	}
+	waitIdle(t, w, hostType, 2)
+	if got, want := p.numCreated(), 3; got != want {
+		t.Errorf("created %d buildlets; want %d", got, want)
+	}
+	if wt := w.types[hostType]; wt.hits != 1 || wt.misses != 0 {
+		t.Errorf("hits, misses = %d, %d; want 1, 0", wt.hits, wt.misses)
+	}
+
+	// Idle buildlets are destroyed after MaxIdle, and replaced.
+	w.mu.Lock()
+	old := w.types[hostType].idle
+	for _, wb := range old {
+		wb.ready = time.Now().Add(-w.opts.MaxIdle - time.Minute)
+	}
+	w.mu.Unlock()
+	w.maintain()
+	for _, wb := range old {
+		if !wb.bc.(*warmTestClient).isClosed() {
+			t.Error("buildlet idle for longer than MaxIdle not closed")
+		}
+	}
+	waitIdle(t, w, hostType, 2)
+}
+
+func TestWarmPoolNoCapacity(t *testing.T) {
+	const hostType = "host-warm"
+	hosts := map[string]*dashboard.HostConfig{hostType: {WarmBuildlets: 3}}
+	p := &warmTestPool{capacity: 1}
+	w := newWarmPool(WarmOpts{}, hosts, p.create, p.hasCapacity)
+
+	w.fill(hostType)
+	waitIdle(t, w, hostType, 1)
+	w.fill(hostType)
+	waitIdle(t, w, hostType, 1)
+	if got := p.numCreated(); got != 1 {
+		t.Errorf("created %d buildlets with capacity for 1", got)
+	}
+}
+
+func TestWarmPoolDemandTarget(t *testing.T) {
+	const hostType = "host-warm"
+	hosts := map[string]*dashboard.HostConfig{hostType: {}}
+	now := time.Now()
+	tests := []struct {
+		name          string
+		maxFromDemand int
+		requests      int
+		want          int
+	}{
+		{"disabled", 0, 60, 0},
+		{"idle", 5, 0, 0},
+		{"demand", 5, 60, 2}, // 60 requests in 30 minutes, 1 minute to start
+		{"capped", 1, 60, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWarmPool(WarmOpts{MaxFromDemand: tt.maxFromDemand}, hosts, nil, nil)
+			wt := w.typeLocked(hostType)
+			wt.startDur = time.Minute
+			for i := 0; i < tt.requests; i++ {
+				wt.requests = append(wt.requests, now.Add(-time.Duration(tt.requests-i)*time.Second))
+			}
+			// Requests from before the window don't count.
+			wt.requests = append([]time.Time{now.Add(-time.Hour)}, wt.requests...)
+			if got := w.targetLocked(hostType, wt, now); got != tt.want {
+				t.Errorf("target = %d; want %d", got, tt.want)
+			}
+			if len(wt.requests) != tt.requests {
+				t.Errorf("%d requests kept; want %d", len(wt.requests), tt.requests)
+			}
+		})
+	}
+}
+
+func TestWarmPoolQuotaBlocked(t *testing.T) {
+	const warmType, otherType = "host-warm", "host-other"
+	hosts := map[string]*dashboard.HostConfig{
+		warmType:  {WarmBuildlets: 2},
+		otherType: {},
+	}
+	p := &warmTestPool{capacity: 2}
+	w := newWarmPool(WarmOpts{}, hosts, p.create, p.hasCapacity)
+
+	w.fill(warmType)
+	waitIdle(t, w, warmType, 2)
+	w.mu.Lock()
+	warm := w.types[warmType].idle
+	w.mu.Unlock()
+
+	// The idle buildlets hold all the quota, so a request for
+	// another host type has them destroyed rather than wait.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	bc, err := w.GetBuildlet(ctx, otherType, noopEventTimeLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wb := range warm {
+		if !wb.bc.(*warmTestClient).isClosed() {
+			t.Errorf("idle buildlet %v not destroyed for a request waiting for quota", wb.bc)
+		}
+	}
+
+	// Once the request is served, warm buildlets are started again
+	// with the quota left.
+	w.maintain()
+	waitIdle(t, w, warmType, 1)
+	bc.Close()
+}
+
+func TestWarmPoolFillWaitsForRequests(t *testing.T) {
+	const warmType, otherType = "host-warm", "host-other"
+	hosts := map[string]*dashboard.HostConfig{
+		warmType:  {WarmBuildlets: 1},
+		otherType: {},
+	}
+	p := &warmTestPool{capacity: 1}
+	w := newWarmPool(WarmOpts{}, hosts, p.create, p.hasCapacity)
+
+	held, err := p.create(context.Background(), otherType, noopEventTimeLogger{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		bc, err := w.GetBuildlet(context.Background(), otherType, noopEventTimeLogger{})
+		if err == nil {
+			bc.Close()
+		}
+		done <- err
+	}()
+	for {
+		w.mu.Lock()
+		n := w.waiting[otherType]
+		w.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Freeing the quota serves the waiting request, not a warm
+	// buildlet.
+	w.fill(warmType)
+	held.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := p.numCreated(); got != 2 {
+		t.Errorf("created %d buildlets; want 2", got)
+	}
+}
+
+func TestWarmPoolCustomDeleteTimeout(t *testing.T) {
+	const hostType = "host-warm"
+	hosts := map[string]*dashboard.HostConfig{hostType: {WarmBuildlets: 2, CustomDeleteTimeout: 8 * time.Hour}}
+	p := &warmTestPool{capacity: 10}
+	w := newWarmPool(WarmOpts{MaxFromDemand: 2, MaxIdle: time.Hour}, hosts, p.create, p.hasCapacity)
+	if w.opts.MaxIdle != maxWarmIdle {
+		t.Errorf("MaxIdle = %v; want it capped at %v", w.opts.MaxIdle, maxWarmIdle)
+	}
+
+	// Idle time would count against the host type's own delete
+	// timeout, so none are started.
+	w.fill(hostType)
+	if _, err := w.GetBuildlet(context.Background(), hostType, noopEventTimeLogger{}); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, w, hostType, 0)
+	if got := p.numCreated(); got != 1 {
+		t.Errorf("created %d buildlets; want only the 1 requested", got)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+// fairShare shares gomote and TryBot buildlets fairly between the
+// owners of the requests waiting for them, using start-time fair
+// queuing. Each request is tagged when it starts waiting with the
+// later of its queue's virtual time and the tag of its owner's last
+// waiting request, plus the inverse of the owner's weight. Requests
+// are served in order of their tags (see schedLess), and serving a
+// request advances its queue's virtual time to its tag, so owners are
+// served round-robin, in proportion to their weights.
+//
+// A fairShare isn't safe for concurrent use.
+type fairShare struct {
+	// vtime is the virtual time of each queue: the tag of the
+	// last item served from it.
+	vtime map[fairQueue]float64
+
+	weights map[string]float64 // SchedItem.Owner -> weight, if not 1
+}
+
+// fairQueue identifies a set of waiters that are served fairly
+// between owners: gomotes or TryBots of a host type.
+type fairQueue struct {
+	hostType string
+	gomote   bool
+}
+
+func newFairShare() *fairShare {
+	return &fairShare{
+		vtime:   make(map[fairQueue]float64),
+		weights: make(map[string]float64),
+	}
+}
+
+// fairQueue returns the fair-share queue si waits in, if any.
+func (si *SchedItem) fairQueue() (q fairQueue, ok bool) {
+	if !si.IsGomote && !si.IsTry {
+		return fairQueue{}, false
+	}
+	return fairQueue{hostType: si.HostType, gomote: si.IsGomote}, true
+}
+
+// setWeight sets the weight of owner. A weight <= 0 restores the
+// default of 1.
+func (f *fairShare) setWeight(owner string, weight float64) {
+	if weight <= 0 || weight == 1 {
+		delete(f.weights, owner)
+		return
+	}
+	f.weights[owner] = weight
+}
+
+// tag sets the fairTag of si, which is about to start waiting with
+// the other items in waiting, all for the same host type.
+func (f *fairShare) tag(si *SchedItem, waiting map[*SchedItem]bool) {
+	q, ok := si.fairQueue()
+	if !ok {
+		return
+	}
+	start := f.vtime[q]
+	if si.Owner != "" {
+		for w := range waiting {
+			if wq, _ := w.fairQueue(); wq == q && w.Owner == si.Owner && w.fairTag > start {
+				start = w.fairTag
+			}
+		}
+	}
+	weight := 1.0
+	if w, ok := f.weights[si.Owner]; ok {
+		weight = w
+	}
+	si.fairTag = start + 1/weight
+}
+
+// served records that si, which was tagged by tag, got a buildlet.
+func (f *fairShare) served(si *SchedItem) {
+	if q, ok := si.fairQueue(); ok && si.fairTag > f.vtime[q] {
+		f.vtime[q] = si.fairTag
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+var (
+	kHostType = tag.MustNewKey("go-build/coordinator/host_type")
+	kClass    = tag.MustNewKey("go-build/coordinator/sched_class")
+	kResult   = tag.MustNewKey("go-build/coordinator/result")
+
+	mWaiters    = stats.Int64("go-build/coordinator/sched_waiters", "number of waiters for buildlets", stats.UnitDimensionless)
+	mWaitTime   = stats.Float64("go-build/coordinator/sched_wait_time", "time from asking the scheduler for a buildlet to getting it", stats.UnitSeconds)
+	mPoolGetAll = stats.Int64("go-build/coordinator/sched_pool_get_count", "buildlet pool GetBuildlet calls made by the scheduler", stats.UnitDimensionless)
+)
+
+// Views are the views of the Scheduler's metrics, to be registered
+// with the metrics service.
+var Views = []*view.View{
+	{
+		Name:        "go-build/coordinator/sched_waiters",
+		Description: "Number of waiters for buildlets, by host type and class (gomote, try or regular)",
+		Measure:     mWaiters,
+		TagKeys:     []tag.Key{kHostType, kClass},
+		Aggregation: view.LastValue(),
+	},
+	{
+		Name:        "go-build/coordinator/sched_wait_time",
+		Description: "Time from asking the scheduler for a buildlet to getting it, by host type and class",
+		Measure:     mWaitTime,
+		TagKeys:     []tag.Key{kHostType, kClass},
+		Aggregation: view.Distribution(1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200),
+	},
+	{
+		Name:        "go-build/coordinator/sched_pool_get_count",
+		Description: "Count of buildlet pool GetBuildlet calls made by the scheduler, by host type and result (ok or error)",
+		Measure:     mPoolGetAll,
+		TagKeys:     []tag.Key{kHostType, kResult},
+		Aggregation: view.Count(),
+	},
+}
+
+// recordWaitersLocked records the number of waiters of each class for
+// hostType.
+//
+// It requires that s.mu be held.
+func (s *Scheduler) recordWaitersLocked(hostType string) {
+	n := map[string]int64{"gomote": 0, "try": 0, "regular": 0}
+	for si := range s.waiting[hostType] {
+		n[si.class()]++
+	}
+	for class, count := range n {
+		stats.RecordWithTags(context.Background(),
+			[]tag.Mutator{tag.Upsert(kHostType, hostType), tag.Upsert(kClass, class)},
+			mWaiters.M(count))
+	}
+}
+
+// recordWaitTime records how long si waited for its buildlet.
+func recordWaitTime(si *SchedItem, d time.Duration) {
+	stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(kHostType, si.HostType), tag.Upsert(kClass, si.class())},
+		mWaitTime.M(d.Seconds()))
+}
+
+// recordPoolGet records the result of a pool's GetBuildlet call.
+func recordPoolGet(hostType string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	stats.RecordWithTags(context.Background(),
+		[]tag.Mutator{tag.Upsert(kHostType, hostType), tag.Upsert(kResult, result)},
+		mPoolGetAll.M(1))
+}
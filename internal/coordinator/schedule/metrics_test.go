@@ -0,0 +1,78 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestMetrics(t *testing.T) {
+	if err := view.Register(Views...); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(Views...)
+
+	s := NewScheduler()
+	s.mu.Lock()
+	s.waiting["metrics-host"] = map[*SchedItem]bool{
+		{HostType: "metrics-host", IsTry: true}: true,
+		{HostType: "metrics-host", IsTry: true}: true,
+		{HostType: "metrics-host"}:              true,
+	}
+	s.recordWaitersLocked("metrics-host")
+	s.mu.Unlock()
+	recordPoolGet("metrics-host", nil)
+	recordPoolGet("metrics-host", errors.New("no quota"))
+	recordPoolGet("metrics-host", errors.New("no quota"))
+
+	rows, err := view.RetrieveData("go-build/coordinator/sched_waiters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]float64{}
+	for _, r := range rows {
+		if hasTag(r.Tags, kHostType, "metrics-host") {
+			for _, tg := range r.Tags {
+				if tg.Key == kClass {
+					got[tg.Value] = r.Data.(*view.LastValueData).Value
+				}
+			}
+		}
+	}
+	if want := map[string]float64{"gomote": 0, "try": 2, "regular": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("waiters = %v; want %v", got, want)
+	}
+
+	rows, err = view.RetrieveData("go-build/coordinator/sched_pool_get_count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if hasTag(r.Tags, kHostType, "metrics-host") && hasTag(r.Tags, kResult, "error") {
+			if n := r.Data.(*view.CountData).Value; n != 2 {
+				t.Errorf("pool GetBuildlet errors = %d; want 2", n)
+			}
+			return
+		}
+	}
+	t.Errorf("no pool GetBuildlet errors recorded")
+}
+
+func hasTag(tags []tag.Tag, k tag.Key, v string) bool {
+	for _, tg := range tags {
+		if tg.Key == k && tg.Value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+import "time"
+
+// A Policy decides which waiting SchedItems get buildlets first,
+// when running ones should give up their buildlets, and how many
+// buildlets of a host type may be created at once. Its methods are
+// called with the Scheduler's lock held, so they must be fast and
+// must not call back into the Scheduler.
+//
+// Policies can be evaluated offline with Simulate before they're
+// used with Scheduler.SetPolicy.
+type Policy interface {
+	// Less reports whether waiting item a should get a buildlet
+	// before b. Both are for the same host type.
+	Less(a, b *SchedItem) bool
+
+	// Preempt reports whether running, an item that has a
+	// buildlet, should give it up for waiter, which has been
+	// waiting for the duration waited. Both are for the same
+	// host type. The Scheduler only considers running items with
+	// an OnPreempt func, and preempts at most one per waiter.
+	Preempt(waiter, running *SchedItem, waited time.Duration) bool
+
+	// MaxCreating returns the most buildlets of hostType that may
+	// be being created at once, or 0 for no limit.
+	MaxCreating(hostType string) int
+}
+
+// DefaultPolicy is the Policy a Scheduler uses unless another is set
+// with SetPolicy. Gomotes go first, then TryBots, each shared fairly
+// between owners, then post-submit builds, newest commit first. It
+// never preempts and doesn't limit buildlet creation.
+var DefaultPolicy Policy = defaultPolicy{}
+
+// PreemptingPolicy returns a Policy like DefaultPolicy, except that
+// gomotes and TryBots that have waited for at least after preempt
+// post-submit builds.
+func PreemptingPolicy(after time.Duration) Policy {
+	return defaultPolicy{preemptAfter: after}
+}
+
+type defaultPolicy struct {
+	preemptAfter time.Duration // or 0 to never preempt
+}
+
+func (defaultPolicy) Less(a, b *SchedItem) bool       { return schedLess(a, b) }
+func (defaultPolicy) MaxCreating(hostType string) int { return 0 }
+
+func (p defaultPolicy) Preempt(waiter, running *SchedItem, waited time.Duration) bool {
+	if p.preemptAfter <= 0 || waited < p.preemptAfter {
+		return false
+	}
+	return (waiter.IsGomote || waiter.IsTry) && !running.IsGomote && !running.IsTry
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPreemptingPolicy(t *testing.T) {
+	var (
+		gomote = &SchedItem{IsGomote: true}
+		try    = &SchedItem{IsTry: true}
+		reg    = &SchedItem{}
+	)
+	p := PreemptingPolicy(time.Minute)
+	tests := []struct {
+		name            string
+		waiter, running *SchedItem
+		waited          time.Duration
+		want            bool
+	}{
+		{"gomote preempts reg", gomote, reg, time.Minute, true},
+		{"try preempts reg", try, reg, 2 * time.Minute, true},
+		{"try waits a minute first", try, reg, time.Second, false},
+		{"try doesn't preempt try", try, try, time.Hour, false},
+		{"gomote doesn't preempt try", gomote, try, time.Hour, false},
+		{"reg doesn't preempt reg", reg, reg, time.Hour, false},
+	}
+	for _, tt := range tests {
+		if got := p.Preempt(tt.waiter, tt.running, tt.waited); got != tt.want {
+			t.Errorf("%s: got %v; want %v", tt.name, got, tt.want)
+		}
+	}
+	if DefaultPolicy.Preempt(gomote, reg, time.Hour) {
+		t.Errorf("DefaultPolicy preempts; want it not to")
+	}
+}
@@ -38,7 +38,11 @@ type Scheduler struct {
 	// to each hostType's respective buildlet pool.
 	hostsCreating map[string]int // hostType -> count
 
-	lastProgress map[string]time.Time // hostType -> time last delivered buildlet
+	lastProgress map[string]time.Time           // hostType -> time last delivered buildlet
+	running      map[string]map[*SchedItem]bool // hostType -> preemptible items with buildlets
+	fair         *fairShare
+	policy       Policy
+	preemptCheck time.Duration // how often waiters consider preempting
 }
 
 // A getBuildletResult is a buildlet that was just created and is up and
@@ -58,10 +62,36 @@ func NewScheduler() *Scheduler {
 		hostsCreating: make(map[string]int),
 		waiting:       make(map[string]map[*SchedItem]bool),
 		lastProgress:  make(map[string]time.Time),
+		running:       make(map[string]map[*SchedItem]bool),
+		fair:          newFairShare(),
+		policy:        DefaultPolicy,
+		preemptCheck:  30 * time.Second,
 	}
 	return s
 }
 
+// SetPolicy sets the policy s uses to decide which waiters get
+// buildlets. A nil Policy restores DefaultPolicy.
+func (s *Scheduler) SetPolicy(p Policy) {
+	if p == nil {
+		p = DefaultPolicy
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// SetOwnerWeight sets the share of gomotes and TryBot buildlets that
+// owner (a SchedItem.Owner) gets relative to other owners waiting for
+// the same host type. The default weight is 1; an owner with weight 2
+// gets two buildlets for every one another owner gets. A weight <= 0
+// restores the default. It affects requests made after it's called.
+func (s *Scheduler) SetOwnerWeight(owner string, weight float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fair.setWeight(owner, weight)
+}
+
 // matchBuildlet matches up a successful getBuildletResult to the
 // highest priority waiter, or closes it if there is none.
 func (s *Scheduler) matchBuildlet(res getBuildletResult) {
@@ -83,6 +113,10 @@ func (s *Scheduler) matchBuildlet(res getBuildletResult) {
 
 			s.mu.Lock()
 			s.lastProgress[res.HostType] = time.Now()
+			// If the policy caps how many buildlets are created
+			// at once, there may be waiters still without one
+			// being created for them.
+			s.scheduleLocked()
 			s.mu.Unlock()
 			return
 		case <-waiter.ctxDone:
@@ -109,6 +143,9 @@ func (s *Scheduler) schedule() {
 func (s *Scheduler) scheduleLocked() {
 	for hostType, waiting := range s.waiting {
 		need := len(waiting) - s.hostsCreating[hostType]
+		if max := s.policy.MaxCreating(hostType); max > 0 && s.hostsCreating[hostType]+need > max {
+			need = max - s.hostsCreating[hostType]
+		}
 		if need <= 0 {
 			continue
 		}
@@ -150,6 +187,7 @@ func (s *Scheduler) getPoolBuildlet(pool pool.Buildlet, hostType string) {
 	}
 	ctx := context.Background() // TODO: make these cancelable and cancel unneeded ones earlier?
 	res.Client, res.Err = pool.GetBuildlet(ctx, hostType, stderrLogger{})
+	recordPoolGet(hostType, res.Err)
 
 	// This is still slightly racy, but probably ok for now.
 	// (We might invoke the schedule method right after
@@ -171,12 +209,14 @@ func (s *Scheduler) matchWaiter(hostType string) (_ *SchedItem, ok bool) {
 
 	var best *SchedItem
 	for si := range waiters {
-		if best == nil || schedLess(si, best) {
+		if best == nil || s.policy.Less(si, best) {
 			best = si
 		}
 	}
 	if best != nil {
 		delete(waiters, best)
+		s.recordWaitersLocked(hostType)
+		s.fair.served(best)
 		return best, true
 	}
 	return nil, false
@@ -187,6 +227,7 @@ func (s *Scheduler) removeWaiter(si *SchedItem) {
 	defer s.mu.Unlock()
 	if m := s.waiting[si.HostType]; m != nil {
 		delete(m, si)
+		s.recordWaitersLocked(si.HostType)
 	}
 }
 
@@ -196,7 +237,9 @@ func (s *Scheduler) addWaiter(si *SchedItem) {
 	if _, ok := s.waiting[si.HostType]; !ok {
 		s.waiting[si.HostType] = make(map[*SchedItem]bool)
 	}
+	s.fair.tag(si, s.waiting[si.HostType])
 	s.waiting[si.HostType][si] = true
+	s.recordWaitersLocked(si.HostType)
 	s.scheduleLocked()
 }
 
@@ -230,6 +273,22 @@ type SchedulerHostState struct {
 	Gomote       SchedulerWaitingState
 	Try          SchedulerWaitingState
 	Regular      SchedulerWaitingState
+
+	// Waiters are the waiters, in the order the policy will
+	// give them buildlets.
+	Waiters []SchedulerWaiter
+}
+
+// SchedulerWaiter describes a waiter for a buildlet in SchedulerState.
+// It's served on the unauthenticated status pages, so it leaves out
+// SchedItem.Owner, which may be a user's email address.
+type SchedulerWaiter struct {
+	Class    string  // "gomote", "try" or "regular"
+	Builder  string  `json:",omitempty"`
+	Rev      string  `json:",omitempty"`
+	SubRev   string  `json:",omitempty"`
+	IsHelper bool    `json:",omitempty"`
+	AgeSec   float64 // how long it's been waiting
 }
 
 type SchedulerState struct {
@@ -256,6 +315,21 @@ func (s *Scheduler) State() (st SchedulerState) {
 				hst.Regular.add(si)
 			}
 		}
+		waiters := make([]*SchedItem, 0, len(m))
+		for si := range m {
+			waiters = append(waiters, si)
+		}
+		sort.Slice(waiters, func(i, j int) bool { return s.policy.Less(waiters[i], waiters[j]) })
+		for _, si := range waiters {
+			hst.Waiters = append(hst.Waiters, SchedulerWaiter{
+				Class:    si.class(),
+				Builder:  si.Name,
+				Rev:      si.Rev,
+				SubRev:   si.SubRev,
+				IsHelper: si.IsHelper,
+				AgeSec:   time.Since(si.requestTime).Seconds(),
+			})
+		}
 		if lp := s.lastProgress[hostType]; !lp.IsZero() {
 			lastProgressAgo := time.Since(lp)
 			if lastProgressAgo < hst.Total.Oldest {
@@ -277,7 +351,7 @@ func (s *Scheduler) WaiterState(waiter *SchedItem) (ws types.BuildletWaitStatus)
 
 	m := s.waiting[waiter.HostType]
 	for si := range m {
-		if schedLess(si, waiter) {
+		if s.policy.Less(si, waiter) {
 			ws.Ahead++
 		}
 	}
@@ -296,7 +370,7 @@ func schedLess(ia, ib *SchedItem) bool {
 	// top of various branches. Then we can use that in decisions rather than doing
 	// lookups or locks in a less function.
 
-	// Gomote is most important, then TryBots (FIFO for either), then
+	// Gomote is most important, then TryBots (fair-share for either), then
 	// post-submit builds (LIFO, by commit time)
 	if ia.IsGomote != ib.IsGomote {
 		return ia.IsGomote
@@ -304,12 +378,12 @@ func schedLess(ia, ib *SchedItem) bool {
 	if ia.IsTry != ib.IsTry {
 		return ia.IsTry
 	}
-	// Gomote and TryBots are FIFO.
+	// Gomote and TryBots are shared fairly between owners (see
+	// fairShare), and otherwise FIFO.
 	if ia.IsGomote || ia.IsTry {
-		// TODO: if IsTry, consider how many TryBot requests
-		// are outstanding per user. The scheduler should
-		// round-robin between CL authors, rather than use
-		// time. But time works for now.
+		if ia.fairTag != ib.fairTag {
+			return ia.fairTag < ib.fairTag
+		}
 		return ia.requestTime.Before(ib.requestTime)
 	}
 
@@ -336,12 +410,30 @@ type SchedItem struct {
 	// being newer, or master being newer).
 	CommitTime time.Time
 
+	// OnPreempt, if non-nil, makes the item preemptible once it
+	// has its buildlet: the Scheduler calls it, in a new
+	// goroutine, when its Policy decides that a waiter needs the
+	// buildlet more. It must stop using the buildlet and close
+	// it. The item is no longer preemptible once the context
+	// passed to GetBuildlet is done.
+	OnPreempt func()
+
+	// Owner identifies who a gomote or TryBot request is for, such
+	// as the gomote user or the owner of the CL being tested.
+	// Waiting requests are shared fairly between owners, so one
+	// owner with 50 TryBot runs doesn't starve another with one.
+	// Requests without an owner are each treated as having their
+	// own.
+	Owner string
+
 	// The following unexported fields are set by the Scheduler in
 	// Scheduler.GetBuildlet.
 
 	s           *Scheduler
 	requestTime time.Time
-	tryFor      string // TODO: which user. (user with 1 trybot >> user with 50 trybots)
+	fairTag     float64   // set by fairShare.tag
+	gotTime     time.Time // when the buildlet was obtained, if OnPreempt is set
+	preempted   bool      // whether a running item was preempted for this waiter
 	pool        pool.Buildlet
 	ctxDone     <-chan struct{}
 
@@ -356,6 +448,18 @@ type SchedItem struct {
 	wantRes chan chan<- buildlet.Client
 }
 
+// class returns the class of si: "gomote", "try" or "regular", for
+// post-submit builds.
+func (si *SchedItem) class() string {
+	switch {
+	case si.IsGomote:
+		return "gomote"
+	case si.IsTry:
+		return "try"
+	}
+	return "regular"
+}
+
 // GetBuildlet requests a buildlet with the parameters described in si.
 //
 // The provided si must be newly allocated; ownership passes to the scheduler.
@@ -374,14 +478,80 @@ func (s *Scheduler) GetBuildlet(ctx context.Context, si *SchedItem) (buildlet.Cl
 
 	s.addWaiter(si)
 
+	check := time.NewTicker(s.preemptCheck)
+	defer check.Stop()
+
 	ch := make(chan buildlet.Client)
-	select {
-	case si.wantRes <- ch:
-		// No need to call removeWaiter. If we're here, the
-		// sender has already done so.
-		return <-ch, nil
-	case <-ctx.Done():
-		s.removeWaiter(si)
-		return nil, ctx.Err()
+	for {
+		select {
+		case si.wantRes <- ch:
+			// No need to call removeWaiter. If we're here, the
+			// sender has already done so.
+			bc := <-ch
+			recordWaitTime(si, time.Since(si.requestTime))
+			s.addRunning(ctx, si)
+			return bc, nil
+		case <-check.C:
+			s.maybePreempt(si)
+		case <-ctx.Done():
+			s.removeWaiter(si)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// addRunning records that si, which was passed to GetBuildlet with
+// ctx, got its buildlet, if it's preemptible.
+func (s *Scheduler) addRunning(ctx context.Context, si *SchedItem) {
+	if si.OnPreempt == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	si.gotTime = time.Now()
+	if _, ok := s.running[si.HostType]; !ok {
+		s.running[si.HostType] = make(map[*SchedItem]bool)
+	}
+	s.running[si.HostType][si] = true
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.running[si.HostType], si)
+	}()
+}
+
+// maybePreempt preempts the least important running item of
+// waiter's host type that the policy says waiter should preempt, if
+// any. Each waiter preempts at most one item.
+func (s *Scheduler) maybePreempt(waiter *SchedItem) {
+	s.mu.Lock()
+	if waiter.preempted || !s.waiting[waiter.HostType][waiter] {
+		s.mu.Unlock()
+		return
+	}
+	waited := time.Since(waiter.requestTime)
+	var victim *SchedItem
+	for si := range s.running[waiter.HostType] {
+		if !s.policy.Preempt(waiter, si, waited) {
+			continue
+		}
+		// Prefer the least important, then the one that's
+		// had its buildlet for the least time, losing the
+		// least work.
+		if victim == nil || s.policy.Less(victim, si) ||
+			(!s.policy.Less(si, victim) && si.gotTime.After(victim.gotTime)) {
+			victim = si
+		}
+	}
+	if victim != nil {
+		delete(s.running[waiter.HostType], victim)
+		waiter.preempted = true
+	}
+	s.mu.Unlock()
+
+	if victim != nil {
+		log.Printf("sched: preempting %v on %q for a waiter of %v", victim.BuilderRev, victim.HostType, waited.Round(time.Second))
+		go victim.OnPreempt()
 	}
 }
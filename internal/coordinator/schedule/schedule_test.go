@@ -10,6 +10,7 @@ package schedule
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"runtime"
 	"testing"
 	"time"
@@ -85,6 +86,20 @@ func TestSchedLess(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "try fair share before FIFO",
+			a: &SchedItem{
+				IsTry:       true,
+				requestTime: t2,
+				fairTag:     1,
+			},
+			b: &SchedItem{
+				IsTry:       true,
+				requestTime: t1,
+				fairTag:     2,
+			},
+			want: true,
+		},
 		{
 			name: "reg LIFO, less",
 			a: &SchedItem{
@@ -226,6 +241,15 @@ func (m poolChan) GetBuildlet(ctx context.Context, hostType string, lg cpool.Log
 
 func (poolChan) String() string { return "testing poolChan" }
 
+// maxCreatingPolicy is a Policy that limits how many buildlets are
+// created at once.
+type maxCreatingPolicy struct {
+	Policy
+	max int
+}
+
+func (p maxCreatingPolicy) MaxCreating(hostType string) int { return p.max }
+
 func TestScheduler(t *testing.T) {
 	defer func() { cpool.TestPoolHook = nil }()
 
@@ -286,6 +310,152 @@ func TestScheduler(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "try-bots-round-robin-between-owners",
+			steps: func() []step {
+				var a, b []*getBuildletCall
+				for i := 0; i < 3; i++ {
+					a = append(a, newGetBuildletCall(&SchedItem{HostType: "test-host-foo", IsTry: true, Owner: "a"}))
+				}
+				b = append(b, newGetBuildletCall(&SchedItem{HostType: "test-host-foo", IsTry: true, Owner: "b"}))
+				return []step{
+					a[0].start,
+					a[1].start,
+					a[2].start,
+					b[0].start,
+					buildletAvailable("test-host-foo"),
+					a[0].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					b[0].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					a[1].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					a[2].wantGetBuildlet,
+				}
+			},
+		},
+		{
+			name: "try-bots-owner-weights",
+			steps: func() []step {
+				var a, b []*getBuildletCall
+				for i := 0; i < 3; i++ {
+					a = append(a, newGetBuildletCall(&SchedItem{HostType: "test-host-foo", IsTry: true, Owner: "a"}))
+					b = append(b, newGetBuildletCall(&SchedItem{HostType: "test-host-foo", IsTry: true, Owner: "b"}))
+				}
+				setWeight := func(t *testing.T, s *Scheduler) { s.SetOwnerWeight("b", 2) }
+				return []step{
+					setWeight,
+					a[0].start,
+					a[1].start,
+					a[2].start,
+					b[0].start,
+					b[1].start,
+					b[2].start,
+					// Tags: a: 1, 2, 3; b: 0.5, 1, 1.5.
+					buildletAvailable("test-host-foo"),
+					b[0].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					a[0].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					b[1].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					b[2].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					a[1].wantGetBuildlet,
+					buildletAvailable("test-host-foo"),
+					a[2].wantGetBuildlet,
+				}
+			},
+		},
+		{
+			name: "policy-limits-creating",
+			steps: func() []step {
+				older := &SchedItem{HostType: "test-host-foo", CommitTime: time.Unix(1, 0)}
+				newer := &SchedItem{HostType: "test-host-foo", CommitTime: time.Unix(2, 0)}
+				oldGet, newGet := newGetBuildletCall(older), newGetBuildletCall(newer)
+				wantCreating := func(n int) step {
+					return func(t *testing.T, s *Scheduler) {
+						if !trueSoon(func() bool {
+							s.mu.Lock()
+							defer s.mu.Unlock()
+							return s.hostsCreating["test-host-foo"] == n
+						}) {
+							t.Fatalf("not creating %d buildlets", n)
+						}
+					}
+				}
+				return []step{
+					func(t *testing.T, s *Scheduler) { s.SetPolicy(maxCreatingPolicy{DefaultPolicy, 1}) },
+					oldGet.start,
+					newGet.start,
+					wantCreating(1),
+					buildletAvailable("test-host-foo"),
+					newGet.wantGetBuildlet,
+					wantCreating(1),
+					buildletAvailable("test-host-foo"),
+					oldGet.wantGetBuildlet,
+				}
+			},
+		},
+		{
+			name: "try-bot-preempts-regular",
+			steps: func() []step {
+				preempted := make(chan bool)
+				regItem := &SchedItem{HostType: "test-host-foo", OnPreempt: func() { close(preempted) }}
+				tryItem := &SchedItem{HostType: "test-host-foo", IsTry: true}
+				regGet := newGetBuildletCall(regItem)
+				tryGet := newGetBuildletCall(tryItem)
+				return []step{
+					func(t *testing.T, s *Scheduler) {
+						s.preemptCheck = 5 * time.Millisecond
+						s.SetPolicy(PreemptingPolicy(10 * time.Millisecond))
+					},
+					regGet.start,
+					buildletAvailable("test-host-foo"),
+					regGet.wantGetBuildlet,
+					tryGet.start,
+					func(t *testing.T, s *Scheduler) {
+						select {
+						case <-preempted:
+						case <-time.After(5 * time.Second):
+							t.Fatalf("timeout waiting for regular build to be preempted")
+						}
+					},
+					regGet.cancel,
+					buildletAvailable("test-host-foo"),
+					tryGet.wantGetBuildlet,
+				}
+			},
+		},
+		{
+			name: "state-lists-waiters-in-order",
+			steps: func() []step {
+				regGet := newGetBuildletCall(&SchedItem{HostType: "test-host-foo"})
+				tryGet := newGetBuildletCall(&SchedItem{HostType: "test-host-foo", IsTry: true, Owner: "a"})
+				return []step{
+					regGet.start,
+					tryGet.start,
+					func(t *testing.T, s *Scheduler) {
+						st := s.State()
+						if len(st.HostTypes) != 1 {
+							t.Fatalf("State has %d host types; want 1", len(st.HostTypes))
+						}
+						var got []string
+						for _, w := range st.HostTypes[0].Waiters {
+							got = append(got, w.Class)
+							if w.AgeSec <= 0 {
+								t.Errorf("waiter %+v has no age", w)
+							}
+						}
+						if want := []string{"try", "regular"}; !reflect.DeepEqual(got, want) {
+							t.Errorf("waiters = %q; want %q", got, want)
+						}
+					},
+					regGet.cancel,
+					tryGet.cancel,
+				}
+			},
+		},
 		{
 			name: "cancel-context-removes-waiter",
 			steps: func() []step {
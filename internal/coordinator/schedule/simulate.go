@@ -0,0 +1,454 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"time"
+
+	"golang.org/x/build/dashboard"
+	"golang.org/x/build/types"
+)
+
+// A Trace is a recorded or synthetic workload for Simulate.
+type Trace struct {
+	// Arrivals are the requests for buildlets, in any order.
+	Arrivals []Arrival
+
+	// Capacity is how many buildlets of each host type can exist
+	// at once, as it changes over time. A host type has unlimited
+	// capacity until its first CapacityChange.
+	Capacity []CapacityChange
+
+	// CreateDelay is how long it takes to create a buildlet of
+	// each host type. It's zero for host types not in the map.
+	CreateDelay map[string]time.Duration
+}
+
+// An Arrival is a request for a buildlet in a Trace.
+type Arrival struct {
+	Time time.Time
+
+	// Item is the request. Its HostType must be set, as must any
+	// other fields the Policy being simulated uses. Simulate
+	// doesn't modify it.
+	Item *SchedItem
+
+	// Hold is how long the buildlet is used once it's obtained.
+	Hold time.Duration
+}
+
+// A CapacityChange sets how many buildlets of a host type can exist
+// at once, from Time on. Lowering the capacity doesn't stop buildlets
+// already in use.
+type CapacityChange struct {
+	Time      time.Time
+	HostType  string
+	Buildlets int
+}
+
+// SimOptions configures Simulate.
+type SimOptions struct {
+	Policy       Policy             // nil means DefaultPolicy
+	OwnerWeights map[string]float64 // as for Scheduler.SetOwnerWeight
+
+	// PreemptInterval is how often waiters are checked for whether
+	// they should preempt running items, in addition to whenever
+	// something happens. Zero means one minute.
+	PreemptInterval time.Duration
+}
+
+// SimResult is the result of Simulate.
+type SimResult struct {
+	// Classes are the wait statistics of each class of request,
+	// keyed by "gomote", "try" and "regular" (post-submit).
+	Classes map[string]*ClassWaits
+}
+
+// ClassWaits are the queue wait statistics of one class of request.
+type ClassWaits struct {
+	Served    int // requests that got a buildlet, counting each after preemption
+	Unserved  int // requests still waiting at the end of the trace
+	Preempted int // times a request lost its buildlet
+
+	// Percentiles of how long served requests waited for their
+	// buildlets.
+	P50, P90, P99, Max time.Duration
+
+	waits []time.Duration
+}
+
+// Simulate replays tr, handing out buildlets as a Scheduler would
+// with the given options, and reports how long requests waited.
+// Buildlets are created on demand, up to each host type's capacity,
+// and destroyed after use; a preempted request starts waiting again
+// and, once it gets a new buildlet, holds it for its full Hold.
+// Simulate is deterministic: the same trace and options always give
+// the same result.
+func Simulate(tr *Trace, opts SimOptions) *SimResult {
+	sim := &simulator{
+		policy:   opts.Policy,
+		interval: opts.PreemptInterval,
+		fair:     newFairShare(),
+		hosts:    make(map[string]*simHost),
+		delay:    tr.CreateDelay,
+		res:      &SimResult{Classes: make(map[string]*ClassWaits)},
+	}
+	if sim.policy == nil {
+		sim.policy = DefaultPolicy
+	}
+	if sim.interval <= 0 {
+		sim.interval = time.Minute
+	}
+	for owner, w := range opts.OwnerWeights {
+		sim.fair.setWeight(owner, w)
+	}
+	for _, c := range []string{"gomote", "try", "regular"} {
+		sim.res.Classes[c] = new(ClassWaits)
+	}
+	// Capacity changes go first, so they apply to arrivals at the
+	// same time.
+	for i := range tr.Capacity {
+		c := &tr.Capacity[i]
+		sim.push(&simEvent{t: c.Time, kind: simCapacity, capacity: c})
+	}
+	for i := range tr.Arrivals {
+		a := &tr.Arrivals[i]
+		sim.push(&simEvent{t: a.Time, kind: simArrive, arrival: a})
+	}
+	sim.run()
+	return sim.res
+}
+
+type simEventKind int
+
+const (
+	simArrive simEventKind = iota
+	simCapacity
+	simCreated
+	simDone
+	simPreemptCheck
+)
+
+type simEvent struct {
+	t    time.Time
+	seq  int // order of events at the same time
+	kind simEventKind
+
+	arrival  *Arrival        // for simArrive
+	capacity *CapacityChange // for simCapacity
+	hostType string          // for simCreated
+	run      *simRun         // for simDone
+}
+
+type simEvents []*simEvent
+
+func (q simEvents) Len() int      { return len(q) }
+func (q simEvents) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q simEvents) Less(i, j int) bool {
+	if !q[i].t.Equal(q[j].t) {
+		return q[i].t.Before(q[j].t)
+	}
+	return q[i].seq < q[j].seq
+}
+func (q *simEvents) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+func (q *simEvents) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+type simulator struct {
+	policy   Policy
+	interval time.Duration
+	fair     *fairShare
+	hosts    map[string]*simHost
+	delay    map[string]time.Duration
+	res      *SimResult
+
+	now       time.Time
+	events    simEvents
+	seq       int
+	nextCheck time.Time // of the pending simPreemptCheck, if non-zero
+}
+
+// simHost is the state of a host type in a simulation.
+type simHost struct {
+	hostType string
+	capacity int // or -1 for unlimited
+	creating int
+	waiting  map[*SchedItem]bool
+	reqs     map[*SchedItem]*simReq // waiting and running items
+	running  map[*SchedItem]*simRun
+}
+
+// simReq is what the simulator knows about a waiting item.
+type simReq struct {
+	hold time.Duration
+	seq  int // arrival order, for deterministic ties
+}
+
+// simRun is an item using a buildlet.
+type simRun struct {
+	si        *SchedItem
+	preempted bool
+}
+
+func (sim *simulator) push(e *simEvent) {
+	sim.seq++
+	e.seq = sim.seq
+	heap.Push(&sim.events, e)
+}
+
+func (sim *simulator) host(hostType string) *simHost {
+	h, ok := sim.hosts[hostType]
+	if !ok {
+		h = &simHost{
+			hostType: hostType,
+			capacity: -1,
+			waiting:  make(map[*SchedItem]bool),
+			reqs:     make(map[*SchedItem]*simReq),
+			running:  make(map[*SchedItem]*simRun),
+		}
+		sim.hosts[hostType] = h
+	}
+	return h
+}
+
+func (sim *simulator) run() {
+	for sim.events.Len() > 0 {
+		e := heap.Pop(&sim.events).(*simEvent)
+		sim.now = e.t
+		var h *simHost
+		switch e.kind {
+		case simArrive:
+			si := new(SchedItem)
+			*si = *e.arrival.Item
+			h = sim.host(si.HostType)
+			sim.wait(h, si, e.arrival.Hold)
+		case simCapacity:
+			h = sim.host(e.capacity.HostType)
+			h.capacity = e.capacity.Buildlets
+		case simCreated:
+			h = sim.host(e.hostType)
+			h.creating--
+			sim.serve(h)
+		case simDone:
+			if e.run.preempted {
+				continue
+			}
+			h = sim.host(e.run.si.HostType)
+			delete(h.running, e.run.si)
+			delete(h.reqs, e.run.si)
+		case simPreemptCheck:
+			sim.nextCheck = time.Time{}
+		}
+		if h != nil {
+			sim.create(h)
+		}
+		sim.preemptAll()
+	}
+	for _, h := range sim.hosts {
+		for si := range h.waiting {
+			sim.res.Classes[si.class()].Unserved++
+		}
+	}
+	for _, cw := range sim.res.Classes {
+		cw.summarize()
+	}
+}
+
+// wait starts si waiting for a buildlet of host type h.
+func (sim *simulator) wait(h *simHost, si *SchedItem, hold time.Duration) {
+	si.requestTime = sim.now
+	sim.fair.tag(si, h.waiting)
+	h.waiting[si] = true
+	h.reqs[si] = &simReq{hold: hold, seq: sim.seq}
+}
+
+// create starts creating buildlets for h's waiters, as capacity and
+// the policy allow.
+func (sim *simulator) create(h *simHost) {
+	need := len(h.waiting) - h.creating
+	if h.capacity >= 0 {
+		if free := h.capacity - h.creating - len(h.running); need > free {
+			need = free
+		}
+	}
+	if max := sim.policy.MaxCreating(h.hostType); max > 0 && h.creating+need > max {
+		need = max - h.creating
+	}
+	for i := 0; i < need; i++ {
+		h.creating++
+		sim.push(&simEvent{t: sim.now.Add(sim.delay[h.hostType]), kind: simCreated, hostType: h.hostType})
+	}
+}
+
+// best returns the waiter of h that the policy says should go first,
+// breaking ties by arrival, or nil if there are none.
+func (sim *simulator) best(h *simHost) *SchedItem {
+	var best *SchedItem
+	for si := range h.waiting {
+		if best == nil || sim.policy.Less(si, best) ||
+			(!sim.policy.Less(best, si) && h.reqs[si].seq < h.reqs[best].seq) {
+			best = si
+		}
+	}
+	return best
+}
+
+// serve gives a newly created buildlet to the best waiter of h, if
+// any, or else destroys it.
+func (sim *simulator) serve(h *simHost) {
+	si := sim.best(h)
+	if si == nil {
+		return
+	}
+	delete(h.waiting, si)
+	sim.fair.served(si)
+	cw := sim.res.Classes[si.class()]
+	cw.Served++
+	cw.waits = append(cw.waits, sim.now.Sub(si.requestTime))
+	r := &simRun{si: si}
+	h.running[si] = r
+	sim.push(&simEvent{t: sim.now.Add(h.reqs[si].hold), kind: simDone, run: r})
+}
+
+// preemptAll preempts running items for waiters at capacity, as the
+// policy says, and schedules the next check if needed.
+func (sim *simulator) preemptAll() {
+	hostTypes := make([]string, 0, len(sim.hosts))
+	for ht := range sim.hosts {
+		hostTypes = append(hostTypes, ht)
+	}
+	sort.Strings(hostTypes)
+	check := false
+	for _, ht := range hostTypes {
+		h := sim.hosts[ht]
+		if h.capacity < 0 || len(h.waiting) == 0 || len(h.running) == 0 {
+			continue
+		}
+		check = true
+		sim.preempt(h)
+	}
+	if check && sim.nextCheck.IsZero() {
+		sim.nextCheck = sim.now.Add(sim.interval)
+		sim.push(&simEvent{t: sim.nextCheck, kind: simPreemptCheck})
+	}
+}
+
+// preempt preempts running items of h for its waiters, in order,
+// skipping as many waiters as there are buildlets being created.
+func (sim *simulator) preempt(h *simHost) {
+	waiters := make([]*SchedItem, 0, len(h.waiting))
+	for si := range h.waiting {
+		waiters = append(waiters, si)
+	}
+	sort.Slice(waiters, func(i, j int) bool {
+		a, b := waiters[i], waiters[j]
+		if sim.policy.Less(a, b) {
+			return true
+		}
+		return !sim.policy.Less(b, a) && h.reqs[a].seq < h.reqs[b].seq
+	})
+	for i, w := range waiters {
+		if i < h.creating {
+			continue
+		}
+		if h.capacity-h.creating-len(h.running) > 0 {
+			break
+		}
+		// Preempt the least important running item that the
+		// policy allows.
+		var victim *SchedItem
+		for si := range h.running {
+			if !sim.policy.Preempt(w, si, sim.now.Sub(w.requestTime)) {
+				continue
+			}
+			if victim == nil || sim.policy.Less(victim, si) ||
+				(!sim.policy.Less(si, victim) && h.reqs[si].seq > h.reqs[victim].seq) {
+				victim = si
+			}
+		}
+		if victim == nil {
+			continue
+		}
+		h.running[victim].preempted = true
+		delete(h.running, victim)
+		sim.res.Classes[victim.class()].Preempted++
+		req := h.reqs[victim]
+		delete(h.reqs, victim)
+		requeued := new(SchedItem)
+		*requeued = *victim
+		sim.wait(h, requeued, req.hold)
+		sim.create(h)
+	}
+}
+
+// summarize computes the percentiles of cw's waits.
+func (cw *ClassWaits) summarize() {
+	if len(cw.waits) == 0 {
+		return
+	}
+	sort.Slice(cw.waits, func(i, j int) bool { return cw.waits[i] < cw.waits[j] })
+	pct := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(cw.waits)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return cw.waits[i]
+	}
+	cw.P50, cw.P90, cw.P99 = pct(0.50), pct(0.90), pct(0.99)
+	cw.Max = cw.waits[len(cw.waits)-1]
+}
+
+// TraceFromRecords returns the arrivals of a Trace for the builds in
+// builds, as recorded by the coordinator, using their "get_buildlet"
+// spans, if present in spans, for when they asked for and got their
+// buildlets. Builds of unknown builders are skipped. The records
+// don't say when the commits being built were made, so post-submit
+// builds' CommitTime is the time they asked for a buildlet. The
+// returned Trace's Capacity and CreateDelay should be filled in from
+// what's known about the pools.
+func TraceFromRecords(builds []*types.BuildRecord, spans []*types.SpanRecord) *Trace {
+	getBuildlet := make(map[string]*types.SpanRecord) // by BuildID
+	for _, sr := range spans {
+		if sr.Event == "get_buildlet" {
+			getBuildlet[sr.BuildID] = sr
+		}
+	}
+	tr := new(Trace)
+	for _, br := range builds {
+		conf, ok := dashboard.Builders[br.Builder]
+		if !ok {
+			continue
+		}
+		end := br.EndTime
+		if end.IsZero() {
+			end = br.StartTime.Add(time.Duration(br.Seconds * float64(time.Second)))
+		}
+		arrived, got := br.StartTime, br.StartTime
+		if sr, ok := getBuildlet[br.ID]; ok {
+			arrived, got = sr.StartTime, sr.EndTime
+		}
+		tr.Arrivals = append(tr.Arrivals, Arrival{
+			Time: arrived,
+			Item: &SchedItem{
+				HostType:   conf.HostType,
+				IsTry:      br.IsTry,
+				CommitTime: arrived,
+			},
+			Hold: end.Sub(got),
+		})
+	}
+	sort.SliceStable(tr.Arrivals, func(i, j int) bool { return tr.Arrivals[i].Time.Before(tr.Arrivals[j].Time) })
+	return tr
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package schedule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/build/types"
+)
+
+// preemptTry is a Policy that lets TryBots that have waited 5 minutes
+// preempt post-submit builds.
+type preemptTry struct{ Policy }
+
+func (preemptTry) Preempt(waiter, running *SchedItem, waited time.Duration) bool {
+	return waiter.IsTry && !running.IsTry && !running.IsGomote && waited >= 5*time.Minute
+}
+
+func TestSimulate(t *testing.T) {
+	t0 := time.Unix(1e9, 0)
+	min := func(n int) time.Time { return t0.Add(time.Duration(n) * time.Minute) }
+	tr := &Trace{
+		Arrivals: []Arrival{
+			{Time: min(0), Item: &SchedItem{HostType: "h", CommitTime: min(0)}, Hold: 30 * time.Minute},
+			{Time: min(5), Item: &SchedItem{HostType: "h", IsTry: true, Owner: "a"}, Hold: 10 * time.Minute},
+			{Time: min(6), Item: &SchedItem{HostType: "h", IsTry: true, Owner: "a"}, Hold: 10 * time.Minute},
+			{Time: min(7), Item: &SchedItem{HostType: "h", IsTry: true, Owner: "b"}, Hold: 10 * time.Minute},
+		},
+		Capacity:    []CapacityChange{{Time: t0, HostType: "h", Buildlets: 1}},
+		CreateDelay: map[string]time.Duration{"h": time.Minute},
+	}
+
+	res := Simulate(tr, SimOptions{})
+	// The post-submit build holds the only buildlet until minute
+	// 31. Then the TryBots get new ones in turn, a minute after each
+	// finishes: a (waited 27 minutes), b (36), a (48).
+	reg, try := res.Classes["regular"], res.Classes["try"]
+	if reg.Served != 1 || reg.Max != time.Minute {
+		t.Errorf("regular = %+v; want 1 served after a minute", reg)
+	}
+	if try.Served != 3 || try.P50 != 36*time.Minute || try.Max != 48*time.Minute {
+		t.Errorf("try = %+v; want 3 served, median wait 36m, max 48m", try)
+	}
+
+	res = Simulate(tr, SimOptions{Policy: preemptTry{DefaultPolicy}})
+	// The first TryBot preempts the post-submit build after 5
+	// minutes, at minute 10, and gets a buildlet at minute 11. The
+	// others follow, b (15) then a (27), and then the post-submit
+	// build starts over.
+	reg, try = res.Classes["regular"], res.Classes["try"]
+	if reg.Preempted != 1 || reg.Served != 2 {
+		t.Errorf("regular = %+v; want preempted once and served twice", reg)
+	}
+	if try.Served != 3 || try.P50 != 15*time.Minute || try.Max != 27*time.Minute {
+		t.Errorf("try = %+v; want 3 served, median wait 15m, max 27m", try)
+	}
+
+	// Determinism.
+	if again := Simulate(tr, SimOptions{Policy: preemptTry{DefaultPolicy}}); !reflect.DeepEqual(again, res) {
+		t.Errorf("second simulation differs: %+v, %+v", again.Classes["try"], res.Classes["try"])
+	}
+}
+
+func TestTraceFromRecords(t *testing.T) {
+	t0 := time.Unix(1e9, 0)
+	builds := []*types.BuildRecord{
+		{ID: "B2", Builder: "linux-amd64", IsTry: true, StartTime: t0.Add(time.Minute), EndTime: t0.Add(20 * time.Minute)},
+		{ID: "B1", Builder: "linux-amd64", StartTime: t0, Seconds: 600},
+		{ID: "B3", Builder: "no-such-builder", StartTime: t0},
+	}
+	spans := []*types.SpanRecord{
+		{BuildID: "B2", Event: "get_buildlet", StartTime: t0.Add(2 * time.Minute), EndTime: t0.Add(5 * time.Minute)},
+		{BuildID: "B2", Event: "make_and_test", StartTime: t0.Add(5 * time.Minute)},
+	}
+	tr := TraceFromRecords(builds, spans)
+	if len(tr.Arrivals) != 2 {
+		t.Fatalf("got %d arrivals; want 2", len(tr.Arrivals))
+	}
+	a1, a2 := tr.Arrivals[0], tr.Arrivals[1]
+	if !a1.Time.Equal(t0) || a1.Item.IsTry || a1.Hold != 10*time.Minute || a1.Item.HostType != "host-linux-bullseye" {
+		t.Errorf("first arrival = %+v, %+v; want B1", a1, a1.Item)
+	}
+	if !a2.Time.Equal(t0.Add(2*time.Minute)) || !a2.Item.IsTry || a2.Hold != 15*time.Minute {
+		t.Errorf("second arrival = %+v, %+v; want B2", a2, a2.Item)
+	}
+}
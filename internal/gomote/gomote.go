@@ -133,6 +133,7 @@ func (s *Server) CreateInstance(req *protos.CreateInstanceRequest, stream protos
 	si := &schedule.SchedItem{
 		HostType: bconf.HostType,
 		IsGomote: true,
+		Owner:    creds.Email,
 	}
 	type result struct {
 		buildletClient buildlet.Client
@@ -357,12 +358,35 @@ func (s *Server) ReadTGZToURL(ctx context.Context, req *protos.ReadTGZToURLReque
 		// the helper function returns meaningful GRPC error.
 		return nil, err
 	}
-	tgz, err := bc.GetTar(ctx, req.GetDirectory())
+	objectName := uuid.NewString()
+	// Have the buildlet upload the archive directly if it can. Older
+	// buildlets can't, so fall back to streaming it through here.
+	putURL, err := s.signURLForPut(objectName)
+	if err == nil {
+		_, err = bc.UploadArchive(ctx, req.GetDirectory(), buildlet.ArchiveTGZ, putURL)
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Aborted, "unable to retrieve tar from gomote instance: %s", err)
+		log.Printf("ReadTGZToURL: direct upload from buildlet failed, streaming through the coordinator instead: %s", err)
+		if err := s.copyTGZToObject(ctx, bc, req.GetDirectory(), objectName); err != nil {
+			return nil, err
+		}
+	}
+	url, err := s.signURLForDownload(objectName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create signed URL for download: %s", err)
+	}
+	return &protos.ReadTGZToURLResponse{
+		Url: url,
+	}, nil
+}
+
+// copyTGZToObject retrieves a directory from the buildlet and writes it to the named object.
+func (s *Server) copyTGZToObject(ctx context.Context, bc buildlet.Client, dir, objectName string) error {
+	tgz, err := bc.GetTar(ctx, dir)
+	if err != nil {
+		return status.Errorf(codes.Aborted, "unable to retrieve tar from gomote instance: %s", err)
 	}
 	defer tgz.Close()
-	objectName := uuid.NewString()
 	objectHandle := s.bucket.Object(objectName)
 	// A context for writes is used to ensure we can cancel the context if a
 	// problem is encountered while writing to the object store. The API documentation
@@ -371,19 +395,13 @@ func (s *Server) ReadTGZToURL(ctx context.Context, req *protos.ReadTGZToURLReque
 	tgzWriter := objectHandle.NewWriter(writeCtx)
 	defer cancel()
 	if _, err = io.Copy(tgzWriter, tgz); err != nil {
-		return nil, status.Errorf(codes.Aborted, "unable to stream tar.gz: %s", err)
+		return status.Errorf(codes.Aborted, "unable to stream tar.gz: %s", err)
 	}
 	// when close is called, the object is stored in the bucket.
 	if err := tgzWriter.Close(); err != nil {
-		return nil, status.Errorf(codes.Aborted, "unable to store object: %s", err)
+		return status.Errorf(codes.Aborted, "unable to store object: %s", err)
 	}
-	url, err := s.signURLForDownload(objectName)
-	if err != nil {
-		return nil, status.Errorf(codes.Internal, "unable to create signed URL for download: %s", err)
-	}
-	return &protos.ReadTGZToURLResponse{
-		Url: url,
-	}, nil
+	return nil
 }
 
 // RemoveFiles removes files or directories from the gomote instance.
@@ -466,6 +484,19 @@ func (s *Server) signURLForUpload(object string) (url string, fields map[string]
 	return pv4.URL, pv4.Fields, nil
 }
 
+// signURLForPut generates a signed URL to be used by a buildlet to upload an object to GCS with a PUT request.
+func (s *Server) signURLForPut(object string) (url string, err error) {
+	url, err = s.bucket.SignedURL(object, &storage.SignedURLOptions{
+		Expires: time.Now().Add(10 * time.Minute),
+		Method:  http.MethodPut,
+		Scheme:  storage.SigningSchemeV4,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to generate signed url: %w", err)
+	}
+	return url, err
+}
+
 // signURLForDownload generates a signed URL and fields to be used to upload an object to GCS without authenticating.
 func (s *Server) signURLForDownload(object string) (url string, err error) {
 	url, err = s.bucket.SignedURL(object, &storage.SignedURLOptions{
@@ -14,12 +14,15 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/build/dashboard"
 	"golang.org/x/build/internal/access"
+	"golang.org/x/build/internal/coordinator/pool"
 	"golang.org/x/build/internal/coordinator/remote"
 	"golang.org/x/build/internal/coordinator/schedule"
 	"golang.org/x/build/internal/gomote/protos"
@@ -48,13 +51,19 @@ func fakeGomoteServer(t *testing.T, ctx context.Context) protos.GomoteServiceSer
 }
 
 func setupGomoteTest(t *testing.T, ctx context.Context) protos.GomoteServiceClient {
+	return setupGomoteTestServer(t, fakeGomoteServer(t, ctx))
+}
+
+// setupGomoteTestServer serves srv over gRPC on localhost and returns
+// a client for it.
+func setupGomoteTestServer(t *testing.T, srv protos.GomoteServiceServer) protos.GomoteServiceClient {
 	lis, err := nettest.NewLocalListener("tcp")
 	if err != nil {
 		t.Fatalf("unable to create net listener: %s", err)
 	}
 	sopts := access.FakeIAPAuthInterceptorOptions()
 	s := grpc.NewServer(sopts...)
-	protos.RegisterGomoteServiceServer(s, fakeGomoteServer(t, ctx))
+	protos.RegisterGomoteServiceServer(s, srv)
 	go s.Serve(lis)
 
 	// create GRPC client
@@ -426,6 +435,78 @@ func TestDestroyInstance(t *testing.T) {
 	}
 }
 
+// TestLocalBuildlet runs a gomote session against a real buildlet,
+// started as a local process by the scheduler.
+func TestLocalBuildlet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode; builds and runs cmd/buildlet")
+	}
+	bin, err := pool.BuildLocalBuildlet(context.Background(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp := pool.NewLocalProcessPool(pool.LocalProcessOpts{Buildlet: bin, Capacity: 1})
+	defer lp.Close()
+	pool.TestPoolHook = func(*dashboard.HostConfig) pool.Buildlet { return lp }
+	defer func() { pool.TestPoolHook = nil }()
+
+	srv := fakeGomoteServer(t, context.Background()).(*Server)
+	srv.scheduler = schedule.NewScheduler()
+	client := setupGomoteTestServer(t, srv)
+	ctx := access.FakeContextWithOutgoingIAPAuth(context.Background(), fakeIAP())
+	gomoteID := mustCreateInstance(t, client, fakeIAP())
+
+	stream, err := client.ExecuteCommand(ctx, &protos.ExecuteCommandRequest{
+		GomoteId:          gomoteID,
+		Command:           "/bin/sh",
+		SystemLevel:       true,
+		AppendEnvironment: []string{"GOMOTE_TEST=ok"},
+		Args:              []string{"-c", `echo "make.bash: $GOMOTE_TEST"`},
+	})
+	if err != nil {
+		t.Fatalf("client.ExecuteCommand(ctx, req) = response, %s; want no error", err)
+	}
+	var out strings.Builder
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("stream.Recv() = _, %s; want no error", err)
+		}
+		out.WriteString(res.GetOutput())
+	}
+	if got, want := out.String(), "make.bash: ok\n"; got != want {
+		t.Errorf("command output = %q; want %q", got, want)
+	}
+
+	// A failing command is a remote error, and the buildlet is
+	// still usable.
+	stream, err = client.ExecuteCommand(ctx, &protos.ExecuteCommandRequest{
+		GomoteId:    gomoteID,
+		Command:     "/bin/sh",
+		SystemLevel: true,
+		Args:        []string{"-c", "exit 3"},
+	})
+	if err != nil {
+		t.Fatalf("client.ExecuteCommand(ctx, req) = response, %s; want no error", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unknown {
+		t.Errorf("stream.Recv() = _, %v; want code %s", err, codes.Unknown)
+	}
+
+	if _, err := client.DestroyInstance(ctx, &protos.DestroyInstanceRequest{GomoteId: gomoteID}); err != nil {
+		t.Fatalf("client.DestroyInstance(ctx, req) = response, %s; want no error", err)
+	}
+	// Destroying the instance stops the buildlet, freeing the
+	// pool's only slot for another.
+	gomoteID = mustCreateInstance(t, client, fakeIAP())
+	if _, err := client.DestroyInstance(ctx, &protos.DestroyInstanceRequest{GomoteId: gomoteID}); err != nil {
+		t.Fatalf("client.DestroyInstance(ctx, req) = response, %s; want no error", err)
+	}
+}
+
 func TestDestroyInstanceError(t *testing.T) {
 	// This test will create a gomote instance and attempt to call DestroyInstance.
 	// If overrideID is set to true, the test will use a different gomoteID than the
@@ -584,6 +584,10 @@ func (b *fakeBuildlet) Put(ctx context.Context, r io.Reader, path string, mode o
 	return f.Close()
 }
 
+func (b *fakeBuildlet) MissingBlobs(ctx context.Context, digests []string) ([]string, error) {
+	return nil, buildlet.ErrBlobsUnsupported
+}
+
 func (b *fakeBuildlet) PutTar(ctx context.Context, r io.Reader, dir string) error {
 	b.logf("put tar to %q", dir)
 	return untar.Untar(r, filepath.Join(b.dir, dir))
@@ -23,6 +23,15 @@ const (
 	// NameBuilderMasterKey is the secret name for the builder master key.
 	NameBuilderMasterKey = "builder-master-key"
 
+	// NameBuildletSessionCACert is the secret name for the PEM-encoded
+	// certificate of the CA that issues the client certificates the
+	// coordinator uses to authenticate to buildlets in mutual TLS mode.
+	NameBuildletSessionCACert = "buildlet-session-ca-cert"
+
+	// NameBuildletSessionCAKey is the secret name for the PEM-encoded
+	// private key of the buildlet session CA.
+	NameBuildletSessionCAKey = "buildlet-session-ca-key"
+
 	// NameFarmerRunBench is the secret name for farmer run bench.
 	NameFarmerRunBench = "farmer-run-bench"
 
@@ -227,7 +227,7 @@ func (b *BuildletStep) BuildBinary(ctx *workflow.TaskContext, sourceArchive io.R
 	buildEnv := buildenv.Production
 	// Push source to buildlet.
 	ctx.Printf("Pushing source to buildlet.")
-	if err := b.Buildlet.PutTar(ctx, sourceArchive, ""); err != nil {
+	if err := buildlet.PutTarDelta(ctx, b.Buildlet, sourceArchive, ""); err != nil {
 		return fmt.Errorf("failed to put generated source tarball: %v", err)
 	}
 
@@ -256,6 +256,9 @@ func (b *BuildletStep) BuildBinary(ctx *workflow.TaskContext, sourceArchive io.R
 	}
 
 	ctx.Printf("Building release tarball.")
+	// The tarball passes through here rather than being uploaded
+	// straight to scratch storage with UploadArchive, because adjustTar
+	// rewrites it on the way.
 	input, err := b.Buildlet.GetTar(ctx, "go")
 	if err != nil {
 		return err
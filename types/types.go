@@ -98,14 +98,79 @@ type BuildRecord struct {
 
 	EndTime    time.Time
 	Seconds    float64
-	Result     string // empty string, "ok", "fail"
+	Result     string // empty string, "ok", "fail", "preempted"
 	FailureURL string `datastore:",noindex"` // deprecated; use LogURL
 	LogURL     string `datastore:",noindex"`
 
+	// PassedAfterRetry is whether the build only passed because
+	// some dist tests that failed passed when retried.
+	// RetriedTests names those tests.
+	PassedAfterRetry bool
+	RetriedTests     []string `datastore:",noindex"`
+
 	// TODO(bradfitz): log which reverse buildlet we got?
 	// Buildlet string
 }
 
+// TestRetryRecord is a datastore entity we write each time a cmd/dist
+// test that failed is run again, recording whether it passed the
+// second (or later) time.
+type TestRetryRecord struct {
+	BuildID  string
+	IsTry    bool // is trybot run
+	GoRev    string
+	Builder  string // "linux-amd64-foo"
+	TestName string // cmd/dist test name, such as "go_test:net/http"
+
+	Attempt   int    // 1 for the first retry
+	Passed    bool   // whether this retry passed
+	FailedOn  string // name of the buildlet where the previous attempt failed
+	Buildlet  string // name of the buildlet that ran this retry
+	Error     string // empty if Passed
+	StartTime time.Time
+	EndTime   time.Time
+	Seconds   float64
+}
+
+// TestResult is the result of one Go test, or of a whole package
+// when Test is empty, as reported by test2json during a build's
+// cmd/dist tests. The coordinator writes them to datastore as children
+// of the build's BuildRecord.
+type TestResult struct {
+	BuildID string
+	Builder string // "linux-amd64-foo"
+	GoRev   string
+	Package string // "net/http"
+	Test    string // "TestServeFile" or "TestServeFile/subtest"; empty for the package
+	Result  string // "pass", "fail", or "skip"
+	Elapsed float64
+	Output  string `datastore:",noindex" json:",omitempty"` // only for failures; possibly truncated
+}
+
+// TestResults are the test results of a build, as served by the
+// coordinator's /status/testresults.json handler.
+type TestResults struct {
+	BuildID string
+	Builder string
+	GoRev   string
+	Done    bool // whether the build has finished
+	Results []*TestResult
+}
+
+// CachedBuildResult is the datastore entity recording a successful
+// post-submit build, keyed by a hash of its inputs: the Go tree, the
+// repo tree, and the builder's configuration. Later builds of the
+// same inputs may reuse its result instead of running.
+type CachedBuildResult struct {
+	BuildID string
+	Builder string
+	GoRev   string
+	Rev     string // same as GoRev for repo "go"
+	Repo    string
+	LogURL  string `datastore:",noindex"`
+	Time    time.Time
+}
+
 type ReverseBuilder struct {
 	Name         string
 	HostType     string
@@ -114,6 +179,8 @@ type ReverseBuilder struct {
 	BusySec      float64 `json:",omitempty"`
 	Version      string  // buildlet version
 	Busy         bool
+	Draining     bool `json:",omitempty"` // taking no new work, to be taken out of service
+	Quarantined  bool `json:",omitempty"` // taking no new work after repeated failures
 }
 
 // ReverseHostStatus is part of ReverseBuilderStatus.
@@ -124,6 +191,10 @@ type ReverseHostStatus struct {
 	Idle      int
 	Busy      int
 	Waiters   int // number of builds waiting on a buildlet host of this type
+	Draining  int // number of connected buildlets taking no new work
+	// Quarantined is the number of connected buildlets taking no
+	// new work because their host failed repeatedly.
+	Quarantined int
 
 	// Machines are all connected buildlets of this host type,
 	// keyed by machine self-reported unique name.
@@ -137,6 +208,30 @@ type ReverseHostStatus struct {
 type ReverseBuilderStatus struct {
 	// Machines maps from the connected builder name (anything unique) to its status.
 	HostTypes map[string]*ReverseHostStatus
+
+	// Health maps from a builder host name to its recent failures,
+	// for hosts that have failed, whether or not they're connected.
+	Health map[string]*ReverseHostHealth `json:",omitempty"`
+}
+
+// ReverseHostHealth is the recent health history of a reverse
+// buildlet host. It is part of ReverseBuilderStatus.
+type ReverseHostHealth struct {
+	// Failures are the host's most recent failures, oldest first.
+	Failures []ReverseHealthEvent
+
+	// QuarantinedUntil is when the host's quarantine ends, if it
+	// has been quarantined for failing repeatedly. A quarantined
+	// host is given no new work.
+	QuarantinedUntil time.Time
+}
+
+// ReverseHealthEvent is a failure of a reverse buildlet.
+type ReverseHealthEvent struct {
+	Time     time.Time
+	HostType string
+	Kind     string // "heartbeat", "health_check", "marked_broken" or "communication"
+	Detail   string `json:",omitempty"`
 }
 
 func (s *ReverseBuilderStatus) Host(hostType string) *ReverseHostStatus {